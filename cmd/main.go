@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,10 +9,10 @@ import (
 	"os/signal"
 	"syscall"
 
-	"aliyun-dcdn-firewall-sync/internal/client"
 	"aliyun-dcdn-firewall-sync/internal/config"
 	"aliyun-dcdn-firewall-sync/internal/scheduler"
-	"aliyun-dcdn-firewall-sync/pkg/models"
+	"aliyun-dcdn-firewall-sync/internal/sink"
+	"aliyun-dcdn-firewall-sync/internal/state"
 )
 
 var (
@@ -19,6 +20,8 @@ var (
 	onceMode   = flag.Bool("once", false, "执行一次后退出，不启动调度器")
 	genConfig  = flag.Bool("gen-config", false, "生成示例配置文件")
 	version    = flag.Bool("version", false, "显示版本信息")
+	listShards = flag.Bool("list-shards", false, "列出各地址组当前的分片地址薄名称")
+	rollback   = flag.String("rollback-sink", "", "将指定oss_policy写入目标的各Bucket Policy恢复为最近一次写入前的快照")
 )
 
 func main() {
@@ -52,14 +55,31 @@ func main() {
 		log.Fatal("加载配置文件失败:", err)
 	}
 
-	// 创建客户端
-	dcdnClient := client.NewDCDNClient(&cfg.DCDN)
-	firewallClient := client.NewFirewallClient(&cfg.Firewall, &cfg.Sync)
+	if *listShards {
+		if err := printShards(cfg); err != nil {
+			log.Fatal("读取分片列表失败:", err)
+		}
+		return
+	}
+
+	if *rollback != "" {
+		if err := rollbackSink(cfg, *rollback); err != nil {
+			log.Fatal("恢复写入目标失败:", err)
+		}
+		fmt.Println("恢复完成")
+		return
+	}
+
+	// 创建调度器（--once模式同样经过调度器，保证状态文件和漂移检测一致）
+	scheduler, err := scheduler.NewScheduler(cfg)
+	if err != nil {
+		log.Fatal("创建调度器失败:", err)
+	}
 
 	if *onceMode {
 		// 执行一次同步
 		fmt.Println("执行一次性同步...")
-		if err := performSync(dcdnClient, firewallClient, cfg); err != nil {
+		if err := scheduler.RunOnce(); err != nil {
 			log.Fatal("同步失败:", err)
 		}
 		fmt.Println("同步完成")
@@ -68,7 +88,6 @@ func main() {
 
 	// 启动调度器
 	fmt.Println("启动调度器...")
-	scheduler := scheduler.NewScheduler(cfg)
 
 	// 设置信号处理
 	sigChan := make(chan os.Signal, 1)
@@ -88,99 +107,50 @@ func main() {
 	fmt.Println("程序已退出")
 }
 
-// performSync 执行一次同步操作
-func performSync(dcdnClient *client.DCDNClient, firewallClient *client.FirewallClient, cfg *config.Config) error {
-	// 获取DCDN源IP信息
-	fmt.Println("查询DCDN L2节点IP信息...")
-	sourceIPs, err := dcdnClient.GetL2IPList() // 新SDK不需要域名列表
-	if err != nil {
-		return fmt.Errorf("查询DCDN源IP失败: %w", err)
-	}
-
-	fmt.Printf("共获取到 %d 个源IP地址\n", len(sourceIPs))
-
-	// 同步到防火墙地址组
-	for _, addressGroup := range cfg.Sync.AddressGroups {
-		fmt.Printf("同步到地址组: %s\n", addressGroup.GroupName)
-
-		// 过滤IP地址
-		filteredIPs := filterSourceIPs(sourceIPs, addressGroup.IncludePatterns, addressGroup.ExcludePatterns)
-
-		fmt.Printf("过滤后的IP数量: %d\n", len(filteredIPs))
-
-		// 同步到防火墙
-		if err := firewallClient.SyncAddressBook(addressGroup.GroupName, filteredIPs); err != nil {
-			return fmt.Errorf("同步地址组 %s 失败: %w", addressGroup.GroupName, err)
+// rollbackSink 将写入目标恢复为最近一次快照，目前只有oss_policy类型保存快照
+func rollbackSink(cfg *config.Config, name string) error {
+	for _, sinkCfg := range cfg.Sinks {
+		if sinkCfg.Name != name {
+			continue
 		}
-
-		fmt.Printf("地址组 %s 同步完成\n", addressGroup.GroupName)
+		if sinkCfg.Type != "oss_policy" {
+			return fmt.Errorf("写入目标 %s 的类型为 %s，只有oss_policy类型支持恢复", name, sinkCfg.Type)
+		}
+		target, err := sink.NewOSSPolicySink(sinkCfg)
+		if err != nil {
+			return err
+		}
+		return target.Rollback(context.Background())
 	}
-
-	return nil
+	return fmt.Errorf("写入目标 %s 不存在", name)
 }
 
-// filterSourceIPs 根据包含和排除模式过滤源IP信息
-func filterSourceIPs(sourceIPs []*models.DCDNSourceIPInfo, includePatterns, excludePatterns []string) []*models.DCDNSourceIPInfo {
-	if len(includePatterns) == 0 && len(excludePatterns) == 0 {
-		return sourceIPs
+// printShards 打印状态文件中记录的分片地址薄名称，供配置访问控制策略时引用
+func printShards(cfg *config.Config) error {
+	store, err := state.Open(cfg.State.Path)
+	if err != nil {
+		return err
 	}
 
-	var result []*models.DCDNSourceIPInfo
-	for _, sourceIP := range sourceIPs {
-		included := len(includePatterns) == 0 // 如果没有包含模式，默认包含所有
-		excluded := false
-
-		// 检查包含模式
-		if len(includePatterns) > 0 {
-			for _, pattern := range includePatterns {
-				if matchPattern(sourceIP.IP, pattern) {
-					included = true
-					break
-				}
-			}
+	for _, group := range cfg.Sync.AddressGroups {
+		if group.Shard.Strategy == "" || group.Shard.Strategy == "none" {
+			fmt.Printf("%s: 未分片\n", group.GroupName)
+			continue
 		}
-
-		// 检查排除模式
-		for _, pattern := range excludePatterns {
-			if matchPattern(sourceIP.IP, pattern) {
-				excluded = true
-				break
-			}
+		gs := store.Group(group.GroupName)
+		if gs == nil || len(gs.Shards) == 0 {
+			fmt.Printf("%s: 尚未同步，暂无分片记录\n", group.GroupName)
+			continue
 		}
-
-		if included && !excluded {
-			result = append(result, sourceIP)
+		fmt.Printf("%s (%s):\n", group.GroupName, group.Shard.Strategy)
+		for _, name := range gs.Shards {
+			fmt.Printf("  %s\n", name)
+		}
+		for _, name := range gs.StaleShards {
+			fmt.Printf("  %s（旧分片，仍被引用或删除失败）\n", name)
 		}
 	}
-
-	return result
-}
-
-// matchPattern 简单的模式匹配（支持通配符*）
-func matchPattern(text, pattern string) bool {
-	// 简单实现，仅支持前缀和后缀通配符
-	if pattern == "*" {
-		return true
-	}
-
-	if len(pattern) == 0 {
-		return len(text) == 0
-	}
-
-	if pattern[0] == '*' {
-		// 后缀匹配
-		suffix := pattern[1:]
-		return len(text) >= len(suffix) && text[len(text)-len(suffix):] == suffix
-	}
-
-	if pattern[len(pattern)-1] == '*' {
-		// 前缀匹配
-		prefix := pattern[:len(pattern)-1]
-		return len(text) >= len(prefix) && text[:len(prefix)] == prefix
-	}
-
-	// 完全匹配
-	return text == pattern
+	return nil
 }
 
 // generateSampleConfig 生成示例配置文件
@@ -198,6 +168,25 @@ dcdn:
   # export ALIBABA_CLOUD_ACCESS_KEY_SECRET=dcdn_user_access_key_secret
   
   region: "ap-southeast-1"  # 新加坡区域
+  qps: 5                    # 客户端限流：每秒请求数（同一账号同一服务共享）
+  burst: 1                  # 客户端限流：令牌桶容量
+
+# 经典CDN配置（可选）- 按加速域名查询L2节点IP，在地址组表达式中以 cdn_l2（全部域名）或 cdn:<域名> 引用
+# 凭证查找顺序：配置文件、CDN_ALIBABA_CLOUD_ACCESS_KEY_ID/SECRET、标准环境变量
+# cdn:
+#   region: "ap-southeast-1"
+#   qps: 5
+#   domains:
+#     - "static.example.com"
+#     - "img.example.com"
+
+# ESA配置（可选）- 获取站点的回源IP白名单（需在ESA控制台开启源站防护），在地址组表达式中以 esa_origin（全部站点）或 esa:<站点ID> 引用
+# 凭证查找顺序：配置文件、ESA_ALIBABA_CLOUD_ACCESS_KEY_ID/SECRET、标准环境变量
+# esa:
+#   region: "ap-southeast-1"  # 国际站；中国站为 cn-hangzhou
+#   site_ids:
+#     - 1234567890
+#   whitelist: "both"         # current（当前生效）、latest（最新）、both（二者合并，默认）
 
 # 防火墙配置 - 更新防火墙地址簿的凭证（需要防火墙管理权限）
 firewall:
@@ -213,6 +202,8 @@ firewall:
   # export FIREWALL_ALIBABA_CLOUD_ACCESS_KEY_SECRET=firewall_user_secret
   
   region: "ap-southeast-1"  # 新加坡区域
+  qps: 5                    # 云防火墙OpenAPI按账号限制QPS，超出会返回Throttling.User
+  burst: 1
 
 scheduler:
   # 优先使用cron表达式（支持秒级精度）
@@ -221,7 +212,9 @@ scheduler:
   interval: "168h"        # 每周执行一次 (168小时)
   run_on_start: true      # 启动时是否立即执行一次
   timeout: "30m"          # 超时时间
-  max_retries: 3          # 最大重试次数
+  max_retries: 3          # 最大重试次数（仅对限流和暂时性错误重试）
+  overlap_policy: "skip"  # 上次任务未结束时的处理策略：skip（跳过）、queue（排队一个）、cancel（取消正在运行的任务）
+  shutdown_timeout: "2m"  # 停止时等待当前地址薄写入完成的最长时间
 
 sync:
   address_groups:
@@ -235,7 +228,21 @@ sync:
         - "127.*"          # 本地回环
         - "192.168.*"      # 私有网络
         - "10.*"           # 私有网络
-        - "172.16.*"       # 私有网络
+        - "172.16.0.0/12"  # 私有网络（CIDR形式按网段匹配）
+      conflict_policy: "replan"  # 写入前发现地址薄被他人修改：replan（重新计划）或 abort（放弃写入）
+      drift_policy: "revert"     # 两次同步之间被外部修改：revert（恢复）、adopt（接受并保留）、alert（告警并保留外部修改的条目）
+      ownership: "full"          # full：管理整个地址薄；managed：只增删本工具添加过的条目，他人维护的条目保持不变
+      # static_entries:          # 始终写入的固定条目，如办公网出口、健康检查IP
+      #   - "203.0.113.10/32"
+      removal_grace_runs: 2      # 条目从DCDN列表消失后，连续缺失2次同步才移除（新增立即生效）
+      # removal_grace_period: "24h"  # 或按时长：缺失超过24小时才移除（同时配置时两项均需满足）
+      normalize:
+        mode: "lossless"         # off：原样写入；lossless：去重并合并相邻网段；aggregate：超过max_entries时合并为更大网段
+        # max_entries: 2000      # aggregate模式的条目上限（地址薄条目配额）
+        # min_prefix_length: 16  # aggregate模式合并后允许的最短前缀
+      # shard:                   # 条目超过单个地址薄配额时拆分为 <group_name>-1、<group_name>-2 ...
+      #   strategy: "count"      # none、count（按地址顺序切分）、first_octet（同一首字节尽量在同一分片）、ip_version
+      #   max_entries: 2000      # 单个分片的条目上限
     
     # IPv6地址组
     - group_name: "dcdn-source-ips-v6"
@@ -247,6 +254,154 @@ sync:
         - "::1"           # IPv6本地回环
         - "fc00::*"       # 私有IPv6
 
+    # 迁移示例：DCDN和ESA并行期间，将二者的回源网段合并写入同一地址薄
+    # - group_name: "origin-migration"
+    #   expression: "dcdn_l2 ∪ esa_origin"
+    #   ip_type: "both"
+
+    # 组合地址组示例：DCDN网段加上监控网段，去除已知的异常网段
+    # - group_name: "origin-allowlist"
+    #   description: "回源白名单"
+    #   expression: "dcdn_l2 ∪ static:monitoring − file:configs/blocked.txt"  # 运算符：∪(+ |) ∩(&) −(-)，ASCII运算符前后需有空格
+    #   ip_type: "both"
+
+# 具名数据源，可在地址组表达式中按名称引用（dcdn_l2 为内置的DCDN L2节点IP列表，cdn_l2 为cdn.domains的L2节点IP，esa_origin 为esa.site_ids的回源IP白名单）
+# sources:
+#   - name: "monitoring"
+#     type: "static"         # static：直接列出条目；file：本地文件；url：HTTP(S)地址；cdn：CDN加速域名的L2节点IP；esa：ESA站点的回源IP白名单
+#     entries:
+#       - "198.51.100.0/24"
+#   - name: "scanner"
+#     type: "url"
+#     url: "https://example.com/scanner-ips.json"
+#     format: "json"         # txt、csv、json、yaml，为空时按扩展名或Content-Type判断
+#     field: "prefixes.ip_prefix"  # csv为列名或列号；json/yaml为点分隔的字段路径
+#     max_invalid_ratio: 0.05      # 解析失败的条目超过5%时本次获取失败
+#     timeout: "30s"
+#     # checksum_url: "https://example.com/scanner-ips.json.sha256"
+#     # signature_url: "https://example.com/scanner-ips.json.sig"
+#     # public_key: "BASE64_ED25519_PUBLIC_KEY"
+#   - name: "video-cdn"
+#     type: "cdn"
+#     domains:
+#       - "video.example.com"
+
+# 云防火墙地址薄以外的写入目标，使用地址组经过过滤、宽限期和归一化之后的内容
+# 地址组设置 skip_address_book: true 时只写入写入目标
+# sinks:
+#   - name: "web-sg"
+#     type: "ecs_security_group"   # ECS安全组入方向规则，只增删描述为marker的规则
+#     group: "dcdn-source-ips-v4"
+#     region: "cn-hangzhou"         # 为空时使用firewall.region；凭证环境变量前缀为ECS_
+#     security_group:
+#       security_group_ids: ["sg-bp1xxxxxxxx"]
+#       ports: ["tcp/443"]          # tcp/443、tcp/8000-8100、udp/53、icmp、all
+#       policy: "accept"
+#       priority: 1
+#       nic_type: "intranet"        # VPC为intranet
+#       max_rules: 200              # 安全组规则配额，写入后超过时本次不写入
+#   - name: "alb-origin-acl"
+#     type: "alb_acl"               # slb_acl（CLB访问控制列表）或alb_acl（ALB访问控制列表），增量增删条目
+#     group: "dcdn-source-ips-v4"
+#     acl:
+#       acl_ids: ["acl-xxxxxxxx"]
+#       ownership: "managed"        # managed（只增删备注为marker的条目，默认）或full（管理整个ACL）
+#       max_entries: 0              # ACL条目配额，0表示不检查
+#   - name: "waf-bot-whitelist"
+#     type: "waf_whitelist"         # WAF 3.0白名单规则，改写规则中 "IP 属于" 条件的IP列表
+#     group: "dcdn-source-ips-v4"
+#     region: "cn-hangzhou"         # cn-hangzhou（中国内地实例）或ap-southeast-1（非中国内地实例）
+#     waf:
+#       instance_id: "waf_v2_public_cn-xxxxxxxx"
+#       rule_ids: [123456]          # 预先在控制台创建的白名单规则
+#       max_entries: 0              # IP条件的条目上限，0表示不检查
+#       state_dir: "data/waf"       # 记录本工具添加的条目，只移除记录中的条目
+#   - name: "orders-db"
+#     type: "rds_whitelist"         # rds_whitelist、polardb_whitelist或redis_whitelist，覆盖写入指定白名单分组
+#     group: "dcdn-source-ips-v4"   # 只支持IPv4地址组
+#     db_whitelist:
+#       instance_ids: ["rm-bp1xxxxxxxx"]   # RDS实例ID、PolarDB集群ID或Redis实例ID
+#       group_name: "dcdn_firewall_sync"   # 白名单分组名，不能为default
+#       max_entries: 1000                  # 实例所有分组的条目总数上限
+#   - name: "origin-bucket"
+#     type: "oss_policy"            # 改写Bucket Policy中Sid为statement_sid的Statement的acs:SourceIp条件
+#     group: "dcdn-source-ips-v4"
+#     oss_policy:
+#       buckets: ["my-origin-bucket"]
+#       endpoint: ""                # 为空时使用oss-<region>.aliyuncs.com
+#       statement_sid: "aliyun-dcdn-firewall-sync"
+#       snapshot_dir: "data/oss-policy-snapshots"   # 写入前保存原Policy，可用--rollback-sink恢复
+#       keep_snapshots: 10
+#   - name: "origin-host"
+#     type: "host_firewall"         # 本机防火墙，在每台源站主机上运行（不要启用选主）
+#     group: "dcdn-source-ips-v4"
+#     host_firewall:
+#       backend: "nftables"         # nftables（nft -f）、ipset（ipset restore + swap）或iptables（iptables-restore --noflush）
+#       name: "dcdn_origin"         # 集合名前缀（<name>_v4、<name>_v6），iptables时为链名（默认DCDN_ORIGIN）
+#       table: "dcdn_firewall_sync" # nftables的inet表名
+#       output_dir: "data/host-firewall"
+#       file_only: false            # 只写规则文件不执行命令；命令不存在时同样只写文件
+#   - name: "nginx-real-ip"
+#     type: "proxy_config"          # 反向代理可信代理配置文件，原子写入，命令失败时恢复原文件
+#     group: "dcdn-source-ips-v4"
+#     proxy_config:
+#       format: "nginx_real_ip"     # nginx_real_ip、nginx_allow、haproxy_acl、caddy、envoy_rbac、envoy_xff
+#       path: "/etc/nginx/conf.d/dcdn_real_ip.inc"
+#       validate_command: "nginx -t"
+#       reload_command: "nginx -s reload"
+#       command_timeout: "30s"
+#   - name: "ack-ingress"
+#     type: "kubernetes"            # ConfigMap，可选托管NetworkPolicy和Calico GlobalNetworkSet，服务端应用写入
+#     group: "dcdn-source-ips-v4"
+#     kubernetes:
+#       kubeconfig: ""              # 为空时优先使用集群内配置，再读取KUBECONFIG
+#       namespace: "kube-system"    # 默认POD_NAMESPACE或default
+#       configmap: "dcdn-source-ips"    # 默认与写入目标名称相同，每行一个CIDR
+#       configmap_key: "cidrs"
+#       network_policy: "dcdn-only-ingress"   # 为空时不写入
+#       pod_selector:
+#         app: "nginx-ingress-lb"
+#       ports: ["tcp/80", "tcp/443"]          # 为空表示所有端口
+#       global_network_set: ""      # Calico GlobalNetworkSet名称，为空时不写入
+#       calico_api_version: "crd.projectcalico.org/v1"
+#       labels: {}                  # 附加到所有对象的标签
+#   - name: "appliance-webhook"
+#     type: "webhook"               # 将期望列表和差异推送到任意HTTP地址
+#     group: "dcdn-source-ips-v4"
+#     webhook:
+#       urls: ["https://fw.example.com/api/dcdn-ips"]
+#       method: "POST"              # POST或PUT
+#       body_template: ""           # text/template，为空时发送JSON
+#       headers:
+#         Authorization: "Bearer xxx"
+#       secret_env: "DCDN_WEBHOOK_SECRET"   # HMAC-SHA256签名密钥所在的环境变量，也可直接设置secret
+#       timeout: "10s"
+#       expect_status: []           # 为空时接受所有2xx
+#       expect_body: ""             # 响应体需匹配的正则表达式
+#       send_unchanged: false
+#       state_dir: "data/webhook"
+
+# 本地状态（记录每个地址组最近一次写入的内容，用于漂移检测）
+state:
+  path: "data/state.json"
+
+# 通知配置（漂移等事件以JSON POST到以下地址）
+notifications:
+  webhook_urls: []
+  timeout: "10s"
+
+# 选主配置（多副本部署时启用，只有leader实例执行同步）
+leader_election:
+  enabled: false
+  backend: "flock"        # flock: 单主机文件锁；kubernetes: 集群Lease
+  lock_file: "/var/run/aliyun-dcdn-firewall-sync.lock"
+  # lease_name: "aliyun-dcdn-firewall-sync"
+  # lease_namespace: "default"   # 默认读取POD_NAMESPACE环境变量
+  lease_duration: "15s"   # leader租约过期后follower接管
+  renew_deadline: "10s"
+  retry_period: "2s"
+  once_timeout: "1m"      # --once模式等待成为leader的最长时间，超时后不执行同步
+
 logging:
   level: "info"           # debug, info, warn, error
   format: "text"          # text, json
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// albAPIVersion 应用型负载均衡（ALB）的OpenAPI版本
+const albAPIVersion = "2020-06-16"
+
+// albBatchSize AddEntriesToAcl和RemoveEntriesFromAcl单次调用的条目数上限
+const albBatchSize = 20
+
+// albConfigureTimeout 等待ACL配置完成的最长时间
+const albConfigureTimeout = 2 * time.Minute
+
+// ALBClient 应用型负载均衡（ALB）客户端，用于维护访问控制列表
+// ALB的ACL修改是异步的，ACL处于Configuring状态时不能再次修改，每批修改后等待ACL恢复Available
+type ALBClient struct {
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewALBClient 创建新的ALB客户端
+func NewALBClient(cfg *config.AliyunConfig) (*ALBClient, error) {
+	client, err := newOpenAPIClient(cfg, "ALB_", fmt.Sprintf("alb.%s.aliyuncs.com", cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("创建 ALB 客户端失败: %v", err)
+	}
+
+	return &ALBClient{
+		client:  client,
+		limiter: limiterFor("alb", cfg, "ALB_"),
+	}, nil
+}
+
+// ListACLEntries 查询访问控制列表的全部条目，ALB的ACL同时支持IPv4和IPv6，地址族为空
+func (c *ALBClient) ListACLEntries(ctx context.Context, aclID string) ([]models.ACLEntry, string, error) {
+	var entries []models.ACLEntry
+	nextToken := ""
+	for {
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return nil, "", err
+		}
+
+		query := map[string]string{"AclId": aclID, "MaxResults": "100"}
+		if nextToken != "" {
+			query["NextToken"] = nextToken
+		}
+		body, err := callRPC(c.client, "ListAclEntries", albAPIVersion, query)
+		if err != nil {
+			return nil, "", fmt.Errorf("调用ListAclEntries API失败（ACL %s）: %w", aclID, err)
+		}
+
+		list, _ := body["AclEntries"].([]interface{})
+		for _, item := range list {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			entry, _ := fields["Entry"].(string)
+			description, _ := fields["Description"].(string)
+			entries = append(entries, models.ACLEntry{Entry: canonicalEntry(entry), Description: description})
+		}
+
+		nextToken, _ = body["NextToken"].(string)
+		if nextToken == "" {
+			return entries, "", nil
+		}
+	}
+}
+
+// AddACLEntries 添加条目，按单次调用上限分批
+func (c *ALBClient) AddACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error {
+	for start := 0; start < len(entries); start += albBatchSize {
+		end := min(start+albBatchSize, len(entries))
+		query := map[string]string{"AclId": aclID}
+		for i, entry := range entries[start:end] {
+			query[fmt.Sprintf("AclEntries.%d.Entry", i+1)] = entry.Entry
+			if entry.Description != "" {
+				query[fmt.Sprintf("AclEntries.%d.Description", i+1)] = entry.Description
+			}
+		}
+		if err := c.modify(ctx, "AddEntriesToAcl", aclID, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveACLEntries 删除条目，按单次调用上限分批
+func (c *ALBClient) RemoveACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error {
+	for start := 0; start < len(entries); start += albBatchSize {
+		end := min(start+albBatchSize, len(entries))
+		query := map[string]string{"AclId": aclID}
+		for i, entry := range entries[start:end] {
+			query[fmt.Sprintf("Entries.%d", i+1)] = entry.Entry
+		}
+		if err := c.modify(ctx, "RemoveEntriesFromAcl", aclID, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modify 提交一批修改并等待ACL配置完成
+func (c *ALBClient) modify(ctx context.Context, action, aclID string, query map[string]string) error {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return err
+	}
+	if _, err := callRPC(c.client, action, albAPIVersion, query); err != nil {
+		return fmt.Errorf("调用%s API失败（ACL %s）: %w", action, aclID, err)
+	}
+	return c.waitAvailable(ctx, aclID)
+}
+
+// waitAvailable 轮询ACL状态直到恢复Available
+func (c *ALBClient) waitAvailable(ctx context.Context, aclID string) error {
+	deadline := time.Now().Add(albConfigureTimeout)
+	for {
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
+		body, err := callRPC(c.client, "ListAcls", albAPIVersion, map[string]string{"AclIds.1": aclID})
+		if err != nil {
+			return fmt.Errorf("调用ListAcls API失败（ACL %s）: %w", aclID, err)
+		}
+
+		status := ""
+		if acls, ok := body["Acls"].([]interface{}); ok && len(acls) > 0 {
+			if fields, ok := acls[0].(map[string]interface{}); ok {
+				status, _ = fields["AclStatus"].(string)
+			}
+		}
+		if status == "Available" {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return &APIError{Class: ErrorClassTransient, Err: fmt.Errorf("ACL %s 在 %s 内未完成配置（状态: %s）", aclID, albConfigureTimeout, status)}
+		}
+
+		log.Printf("ALB ACL %s 状态为 %s，等待配置完成...", aclID, status)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// cdnAPIVersion CDN产品的OpenAPI版本
+const cdnAPIVersion = "2018-05-10"
+
+// CDNClient 阿里云CDN（非DCDN）客户端
+// CDN产品的Go SDK未引入依赖，通过通用的OpenAPI客户端调用
+type CDNClient struct {
+	config  *config.CDNConfig
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewCDNClient 创建新的CDN客户端
+func NewCDNClient(cfg *config.CDNConfig) (*CDNClient, error) {
+	// CDN服务使用全球endpoint
+	client, err := newOpenAPIClient(&cfg.AliyunConfig, "CDN_", "cdn.aliyuncs.com")
+	if err != nil {
+		return nil, fmt.Errorf("创建 CDN 客户端失败: %v", err)
+	}
+
+	return &CDNClient{
+		config:  cfg,
+		client:  client,
+		limiter: limiterFor("cdn", &cfg.AliyunConfig, "CDN_"),
+	}, nil
+}
+
+// DescribeL2Vips 查询单个加速域名的L2节点IP
+func (c *CDNClient) DescribeL2Vips(ctx context.Context, domain string) (*models.DCDNDomainInfo, error) {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
+	body, err := callRPC(c.client, "DescribeL2VipsByDomain", cdnAPIVersion, map[string]string{"DomainName": domain})
+	if err != nil {
+		return nil, fmt.Errorf("调用DescribeL2VipsByDomain API失败（域名 %s）: %w", domain, err)
+	}
+
+	now := time.Now()
+	info := &models.DCDNDomainInfo{DomainName: domain, UpdateTime: now}
+	for _, vip := range vipList(body["Vips"]) {
+		info.SourceIPs = append(info.SourceIPs, models.DCDNSourceIPInfo{
+			IP:          vip,
+			Location:    "Global",
+			ISP:         "阿里云",
+			Status:      "Active",
+			LastUpdated: now,
+			Origin:      "cdn:" + domain,
+		})
+	}
+	return info, nil
+}
+
+// QueryDomains 逐个查询域名的L2节点IP，任一域名查询失败时返回错误
+func (c *CDNClient) QueryDomains(ctx context.Context, domains []string) ([]*models.DCDNDomainInfo, error) {
+	result := make([]*models.DCDNDomainInfo, 0, len(domains))
+	for _, domain := range domains {
+		info, err := c.DescribeL2Vips(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("CDN域名 %s: 查询到 %d 个L2节点IP", domain, len(info.SourceIPs))
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// MergeDomainIPs 合并各域名的L2节点IP并去重，同一网段出现在多个域名中时保留第一个域名的来源信息
+func MergeDomainIPs(domains []*models.DCDNDomainInfo) []*models.DCDNSourceIPInfo {
+	seen := &ipset.Set{}
+	var result []*models.DCDNSourceIPInfo
+	for _, domain := range domains {
+		for i := range domain.SourceIPs {
+			ip := domain.SourceIPs[i]
+			if prefix, err := ipset.ParsePrefix(ip.IP); err == nil {
+				if seen.Has(prefix) {
+					continue
+				}
+				seen.Add(prefix)
+			}
+			result = append(result, &ip)
+		}
+	}
+	return result
+}
+
+// vipList 解析响应中的Vips字段，兼容 {"Vip": [...]} 和直接的数组两种结构
+func vipList(value interface{}) []string {
+	if wrapper, ok := value.(map[string]interface{}); ok {
+		value = wrapper["Vip"]
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	vips := make([]string, 0, len(list))
+	for _, item := range list {
+		if vip, ok := item.(string); ok && vip != "" {
+			vips = append(vips, vip)
+		}
+	}
+	return vips
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// dbWhitelistProduct 数据库产品白名单接口的差异
+// RDS、PolarDB和Redis的白名单接口结构相同，只有接口名和字段名不同
+type dbWhitelistProduct struct {
+	name        string // 日志和限流使用的产品名
+	envPrefix   string
+	endpoint    string
+	version     string
+	idParam     string // 实例ID参数名
+	describe    string
+	modify      string
+	nameParam   string // ModifySecurityIps等接口中的分组名参数
+	listKey     string // 分组列表的外层字段
+	itemKey     string // 分组列表的内层字段
+	nameField   string
+	ipsField    string
+	attrField   string
+	extraParams map[string]string
+}
+
+// dbWhitelistProducts 支持的数据库产品，按写入目标类型索引
+var dbWhitelistProducts = map[string]dbWhitelistProduct{
+	"rds_whitelist": {
+		name:      "rds",
+		envPrefix: "RDS_",
+		endpoint:  "rds.aliyuncs.com",
+		version:   "2014-08-15",
+		idParam:   "DBInstanceId",
+		describe:  "DescribeDBInstanceIPArrayList",
+		modify:    "ModifySecurityIps",
+		nameParam: "DBInstanceIPArrayName",
+		listKey:   "Items",
+		itemKey:   "DBInstanceIPArray",
+		nameField: "DBInstanceIPArrayName",
+		ipsField:  "SecurityIPList",
+		attrField: "DBInstanceIPArrayAttribute",
+	},
+	"polardb_whitelist": {
+		name:        "polardb",
+		envPrefix:   "POLARDB_",
+		endpoint:    "polardb.aliyuncs.com",
+		version:     "2017-08-01",
+		idParam:     "DBClusterId",
+		describe:    "DescribeDBClusterAccessWhitelist",
+		modify:      "ModifyDBClusterAccessWhitelist",
+		nameParam:   "DBClusterIPArrayName",
+		listKey:     "Items",
+		itemKey:     "DBClusterIPArray",
+		nameField:   "DBClusterIPArrayName",
+		ipsField:    "SecurityIps",
+		attrField:   "DBClusterIPArrayAttribute",
+		extraParams: map[string]string{"WhiteListType": "IP"},
+	},
+	"redis_whitelist": {
+		name:      "redis",
+		envPrefix: "REDIS_",
+		endpoint:  "r-kvstore.aliyuncs.com",
+		version:   "2015-01-01",
+		idParam:   "InstanceId",
+		describe:  "DescribeSecurityIps",
+		modify:    "ModifySecurityIps",
+		nameParam: "SecurityIpGroupName",
+		listKey:   "SecurityIpGroups",
+		itemKey:   "SecurityIpGroup",
+		nameField: "SecurityIpGroupName",
+		ipsField:  "SecurityIpList",
+		attrField: "SecurityIpGroupAttribute",
+	},
+}
+
+// DBWhitelistClient RDS、PolarDB、Redis的IP白名单客户端
+type DBWhitelistClient struct {
+	product dbWhitelistProduct
+	region  string
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewDBWhitelistClient 根据写入目标类型创建数据库白名单客户端
+func NewDBWhitelistClient(sinkType string, cfg *config.AliyunConfig) (*DBWhitelistClient, error) {
+	product, ok := dbWhitelistProducts[sinkType]
+	if !ok {
+		return nil, fmt.Errorf("不支持的数据库白名单类型: %s", sinkType)
+	}
+
+	// 三个产品都提供中心化endpoint，通过RegionId区分区域
+	client, err := newOpenAPIClient(cfg, product.envPrefix, product.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("创建 %s 客户端失败: %v", strings.ToUpper(product.name), err)
+	}
+
+	return &DBWhitelistClient{
+		product: product,
+		region:  cfg.Region,
+		client:  client,
+		limiter: limiterFor(product.name, cfg, product.envPrefix),
+	}, nil
+}
+
+// ListWhitelistGroups 查询实例的全部白名单分组
+func (c *DBWhitelistClient) ListWhitelistGroups(ctx context.Context, instanceID string) ([]models.WhitelistGroup, error) {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
+	p := c.product
+	body, err := callRPC(c.client, p.describe, p.version, map[string]string{
+		"RegionId": c.region,
+		p.idParam:  instanceID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("调用%s API失败（实例 %s）: %w", p.describe, instanceID, err)
+	}
+
+	wrapper, _ := body[p.listKey].(map[string]interface{})
+	list, _ := wrapper[p.itemKey].([]interface{})
+	groups := make([]models.WhitelistGroup, 0, len(list))
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group := models.WhitelistGroup{}
+		group.Name, _ = fields[p.nameField].(string)
+		group.Attribute, _ = fields[p.attrField].(string)
+		ips, _ := fields[p.ipsField].(string)
+		for _, entry := range strings.Split(ips, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				group.Entries = append(group.Entries, canonicalEntry(entry))
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// SetWhitelistGroup 以覆盖方式写入指定分组，分组不存在时由接口创建，其他分组不受影响
+func (c *DBWhitelistClient) SetWhitelistGroup(ctx context.Context, instanceID, name string, entries []string) error {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return err
+	}
+
+	p := c.product
+	query := map[string]string{
+		"RegionId":    c.region,
+		p.idParam:     instanceID,
+		p.nameParam:   name,
+		"SecurityIps": strings.Join(entries, ","),
+		"ModifyMode":  "Cover",
+	}
+	for key, value := range p.extraParams {
+		query[key] = value
+	}
+
+	if _, err := callRPC(c.client, p.modify, p.version, query); err != nil {
+		return fmt.Errorf("调用%s API失败（实例 %s）: %w", p.modify, instanceID, err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -10,6 +11,7 @@ import (
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
 	credential "github.com/aliyun/credentials-go/credentials"
+	"golang.org/x/time/rate"
 
 	"aliyun-dcdn-firewall-sync/internal/config"
 	"aliyun-dcdn-firewall-sync/pkg/models"
@@ -17,21 +19,23 @@ import (
 
 // DCDNClient 阿里云DCDN客户端
 type DCDNClient struct {
-	config *config.DCDNConfig
-	client *dcdn20180115.Client
+	config  *config.DCDNConfig
+	client  *dcdn20180115.Client
+	limiter *rate.Limiter
 }
 
 // NewDCDNClient 创建新的DCDN客户端
-func NewDCDNClient(cfg *config.DCDNConfig) *DCDNClient {
+func NewDCDNClient(cfg *config.DCDNConfig) (*DCDNClient, error) {
 	client, err := createClient(&cfg.AliyunConfig)
 	if err != nil {
-		panic(fmt.Sprintf("创建DCDN客户端失败: %v", err))
+		return nil, fmt.Errorf("创建DCDN客户端失败: %v", err)
 	}
 
 	return &DCDNClient{
-		config: cfg,
-		client: client,
-	}
+		config:  cfg,
+		client:  client,
+		limiter: limiterFor("dcdn", &cfg.AliyunConfig, "DCDN_"),
+	}, nil
 }
 
 // createClient 使用凭证初始化账号Client
@@ -61,7 +65,7 @@ func createClient(cfg *config.AliyunConfig) (*dcdn20180115.Client, error) {
 		Credential: cred,
 		RegionId:   tea.String(cfg.Region),
 	}
-	
+
 	// 根据区域设置对应的endpoint
 	// DCDN服务默认使用全球endpoint
 	config.Endpoint = tea.String("dcdn.aliyuncs.com")
@@ -75,13 +79,19 @@ func createClient(cfg *config.AliyunConfig) (*dcdn20180115.Client, error) {
 }
 
 // QuerySourceIPs 查询DCDN L2节点IP段
-func (c *DCDNClient) QuerySourceIPs(domains []string) ([]*models.DCDNSourceIPInfo, error) {
+// DescribeDcdnL2Ips返回账号下全部L2节点IP，不区分域名，domains参数仅为兼容保留；
+// 需要按域名查询的经典CDN见CDNClient
+func (c *DCDNClient) QuerySourceIPs(ctx context.Context, domains []string) ([]*models.DCDNSourceIPInfo, error) {
 	runtime := &util.RuntimeOptions{}
 
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
 	// 调用DescribeDcdnL2IpsWithOptions获取L2节点IP段
 	response, err := c.client.DescribeDcdnL2IpsWithOptions(runtime)
 	if err != nil {
-		return nil, fmt.Errorf("调用DescribeDcdnL2Ips API失败: %w", err)
+		return nil, fmt.Errorf("调用DescribeDcdnL2Ips API失败: %w", classifyError(err))
 	}
 
 	if response.Body == nil {
@@ -113,6 +123,7 @@ func (c *DCDNClient) parseL2IPs(responseBody *dcdn20180115.DescribeDcdnL2IpsResp
 			ISP:         "阿里云",    // 默认值
 			Status:      "Active",
 			LastUpdated: time.Now(), // 使用当前时间
+			Origin:      config.SourceDCDNL2,
 		}
 
 		sourceIPs = append(sourceIPs, sourceIP)
@@ -122,9 +133,9 @@ func (c *DCDNClient) parseL2IPs(responseBody *dcdn20180115.DescribeDcdnL2IpsResp
 }
 
 // GetL2IPList 获取DCDN L2节点IP列表（别名方法，保持兼容性）
-func (c *DCDNClient) GetL2IPList() ([]*models.DCDNSourceIPInfo, error) {
+func (c *DCDNClient) GetL2IPList(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
 	// 不需要域名列表，直接调用QuerySourceIPs
-	return c.QuerySourceIPs(nil)
+	return c.QuerySourceIPs(ctx, nil)
 }
 
 // createDCDNCredential 创建DCDN专用凭证
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// ecsAPIVersion ECS产品的OpenAPI版本
+const ecsAPIVersion = "2014-05-26"
+
+// ecsBatchSize AuthorizeSecurityGroup和RevokeSecurityGroup单次调用的规则数上限
+const ecsBatchSize = 100
+
+// ECSClient 阿里云ECS客户端，用于维护安全组规则
+type ECSClient struct {
+	region  string
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewECSClient 创建新的ECS客户端
+func NewECSClient(cfg *config.AliyunConfig) (*ECSClient, error) {
+	client, err := newOpenAPIClient(cfg, "ECS_", fmt.Sprintf("ecs.%s.aliyuncs.com", cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("创建 ECS 客户端失败: %v", err)
+	}
+
+	return &ECSClient{
+		region:  cfg.Region,
+		client:  client,
+		limiter: limiterFor("ecs", cfg, "ECS_"),
+	}, nil
+}
+
+// ListSecurityGroupRules 查询安全组的全部规则（包含出方向）
+func (c *ECSClient) ListSecurityGroupRules(ctx context.Context, securityGroupID string) ([]models.SecurityGroupRule, error) {
+	var rules []models.SecurityGroupRule
+	nextToken := ""
+	for {
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return nil, err
+		}
+
+		query := map[string]string{
+			"RegionId":        c.region,
+			"SecurityGroupId": securityGroupID,
+			"MaxResults":      "1000",
+		}
+		if nextToken != "" {
+			query["NextToken"] = nextToken
+		}
+		body, err := callRPC(c.client, "DescribeSecurityGroupAttribute", ecsAPIVersion, query)
+		if err != nil {
+			return nil, fmt.Errorf("调用DescribeSecurityGroupAttribute API失败（安全组 %s）: %w", securityGroupID, err)
+		}
+
+		permissions, _ := body["Permissions"].(map[string]interface{})
+		list, _ := permissions["Permission"].([]interface{})
+		for _, item := range list {
+			if fields, ok := item.(map[string]interface{}); ok {
+				rules = append(rules, convertSecurityGroupRule(fields))
+			}
+		}
+
+		nextToken, _ = body["NextToken"].(string)
+		if nextToken == "" {
+			return rules, nil
+		}
+	}
+}
+
+// AuthorizeRules 添加入方向规则，按单次调用上限分批
+func (c *ECSClient) AuthorizeRules(ctx context.Context, securityGroupID string, rules []models.SecurityGroupRule) error {
+	for start := 0; start < len(rules); start += ecsBatchSize {
+		end := min(start+ecsBatchSize, len(rules))
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
+
+		query := map[string]string{
+			"RegionId":        c.region,
+			"SecurityGroupId": securityGroupID,
+		}
+		for i, rule := range rules[start:end] {
+			prefix := fmt.Sprintf("Permissions.%d.", i+1)
+			query[prefix+"IpProtocol"] = rule.Protocol
+			query[prefix+"PortRange"] = rule.PortRange
+			query[prefix+"Policy"] = rule.Policy
+			query[prefix+"Priority"] = strconv.Itoa(rule.Priority)
+			query[prefix+"NicType"] = rule.NicType
+			query[prefix+"Description"] = rule.Description
+			if strings.Contains(rule.SourceCIDR, ":") {
+				query[prefix+"Ipv6SourceCidrIp"] = rule.SourceCIDR
+			} else {
+				query[prefix+"SourceCidrIp"] = rule.SourceCIDR
+			}
+		}
+
+		if _, err := callRPC(c.client, "AuthorizeSecurityGroup", ecsAPIVersion, query); err != nil {
+			return fmt.Errorf("调用AuthorizeSecurityGroup API失败（安全组 %s）: %w", securityGroupID, err)
+		}
+	}
+	return nil
+}
+
+// RevokeRules 按规则ID删除入方向规则，按单次调用上限分批
+func (c *ECSClient) RevokeRules(ctx context.Context, securityGroupID string, rules []models.SecurityGroupRule) error {
+	for start := 0; start < len(rules); start += ecsBatchSize {
+		end := min(start+ecsBatchSize, len(rules))
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
+
+		query := map[string]string{
+			"RegionId":        c.region,
+			"SecurityGroupId": securityGroupID,
+		}
+		for i, rule := range rules[start:end] {
+			query[fmt.Sprintf("SecurityGroupRuleId.%d", i+1)] = rule.RuleID
+		}
+
+		if _, err := callRPC(c.client, "RevokeSecurityGroup", ecsAPIVersion, query); err != nil {
+			return fmt.Errorf("调用RevokeSecurityGroup API失败（安全组 %s）: %w", securityGroupID, err)
+		}
+	}
+	return nil
+}
+
+// convertSecurityGroupRule 转换API返回的规则，协议和策略统一为小写，地址统一为规范CIDR
+func convertSecurityGroupRule(fields map[string]interface{}) models.SecurityGroupRule {
+	text := func(key string) string {
+		value, _ := fields[key].(string)
+		return value
+	}
+
+	source := text("SourceCidrIp")
+	if source == "" {
+		source = text("Ipv6SourceCidrIp")
+	}
+	if prefix, err := ipset.ParsePrefix(source); err == nil {
+		source = prefix.String()
+	}
+
+	// 响应按json.Number解析，部分接口版本以字符串返回
+	priority, _ := strconv.Atoi(fmt.Sprint(fields["Priority"]))
+
+	return models.SecurityGroupRule{
+		RuleID:      text("SecurityGroupRuleId"),
+		Direction:   strings.ToLower(text("Direction")),
+		Protocol:    strings.ToLower(text("IpProtocol")),
+		PortRange:   text("PortRange"),
+		SourceCIDR:  source,
+		Policy:      strings.ToLower(text("Policy")),
+		Priority:    priority,
+		NicType:     strings.ToLower(text("NicType")),
+		Description: text("Description"),
+	}
+}
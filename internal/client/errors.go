@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/alibabacloud-go/tea/tea"
+
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// ErrorClass 阿里云API错误分类
+type ErrorClass string
+
+const (
+	ErrorClassThrottled     ErrorClass = "throttled"      // 触发限流，如 Throttling.User
+	ErrorClassAuth          ErrorClass = "auth"           // 凭证无效或权限不足
+	ErrorClassNotFound      ErrorClass = "not_found"      // 资源不存在
+	ErrorClassQuotaExceeded ErrorClass = "quota_exceeded" // 超出配额
+	ErrorClassInvalidParam  ErrorClass = "invalid_param"  // 参数错误
+	ErrorClassTransient     ErrorClass = "transient"      // 服务端暂时性错误或网络错误
+	ErrorClassUnknown       ErrorClass = "unknown"        // 无法分类
+)
+
+// APIError 带分类信息的API错误
+type APIError struct {
+	Class      ErrorClass
+	Code       string
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+// Error 实现error接口
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("[%s] %v", e.Class, e.Err)
+	}
+	return fmt.Sprintf("[%s] %s: %s", e.Class, e.Code, e.Message)
+}
+
+// Unwrap 返回原始错误
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable 判断该错误是否值得重试
+func (e *APIError) Retryable() bool {
+	return e.Class == ErrorClassThrottled || e.Class == ErrorClassTransient
+}
+
+// classifyError 将SDK返回的错误包装为APIError
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) {
+		code := tea.StringValue(sdkErr.Code)
+		statusCode := tea.IntValue(sdkErr.StatusCode)
+		return &APIError{
+			Class:      classifyCode(code, statusCode),
+			Code:       code,
+			StatusCode: statusCode,
+			Message:    tea.StringValue(sdkErr.Message),
+			Err:        err,
+		}
+	}
+
+	class := ErrorClassUnknown
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		class = ErrorClassTransient
+	}
+
+	return &APIError{Class: class, Err: err}
+}
+
+// classifyCode 根据错误码和HTTP状态码确定错误分类
+func classifyCode(code string, statusCode int) ErrorClass {
+	lower := strings.ToLower(code)
+
+	switch {
+	case strings.HasPrefix(lower, "throttling"), statusCode == 429:
+		return ErrorClassThrottled
+	case strings.HasPrefix(lower, "invalidaccesskeyid"),
+		strings.HasPrefix(lower, "invalidsecuritytoken"),
+		strings.HasPrefix(lower, "signaturedoesnotmatch"),
+		strings.HasPrefix(lower, "incompletesignature"),
+		strings.HasPrefix(lower, "forbidden"),
+		strings.Contains(lower, "nopermission"),
+		strings.Contains(lower, "unauthorized"),
+		statusCode == 401, statusCode == 403:
+		return ErrorClassAuth
+	case strings.Contains(lower, "quota"),
+		strings.Contains(lower, "limitexceeded"),
+		strings.Contains(lower, "exceed"):
+		return ErrorClassQuotaExceeded
+	case strings.Contains(lower, "notfound"),
+		strings.Contains(lower, "notexist"),
+		statusCode == 404:
+		return ErrorClassNotFound
+	case strings.HasPrefix(lower, "serviceunavailable"),
+		strings.HasPrefix(lower, "internalerror"),
+		strings.HasPrefix(lower, "sdk.serverunreachable"),
+		strings.HasPrefix(lower, "incorrectstatus"),          // 资源正在配置中，如ALB ACL处于Configuring状态
+		strings.HasPrefix(lower, "incorrectdbinstancestate"), // 数据库实例正在变更中，如上一次白名单修改尚未生效
+		strings.HasPrefix(lower, "incorrectinstancestatus"),
+		strings.Contains(lower, "timeout"),
+		statusCode >= 500:
+		return ErrorClassTransient
+	case strings.HasPrefix(lower, "invalidparam"),
+		strings.HasPrefix(lower, "missingparam"),
+		strings.HasPrefix(lower, "invalid"),
+		strings.HasPrefix(lower, "missing"),
+		statusCode == 400:
+		return ErrorClassInvalidParam
+	}
+
+	return ErrorClassUnknown
+}
+
+// ErrorClassOf 返回错误链中APIError的分类
+func ErrorClassOf(err error) ErrorClass {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Class
+	}
+	return ErrorClassUnknown
+}
+
+// HTTPError 将HTTP请求的错误包装为带分类的错误，限流、服务端错误和网络错误可以重试
+func HTTPError(err error, statusCode int) error {
+	class := ErrorClassUnknown
+	var netErr net.Error
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		class = ErrorClassThrottled
+	case statusCode >= 500:
+		class = ErrorClassTransient
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		class = ErrorClassAuth
+	case statusCode == http.StatusNotFound:
+		class = ErrorClassNotFound
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		class = ErrorClassTransient
+	}
+	return &APIError{Class: class, StatusCode: statusCode, Err: err}
+}
+
+// IsRetryable 判断错误是否属于可重试的分类（限流或暂时性错误）
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return false
+}
+
+// ConflictError 写入前发现地址薄已被他人修改
+type ConflictError struct {
+	Conflict *models.AddressBookConflict
+}
+
+// Error 实现error接口
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("地址薄 %s 发生并发修改: %s（外部新增 %d 条，外部删除 %d 条）",
+		e.Conflict.GroupName, e.Conflict.Reason, len(e.Conflict.ForeignAdded), len(e.Conflict.ForeignRemoved))
+}
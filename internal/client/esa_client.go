@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// esaAPIVersion ESA产品的OpenAPI版本
+const esaAPIVersion = "2024-09-10"
+
+// ESAClient 阿里云边缘安全加速（ESA）客户端，用于获取站点的回源IP白名单
+type ESAClient struct {
+	config  *config.ESAConfig
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewESAClient 创建新的ESA客户端
+func NewESAClient(cfg *config.ESAConfig) (*ESAClient, error) {
+	// ESA按区域提供endpoint，中国站为cn-hangzhou，国际站为ap-southeast-1
+	client, err := newOpenAPIClient(&cfg.AliyunConfig, "ESA_", fmt.Sprintf("esa.%s.aliyuncs.com", cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("创建 ESA 客户端失败: %v", err)
+	}
+
+	return &ESAClient{
+		config:  cfg,
+		client:  client,
+		limiter: limiterFor("esa", &cfg.AliyunConfig, "ESA_"),
+	}, nil
+}
+
+// OriginWhitelist 查询站点的回源IP白名单
+// 按whitelist配置返回当前生效的列表、最新列表或二者的并集；只在最新列表中出现的条目状态为Pending
+func (c *ESAClient) OriginWhitelist(ctx context.Context, siteID int64) ([]*models.DCDNSourceIPInfo, error) {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
+	site := strconv.FormatInt(siteID, 10)
+	body, err := callRPC(c.client, "GetOriginProtection", esaAPIVersion, map[string]string{"SiteId": site})
+	if err != nil {
+		return nil, fmt.Errorf("调用GetOriginProtection API失败（站点 %s）: %w", site, err)
+	}
+
+	var lists [][]string
+	var statuses []string
+	if c.config.Whitelist != "latest" {
+		lists = append(lists, whitelistEntries(body["CurrentIPWhitelist"]))
+		statuses = append(statuses, "Active")
+	}
+	if c.config.Whitelist != "current" {
+		lists = append(lists, whitelistEntries(body["LatestIPWhitelist"]))
+		statuses = append(statuses, "Pending")
+	}
+
+	now := time.Now()
+	seen := &ipset.Set{}
+	var result []*models.DCDNSourceIPInfo
+	for i, list := range lists {
+		for _, entry := range list {
+			if prefix, err := ipset.ParsePrefix(entry); err == nil {
+				if seen.Has(prefix) {
+					continue
+				}
+				seen.Add(prefix)
+			}
+			result = append(result, &models.DCDNSourceIPInfo{
+				IP:          entry,
+				Location:    "Global",
+				ISP:         "阿里云ESA",
+				Status:      statuses[i],
+				LastUpdated: now,
+				Origin:      "esa:" + site,
+			})
+		}
+	}
+
+	// 未开启源站防护的站点没有白名单，返回空列表会清空地址薄，按错误处理
+	if len(result) == 0 {
+		return nil, fmt.Errorf("站点 %s 没有回源IP白名单（源站防护状态: %v），请先在ESA控制台开启源站防护", site, body["OriginProtection"])
+	}
+	return result, nil
+}
+
+// QuerySites 逐个查询站点的回源IP白名单并合并去重，任一站点查询失败时返回错误
+func (c *ESAClient) QuerySites(ctx context.Context, siteIDs []int64) ([]*models.DCDNSourceIPInfo, error) {
+	seen := &ipset.Set{}
+	var result []*models.DCDNSourceIPInfo
+	for _, siteID := range siteIDs {
+		list, err := c.OriginWhitelist(ctx, siteID)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("ESA站点 %d: 回源IP白名单 %d 条", siteID, len(list))
+
+		for _, ip := range list {
+			if prefix, err := ipset.ParsePrefix(ip.IP); err == nil {
+				if seen.Has(prefix) {
+					continue
+				}
+				seen.Add(prefix)
+			}
+			result = append(result, ip)
+		}
+	}
+	return result, nil
+}
+
+// whitelistEntries 解析白名单字段，包含IPv4和IPv6两个列表
+func whitelistEntries(value interface{}) []string {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var entries []string
+	for _, key := range []string{"IPv4", "IPv6"} {
+		list, _ := fields[key].([]interface{})
+		for _, item := range list {
+			if entry, ok := item.(string); ok && entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
@@ -1,13 +1,15 @@
 package client
 
 import (
+	"context"
 	"fmt"
-	"net"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
 	"aliyun-dcdn-firewall-sync/pkg/models"
 
 	cloudfw20171207 "github.com/alibabacloud-go/cloudfw-20171207/v8/client"
@@ -15,17 +17,26 @@ import (
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
 	credential "github.com/aliyun/credentials-go/credentials"
+	"golang.org/x/time/rate"
 )
 
+// FirewallAPI 地址薄相关的云防火墙API，由SDK客户端实现，测试时可替换为内存实现
+type FirewallAPI interface {
+	DescribeAddressBookWithOptions(request *cloudfw20171207.DescribeAddressBookRequest, runtime *util.RuntimeOptions) (*cloudfw20171207.DescribeAddressBookResponse, error)
+	AddAddressBookWithOptions(request *cloudfw20171207.AddAddressBookRequest, runtime *util.RuntimeOptions) (*cloudfw20171207.AddAddressBookResponse, error)
+	ModifyAddressBookWithOptions(request *cloudfw20171207.ModifyAddressBookRequest, runtime *util.RuntimeOptions) (*cloudfw20171207.ModifyAddressBookResponse, error)
+	DeleteAddressBookWithOptions(request *cloudfw20171207.DeleteAddressBookRequest, runtime *util.RuntimeOptions) (*cloudfw20171207.DeleteAddressBookResponse, error)
+}
+
 // FirewallClient 阿里云云防火墙客户端
 type FirewallClient struct {
-	config *config.FirewallConfig
-	sync   *config.SyncConfig
-	client *cloudfw20171207.Client
+	config  *config.FirewallConfig
+	client  FirewallAPI
+	limiter *rate.Limiter
 }
 
 // NewFirewallClient 创建新的云防火墙客户端
-func NewFirewallClient(cfg *config.FirewallConfig, sync *config.SyncConfig) *FirewallClient {
+func NewFirewallClient(cfg *config.FirewallConfig) *FirewallClient {
 	// 初始化安全凭证
 	cred, err := initializeCredential(&cfg.AliyunConfig)
 	if err != nil {
@@ -51,10 +62,15 @@ func NewFirewallClient(cfg *config.FirewallConfig, sync *config.SyncConfig) *Fir
 		panic(fmt.Sprintf("创建防火墙客户端失败: %v", err))
 	}
 
+	return NewFirewallClientWithAPI(cfg, client)
+}
+
+// NewFirewallClientWithAPI 使用已有的API实现创建云防火墙客户端
+func NewFirewallClientWithAPI(cfg *config.FirewallConfig, api FirewallAPI) *FirewallClient {
 	return &FirewallClient{
-		config: cfg,
-		sync:   sync,
-		client: client,
+		config:  cfg,
+		client:  api,
+		limiter: limiterFor("cloudfw", &cfg.AliyunConfig, "FIREWALL_"),
 	}
 }
 
@@ -97,49 +113,74 @@ func initializeCredential(cfg *config.AliyunConfig) (credential.Credential, erro
 	return credential.NewCredential(nil)
 }
 
-// GetAddressBookByName 根据名称获取单个地址薄的详细信息
-func (c *FirewallClient) GetAddressBookByName(groupName string) (*models.FirewallAddressBook, error) {
+// GetAddressBookByName 根据名称获取单个IPv4地址薄的详细信息
+func (c *FirewallClient) GetAddressBookByName(ctx context.Context, groupName string) (*models.FirewallAddressBook, error) {
+	return c.FindAddressBook(ctx, groupName, "ip")
+}
+
+// FindAddressBook 根据名称和地址薄类型（ip、ipv6）获取单个地址薄，不存在时返回nil
+func (c *FirewallClient) FindAddressBook(ctx context.Context, groupName, groupType string) (*models.FirewallAddressBook, error) {
 	fmt.Printf("DEBUG: 开始根据名称获取地址薄详情: %s\n", groupName)
 
-	// 使用精确的名称查询
-	request := &cloudfw20171207.DescribeAddressBookRequest{
-		PageSize:    tea.String("50"),
-		CurrentPage: tea.String("1"),
-		Lang:        tea.String("zh"),
-		GroupType:   tea.String("ip"),
+	// 使用名称查询后再精确匹配
+	books, err := c.ListAddressBooks(ctx, groupName, groupType)
+	if err != nil {
+		return nil, err
 	}
 
+	for _, book := range books {
+		if book.GroupName == groupName {
+			return book, nil
+		}
+	}
+
+	return nil, nil // 未找到精确匹配的地址薄
+}
+
+// ListAddressBooks 分页查询名称包含query的地址薄
+func (c *FirewallClient) ListAddressBooks(ctx context.Context, query, groupType string) ([]*models.FirewallAddressBook, error) {
+	var books []*models.FirewallAddressBook
 	runtime := &util.RuntimeOptions{}
 
-	response, err := c.client.DescribeAddressBookWithOptions(request, runtime)
-	if err != nil {
-		return nil, fmt.Errorf("调用DescribeAddressBook API失败: %v", err)
-	}
+	for page := 1; ; page++ {
+		request := &cloudfw20171207.DescribeAddressBookRequest{
+			PageSize:    tea.String("50"),
+			CurrentPage: tea.String(strconv.Itoa(page)),
+			Lang:        tea.String("zh"),
+			GroupType:   tea.String(groupType),
+			Query:       tea.String(query),
+		}
 
-	if response.Body == nil {
-		return nil, fmt.Errorf("API响应体为空")
-	}
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return nil, err
+		}
 
-	if len(response.Body.Acls) == 0 {
-		return nil, nil // 地址薄不存在
-	}
+		response, err := c.client.DescribeAddressBookWithOptions(request, runtime)
+		if err != nil {
+			return nil, fmt.Errorf("调用DescribeAddressBook API失败: %w", classifyError(err))
+		}
 
-	// 查找匹配的地址簿
-	var targetAcl *cloudfw20171207.DescribeAddressBookResponseBodyAcls
-	for _, acl := range response.Body.Acls {
-		if acl != nil && tea.StringValue(acl.GroupName) == groupName {
-			targetAcl = acl
-			break
+		if response.Body == nil {
+			return nil, fmt.Errorf("API响应体为空")
 		}
-	}
 
-	if targetAcl == nil {
-		return nil, nil // 未找到精确匹配的地址薄
+		for _, acl := range response.Body.Acls {
+			if acl != nil {
+				books = append(books, convertAddressBook(acl))
+			}
+		}
+
+		total, _ := strconv.Atoi(tea.StringValue(response.Body.TotalCount))
+		if len(response.Body.Acls) == 0 || len(books) >= total {
+			return books, nil
+		}
 	}
+}
 
-	// 转换为内部数据结构
+// convertAddressBook 转换为内部数据结构
+func convertAddressBook(acl *cloudfw20171207.DescribeAddressBookResponseBodyAcls) *models.FirewallAddressBook {
 	var entries []models.FirewallAddressEntry
-	for _, addr := range targetAcl.AddressList {
+	for _, addr := range acl.AddressList {
 		if addr == nil {
 			continue
 		}
@@ -154,175 +195,204 @@ func (c *FirewallClient) GetAddressBookByName(groupName string) (*models.Firewal
 	}
 
 	return &models.FirewallAddressBook{
-		GroupId:     tea.StringValue(targetAcl.GroupUuid),
-		GroupName:   tea.StringValue(targetAcl.GroupName),
-		Description: tea.StringValue(targetAcl.Description),
-		UpdateTime:  time.Now(),
-		Entries:     entries,
-	}, nil
+		GroupId:        tea.StringValue(acl.GroupUuid),
+		GroupName:      tea.StringValue(acl.GroupName),
+		GroupType:      tea.StringValue(acl.GroupType),
+		Description:    tea.StringValue(acl.Description),
+		ReferenceCount: int(tea.Int32Value(acl.ReferenceCount)),
+		UpdateTime:     time.Now(),
+		Entries:        entries,
+	}
 }
 
-// SyncAddressBook 同步地址薄
-func (c *FirewallClient) SyncAddressBook(groupName string, sourceIPs []*models.DCDNSourceIPInfo) error {
-	// 1. 准备新的IP地址集合（过滤无效IP并进行格式化）
-	var newIPs []string
+// DeleteAddressBook 删除地址薄，被访问控制策略引用的地址薄会删除失败
+func (c *FirewallClient) DeleteAddressBook(ctx context.Context, book *models.FirewallAddressBook) error {
+	request := &cloudfw20171207.DeleteAddressBookRequest{
+		GroupUuid: tea.String(book.GroupId),
+		Lang:      tea.String("zh"),
+	}
 
-	fmt.Printf("DEBUG: 开始处理地址薄: %s\n", groupName)
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return err
+	}
+	if _, err := c.client.DeleteAddressBookWithOptions(request, &util.RuntimeOptions{}); err != nil {
+		return fmt.Errorf("删除地址薄 %s 失败: %w", book.GroupName, classifyError(err))
+	}
+	fmt.Printf("成功删除地址薄 %s\n", book.GroupName)
+	return nil
+}
+
+// normalizeAddressList 过滤无效IP并格式化为地址薄使用的地址列表
+// ip类型地址薄只保留IPv4地址，ipv6类型地址薄只保留IPv6地址；结果为去重、排序后的规范CIDR形式
+func (c *FirewallClient) normalizeAddressList(sourceIPs []*models.DCDNSourceIPInfo, groupType string) []string {
+	set := &ipset.Set{}
+	wantIPv6 := groupType == "ipv6"
 
-	// 处理IP地址
 	for _, ip := range sourceIPs {
-		if !c.isValidIP(ip.IP) {
+		prefix, err := ipset.ParsePrefix(ip.IP)
+		if err != nil {
 			fmt.Printf("DEBUG: 跳过无效IP: %s\n", ip.IP)
 			continue
 		}
-
-		ipStr := ip.IP
-
-		// 解析IP和CIDR
-		var ipNet *net.IPNet
-		var parsedIP net.IP
-		var err error
-
-		if strings.Contains(ipStr, "/") {
-			// CIDR格式
-			parsedIP, ipNet, err = net.ParseCIDR(ipStr)
-			if err != nil {
-				fmt.Printf("DEBUG: 无法解析CIDR: %s, 错误: %v\n", ipStr, err)
-				continue
-			}
-			// 确保是IPv4地址
-			if parsedIP.To4() == nil {
-				continue
-			}
-			ipStr = ipNet.String()
-		} else {
-			// 单个IP地址
-			parsedIP = net.ParseIP(ipStr)
-			if parsedIP == nil {
-				fmt.Printf("DEBUG: 无法解析IP: %s\n", ipStr)
-				continue
-			}
-			// 确保是IPv4地址
-			if parsedIP.To4() == nil {
-				continue
-			}
-			ipStr = parsedIP.String()
+		// 确保地址族与地址薄类型一致
+		if prefix.Addr().Is6() != wantIPv6 {
+			continue
 		}
+		set.Add(prefix)
+	}
+
+	return set.Strings()
+}
 
-		fmt.Printf("DEBUG: 添加IPv4地址: %s\n", ipStr)
-		newIPs = append(newIPs, ipStr)
+// AddressBookSpec 根据地址组配置生成地址薄描述
+func (c *FirewallClient) AddressBookSpec(group config.AddressGroup) models.AddressBookSpec {
+	groupType := "ip"
+	if group.IPType == "ipv6" {
+		groupType = "ipv6"
 	}
+	return models.AddressBookSpec{
+		GroupName:   group.GroupName,
+		Description: group.Description,
+		GroupType:   groupType,
+	}
+}
+
+// PlanAddressBook 读取地址薄当前内容并计算同步计划
+// 计划中记录了读取到的条目，写入前会据此检查地址薄是否被他人修改
+func (c *FirewallClient) PlanAddressBook(ctx context.Context, spec models.AddressBookSpec, sourceIPs []*models.DCDNSourceIPInfo) (*models.AddressBookPlan, error) {
+	fmt.Printf("DEBUG: 开始处理地址薄: %s\n", spec.GroupName)
 
+	// 1. 准备新的IP地址集合（过滤无效IP并进行格式化）
+	newIPs := c.normalizeAddressList(sourceIPs, spec.GroupType)
 	fmt.Printf("DEBUG: 过滤后的IP数量: %d\n", len(newIPs))
 
 	// 2. 获取地址薄信息
-	targetBook, err := c.GetAddressBookByName(groupName)
+	targetBook, err := c.FindAddressBook(ctx, spec.GroupName, spec.GroupType)
 	if err != nil {
-		return fmt.Errorf("获取地址薄信息失败: %v", err)
+		return nil, fmt.Errorf("获取地址薄信息失败: %w", err)
 	}
 
-	runtime := &util.RuntimeOptions{}
+	plan := &models.AddressBookPlan{
+		GroupName:   spec.GroupName,
+		GroupType:   spec.GroupType,
+		Description: spec.Description,
+		Desired:     newIPs,
+		PlannedAt:   time.Now(),
+	}
+	if targetBook != nil {
+		plan.Exists = true
+		plan.GroupId = targetBook.GroupId
+		plan.Observed = targetBook.AddressList()
+	}
+	plan.ToAdd, plan.ToRemove = c.calculateIPDifferences(plan.Observed, plan.Desired)
 
-	if targetBook == nil {
-		// 获取地址薄配置
-		var addressGroup *config.AddressGroup
-		for _, group := range c.sync.AddressGroups {
-			if group.GroupName == groupName {
-				addressGroup = &group
-				break
-			}
-		}
-		if addressGroup == nil {
-			return fmt.Errorf("未找到地址薄 %s 的配置信息", groupName)
-		}
+	return plan, nil
+}
+
+// RevisePlan 替换计划的期望地址列表并重新计算差异
+func (c *FirewallClient) RevisePlan(plan *models.AddressBookPlan, desired []string) {
+	plan.Desired = desired
+	plan.ToAdd, plan.ToRemove = c.calculateIPDifferences(plan.Observed, plan.Desired)
+}
+
+// ApplyPlan 按计划写入地址薄
+// 写入前重新读取地址薄，若内容与计划时不一致则返回ConflictError，不做任何修改
+func (c *FirewallClient) ApplyPlan(ctx context.Context, plan *models.AddressBookPlan) error {
+	// 1. 乐观并发检查
+	currentBook, err := c.FindAddressBook(ctx, plan.GroupName, plan.GroupType)
+	if err != nil {
+		return fmt.Errorf("写入前重新读取地址薄失败: %w", err)
+	}
+	if conflict := detectConflict(plan, currentBook); conflict != nil {
+		return &ConflictError{Conflict: conflict}
+	}
+
+	runtime := &util.RuntimeOptions{}
+	groupName := plan.GroupName
+	newIPs := plan.Desired
 
+	if !plan.Exists {
 		// 如果地址薄不存在，创建新的
 		request := &cloudfw20171207.AddAddressBookRequest{
-			Description:   tea.String(addressGroup.Description),
+			Description:   tea.String(plan.Description),
 			GroupName:     tea.String(groupName),
 			AddressList:   tea.String(strings.Join(newIPs, ",")),
 			AutoAddTagEcs: tea.String("false"),
 			TagRelation:   tea.String("and"),
-			GroupType:     tea.String("ip"),
+			GroupType:     tea.String(plan.GroupType),
 			Lang:          tea.String("zh"),
 		}
 
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
 		_, err = c.client.AddAddressBookWithOptions(request, runtime)
 		if err != nil {
-			return fmt.Errorf("创建地址薄失败: %v", err)
+			return fmt.Errorf("创建地址薄失败: %w", classifyError(err))
 		}
 		fmt.Printf("成功创建地址薄 %s，IP数量: %d\n", groupName, len(newIPs))
 	} else {
-		// 获取地址薄配置
-		var addressGroup *config.AddressGroup
-		for _, group := range c.sync.AddressGroups {
-			if group.GroupName == groupName {
-				addressGroup = &group
-				break
-			}
-		}
-		if addressGroup == nil {
-			return fmt.Errorf("未找到地址薄 %s 的配置信息", groupName)
-		}
-
 		// 如果地址薄已存在，直接覆盖IP列表
 		modifyRequest := &cloudfw20171207.ModifyAddressBookRequest{
-			GroupUuid:   tea.String(targetBook.GroupId),
+			GroupUuid:   tea.String(plan.GroupId),
 			GroupName:   tea.String(groupName),
-			Description: tea.String(addressGroup.Description),
+			Description: tea.String(plan.Description),
 			AddressList: tea.String(strings.Join(newIPs, ",")),
 		}
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
 		_, err = c.client.ModifyAddressBookWithOptions(modifyRequest, runtime)
 		if err != nil {
-			return fmt.Errorf("更新地址薄失败: %v", err)
+			return fmt.Errorf("更新地址薄失败: %w", classifyError(err))
 		}
-		fmt.Printf("成功更新地址薄 %s，IP数量: %d\n", groupName, len(newIPs))
+		fmt.Printf("成功更新地址薄 %s，IP数量: %d (新增 %d，移除 %d)\n",
+			groupName, len(newIPs), len(plan.ToAdd), len(plan.ToRemove))
 	}
 
 	return nil
 }
 
-// isValidIP 验证是否为有效IP地址或CIDR
-func (c *FirewallClient) isValidIP(ip string) bool {
-	// 检查是否为CIDR格式
-	if strings.Contains(ip, "/") {
-		_, _, err := net.ParseCIDR(ip)
-		return err == nil
+// detectConflict 比较地址薄当前内容与计划时读取的内容，返回外部修改的条目
+func detectConflict(plan *models.AddressBookPlan, current *models.FirewallAddressBook) *models.AddressBookConflict {
+	var currentList []string
+	if current != nil {
+		currentList = current.AddressList()
 	}
 
-	// 检查单个IP地址
-	return net.ParseIP(ip) != nil
-}
-
-// calculateIPDifferences 计算IP地址集合差异
-func (c *FirewallClient) calculateIPDifferences(existing []string, new []string) (toAdd []string, toRemove []string) {
-	// 使用map提高查找效率
-	existingMap := make(map[string]bool)
-	newMap := make(map[string]bool)
-
-	// 构建现有IP集合
-	for _, ip := range existing {
-		existingMap[ip] = true
+	conflict := &models.AddressBookConflict{
+		GroupName:  plan.GroupName,
+		DetectedAt: time.Now(),
 	}
 
-	// 构建新IP集合
-	for _, ip := range new {
-		newMap[ip] = true
+	if plan.Exists != (current != nil) || (current != nil && current.GroupId != plan.GroupId) {
+		conflict.Reason = "地址薄在计划后被创建、删除或重建"
 	}
 
-	// 找出需要添加的IP（新集合中有但现有集合中没有的）
-	for _, ip := range new {
-		if !existingMap[ip] {
-			toAdd = append(toAdd, ip)
-		}
-	}
+	observed, _ := ipset.FromStrings(plan.Observed)
+	now, _ := ipset.FromStrings(currentList)
+	conflict.ForeignAdded = now.Difference(observed).Strings()
+	conflict.ForeignRemoved = observed.Difference(now).Strings()
 
-	// 找出需要删除的IP（现有集合中有但新集合中没有的）
-	for _, ip := range existing {
-		if !newMap[ip] {
-			toRemove = append(toRemove, ip)
-		}
+	if conflict.Reason == "" && len(conflict.ForeignAdded) == 0 && len(conflict.ForeignRemoved) == 0 {
+		return nil
+	}
+	if conflict.Reason == "" {
+		conflict.Reason = "地址薄内容在计划后被修改"
 	}
+	return conflict
+}
+
+// calculateIPDifferences 计算IP地址集合差异
+// 按规范化后的网段比较，1.2.3.4 与 1.2.3.4/32 视为同一条目
+func (c *FirewallClient) calculateIPDifferences(existing []string, new []string) (toAdd []string, toRemove []string) {
+	existingSet, invalid := ipset.FromStrings(existing)
+	newSet, _ := ipset.FromStrings(new)
+
+	toAdd = newSet.Difference(existingSet).Strings()
+	toRemove = existingSet.Difference(newSet).Strings()
 
+	// 地址薄中无法解析的条目不会出现在期望列表中，覆盖写入时会被移除
+	toRemove = append(toRemove, invalid...)
 	return toAdd, toRemove
 }
@@ -0,0 +1,37 @@
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// LoadKubeConfig 加载Kubernetes客户端配置
+// kubeconfig为空时优先使用集群内配置，否则读取KUBECONFIG
+func LoadKubeConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		if restConfig, err := rest.InClusterConfig(); err == nil {
+			return restConfig, nil
+		}
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("加载Kubernetes配置失败: %v", err)
+	}
+	return restConfig, nil
+}
+
+// KubernetesNamespace 返回命名空间，为空时使用POD_NAMESPACE，仍为空时使用default
+func KubernetesNamespace(namespace string) string {
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"os"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"github.com/alibabacloud-go/tea/dara"
+	"github.com/alibabacloud-go/tea/tea"
+	credential "github.com/aliyun/credentials-go/credentials"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// 未引入产品SDK的服务（CDN、ESA等）通过通用的OpenAPI客户端以RPC风格调用
+
+// newOpenAPIClient 创建通用OpenAPI客户端
+func newOpenAPIClient(cfg *config.AliyunConfig, envPrefix, endpoint string) (*openapi.Client, error) {
+	cred, err := createPrefixedCredential(cfg, envPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("创建凭证失败: %v", err)
+	}
+
+	return openapi.NewClient(&openapi.Config{
+		Credential: cred,
+		RegionId:   tea.String(cfg.Region),
+		Endpoint:   tea.String(endpoint),
+	})
+}
+
+// callRPC 调用RPC风格的接口并返回响应体，错误已按APIError分类
+func callRPC(client *openapi.Client, action, version string, query map[string]string) (map[string]interface{}, error) {
+	params := &openapi.Params{
+		Action:      tea.String(action),
+		Version:     tea.String(version),
+		Protocol:    tea.String("HTTPS"),
+		Pathname:    tea.String("/"),
+		Method:      tea.String("POST"),
+		AuthType:    tea.String("AK"),
+		Style:       tea.String("RPC"),
+		ReqBodyType: tea.String("formData"),
+		BodyType:    tea.String("json"),
+	}
+	request := &openapi.OpenApiRequest{Query: make(map[string]*string, len(query))}
+	for key, value := range query {
+		request.Query[key] = tea.String(value)
+	}
+
+	response, err := client.CallApi(params, request, &dara.RuntimeOptions{})
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	body, ok := response["body"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("API响应体为空")
+	}
+	return body, nil
+}
+
+// createPrefixedCredential 创建服务专用凭证，查找顺序与防火墙客户端一致：
+// 配置文件中的AK/SK、<envPrefix>ALIBABA_CLOUD_ACCESS_KEY_ID/SECRET、标准环境变量、默认凭证链
+func createPrefixedCredential(cfg *config.AliyunConfig, envPrefix string) (credential.Credential, error) {
+	// 1. 优先使用配置文件中的AK/SK
+	if cfg.AccessKeyId != "" && cfg.AccessKeySecret != "" {
+		return credential.NewCredential(&credential.Config{
+			Type:            tea.String("access_key"),
+			AccessKeyId:     tea.String(cfg.AccessKeyId),
+			AccessKeySecret: tea.String(cfg.AccessKeySecret),
+		})
+	}
+
+	// 2. 尝试使用服务专用环境变量，再回退到标准环境变量
+	for _, prefix := range []string{envPrefix, ""} {
+		accessKeyId := os.Getenv(prefix + "ALIBABA_CLOUD_ACCESS_KEY_ID")
+		accessKeySecret := os.Getenv(prefix + "ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+		if accessKeyId != "" && accessKeySecret != "" {
+			return credential.NewCredential(&credential.Config{
+				Type:            tea.String("access_key"),
+				AccessKeyId:     tea.String(accessKeyId),
+				AccessKeySecret: tea.String(accessKeySecret),
+			})
+		}
+	}
+
+	// 3. 使用默认凭证链
+	return credential.NewCredential(nil)
+}
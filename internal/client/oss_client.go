@@ -0,0 +1,144 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alibabacloud-go/tea/tea"
+	credential "github.com/aliyun/credentials-go/credentials"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// OSSClient OSS客户端，用于读写Bucket Policy
+// 已引入的tea-oss-sdk不包含Bucket Policy接口且只支持固定AK/SK，这里直接按OSS V1签名发送请求
+type OSSClient struct {
+	endpoint   string
+	credential credential.Credential
+	httpClient *http.Client
+	limiter    *rate.Limiter
+}
+
+// ossError OSS返回的XML错误
+type ossError struct {
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	RequestID string `xml:"RequestId"`
+}
+
+// NewOSSClient 创建新的OSS客户端，endpoint为空时使用区域的公网endpoint
+func NewOSSClient(cfg *config.AliyunConfig, endpoint string) (*OSSClient, error) {
+	cred, err := createPrefixedCredential(cfg, "OSS_")
+	if err != nil {
+		return nil, fmt.Errorf("创建 OSS 客户端失败: 创建凭证失败: %v", err)
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("oss-%s.aliyuncs.com", cfg.Region)
+	}
+
+	return &OSSClient{
+		endpoint:   endpoint,
+		credential: cred,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		limiter:    limiterFor("oss", cfg, "OSS_"),
+	}, nil
+}
+
+// GetBucketPolicy 读取Bucket Policy原文，未设置时返回NotFound类错误
+func (c *OSSClient) GetBucketPolicy(ctx context.Context, bucket string) ([]byte, error) {
+	body, err := c.do(ctx, http.MethodGet, bucket, nil)
+	if err != nil {
+		return nil, fmt.Errorf("读取Bucket %s 的Policy失败: %w", bucket, err)
+	}
+	return body, nil
+}
+
+// PutBucketPolicy 覆盖写入Bucket Policy
+func (c *OSSClient) PutBucketPolicy(ctx context.Context, bucket string, policy []byte) error {
+	if _, err := c.do(ctx, http.MethodPut, bucket, policy); err != nil {
+		return fmt.Errorf("写入Bucket %s 的Policy失败: %w", bucket, err)
+	}
+	return nil
+}
+
+// do 发送 /?policy 子资源请求
+func (c *OSSClient) do(ctx context.Context, method, bucket string, body []byte) ([]byte, error) {
+	if err := waitLimiter(ctx, c.limiter); err != nil {
+		return nil, err
+	}
+
+	cred, err := c.credential.GetCredential()
+	if err != nil {
+		return nil, &APIError{Class: ErrorClassAuth, Err: fmt.Errorf("获取凭证失败: %v", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("https://%s.%s/?policy", bucket, c.endpoint), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := tea.StringValue(cred.SecurityToken); token != "" {
+		req.Header.Set("x-oss-security-token", token)
+	}
+	signature := signOSSRequest(req, "/"+bucket+"/?policy", tea.StringValue(cred.AccessKeySecret))
+	req.Header.Set("Authorization", "OSS "+tea.StringValue(cred.AccessKeyId)+":"+signature)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	if resp.StatusCode >= 300 {
+		var ossErr ossError
+		_ = xml.Unmarshal(data, &ossErr)
+		return nil, &APIError{
+			Class:      classifyCode(ossErr.Code, resp.StatusCode),
+			Code:       ossErr.Code,
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("%s (RequestId: %s)", ossErr.Message, ossErr.RequestID),
+			Err:        fmt.Errorf("HTTP %d", resp.StatusCode),
+		}
+	}
+	return data, nil
+}
+
+// signOSSRequest 计算OSS V1签名
+// StringToSign = VERB\nContent-MD5\nContent-Type\nDate\nCanonicalizedOSSHeaders + CanonicalizedResource
+func signOSSRequest(req *http.Request, resource, secret string) string {
+	var ossHeaders []string
+	for key := range req.Header {
+		if lower := strings.ToLower(key); strings.HasPrefix(lower, "x-oss-") {
+			ossHeaders = append(ossHeaders, lower+":"+strings.TrimSpace(req.Header.Get(key))+"\n")
+		}
+	}
+	sort.Strings(ossHeaders)
+
+	stringToSign := req.Method + "\n" +
+		req.Header.Get("Content-MD5") + "\n" +
+		req.Header.Get("Content-Type") + "\n" +
+		req.Header.Get("Date") + "\n" +
+		strings.Join(ossHeaders, "") + resource
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
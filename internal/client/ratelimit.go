@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[string]*rate.Limiter)
+)
+
+// limiterFor 返回指定服务和账号共享的令牌桶限流器
+// 同一账号下的同一服务共用一个限流器，与阿里云按账号统计QPS的方式一致
+func limiterFor(service string, cfg *config.AliyunConfig, envPrefix string) *rate.Limiter {
+	key := fmt.Sprintf("%s/%s", service, accountKey(cfg, envPrefix))
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	if limiter, ok := limiters[key]; ok {
+		return limiter
+	}
+
+	qps := cfg.QPS
+	if qps <= 0 {
+		qps = config.DefaultQPS
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(qps), burst)
+	limiters[key] = limiter
+	return limiter
+}
+
+// accountKey 按凭证来源确定账号标识，与凭证初始化的查找顺序保持一致
+func accountKey(cfg *config.AliyunConfig, envPrefix string) string {
+	if cfg.AccessKeyId != "" {
+		return cfg.AccessKeyId
+	}
+	if id := os.Getenv(envPrefix + "ALIBABA_CLOUD_ACCESS_KEY_ID"); id != "" {
+		return id
+	}
+	if id := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID"); id != "" {
+		return id
+	}
+	return "default"
+}
+
+// waitLimiter 等待令牌，ctx取消时返回错误
+func waitLimiter(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	if err := limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("等待限流令牌失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	"golang.org/x/time/rate"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// slbAPIVersion 传统型负载均衡（CLB）的OpenAPI版本
+const slbAPIVersion = "2014-05-15"
+
+// slbBatchSize AddAccessControlListEntry和RemoveAccessControlListEntry单次调用的条目数上限
+const slbBatchSize = 50
+
+// SLBClient 传统型负载均衡（CLB）客户端，用于维护访问控制列表
+type SLBClient struct {
+	region  string
+	client  *openapi.Client
+	limiter *rate.Limiter
+}
+
+// NewSLBClient 创建新的CLB客户端
+func NewSLBClient(cfg *config.AliyunConfig) (*SLBClient, error) {
+	client, err := newOpenAPIClient(cfg, "SLB_", fmt.Sprintf("slb.%s.aliyuncs.com", cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("创建 SLB 客户端失败: %v", err)
+	}
+
+	return &SLBClient{
+		region:  cfg.Region,
+		client:  client,
+		limiter: limiterFor("slb", cfg, "SLB_"),
+	}, nil
+}
+
+// ListACLEntries 查询访问控制列表的全部条目和地址族（ipv4或ipv6）
+func (c *SLBClient) ListACLEntries(ctx context.Context, aclID string) ([]models.ACLEntry, string, error) {
+	var entries []models.ACLEntry
+	family := ""
+	for page := 1; ; page++ {
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return nil, "", err
+		}
+
+		body, err := callRPC(c.client, "DescribeAccessControlListAttribute", slbAPIVersion, map[string]string{
+			"RegionId": c.region,
+			"AclId":    aclID,
+			"Page":     strconv.Itoa(page),
+			"PageSize": "50",
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("调用DescribeAccessControlListAttribute API失败（ACL %s）: %w", aclID, err)
+		}
+
+		if version, ok := body["AddressIPVersion"].(string); ok {
+			family = strings.ToLower(version)
+		}
+		wrapper, _ := body["AclEntrys"].(map[string]interface{})
+		list, _ := wrapper["AclEntry"].([]interface{})
+		for _, item := range list {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			entry, _ := fields["AclEntryIP"].(string)
+			comment, _ := fields["AclEntryComment"].(string)
+			entries = append(entries, models.ACLEntry{Entry: canonicalEntry(entry), Description: comment})
+		}
+
+		if len(list) < 50 {
+			return entries, family, nil
+		}
+	}
+}
+
+// AddACLEntries 添加条目，按单次调用上限分批
+func (c *SLBClient) AddACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error {
+	return c.modifyEntries(ctx, "AddAccessControlListEntry", aclID, entries, true)
+}
+
+// RemoveACLEntries 删除条目，按单次调用上限分批
+func (c *SLBClient) RemoveACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error {
+	return c.modifyEntries(ctx, "RemoveAccessControlListEntry", aclID, entries, false)
+}
+
+// modifyEntries 以JSON数组形式提交条目
+func (c *SLBClient) modifyEntries(ctx context.Context, action, aclID string, entries []models.ACLEntry, withComment bool) error {
+	type aclEntry struct {
+		Entry   string `json:"entry"`
+		Comment string `json:"comment,omitempty"`
+	}
+
+	for start := 0; start < len(entries); start += slbBatchSize {
+		end := min(start+slbBatchSize, len(entries))
+		if err := waitLimiter(ctx, c.limiter); err != nil {
+			return err
+		}
+
+		batch := make([]aclEntry, 0, end-start)
+		for _, entry := range entries[start:end] {
+			item := aclEntry{Entry: entry.Entry}
+			if withComment {
+				item.Comment = entry.Description
+			}
+			batch = append(batch, item)
+		}
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		if _, err := callRPC(c.client, action, slbAPIVersion, map[string]string{
+			"RegionId":  c.region,
+			"AclId":     aclID,
+			"AclEntrys": string(data),
+		}); err != nil {
+			return fmt.Errorf("调用%s API失败（ACL %s）: %w", action, aclID, err)
+		}
+	}
+	return nil
+}
+
+// canonicalEntry 将条目转换为规范CIDR，无法解析时原样返回
+func canonicalEntry(entry string) string {
+	if prefix, err := ipset.ParsePrefix(entry); err == nil {
+		return prefix.String()
+	}
+	return entry
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -10,25 +11,67 @@ import (
 // Config 应用配置
 type Config struct {
 	DCDN      DCDNConfig      `yaml:"dcdn"`
+	CDN       CDNConfig       `yaml:"cdn"`
+	ESA       ESAConfig       `yaml:"esa"`
 	Firewall  FirewallConfig  `yaml:"firewall"`
 	Scheduler SchedulerConfig `yaml:"scheduler"`
 	Sync      SyncConfig      `yaml:"sync"`
 	Logging   LogConfig       `yaml:"logging"`
+	// 多副本部署时的选主配置
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+	State          StateConfig          `yaml:"state"`
+	Notifications  NotificationConfig   `yaml:"notifications"`
+	// 具名数据源，供地址组表达式引用
+	Sources []SourceConfig `yaml:"sources"`
+	// 云防火墙地址薄以外的写入目标
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
+// StateConfig 本地状态存储配置
+type StateConfig struct {
+	Path string `yaml:"path"` // 状态文件路径，记录每个地址组最近一次写入的内容
+}
+
+// NotificationConfig 通知配置
+type NotificationConfig struct {
+	WebhookURLs []string `yaml:"webhook_urls"` // 接收事件JSON的Webhook地址
+	Timeout     string   `yaml:"timeout"`      // 单次请求超时时间
 }
 
 // AliyunConfig 阿里云基础配置
 type AliyunConfig struct {
-	AccessKeyId     string `yaml:"access_key_id"`
-	AccessKeySecret string `yaml:"access_key_secret"`
-	Region          string `yaml:"region"`
+	AccessKeyId     string  `yaml:"access_key_id"`
+	AccessKeySecret string  `yaml:"access_key_secret"`
+	Region          string  `yaml:"region"`
+	QPS             float64 `yaml:"qps"`   // 客户端限流：每秒请求数，同一账号同一服务共享
+	Burst           int     `yaml:"burst"` // 客户端限流：令牌桶容量
 }
 
+// DefaultQPS 未配置qps时使用的默认客户端限流值
+const DefaultQPS = 5
+
 // DCDNConfig DCDN配置
 type DCDNConfig struct {
 	AliyunConfig `yaml:",inline"` // 内嵌阿里云配置
 	// 移除Domains字段，新SDK直接获取全部L2节点IP，无需指定域名
 }
 
+// CDNConfig 经典CDN（非DCDN）配置
+// CDN的L2节点IP需要按加速域名查询，各域名的结果合并为内置数据源cdn_l2
+type CDNConfig struct {
+	AliyunConfig `yaml:",inline"` // 内嵌阿里云配置
+	Domains      []string         `yaml:"domains"` // 加速域名列表
+}
+
+// ESAConfig 边缘安全加速（ESA）配置
+// 各站点的回源IP白名单合并为内置数据源esa_origin，DCDN迁移到ESA期间可与dcdn_l2合并写入同一地址薄
+type ESAConfig struct {
+	AliyunConfig `yaml:",inline"` // 内嵌阿里云配置，region决定endpoint：中国站cn-hangzhou，国际站ap-southeast-1
+	SiteIDs      []int64          `yaml:"site_ids"` // 开启了源站防护的站点ID
+	// 使用的白名单：current（当前生效）、latest（ESA更新后的最新列表，确认更新前需要提前放行）、both（默认，二者合并）
+	Whitelist string `yaml:"whitelist"`
+}
+
 // FirewallConfig 防火墙配置
 type FirewallConfig struct {
 	AliyunConfig `yaml:",inline"` // 内嵌阿里云配置
@@ -41,6 +84,10 @@ type SchedulerConfig struct {
 	RunOnStart bool   `yaml:"run_on_start"` // 启动时是否立即执行
 	Timeout    string `yaml:"timeout"`      // 超时时间
 	MaxRetries int    `yaml:"max_retries"`  // 最大重试次数
+	// 重叠执行策略：skip（跳过，默认）、queue（排队一个）、cancel（取消正在运行的任务）
+	OverlapPolicy string `yaml:"overlap_policy"`
+	// 停止时等待正在运行的任务结束的最长时间
+	ShutdownTimeout string `yaml:"shutdown_timeout"`
 }
 
 // SyncConfig 同步配置
@@ -52,9 +99,66 @@ type SyncConfig struct {
 type AddressGroup struct {
 	GroupName       string   `yaml:"group_name"`
 	Description     string   `yaml:"description"`
-	IPType          string   `yaml:"ip_type"`          // 支持的IP类型: "ipv4", "ipv6", "both" (默认)
+	IPType          string   `yaml:"ip_type"` // 支持的IP类型: "ipv4"（默认）、"ipv6"、"both"
 	IncludePatterns []string `yaml:"include_patterns"`
 	ExcludePatterns []string `yaml:"exclude_patterns"`
+	// 地址组内容的集合表达式，如 "dcdn_l2 ∪ static:office − file:blocked.txt − group:legacy"
+	// 为空时使用DCDN L2节点IP列表；包含/排除模式和固定条目在表达式结果上继续生效
+	Expression string `yaml:"expression"`
+	// 写入前发现地址薄被他人修改时的处理策略：replan（重新计划后写入，保留期间的外部修改，默认）、abort（放弃本次写入）
+	ConflictPolicy string `yaml:"conflict_policy"`
+	// 地址薄在两次同步之间被外部修改时的处理策略：
+	// revert（恢复为期望内容，默认）、adopt（接受外部修改并持续保留）、alert（告警并保留外部修改的条目，其他变化照常写入）
+	DriftPolicy string `yaml:"drift_policy"`
+	// 始终写入地址薄的固定条目，如办公网出口或健康检查IP
+	StaticEntries []string `yaml:"static_entries"`
+	// 地址薄归属模式：full（本工具管理整个地址薄，默认）、managed（只增删本工具添加过的条目，其他条目保持不变）
+	Ownership string `yaml:"ownership"`
+	// 源列表中消失的条目连续缺失多少次同步后才移除，0表示不按次数限制
+	RemovalGraceRuns int `yaml:"removal_grace_runs"`
+	// 源列表中消失的条目缺失多长时间后才移除，如 "24h"，为空表示不按时间限制
+	// 同时配置两项时需两项条件都满足才移除；新增条目始终立即写入
+	RemovalGracePeriod string `yaml:"removal_grace_period"`
+	// 写入前的CIDR归一化
+	Normalize NormalizeConfig `yaml:"normalize"`
+	// 条目超过单个地址薄配额时自动拆分到多个地址薄
+	Shard ShardConfig `yaml:"shard"`
+	// 不写入云防火墙地址薄，只同步到sinks中引用该地址组的写入目标
+	SkipAddressBook bool `yaml:"skip_address_book"`
+}
+
+// ShardConfig 地址薄分片配置
+type ShardConfig struct {
+	// 分片策略：为空或none（不分片，默认）、count（按地址顺序装入分片，每个分片不超过max_entries条）、
+	// first_octet（按首字节分组装入分片，同一网段尽量在同一分片）、ip_version（IPv4和IPv6各一个分片）
+	// count和first_octet只决定新条目的位置，已写入的条目留在原分片
+	// 分片地址薄命名为 <group_name>-1、<group_name>-2 ...
+	Strategy   string `yaml:"strategy"`
+	MaxEntries int    `yaml:"max_entries"` // 单个分片的条目上限，一般设置为地址薄条目配额
+}
+
+// NormalizeConfig CIDR归一化配置
+type NormalizeConfig struct {
+	// off（默认，原样写入）、lossless（去重、去除被覆盖子网、合并相邻网段，覆盖范围不变）、
+	// aggregate（在lossless基础上，超过max_entries时继续合并为更大网段，会多覆盖部分地址）
+	Mode            string `yaml:"mode"`
+	MaxEntries      int    `yaml:"max_entries"`       // aggregate模式的条目上限，一般设置为地址薄条目配额
+	MinPrefixLength int    `yaml:"min_prefix_length"` // aggregate模式合并后允许的最短前缀长度
+}
+
+// LeaderElectionConfig 选主配置，启用后只有leader实例执行同步
+type LeaderElectionConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Backend        string `yaml:"backend"`         // flock（单主机）或 kubernetes（Lease）
+	Identity       string `yaml:"identity"`        // 实例标识，默认使用 主机名-进程号
+	LockFile       string `yaml:"lock_file"`       // flock后端使用的锁文件
+	LeaseName      string `yaml:"lease_name"`      // kubernetes后端使用的Lease名称
+	LeaseNamespace string `yaml:"lease_namespace"` // Lease所在命名空间，默认读取POD_NAMESPACE
+	Kubeconfig     string `yaml:"kubeconfig"`      // 集群外运行时使用的kubeconfig路径
+	LeaseDuration  string `yaml:"lease_duration"`  // leader租约时长，超过后follower可接管
+	RenewDeadline  string `yaml:"renew_deadline"`  // leader续约的最长时间
+	RetryPeriod    string `yaml:"retry_period"`    // 获取和续约的重试间隔
+	OnceTimeout    string `yaml:"once_timeout"`    // --once模式等待成为leader的最长时间
 }
 
 // LogConfig 日志配置
@@ -103,22 +207,106 @@ func setDefaults(config *Config) {
 	if config.Scheduler.MaxRetries == 0 {
 		config.Scheduler.MaxRetries = 3
 	}
+	if config.Scheduler.ShutdownTimeout == "" {
+		config.Scheduler.ShutdownTimeout = "2m"
+	}
+	if config.Scheduler.OverlapPolicy == "" {
+		config.Scheduler.OverlapPolicy = "skip"
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
 	if config.Logging.Format == "" {
 		config.Logging.Format = "text"
 	}
-	
+
 	// 为DCDN设置默认区域
 	if config.DCDN.Region == "" {
 		config.DCDN.Region = "ap-southeast-1" // 新加坡区域
 	}
-	
+
+	// 为CDN设置默认区域
+	if config.CDN.Region == "" {
+		config.CDN.Region = "ap-southeast-1" // 新加坡区域
+	}
+
+	// 为ESA设置默认区域
+	if config.ESA.Region == "" {
+		config.ESA.Region = "ap-southeast-1" // 国际站
+	}
+	if config.ESA.Whitelist == "" {
+		config.ESA.Whitelist = "both"
+	}
+
 	// 为防火墙设置默认区域
 	if config.Firewall.Region == "" {
 		config.Firewall.Region = "ap-southeast-1" // 新加坡区域
 	}
+
+	// 地址组默认值
+	for i := range config.Sync.AddressGroups {
+		if config.Sync.AddressGroups[i].ConflictPolicy == "" {
+			config.Sync.AddressGroups[i].ConflictPolicy = "replan"
+		}
+		if config.Sync.AddressGroups[i].DriftPolicy == "" {
+			config.Sync.AddressGroups[i].DriftPolicy = "revert"
+		}
+		if config.Sync.AddressGroups[i].Ownership == "" {
+			config.Sync.AddressGroups[i].Ownership = "full"
+		}
+		if config.Sync.AddressGroups[i].Normalize.Mode == "" {
+			config.Sync.AddressGroups[i].Normalize.Mode = "off"
+		}
+		if config.Sync.AddressGroups[i].Normalize.MinPrefixLength == 0 {
+			config.Sync.AddressGroups[i].Normalize.MinPrefixLength = 16
+		}
+	}
+
+	if config.State.Path == "" {
+		config.State.Path = "data/state.json"
+	}
+	if config.Notifications.Timeout == "" {
+		config.Notifications.Timeout = "10s"
+	}
+
+	// 选主默认值
+	if config.LeaderElection.Backend == "" {
+		config.LeaderElection.Backend = "flock"
+	}
+	if config.LeaderElection.LockFile == "" {
+		config.LeaderElection.LockFile = "/var/run/aliyun-dcdn-firewall-sync.lock"
+	}
+	if config.LeaderElection.LeaseName == "" {
+		config.LeaderElection.LeaseName = "aliyun-dcdn-firewall-sync"
+	}
+	if config.LeaderElection.LeaseDuration == "" {
+		config.LeaderElection.LeaseDuration = "15s"
+	}
+	if config.LeaderElection.RenewDeadline == "" {
+		config.LeaderElection.RenewDeadline = "10s"
+	}
+	if config.LeaderElection.RetryPeriod == "" {
+		config.LeaderElection.RetryPeriod = "2s"
+	}
+	if config.LeaderElection.OnceTimeout == "" {
+		config.LeaderElection.OnceTimeout = "1m"
+	}
+
+	// 客户端限流默认值
+	if config.DCDN.QPS == 0 {
+		config.DCDN.QPS = DefaultQPS
+	}
+	if config.CDN.QPS == 0 {
+		config.CDN.QPS = DefaultQPS
+	}
+	if config.ESA.QPS == 0 {
+		config.ESA.QPS = DefaultQPS
+	}
+	if config.Firewall.QPS == 0 {
+		config.Firewall.QPS = DefaultQPS
+	}
+
+	setSinkDefaults(config)
 }
 
 // validateConfig 验证配置
@@ -129,11 +317,123 @@ func validateConfig(config *Config) error {
 	// 2. 配置文件 (~/.alibabacloud/credentials)
 	// 3. 实例RAM角色
 	// 4. ECS实例元数据服务 (IMDS)
-	
+
 	// 移除DCDN域名验证，新SDK无需指定域名
 	if len(config.Sync.AddressGroups) == 0 {
 		return fmt.Errorf("防火墙地址组列表不能为空")
 	}
+
+	if _, err := time.ParseDuration(config.Scheduler.ShutdownTimeout); err != nil {
+		return fmt.Errorf("解析shutdown_timeout失败: %v", err)
+	}
+
+	switch config.Scheduler.OverlapPolicy {
+	case "skip", "queue", "cancel":
+	default:
+		return fmt.Errorf("不支持的重叠执行策略: %s（可选 skip、queue、cancel）", config.Scheduler.OverlapPolicy)
+	}
+
+	for _, group := range config.Sync.AddressGroups {
+		switch group.ConflictPolicy {
+		case "replan", "abort":
+		default:
+			return fmt.Errorf("地址组 %s 不支持的冲突策略: %s（可选 replan、abort）", group.GroupName, group.ConflictPolicy)
+		}
+		switch group.DriftPolicy {
+		case "revert", "adopt", "alert":
+		default:
+			return fmt.Errorf("地址组 %s 不支持的漂移策略: %s（可选 revert、adopt、alert）", group.GroupName, group.DriftPolicy)
+		}
+		switch group.Ownership {
+		case "full", "managed":
+		default:
+			return fmt.Errorf("地址组 %s 不支持的归属模式: %s（可选 full、managed）", group.GroupName, group.Ownership)
+		}
+		switch group.Normalize.Mode {
+		case "", "off", "lossless":
+		case "aggregate":
+			if group.Normalize.MaxEntries <= 0 {
+				return fmt.Errorf("地址组 %s 的normalize.mode为aggregate时必须设置max_entries", group.GroupName)
+			}
+		default:
+			return fmt.Errorf("地址组 %s 不支持的归一化模式: %s（可选 off、lossless、aggregate）", group.GroupName, group.Normalize.Mode)
+		}
+		switch group.Shard.Strategy {
+		case "", "none":
+		case "count", "first_octet", "ip_version":
+			if group.Shard.MaxEntries <= 0 {
+				return fmt.Errorf("地址组 %s 启用分片时必须设置shard.max_entries", group.GroupName)
+			}
+			if group.Shard.Strategy == "ip_version" && group.IPType != "both" {
+				return fmt.Errorf("地址组 %s 的分片策略ip_version需要ip_type为both", group.GroupName)
+			}
+		default:
+			return fmt.Errorf("地址组 %s 不支持的分片策略: %s（可选 none、count、first_octet、ip_version）", group.GroupName, group.Shard.Strategy)
+		}
+		switch group.IPType {
+		case "", "ipv4", "ipv6", "both":
+		default:
+			return fmt.Errorf("地址组 %s 不支持的IP类型: %s（可选 ipv4、ipv6、both）", group.GroupName, group.IPType)
+		}
+		if group.RemovalGraceRuns < 0 {
+			return fmt.Errorf("地址组 %s 的removal_grace_runs不能为负数", group.GroupName)
+		}
+		if group.RemovalGracePeriod != "" {
+			if _, err := time.ParseDuration(group.RemovalGracePeriod); err != nil {
+				return fmt.Errorf("地址组 %s 解析removal_grace_period失败: %v", group.GroupName, err)
+			}
+		}
+	}
+
+	if err := validateSources(config); err != nil {
+		return err
+	}
+	if err := validateExpressions(config); err != nil {
+		return err
+	}
+	if err := validateSinks(config); err != nil {
+		return err
+	}
+	for _, group := range config.Sync.AddressGroups {
+		if group.SkipAddressBook && len(config.SinksFor(group.GroupName)) == 0 {
+			return fmt.Errorf("地址组 %s 设置了skip_address_book，但没有写入目标引用该地址组", group.GroupName)
+		}
+	}
+
+	if config.LeaderElection.Enabled {
+		if err := validateLeaderElection(&config.LeaderElection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLeaderElection 验证选主配置
+func validateLeaderElection(le *LeaderElectionConfig) error {
+	switch le.Backend {
+	case "flock", "kubernetes":
+	default:
+		return fmt.Errorf("不支持的选主后端: %s（可选 flock、kubernetes）", le.Backend)
+	}
+
+	durations := map[string]string{
+		"lease_duration": le.LeaseDuration,
+		"renew_deadline": le.RenewDeadline,
+		"retry_period":   le.RetryPeriod,
+		"once_timeout":   le.OnceTimeout,
+	}
+	parsed := make(map[string]time.Duration)
+	for name, value := range durations {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("解析leader_election.%s失败: %v", name, err)
+		}
+		parsed[name] = d
+	}
+
+	if parsed["lease_duration"] <= parsed["renew_deadline"] {
+		return fmt.Errorf("leader_election.lease_duration必须大于renew_deadline")
+	}
 	return nil
 }
 
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validGroupConfig 返回只包含一个地址组、其他配置为默认值的配置
+func validGroupConfig(group AddressGroup) *Config {
+	cfg := &Config{}
+	cfg.Sync.AddressGroups = []AddressGroup{group}
+	setDefaults(cfg)
+	return cfg
+}
+
+func TestValidateShardIPVersion(t *testing.T) {
+	tests := []struct {
+		ipType string
+		want   string
+	}{
+		{"both", ""},
+		{"", "需要ip_type为both"},
+		{"ipv4", "需要ip_type为both"},
+		{"ipv6", "需要ip_type为both"},
+	}
+	for _, tt := range tests {
+		cfg := validGroupConfig(AddressGroup{
+			GroupName: "dcdn",
+			IPType:    tt.ipType,
+			Shard:     ShardConfig{Strategy: "ip_version", MaxEntries: 100},
+		})
+		err := validateConfig(cfg)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("ip_type %q: validateConfig 返回错误: %v", tt.ipType, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ip_type %q: validateConfig 返回 %v，期望包含 %q", tt.ipType, err, tt.want)
+		}
+	}
+}
+
+// validSinkConfig 返回包含一个地址组和指定写入目标的配置，写入目标使用该地址组
+func validSinkConfig(sink SinkConfig) *Config {
+	sink.Group = "dcdn"
+	cfg := &Config{Sinks: []SinkConfig{sink}}
+	cfg.Sync.AddressGroups = []AddressGroup{{GroupName: "dcdn"}}
+	setDefaults(cfg)
+	return cfg
+}
+
+func TestValidateWAFSinkName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"waf-main", ""},
+		{"../../etc/cron.d/evil", "不能包含路径分隔符"},
+		{"team/waf", "不能包含路径分隔符"},
+		{`team\waf`, "不能包含路径分隔符"},
+	}
+	for _, tt := range tests {
+		cfg := validSinkConfig(SinkConfig{
+			Name: tt.name,
+			Type: "waf_whitelist",
+			WAF:  WAFSinkConfig{InstanceID: "waf_v3prepaid_public_cn-xxx", RuleIDs: []int64{1}, StateDir: t.TempDir()},
+		})
+		err := validateConfig(cfg)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("名称 %q: validateConfig 返回错误: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("名称 %q: validateConfig 返回 %v，期望包含 %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateOSSPolicySinkName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"oss-static", ""},
+		{"..", "不能包含路径分隔符"},
+		{".", "不能包含路径分隔符"},
+		{"../../etc", "不能包含路径分隔符"},
+		{`team\oss`, "不能包含路径分隔符"},
+	}
+	for _, tt := range tests {
+		cfg := validSinkConfig(SinkConfig{
+			Name:      tt.name,
+			Type:      "oss_policy",
+			OSSPolicy: OSSPolicySinkConfig{Buckets: []string{"static-assets"}, SnapshotDir: t.TempDir()},
+		})
+		err := validateConfig(cfg)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("名称 %q: validateConfig 返回错误: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("名称 %q: validateConfig 返回 %v，期望包含 %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,699 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// SinkConfig 写入目标配置，将地址组的期望列表同步到云防火墙地址薄以外的位置
+type SinkConfig struct {
+	Name string `yaml:"name"`
+	// ecs_security_group（ECS安全组入方向规则）、slb_acl（CLB访问控制列表）、alb_acl（ALB访问控制列表）、
+	// waf_whitelist（WAF 3.0白名单规则）、rds_whitelist、polardb_whitelist、redis_whitelist（数据库IP白名单分组）、
+	// oss_policy（OSS Bucket Policy的IP条件）、host_firewall（本机nftables/ipset/iptables）、
+	// proxy_config（nginx、HAProxy、Caddy、Envoy的可信代理配置文件）、
+	// kubernetes（ConfigMap，可选NetworkPolicy和Calico GlobalNetworkSet）、webhook（推送到任意HTTP地址）
+	Type  string `yaml:"type"`
+	Group string `yaml:"group"` // 数据来源的地址组名称，使用过滤、宽限期和归一化之后的内容
+
+	AliyunConfig `yaml:",inline"` // 写入目标所在账号和区域，凭证查找顺序与其他客户端一致
+
+	SecurityGroup SecurityGroupSinkConfig `yaml:"security_group"`
+	ACL           ACLSinkConfig           `yaml:"acl"`
+	WAF           WAFSinkConfig           `yaml:"waf"`
+	DBWhitelist   DBWhitelistSinkConfig   `yaml:"db_whitelist"`
+	OSSPolicy     OSSPolicySinkConfig     `yaml:"oss_policy"`
+	HostFirewall  HostFirewallSinkConfig  `yaml:"host_firewall"`
+	ProxyConfig   ProxyConfigSinkConfig   `yaml:"proxy_config"`
+	Kubernetes    KubernetesSinkConfig    `yaml:"kubernetes"`
+	Webhook       WebhookSinkConfig       `yaml:"webhook"`
+}
+
+// SecurityGroupSinkConfig ECS安全组写入配置
+// 每个CIDR按ports生成入方向规则，本工具只增删描述为marker的规则，其他规则保持不变
+type SecurityGroupSinkConfig struct {
+	SecurityGroupIDs []string `yaml:"security_group_ids"`
+	// 放行的协议和端口，如 "tcp/443"、"tcp/8000-8100"、"udp/53"、"icmp"、"all"，默认 ["tcp/443"]
+	Ports    []string `yaml:"ports"`
+	Policy   string   `yaml:"policy"`   // accept（默认）或drop
+	Priority int      `yaml:"priority"` // 规则优先级1-100，默认1
+	NicType  string   `yaml:"nic_type"` // intranet（VPC，默认）或internet（经典网络公网）
+	// 规则描述中的标记，用于识别本工具管理的规则，默认 "managed-by:aliyun-dcdn-firewall-sync/<name>"
+	Marker string `yaml:"marker"`
+	// 单个安全组的规则配额（含出方向和他人维护的规则），写入后超过配额时本次不写入，默认200
+	MaxRules int `yaml:"max_rules"`
+}
+
+// ACLSinkConfig CLB/ALB访问控制列表写入配置
+type ACLSinkConfig struct {
+	ACLIDs []string `yaml:"acl_ids"`
+	// 归属模式：managed（只增删备注为marker的条目，默认）、full（本工具管理整个ACL，删除不在期望列表中的所有条目）
+	Ownership string `yaml:"ownership"`
+	// 条目备注，managed模式下用于识别本工具管理的条目，默认 "managed-by/aliyun-dcdn-firewall-sync/<name>"
+	// CLB备注只允许字母、数字、中文和 - / . _
+	Marker string `yaml:"marker"`
+	// 单个ACL的条目配额，写入后超过时本次不写入，0表示不检查
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// WAFSinkConfig WAF 3.0白名单规则写入配置
+// 规则需要预先在控制台创建，并包含一个"IP 属于"条件，本工具只改写该条件的IP列表，规则的其他字段保持不变
+type WAFSinkConfig struct {
+	InstanceID string  `yaml:"instance_id"`
+	RuleIDs    []int64 `yaml:"rule_ids"` // 白名单规则ID，可在控制台或DescribeDefenseRules中查看
+	// 单条规则IP条件的条目上限，写入后超过时本次不写入，0表示不检查
+	MaxEntries int `yaml:"max_entries"`
+	// 记录各规则中由本工具添加的条目的目录，文件名为 <写入目标名称>.json，默认 "data/waf"
+	// 只有记录中的条目会被移除，他人添加的条目保持不变
+	StateDir string `yaml:"state_dir"`
+}
+
+// DBWhitelistSinkConfig RDS、PolarDB、Redis白名单写入配置
+// 只覆盖写入group_name指定的白名单分组（DBInstanceIPArrayName），default分组和其他分组保持不变
+type DBWhitelistSinkConfig struct {
+	InstanceIDs []string `yaml:"instance_ids"` // RDS实例ID、PolarDB集群ID或Redis实例ID
+	// 白名单分组名，2-120个字符，由小写字母、数字和下划线组成，以小写字母开头，默认 "dcdn_firewall_sync"
+	GroupName string `yaml:"group_name"`
+	// 单个实例所有分组的条目总数上限，写入后超过时本次不写入，默认1000
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// OSSPolicySinkConfig OSS Bucket Policy写入配置
+// 只改写Sid为statement_sid的Statement中的acs:SourceIp条件，Policy的其他内容保持不变
+type OSSPolicySinkConfig struct {
+	Buckets  []string `yaml:"buckets"`
+	Endpoint string   `yaml:"endpoint"` // 为空时使用 oss-<region>.aliyuncs.com，同区域ECS可使用内网endpoint
+	// 需要改写的Statement的Sid，Statement需预先在Policy中创建，默认 "aliyun-dcdn-firewall-sync"
+	StatementSid string `yaml:"statement_sid"`
+	// 写入前保存原Policy的快照目录，默认 "data/oss-policy-snapshots"
+	SnapshotDir string `yaml:"snapshot_dir"`
+	// 每个Bucket保留的快照数量，默认10
+	KeepSnapshots int `yaml:"keep_snapshots"`
+}
+
+// HostFirewallSinkConfig 本机防火墙写入配置，用于在源站主机上维护回源IP白名单
+// 将期望列表渲染为规则文件，再通过本机命令原子地应用；命令不存在或设置file_only时只写文件
+type HostFirewallSinkConfig struct {
+	// nftables（nft -f，一个事务内清空并填充集合）、ipset（ipset restore，填充临时集合后swap）、
+	// iptables（iptables-restore/ip6tables-restore --noflush，整体替换自定义链）
+	Backend string `yaml:"backend"`
+	// nftables/ipset的集合名前缀（实际集合为 <name>_v4、<name>_v6），或iptables的链名，默认 "dcdn_origin" / "DCDN_ORIGIN"
+	Name string `yaml:"name"`
+	// nftables的表名（inet族），默认 "dcdn_firewall_sync"
+	Table string `yaml:"table"`
+	// 规则文件目录，文件名为 <写入目标名称>.nft、.ipset、.v4.rules/.v6.rules，默认 "data/host-firewall"
+	OutputDir string `yaml:"output_dir"`
+	// 只写规则文件，不执行命令，可用于离线检查渲染结果或由其他工具加载
+	FileOnly bool `yaml:"file_only"`
+}
+
+// ProxyConfigSinkConfig 反向代理配置文件写入配置
+// 文件原子写入后依次执行validate_command和reload_command，任一失败时恢复原文件
+type ProxyConfigSinkConfig struct {
+	// nginx_real_ip（set_real_ip_from）、nginx_allow（allow）、haproxy_acl（每行一个CIDR，供 src -f 引用）、
+	// caddy（trusted_proxies代码片段）、envoy_rbac（RBAC过滤器配置）、envoy_xff（XFF原始IP检测配置）
+	Format string `yaml:"format"`
+	Path   string `yaml:"path"` // 生成的文件路径，通常由主配置include
+	// 写入后执行的校验命令，通过 sh -c 执行，如 "nginx -t"
+	ValidateCommand string `yaml:"validate_command"`
+	// 校验通过后执行的重新加载命令，如 "nginx -s reload"
+	ReloadCommand string `yaml:"reload_command"`
+	// 单个命令的超时时间，默认30s
+	CommandTimeout string `yaml:"command_timeout"`
+	// caddy格式的代码片段名，在Caddyfile中通过 import 引用，默认 "dcdn_trusted_proxies"
+	Snippet string `yaml:"snippet"`
+}
+
+// KubernetesSinkConfig Kubernetes写入配置
+// 通过服务端应用（server-side apply）写入本工具管理的对象，对象带有归属标签，
+// 已存在但不属于本写入目标的同名对象不会被改写
+type KubernetesSinkConfig struct {
+	Kubeconfig string `yaml:"kubeconfig"` // 集群外运行时使用的kubeconfig路径，为空时优先使用集群内配置，再读取KUBECONFIG
+	Namespace  string `yaml:"namespace"`  // ConfigMap和NetworkPolicy所在的命名空间，默认POD_NAMESPACE或default
+	// ConfigMap名称，默认与写入目标名称相同；内容为每行一个CIDR
+	ConfigMap    string `yaml:"configmap"`
+	ConfigMapKey string `yaml:"configmap_key"` // ConfigMap中的键，默认 "cidrs"
+	// 托管的NetworkPolicy名称，为空时不写入；策略只包含一条入方向规则，来源为期望列表中的ipBlock
+	NetworkPolicy string            `yaml:"network_policy"`
+	PodSelector   map[string]string `yaml:"pod_selector"` // NetworkPolicy选择的Pod标签，为空表示命名空间内所有Pod
+	// NetworkPolicy放行的协议和端口，如 "tcp/443"、"tcp/8000-8100"，为空表示所有端口
+	Ports []string `yaml:"ports"`
+	// Calico GlobalNetworkSet名称，为空时不写入
+	GlobalNetworkSet string `yaml:"global_network_set"`
+	// GlobalNetworkSet的API版本：crd.projectcalico.org/v1（默认）或 projectcalico.org/v3（安装了Calico API Server时）
+	CalicoAPIVersion string `yaml:"calico_api_version"`
+	// 附加到所有对象的标签，如供Calico策略通过selector引用GlobalNetworkSet
+	Labels map[string]string `yaml:"labels"`
+	// 服务端应用的字段管理者名称，默认 "aliyun-dcdn-firewall-sync"
+	FieldManager string `yaml:"field_manager"`
+}
+
+// WebhookSinkConfig 通用Webhook写入配置，将期望列表和相对上次推送的差异推送到配置的HTTP地址
+// 每个地址单独记录最近一次推送成功的内容，内容未变化时默认不再推送
+type WebhookSinkConfig struct {
+	URLs   []string `yaml:"urls"`
+	Method string   `yaml:"method"` // POST（默认）或PUT
+	// 请求体模板（text/template），为空时发送JSON；可使用 .Sink、.Group、.Entries、.Added、.Removed、.Count、.SHA256、.Time，
+	// 以及函数 join（如 {{join .Entries "\n"}}）和 json
+	BodyTemplate string `yaml:"body_template"`
+	// 请求的Content-Type，默认JSON为 "application/json"，模板为 "text/plain; charset=utf-8"
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"` // 附加的请求头，如Authorization
+	// HMAC-SHA256签名密钥，为空时读取secret_env指定的环境变量，仍为空时不签名
+	Secret    string `yaml:"secret"`
+	SecretEnv string `yaml:"secret_env"`
+	// 单次请求超时时间，默认10s
+	Timeout string `yaml:"timeout"`
+	// 视为成功的HTTP状态码，为空时接受所有2xx
+	ExpectStatus []int `yaml:"expect_status"`
+	// 响应体需匹配的正则表达式，为空时不检查
+	ExpectBody string `yaml:"expect_body"`
+	// 内容未变化时也推送，用于需要定期收到完整列表的接收方
+	SendUnchanged bool `yaml:"send_unchanged"`
+	// 记录各地址最近一次推送成功内容的目录，文件名为 <写入目标名称>.json，默认 "data/webhook"
+	StateDir string `yaml:"state_dir"`
+}
+
+// WebhookTemplateFuncs Webhook请求体模板可使用的函数
+var WebhookTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// ParseWebhookTemplate 解析Webhook请求体模板
+func ParseWebhookTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(WebhookTemplateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// DefaultDBWhitelistMaxEntries 数据库实例白名单的默认条目上限
+const DefaultDBWhitelistMaxEntries = 1000
+
+// identifierPattern 写入本机配置的集合、表、链和代码片段名的格式
+var identifierPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// dbWhitelistGroupName 白名单分组名的格式
+var dbWhitelistGroupName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,118}[a-z0-9]$`)
+
+// DefaultSecurityGroupMaxRules 普通安全组的默认规则配额
+const DefaultSecurityGroupMaxRules = 200
+
+// ParsePortSpec 解析 "tcp/443" 形式的端口配置，返回安全组规则使用的协议和端口范围
+func ParsePortSpec(spec string) (protocol, portRange string, err error) {
+	protocol, ports, _ := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "/")
+	switch protocol {
+	case "all", "icmp", "icmpv6", "gre":
+		if ports != "" {
+			return "", "", fmt.Errorf("%s 协议不能指定端口: %s", protocol, spec)
+		}
+		return protocol, "-1/-1", nil
+	case "tcp", "udp":
+	default:
+		return "", "", fmt.Errorf("不支持的协议: %s（可选 tcp、udp、icmp、icmpv6、gre、all）", spec)
+	}
+
+	from, to, found := strings.Cut(ports, "-")
+	if !found {
+		to = from
+	}
+	low, err1 := strconv.Atoi(from)
+	high, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+		return "", "", fmt.Errorf("端口范围无效: %s", spec)
+	}
+	return protocol, fmt.Sprintf("%d/%d", low, high), nil
+}
+
+// SinksFor 返回使用指定地址组的写入目标
+func (c *Config) SinksFor(group string) []SinkConfig {
+	var sinks []SinkConfig
+	for _, sink := range c.Sinks {
+		if sink.Group == group {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// setSinkDefaults 设置写入目标的默认值
+func setSinkDefaults(config *Config) {
+	for i := range config.Sinks {
+		sink := &config.Sinks[i]
+		if sink.Region == "" {
+			sink.Region = config.Firewall.Region
+			// WAF 3.0只在杭州（中国内地实例）和新加坡（非中国内地实例）提供接入点
+			if sink.Type == "waf_whitelist" && !isWAFRegion(sink.Region) {
+				sink.Region = "cn-hangzhou"
+			}
+		}
+		if sink.QPS == 0 {
+			sink.QPS = DefaultQPS
+		}
+
+		switch sink.Type {
+		case "ecs_security_group":
+			sg := &sink.SecurityGroup
+			if len(sg.Ports) == 0 {
+				sg.Ports = []string{"tcp/443"}
+			}
+			if sg.Policy == "" {
+				sg.Policy = "accept"
+			}
+			if sg.Priority == 0 {
+				sg.Priority = 1
+			}
+			if sg.NicType == "" {
+				sg.NicType = "intranet"
+			}
+			if sg.Marker == "" {
+				sg.Marker = "managed-by:aliyun-dcdn-firewall-sync/" + sink.Name
+			}
+			if sg.MaxRules == 0 {
+				sg.MaxRules = DefaultSecurityGroupMaxRules
+			}
+		case "slb_acl", "alb_acl":
+			if sink.ACL.Ownership == "" {
+				sink.ACL.Ownership = "managed"
+			}
+			if sink.ACL.Marker == "" {
+				sink.ACL.Marker = "managed-by/aliyun-dcdn-firewall-sync/" + sink.Name
+			}
+		case "waf_whitelist":
+			if sink.WAF.StateDir == "" {
+				sink.WAF.StateDir = "data/waf"
+			}
+		case "rds_whitelist", "polardb_whitelist", "redis_whitelist":
+			if sink.DBWhitelist.GroupName == "" {
+				sink.DBWhitelist.GroupName = "dcdn_firewall_sync"
+			}
+			if sink.DBWhitelist.MaxEntries == 0 {
+				sink.DBWhitelist.MaxEntries = DefaultDBWhitelistMaxEntries
+			}
+		case "host_firewall":
+			host := &sink.HostFirewall
+			if host.Name == "" {
+				host.Name = "dcdn_origin"
+				if host.Backend == "iptables" {
+					host.Name = "DCDN_ORIGIN"
+				}
+			}
+			if host.Table == "" {
+				host.Table = "dcdn_firewall_sync"
+			}
+			if host.OutputDir == "" {
+				host.OutputDir = "data/host-firewall"
+			}
+		case "proxy_config":
+			proxy := &sink.ProxyConfig
+			if proxy.CommandTimeout == "" {
+				proxy.CommandTimeout = "30s"
+			}
+			if proxy.Snippet == "" {
+				proxy.Snippet = "dcdn_trusted_proxies"
+			}
+		case "kubernetes":
+			k8s := &sink.Kubernetes
+			if k8s.ConfigMap == "" {
+				k8s.ConfigMap = sink.Name
+			}
+			if k8s.ConfigMapKey == "" {
+				k8s.ConfigMapKey = "cidrs"
+			}
+			if k8s.CalicoAPIVersion == "" {
+				k8s.CalicoAPIVersion = "crd.projectcalico.org/v1"
+			}
+			if k8s.FieldManager == "" {
+				k8s.FieldManager = "aliyun-dcdn-firewall-sync"
+			}
+		case "webhook":
+			webhook := &sink.Webhook
+			if webhook.Method == "" {
+				webhook.Method = "POST"
+			}
+			if webhook.ContentType == "" {
+				webhook.ContentType = "application/json"
+				if webhook.BodyTemplate != "" {
+					webhook.ContentType = "text/plain; charset=utf-8"
+				}
+			}
+			if webhook.Timeout == "" {
+				webhook.Timeout = "10s"
+			}
+			if webhook.StateDir == "" {
+				webhook.StateDir = "data/webhook"
+			}
+		case "oss_policy":
+			oss := &sink.OSSPolicy
+			if oss.StatementSid == "" {
+				oss.StatementSid = "aliyun-dcdn-firewall-sync"
+			}
+			if oss.SnapshotDir == "" {
+				oss.SnapshotDir = "data/oss-policy-snapshots"
+			}
+			if oss.KeepSnapshots == 0 {
+				oss.KeepSnapshots = 10
+			}
+		}
+	}
+}
+
+// validateSinks 验证写入目标配置
+func validateSinks(config *Config) error {
+	seen := make(map[string]bool)
+	for _, sink := range config.Sinks {
+		if sink.Name == "" {
+			return fmt.Errorf("写入目标名称不能为空")
+		}
+		if seen[sink.Name] {
+			return fmt.Errorf("写入目标名称 %s 重复", sink.Name)
+		}
+		seen[sink.Name] = true
+
+		if config.FindAddressGroup(sink.Group) == nil {
+			return fmt.Errorf("写入目标 %s 引用了不存在的地址组: %s", sink.Name, sink.Group)
+		}
+
+		switch sink.Type {
+		case "ecs_security_group":
+			if err := validateSecurityGroupSink(sink); err != nil {
+				return err
+			}
+		case "slb_acl", "alb_acl":
+			if err := validateACLSink(sink); err != nil {
+				return err
+			}
+		case "waf_whitelist":
+			if err := validateWAFSink(sink); err != nil {
+				return err
+			}
+		case "rds_whitelist", "polardb_whitelist", "redis_whitelist":
+			if err := validateDBWhitelistSink(sink, config.FindAddressGroup(sink.Group)); err != nil {
+				return err
+			}
+		case "oss_policy":
+			if err := validateOSSPolicySink(sink); err != nil {
+				return err
+			}
+		case "host_firewall":
+			if err := validateHostFirewallSink(sink); err != nil {
+				return err
+			}
+		case "proxy_config":
+			if err := validateProxyConfigSink(sink); err != nil {
+				return err
+			}
+		case "kubernetes":
+			if err := validateKubernetesSink(sink); err != nil {
+				return err
+			}
+		case "webhook":
+			if err := validateWebhookSink(sink); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("写入目标 %s 不支持的类型: %s（可选 ecs_security_group、slb_acl、alb_acl、waf_whitelist、"+
+				"rds_whitelist、polardb_whitelist、redis_whitelist、oss_policy、host_firewall、proxy_config、kubernetes、webhook）",
+				sink.Name, sink.Type)
+		}
+	}
+	return nil
+}
+
+// validateSecurityGroupSink 验证ECS安全组写入配置
+func validateSecurityGroupSink(sink SinkConfig) error {
+	sg := sink.SecurityGroup
+	if len(sg.SecurityGroupIDs) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置security_group.security_group_ids", sink.Name)
+	}
+	for _, spec := range sg.Ports {
+		if _, _, err := ParsePortSpec(spec); err != nil {
+			return fmt.Errorf("写入目标 %s 的ports无效: %v", sink.Name, err)
+		}
+	}
+	switch sg.Policy {
+	case "accept", "drop":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的规则策略: %s（可选 accept、drop）", sink.Name, sg.Policy)
+	}
+	if sg.Priority < 1 || sg.Priority > 100 {
+		return fmt.Errorf("写入目标 %s 的priority应在1到100之间", sink.Name)
+	}
+	switch sg.NicType {
+	case "intranet", "internet":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的网卡类型: %s（可选 intranet、internet）", sink.Name, sg.NicType)
+	}
+	if len(sg.Marker) > 512 {
+		return fmt.Errorf("写入目标 %s 的marker超过安全组规则描述的512字符上限", sink.Name)
+	}
+	if sg.MaxRules < 0 {
+		return fmt.Errorf("写入目标 %s 的max_rules不能为负数", sink.Name)
+	}
+	return nil
+}
+
+// validateACLSink 验证CLB/ALB访问控制列表写入配置
+func validateACLSink(sink SinkConfig) error {
+	acl := sink.ACL
+	if len(acl.ACLIDs) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置acl.acl_ids", sink.Name)
+	}
+	switch acl.Ownership {
+	case "full", "managed":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的归属模式: %s（可选 full、managed）", sink.Name, acl.Ownership)
+	}
+	if len(acl.Marker) < 2 || len(acl.Marker) > 100 {
+		return fmt.Errorf("写入目标 %s 的marker长度应为2到100个字符", sink.Name)
+	}
+	if acl.MaxEntries < 0 {
+		return fmt.Errorf("写入目标 %s 的max_entries不能为负数", sink.Name)
+	}
+	return nil
+}
+
+// validateWAFSink 验证WAF白名单规则写入配置
+func validateWAFSink(sink SinkConfig) error {
+	waf := sink.WAF
+	if !isWAFRegion(sink.Region) {
+		return fmt.Errorf("写入目标 %s 的区域 %s 无效，WAF 3.0只支持 cn-hangzhou（中国内地实例）和 ap-southeast-1（非中国内地实例）", sink.Name, sink.Region)
+	}
+	if waf.InstanceID == "" {
+		return fmt.Errorf("写入目标 %s 必须设置waf.instance_id", sink.Name)
+	}
+	if len(waf.RuleIDs) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置waf.rule_ids", sink.Name)
+	}
+	for _, id := range waf.RuleIDs {
+		if id <= 0 {
+			return fmt.Errorf("写入目标 %s 的规则ID无效: %d", sink.Name, id)
+		}
+	}
+	if waf.MaxEntries < 0 {
+		return fmt.Errorf("写入目标 %s 的max_entries不能为负数", sink.Name)
+	}
+	if strings.ContainsAny(sink.Name, "/\\") {
+		return fmt.Errorf("写入目标 %s 的名称用作状态文件名，不能包含路径分隔符", sink.Name)
+	}
+	return nil
+}
+
+// validateDBWhitelistSink 验证数据库白名单写入配置
+func validateDBWhitelistSink(sink SinkConfig, group *AddressGroup) error {
+	db := sink.DBWhitelist
+	if len(db.InstanceIDs) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置db_whitelist.instance_ids", sink.Name)
+	}
+	if !dbWhitelistGroupName.MatchString(db.GroupName) {
+		return fmt.Errorf("写入目标 %s 的白名单分组名 %s 无效，应为2-120个字符，由小写字母、数字和下划线组成，以小写字母开头、字母或数字结尾",
+			sink.Name, db.GroupName)
+	}
+	// 默认分组可能包含业务自行维护的地址，覆盖写入会造成业务中断
+	if db.GroupName == "default" {
+		return fmt.Errorf("写入目标 %s 不能写入default白名单分组，请使用单独的分组名", sink.Name)
+	}
+	if db.MaxEntries < 0 {
+		return fmt.Errorf("写入目标 %s 的max_entries不能为负数", sink.Name)
+	}
+	if group.IPType != "" && group.IPType != "ipv4" {
+		return fmt.Errorf("写入目标 %s 只支持IPv4地址组，地址组 %s 的ip_type为 %s", sink.Name, group.GroupName, group.IPType)
+	}
+	return nil
+}
+
+// validateOSSPolicySink 验证OSS Bucket Policy写入配置
+func validateOSSPolicySink(sink SinkConfig) error {
+	oss := sink.OSSPolicy
+	if len(oss.Buckets) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置oss_policy.buckets", sink.Name)
+	}
+	for _, bucket := range oss.Buckets {
+		if bucket == "" || strings.ContainsAny(bucket, "./") {
+			return fmt.Errorf("写入目标 %s 的Bucket名称无效: %q", sink.Name, bucket)
+		}
+	}
+	if oss.KeepSnapshots < 1 {
+		return fmt.Errorf("写入目标 %s 的keep_snapshots至少为1", sink.Name)
+	}
+	// 名称用作快照目录名，"."和".."会让快照写到snapshot_dir之外
+	if strings.ContainsAny(sink.Name, "/\\") || sink.Name == "." || sink.Name == ".." {
+		return fmt.Errorf("写入目标 %s 的名称用作快照目录名，不能包含路径分隔符或为.、..", sink.Name)
+	}
+	return nil
+}
+
+// validateHostFirewallSink 验证本机防火墙写入配置
+func validateHostFirewallSink(sink SinkConfig) error {
+	host := sink.HostFirewall
+	switch host.Backend {
+	case "nftables", "ipset", "iptables":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的本机防火墙: %s（可选 nftables、ipset、iptables）", sink.Name, host.Backend)
+	}
+	// 名称会出现在规则文件和命令中，限制为字母、数字、下划线和连字符
+	if !identifierPattern.MatchString(host.Name) || !identifierPattern.MatchString(host.Table) {
+		return fmt.Errorf("写入目标 %s 的name和table只能包含字母、数字、下划线和连字符，且以字母开头", sink.Name)
+	}
+	// ipset集合名最长31个字符，iptables链名最长28个字符
+	if host.Backend == "ipset" && len(host.Name)+len("_v4-tmp") > 31 {
+		return fmt.Errorf("写入目标 %s 的name过长，ipset集合名（含 _v4-tmp 后缀）最长31个字符", sink.Name)
+	}
+	if host.Backend == "iptables" && len(host.Name) > 28 {
+		return fmt.Errorf("写入目标 %s 的name过长，iptables链名最长28个字符", sink.Name)
+	}
+	if strings.ContainsAny(sink.Name, "/\\") {
+		return fmt.Errorf("写入目标 %s 的名称用作规则文件名，不能包含路径分隔符", sink.Name)
+	}
+	return nil
+}
+
+// validateProxyConfigSink 验证反向代理配置文件写入配置
+func validateProxyConfigSink(sink SinkConfig) error {
+	proxy := sink.ProxyConfig
+	switch proxy.Format {
+	case "nginx_real_ip", "nginx_allow", "haproxy_acl", "caddy", "envoy_rbac", "envoy_xff":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的格式: %s（可选 nginx_real_ip、nginx_allow、haproxy_acl、caddy、envoy_rbac、envoy_xff）",
+			sink.Name, proxy.Format)
+	}
+	if proxy.Path == "" {
+		return fmt.Errorf("写入目标 %s 必须设置proxy_config.path", sink.Name)
+	}
+	if timeout, err := time.ParseDuration(proxy.CommandTimeout); err != nil || timeout <= 0 {
+		return fmt.Errorf("写入目标 %s 的command_timeout无效: %s", sink.Name, proxy.CommandTimeout)
+	}
+	if !identifierPattern.MatchString(proxy.Snippet) {
+		return fmt.Errorf("写入目标 %s 的snippet只能包含字母、数字、下划线和连字符，且以字母开头", sink.Name)
+	}
+	return nil
+}
+
+// validateKubernetesSink 验证Kubernetes写入配置
+func validateKubernetesSink(sink SinkConfig) error {
+	k8s := sink.Kubernetes
+	// 写入目标名称用作归属标签的值
+	if errs := validation.IsValidLabelValue(sink.Name); len(errs) > 0 {
+		return fmt.Errorf("写入目标 %s 的名称用作Kubernetes标签值: %s", sink.Name, strings.Join(errs, "; "))
+	}
+	if k8s.Namespace != "" {
+		if errs := validation.IsDNS1123Label(k8s.Namespace); len(errs) > 0 {
+			return fmt.Errorf("写入目标 %s 的namespace无效: %s", sink.Name, strings.Join(errs, "; "))
+		}
+	}
+	for field, name := range map[string]string{
+		"configmap":          k8s.ConfigMap,
+		"network_policy":     k8s.NetworkPolicy,
+		"global_network_set": k8s.GlobalNetworkSet,
+	} {
+		if name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return fmt.Errorf("写入目标 %s 的%s无效: %s", sink.Name, field, strings.Join(errs, "; "))
+		}
+	}
+	if errs := validation.IsConfigMapKey(k8s.ConfigMapKey); len(errs) > 0 {
+		return fmt.Errorf("写入目标 %s 的configmap_key无效: %s", sink.Name, strings.Join(errs, "; "))
+	}
+	for _, labels := range []map[string]string{k8s.PodSelector, k8s.Labels} {
+		for key, value := range labels {
+			errs := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...)
+			if len(errs) > 0 {
+				return fmt.Errorf("写入目标 %s 的标签 %s=%s 无效: %s", sink.Name, key, value, strings.Join(errs, "; "))
+			}
+		}
+	}
+	for _, spec := range k8s.Ports {
+		protocol, _, err := ParsePortSpec(spec)
+		if err != nil {
+			return fmt.Errorf("写入目标 %s 的ports无效: %v", sink.Name, err)
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("写入目标 %s 的ports只支持tcp和udp: %s", sink.Name, spec)
+		}
+	}
+	if len(k8s.Ports) > 0 && k8s.NetworkPolicy == "" {
+		return fmt.Errorf("写入目标 %s 设置了ports但未设置network_policy", sink.Name)
+	}
+	switch k8s.CalicoAPIVersion {
+	case "crd.projectcalico.org/v1", "projectcalico.org/v3":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的calico_api_version: %s（可选 crd.projectcalico.org/v1、projectcalico.org/v3）",
+			sink.Name, k8s.CalicoAPIVersion)
+	}
+	return nil
+}
+
+// validateWebhookSink 验证Webhook写入配置
+func validateWebhookSink(sink SinkConfig) error {
+	webhook := sink.Webhook
+	if len(webhook.URLs) == 0 {
+		return fmt.Errorf("写入目标 %s 必须设置webhook.urls", sink.Name)
+	}
+	for _, raw := range webhook.URLs {
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("写入目标 %s 的地址无效: %s", sink.Name, raw)
+		}
+	}
+	switch webhook.Method {
+	case "POST", "PUT":
+	default:
+		return fmt.Errorf("写入目标 %s 不支持的请求方法: %s（可选 POST、PUT）", sink.Name, webhook.Method)
+	}
+	if webhook.BodyTemplate != "" {
+		if _, err := ParseWebhookTemplate(sink.Name, webhook.BodyTemplate); err != nil {
+			return fmt.Errorf("写入目标 %s 的body_template无效: %v", sink.Name, err)
+		}
+	}
+	if d, err := time.ParseDuration(webhook.Timeout); err != nil || d <= 0 {
+		return fmt.Errorf("写入目标 %s 的timeout无效: %s", sink.Name, webhook.Timeout)
+	}
+	for _, status := range webhook.ExpectStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("写入目标 %s 的expect_status无效: %d", sink.Name, status)
+		}
+	}
+	if _, err := regexp.Compile(webhook.ExpectBody); err != nil {
+		return fmt.Errorf("写入目标 %s 的expect_body无效: %v", sink.Name, err)
+	}
+	if strings.ContainsAny(sink.Name, "/\\") {
+		return fmt.Errorf("写入目标 %s 的名称用作状态文件名，不能包含路径分隔符", sink.Name)
+	}
+	return nil
+}
+
+// isWAFRegion 判断是否为WAF 3.0的OpenAPI区域
+func isWAFRegion(region string) bool {
+	return region == "cn-hangzhou" || region == "ap-southeast-1"
+}
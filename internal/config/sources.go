@@ -0,0 +1,276 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+)
+
+// 内置数据源
+const (
+	SourceDCDNL2    = "dcdn_l2"    // DCDN L2节点IP列表
+	SourceCDNL2     = "cdn_l2"     // 经典CDN在cdn.domains中各域名的L2节点IP合并结果
+	SourceESAOrigin = "esa_origin" // ESA在esa.site_ids中各站点的回源IP白名单合并结果
+)
+
+// DefaultMaxInvalidRatio 未配置max_invalid_ratio时允许的解析失败条目比例
+const DefaultMaxInvalidRatio = 0.1
+
+// SourceConfig 具名数据源配置，可在地址组表达式中按名称引用
+type SourceConfig struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`     // static（配置中直接列出的条目）、file（本地文件）、url（HTTP(S)地址）、cdn（经典CDN域名的L2节点IP）、esa（ESA站点的回源IP白名单）
+	Entries []string `yaml:"entries"`  // static类型的条目
+	Path    string   `yaml:"path"`     // file类型的文件路径
+	URL     string   `yaml:"url"`      // url类型的地址
+	Domains []string `yaml:"domains"`  // cdn类型的加速域名，使用cdn段的凭证
+	SiteIDs []int64  `yaml:"site_ids"` // esa类型的站点ID，使用esa段的凭证和白名单配置
+
+	// 内容格式：txt（每行一个IP或CIDR）、csv、json、yaml，为空时按文件扩展名或Content-Type判断
+	Format string `yaml:"format"`
+	// csv格式为列名或列号（从0开始），默认第一列；json/yaml格式为以点分隔的字段路径，如 "prefixes.ip_prefix"，
+	// 为空时支持字符串数组，或对象数组中的 ip、cidr、prefix、ip_prefix、ipv6_prefix、address 字段
+	Field string `yaml:"field"`
+	// 允许解析失败的条目比例，超过时本次获取失败，默认0.1；设置为0表示不允许任何解析失败
+	MaxInvalidRatio *float64 `yaml:"max_invalid_ratio"`
+
+	// 以下为url类型的配置
+	Timeout  string            `yaml:"timeout"`   // 请求超时时间，默认30s
+	Headers  map[string]string `yaml:"headers"`   // 附加的请求头，如认证信息
+	CacheDir string            `yaml:"cache_dir"` // 缓存目录，保存最近一次成功获取的内容和ETag/Last-Modified，默认为状态文件所在目录下的sources
+	// 内容校验：固定的摘要值，如 "sha256:<hex>"
+	Checksum string `yaml:"checksum"`
+	// 内容校验：摘要文件地址，文件内容为sha256十六进制摘要（可带文件名，如 sha256sum 的输出）
+	ChecksumURL string `yaml:"checksum_url"`
+	// 内容签名：签名文件地址和Ed25519公钥（base64），签名文件内容为base64编码的签名
+	SignatureURL string `yaml:"signature_url"`
+	PublicKey    string `yaml:"public_key"`
+}
+
+// InvalidRatio 返回允许解析失败的条目比例
+func (s *SourceConfig) InvalidRatio() float64 {
+	if s.MaxInvalidRatio == nil {
+		return DefaultMaxInvalidRatio
+	}
+	return *s.MaxInvalidRatio
+}
+
+// ParseSourceTerm 拆分表达式中的名称
+// "group:legacy" 返回 ("group", "legacy")；不带类型前缀的名称返回 ("", name)
+func ParseSourceTerm(term string) (kind, ref string) {
+	if i := strings.Index(term, ":"); i > 0 {
+		return term[:i], term[i+1:]
+	}
+	return "", term
+}
+
+// FindSource 按名称查找具名数据源
+func (c *Config) FindSource(name string) *SourceConfig {
+	for i := range c.Sources {
+		if c.Sources[i].Name == name {
+			return &c.Sources[i]
+		}
+	}
+	return nil
+}
+
+// FindAddressGroup 按名称查找地址组
+func (c *Config) FindAddressGroup(name string) *AddressGroup {
+	for i := range c.Sync.AddressGroups {
+		if c.Sync.AddressGroups[i].GroupName == name {
+			return &c.Sync.AddressGroups[i]
+		}
+	}
+	return nil
+}
+
+// isBuiltinSource 判断名称是否为内置数据源
+func isBuiltinSource(name string) bool {
+	return name == SourceDCDNL2 || name == SourceCDNL2 || name == SourceESAOrigin
+}
+
+// validateSources 验证具名数据源配置
+func validateSources(config *Config) error {
+	switch config.ESA.Whitelist {
+	case "current", "latest", "both":
+	default:
+		return fmt.Errorf("不支持的ESA白名单类型: %s（可选 current、latest、both）", config.ESA.Whitelist)
+	}
+
+	seen := make(map[string]bool)
+	for _, source := range config.Sources {
+		if source.Name == "" {
+			return fmt.Errorf("数据源名称不能为空")
+		}
+		if isBuiltinSource(source.Name) || strings.ContainsAny(source.Name, ": ()") {
+			return fmt.Errorf("数据源名称 %s 无效（不能使用内置名称 %s、%s、%s，不能包含冒号、空格或括号）",
+				source.Name, SourceDCDNL2, SourceCDNL2, SourceESAOrigin)
+		}
+		if seen[source.Name] {
+			return fmt.Errorf("数据源名称 %s 重复", source.Name)
+		}
+		seen[source.Name] = true
+
+		switch source.Type {
+		case "static":
+			if len(source.Entries) == 0 {
+				return fmt.Errorf("数据源 %s 为static类型时必须设置entries", source.Name)
+			}
+		case "file":
+			if source.Path == "" {
+				return fmt.Errorf("数据源 %s 为file类型时必须设置path", source.Name)
+			}
+		case "url":
+			if !strings.HasPrefix(source.URL, "http://") && !strings.HasPrefix(source.URL, "https://") {
+				return fmt.Errorf("数据源 %s 为url类型时必须设置http(s)地址", source.Name)
+			}
+			if source.Timeout != "" {
+				if _, err := time.ParseDuration(source.Timeout); err != nil {
+					return fmt.Errorf("数据源 %s 解析timeout失败: %v", source.Name, err)
+				}
+			}
+			if source.Checksum != "" && !strings.HasPrefix(source.Checksum, "sha256:") {
+				return fmt.Errorf("数据源 %s 的checksum格式应为 sha256:<十六进制摘要>", source.Name)
+			}
+			if (source.SignatureURL == "") != (source.PublicKey == "") {
+				return fmt.Errorf("数据源 %s 的signature_url和public_key需要同时设置", source.Name)
+			}
+		case "cdn":
+			if len(source.Domains) == 0 {
+				return fmt.Errorf("数据源 %s 为cdn类型时必须设置domains", source.Name)
+			}
+		case "esa":
+			if len(source.SiteIDs) == 0 {
+				return fmt.Errorf("数据源 %s 为esa类型时必须设置site_ids", source.Name)
+			}
+		default:
+			return fmt.Errorf("数据源 %s 不支持的类型: %s（可选 static、file、url、cdn、esa）", source.Name, source.Type)
+		}
+
+		switch source.Format {
+		case "", "txt", "csv", "json", "yaml":
+		default:
+			return fmt.Errorf("数据源 %s 不支持的格式: %s（可选 txt、csv、json、yaml）", source.Name, source.Format)
+		}
+		// csv格式按列号取值时列号从0开始，格式可能按扩展名判断，因此不论format都检查
+		if index, err := strconv.Atoi(source.Field); err == nil && index < 0 {
+			return fmt.Errorf("数据源 %s 的field为列号时不能为负数: %s", source.Name, source.Field)
+		}
+		if ratio := source.InvalidRatio(); ratio < 0 || ratio > 1 {
+			return fmt.Errorf("数据源 %s 的max_invalid_ratio应在0到1之间", source.Name)
+		}
+	}
+	return nil
+}
+
+// validateExpressions 验证地址组表达式的语法和引用，并检查地址组之间的循环引用
+func validateExpressions(config *Config) error {
+	refs := make(map[string][]string)
+
+	for _, group := range config.Sync.AddressGroups {
+		if group.Expression == "" {
+			continue
+		}
+		expr, err := ipset.ParseExpr(group.Expression)
+		if err != nil {
+			return fmt.Errorf("地址组 %s 的表达式无效: %v", group.GroupName, err)
+		}
+
+		for _, term := range expr.Terms() {
+			kind, ref := ParseSourceTerm(term)
+			switch kind {
+			case "":
+				if ref == SourceCDNL2 && len(config.CDN.Domains) == 0 {
+					return fmt.Errorf("地址组 %s 的表达式引用了 %s，但没有配置cdn.domains", group.GroupName, SourceCDNL2)
+				}
+				if ref == SourceESAOrigin && len(config.ESA.SiteIDs) == 0 {
+					return fmt.Errorf("地址组 %s 的表达式引用了 %s，但没有配置esa.site_ids", group.GroupName, SourceESAOrigin)
+				}
+				if !isBuiltinSource(ref) && config.FindSource(ref) == nil {
+					return fmt.Errorf("地址组 %s 的表达式引用了未定义的数据源: %s", group.GroupName, ref)
+				}
+			case "static":
+				source := config.FindSource(ref)
+				if source == nil || source.Type != "static" {
+					return fmt.Errorf("地址组 %s 的表达式引用了未定义的static数据源: %s", group.GroupName, ref)
+				}
+			case "file":
+				if ref == "" {
+					return fmt.Errorf("地址组 %s 的表达式中 file: 后缺少文件路径", group.GroupName)
+				}
+			case "url":
+				if !strings.HasPrefix(ref, "http://") && !strings.HasPrefix(ref, "https://") {
+					return fmt.Errorf("地址组 %s 的表达式中 url: 后应为http(s)地址", group.GroupName)
+				}
+			case "cdn":
+				if ref == "" {
+					return fmt.Errorf("地址组 %s 的表达式中 cdn: 后缺少加速域名", group.GroupName)
+				}
+			case "esa":
+				if _, err := strconv.ParseInt(ref, 10, 64); err != nil {
+					return fmt.Errorf("地址组 %s 的表达式中 esa: 后应为站点ID", group.GroupName)
+				}
+			case "group":
+				if config.FindAddressGroup(ref) == nil {
+					return fmt.Errorf("地址组 %s 的表达式引用了不存在的地址组: %s", group.GroupName, ref)
+				}
+				refs[group.GroupName] = append(refs[group.GroupName], ref)
+			default:
+				return fmt.Errorf("地址组 %s 的表达式中有不支持的引用类型: %s（可选 static、file、url、cdn、esa、group）", group.GroupName, kind)
+			}
+		}
+	}
+
+	// 深度优先检查地址组之间的循环引用
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("地址组表达式存在循环引用: %s", strings.Join(append(path, name), " -> "))
+		case done:
+			return nil
+		}
+		marks[name] = visiting
+		for _, ref := range refs[name] {
+			if err := visit(ref, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = done
+		return nil
+	}
+	for _, group := range config.Sync.AddressGroups {
+		if err := visit(group.GroupName, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UsesDCDN 判断是否有地址组需要DCDN L2节点IP列表
+// 未配置表达式的地址组默认使用DCDN列表
+func (c *Config) UsesDCDN() bool {
+	for _, group := range c.Sync.AddressGroups {
+		if group.Expression == "" {
+			return true
+		}
+		expr, err := ipset.ParseExpr(group.Expression)
+		if err != nil {
+			continue
+		}
+		for _, term := range expr.Terms() {
+			if term == SourceDCDNL2 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package leader
+
+import (
+	"context"
+	"fmt"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// FlockBackend 当前平台不支持文件锁选主
+type FlockBackend struct{}
+
+// NewFlockBackend 当前平台不支持flock，返回错误
+func NewFlockBackend(cfg *config.LeaderElectionConfig) (*FlockBackend, error) {
+	return nil, fmt.Errorf("当前平台不支持flock选主后端")
+}
+
+// Name 后端名称
+func (b *FlockBackend) Name() string {
+	return "flock"
+}
+
+// Campaign 不可用
+func (b *FlockBackend) Campaign(ctx context.Context, identity string, callbacks Callbacks) {}
@@ -0,0 +1,106 @@
+//go:build unix
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// FlockBackend 基于本地文件锁的选主后端，适用于同一主机上的多个实例
+// 持锁进程退出时内核自动释放文件锁，其他实例在下一次重试时接管
+type FlockBackend struct {
+	path        string
+	retryPeriod time.Duration
+}
+
+// NewFlockBackend 创建文件锁选主后端
+func NewFlockBackend(cfg *config.LeaderElectionConfig) (*FlockBackend, error) {
+	retryPeriod, err := time.ParseDuration(cfg.RetryPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("解析retry_period失败: %v", err)
+	}
+
+	return &FlockBackend{
+		path:        cfg.LockFile,
+		retryPeriod: retryPeriod,
+	}, nil
+}
+
+// Name 后端名称
+func (b *FlockBackend) Name() string {
+	return "flock"
+}
+
+// Campaign 循环尝试获取文件锁，获取成功后持有直到ctx取消
+func (b *FlockBackend) Campaign(ctx context.Context, identity string, callbacks Callbacks) {
+	ticker := time.NewTicker(b.retryPeriod)
+	defer ticker.Stop()
+
+	for {
+		file, acquired, err := b.tryLock(identity)
+		if err != nil {
+			log.Printf("获取文件锁 %s 失败: %v", b.path, err)
+		} else if acquired {
+			callbacks.OnNewLeader(identity)
+			callbacks.OnStartedLeading()
+
+			<-ctx.Done()
+
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+			callbacks.OnStoppedLeading()
+			return
+		} else if holder := b.holder(); holder != "" {
+			callbacks.OnNewLeader(holder)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryLock 以非阻塞方式获取文件锁，成功后写入持有者标识
+func (b *FlockBackend) tryLock(identity string) (*os.File, bool, error) {
+	file, err := os.OpenFile(b.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if err := file.Truncate(0); err == nil {
+		fmt.Fprintf(file, "%s %s\n", identity, time.Now().Format(time.RFC3339))
+	}
+
+	return file, true, nil
+}
+
+// holder 读取锁文件中记录的持有者标识
+func (b *FlockBackend) holder() string {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,125 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// KubernetesBackend 基于coordination.k8s.io/v1 Lease的选主后端，适用于集群中的多副本部署
+type KubernetesBackend struct {
+	clientset     kubernetes.Interface
+	namespace     string
+	name          string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+// NewKubernetesBackend 创建Lease选主后端
+// 优先使用集群内配置，否则读取KUBECONFIG或kubeconfig配置项
+func NewKubernetesBackend(cfg *config.LeaderElectionConfig) (*KubernetesBackend, error) {
+	restConfig, err := client.LoadKubeConfig(cfg.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("创建Kubernetes客户端失败: %v", err)
+	}
+
+	return NewKubernetesBackendWithClient(cfg, clientset)
+}
+
+// NewKubernetesBackendWithClient 使用已有的clientset创建Lease选主后端
+func NewKubernetesBackendWithClient(cfg *config.LeaderElectionConfig, clientset kubernetes.Interface) (*KubernetesBackend, error) {
+	leaseDuration, err := time.ParseDuration(cfg.LeaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("解析lease_duration失败: %v", err)
+	}
+	renewDeadline, err := time.ParseDuration(cfg.RenewDeadline)
+	if err != nil {
+		return nil, fmt.Errorf("解析renew_deadline失败: %v", err)
+	}
+	retryPeriod, err := time.ParseDuration(cfg.RetryPeriod)
+	if err != nil {
+		return nil, fmt.Errorf("解析retry_period失败: %v", err)
+	}
+
+	return &KubernetesBackend{
+		clientset:     clientset,
+		namespace:     client.KubernetesNamespace(cfg.LeaseNamespace),
+		name:          cfg.LeaseName,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+	}, nil
+}
+
+// Name 后端名称
+func (b *KubernetesBackend) Name() string {
+	return "kubernetes"
+}
+
+// Campaign 通过Lease参与选主，失去leader身份后重新参与，直到ctx取消
+func (b *KubernetesBackend) Campaign(ctx context.Context, identity string, callbacks Callbacks) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      b.name,
+			Namespace: b.namespace,
+		},
+		Client: b.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	for ctx.Err() == nil {
+		// client-go在Run返回时总会调用OnStoppedLeading，未成为过leader时不应通知失去身份
+		var led atomic.Bool
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   b.leaseDuration,
+			RenewDeadline:   b.renewDeadline,
+			RetryPeriod:     b.retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            b.name,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) {
+					led.Store(true)
+					callbacks.OnStartedLeading()
+				},
+				OnStoppedLeading: func() {
+					if led.Load() {
+						callbacks.OnStoppedLeading()
+					}
+				},
+				OnNewLeader: callbacks.OnNewLeader,
+			},
+		})
+		if err != nil {
+			// 配置错误无法通过重试恢复
+			log.Printf("创建Lease选主器失败: %v", err)
+			return
+		}
+
+		// Run在失去leader身份或ctx取消时返回
+		elector.Run(ctx)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(b.retryPeriod):
+		}
+	}
+}
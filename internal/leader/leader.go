@@ -0,0 +1,152 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// Callbacks 选主状态变化回调
+type Callbacks struct {
+	OnStartedLeading func()                // 当前实例成为leader
+	OnStoppedLeading func()                // 当前实例失去leader身份
+	OnNewLeader      func(identity string) // 观察到新的leader
+}
+
+// Backend 选主后端
+type Backend interface {
+	// Name 后端名称
+	Name() string
+	// Campaign 以identity参与选主，直到ctx取消才返回，返回前释放持有的锁
+	Campaign(ctx context.Context, identity string, callbacks Callbacks)
+}
+
+// Elector 选主器，记录当前实例的角色供调度器和状态查询使用
+type Elector struct {
+	backend  Backend
+	identity string
+
+	mu          sync.Mutex
+	isLeader    bool
+	leader      string
+	since       time.Time
+	transitions int
+}
+
+// NewElector 根据配置创建选主器
+func NewElector(cfg *config.LeaderElectionConfig) (*Elector, error) {
+	identity := cfg.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("获取主机名失败: %v", err)
+		}
+		identity = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	var backend Backend
+	var err error
+	switch cfg.Backend {
+	case "flock":
+		backend, err = NewFlockBackend(cfg)
+	case "kubernetes":
+		backend, err = NewKubernetesBackend(cfg)
+	default:
+		err = fmt.Errorf("不支持的选主后端: %s", cfg.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Elector{
+		backend:  backend,
+		identity: identity,
+		since:    time.Now(),
+	}, nil
+}
+
+// Run 参与选主，直到ctx取消才返回
+// onStarted和onStopped在角色变化时调用，用于触发同步或取消正在运行的任务
+func (e *Elector) Run(ctx context.Context, onStarted, onStopped func()) {
+	log.Printf("参与选主: 后端 %s，实例标识 %s", e.backend.Name(), e.identity)
+
+	e.backend.Campaign(ctx, e.identity, Callbacks{
+		OnStartedLeading: func() {
+			e.setRole(true, e.identity)
+			log.Printf("当前实例 %s 成为leader", e.identity)
+			if onStarted != nil {
+				onStarted()
+			}
+		},
+		OnStoppedLeading: func() {
+			e.setRole(false, "")
+			log.Printf("当前实例 %s 不再是leader", e.identity)
+			if onStopped != nil {
+				onStopped()
+			}
+		},
+		OnNewLeader: func(identity string) {
+			e.mu.Lock()
+			changed := e.leader != identity
+			e.leader = identity
+			e.mu.Unlock()
+			if changed && identity != e.identity {
+				log.Printf("当前leader: %s，本实例 %s 作为follower运行", identity, e.identity)
+			}
+		},
+	})
+}
+
+// setRole 更新当前实例角色
+func (e *Elector) setRole(isLeader bool, leader string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isLeader != isLeader {
+		e.transitions++
+		e.since = time.Now()
+	}
+	e.isLeader = isLeader
+	if leader != "" || !isLeader {
+		e.leader = leader
+	}
+}
+
+// IsLeader 当前实例是否为leader
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.isLeader
+}
+
+// Leader 返回当前已知的leader标识
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Status 返回选主状态
+func (e *Elector) Status() map[string]interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	role := "follower"
+	if e.isLeader {
+		role = "leader"
+	}
+
+	return map[string]interface{}{
+		"backend":     e.backend.Name(),
+		"identity":    e.identity,
+		"role":        role,
+		"leader":      e.leader,
+		"role_since":  e.since.Format("2006-01-02 15:04:05"),
+		"transitions": e.transitions,
+	}
+}
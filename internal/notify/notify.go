@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// Event 通知事件
+type Event struct {
+	Type      string      `json:"type"` // 事件类型，如 drift
+	GroupName string      `json:"group_name,omitempty"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	Time      time.Time   `json:"time"`
+}
+
+// Notifier 将事件以JSON形式POST到配置的Webhook地址
+type Notifier struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewNotifier 创建通知器，未配置Webhook时返回的通知器不发送任何请求
+func NewNotifier(cfg *config.NotificationConfig) *Notifier {
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		timeout = 10 * time.Second
+	}
+
+	return &Notifier{
+		urls:   cfg.WebhookURLs,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify 发送事件，发送失败只记录日志，不影响同步流程
+func (n *Notifier) Notify(event Event) {
+	if n == nil || len(n.urls) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("序列化通知事件失败: %v", err)
+		return
+	}
+
+	for _, url := range n.urls {
+		if err := n.post(url, body); err != nil {
+			log.Printf("发送 %s 通知到 %s 失败: %v", event.Type, url, err)
+		}
+	}
+}
+
+// post 发送单个Webhook请求
+func (n *Notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
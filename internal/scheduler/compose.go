@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// runInputs 单次同步任务内的数据源缓存，每个数据源和地址组只计算一次
+type runInputs struct {
+	ctx       context.Context
+	dcdn      []*models.DCDNSourceIPInfo // DCDN L2节点IP（全部地址族）
+	dcdnIPv4  []*models.DCDNSourceIPInfo
+	fetched   map[string][]*models.DCDNSourceIPInfo
+	failed    map[string]error
+	groups    map[string][]*models.DCDNSourceIPInfo
+	resolving map[string]bool
+}
+
+// newRunInputs 创建单次任务的数据源缓存
+func newRunInputs(ctx context.Context, dcdn, dcdnIPv4 []*models.DCDNSourceIPInfo) *runInputs {
+	return &runInputs{
+		ctx:       ctx,
+		dcdn:      dcdn,
+		dcdnIPv4:  dcdnIPv4,
+		fetched:   make(map[string][]*models.DCDNSourceIPInfo),
+		failed:    make(map[string]error),
+		groups:    make(map[string][]*models.DCDNSourceIPInfo),
+		resolving: make(map[string]bool),
+	}
+}
+
+// groupSourceIPs 计算地址组的源列表
+// 未配置表达式时使用DCDN列表（按ip_type选择地址族），否则使用表达式结果；
+// 之后按包含/排除模式过滤并加入固定条目。group:引用得到的也是这个结果
+func (s *Scheduler) groupSourceIPs(inputs *runInputs, group config.AddressGroup) ([]*models.DCDNSourceIPInfo, error) {
+	if cached, ok := inputs.groups[group.GroupName]; ok {
+		return cached, nil
+	}
+	if inputs.resolving[group.GroupName] {
+		return nil, fmt.Errorf("地址组 %s 存在循环引用", group.GroupName)
+	}
+	inputs.resolving[group.GroupName] = true
+	defer delete(inputs.resolving, group.GroupName)
+
+	var targetIPs []*models.DCDNSourceIPInfo
+	if group.Expression == "" {
+		// 按地址组的ip_type选择地址，默认只使用IPv4
+		targetIPs = inputs.dcdnIPv4
+		if group.IPType == "ipv6" || group.IPType == "both" {
+			targetIPs = inputs.dcdn
+		}
+	} else {
+		var err error
+		targetIPs, err = s.evaluateExpression(inputs, group)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("地址组 %s: 表达式 %s 计算结果 %d 条", group.GroupName, group.Expression, len(targetIPs))
+	}
+
+	// 过滤源IP（如果有过滤规则）
+	filteredIPs := s.filterSourceIPs(targetIPs, group)
+	log.Printf("地址薄 %s: 过滤后剩余 %d 个IP地址", group.GroupName, len(filteredIPs))
+
+	// 固定条目始终写入
+	if len(group.StaticEntries) > 0 {
+		filteredIPs = append(filteredIPs, staticSourceIPs(group)...)
+		log.Printf("地址薄 %s: 加入 %d 个固定条目", group.GroupName, len(group.StaticEntries))
+	}
+
+	inputs.groups[group.GroupName] = filteredIPs
+	return filteredIPs, nil
+}
+
+// evaluateExpression 计算地址组表达式
+// 结果条目沿用数据源中对应条目的信息；差集或交集拆分出的网段沿用原网段的信息
+func (s *Scheduler) evaluateExpression(inputs *runInputs, group config.AddressGroup) ([]*models.DCDNSourceIPInfo, error) {
+	expr, err := ipset.ParseExpr(group.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("解析表达式失败: %w", err)
+	}
+
+	infos := make(map[netip.Prefix]*models.DCDNSourceIPInfo)
+	result, err := expr.Eval(func(term string) (*ipset.Set, error) {
+		list, err := s.resolveTerm(inputs, term)
+		if err != nil {
+			return nil, err
+		}
+
+		set := &ipset.Set{}
+		for _, ip := range list {
+			prefix, err := ipset.ParsePrefix(ip.IP)
+			if err != nil {
+				log.Printf("警告: %s 中的条目无法解析，已跳过: %s", term, ip.IP)
+				continue
+			}
+			set.Add(prefix)
+			if _, ok := infos[prefix]; !ok {
+				infos[prefix] = ip
+			}
+		}
+		return set, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sourceIPs := make([]*models.DCDNSourceIPInfo, 0, result.Len())
+	result.Walk(func(prefix netip.Prefix) bool {
+		info := &models.DCDNSourceIPInfo{
+			Location:    "Global",
+			ISP:         "expression",
+			Status:      "Active",
+			LastUpdated: now,
+		}
+		for bits := prefix.Bits(); bits >= 0; bits-- {
+			if origin, ok := infos[netip.PrefixFrom(prefix.Addr(), bits).Masked()]; ok {
+				copied := *origin
+				info = &copied
+				break
+			}
+		}
+		info.IP = prefix.String()
+		sourceIPs = append(sourceIPs, info)
+		return true
+	})
+	return sourceIPs, nil
+}
+
+// resolveTerm 返回表达式中名称对应的IP列表，同一任务内每个数据源只获取一次
+func (s *Scheduler) resolveTerm(inputs *runInputs, term string) ([]*models.DCDNSourceIPInfo, error) {
+	if term == config.SourceDCDNL2 {
+		return inputs.dcdn, nil
+	}
+
+	kind, ref := config.ParseSourceTerm(term)
+	if kind == "group" {
+		group := s.config.FindAddressGroup(ref)
+		if group == nil {
+			return nil, fmt.Errorf("地址组 %s 不存在", ref)
+		}
+		return s.groupSourceIPs(inputs, *group)
+	}
+
+	if cached, ok := inputs.fetched[term]; ok {
+		return cached, nil
+	}
+	if err, ok := inputs.failed[term]; ok {
+		return nil, err
+	}
+
+	source, err := s.sources.Lookup(term)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*models.DCDNSourceIPInfo
+	err = s.withRetry(inputs.ctx, "获取数据源 "+source.Name(), func() error {
+		var fetchErr error
+		list, fetchErr = source.Fetch(inputs.ctx)
+		return fetchErr
+	})
+	if err != nil {
+		inputs.failed[term] = err
+		return nil, err
+	}
+
+	log.Printf("数据源 %s: 获取到 %d 条", source.Name(), len(list))
+	inputs.fetched[term] = list
+	return list, nil
+}
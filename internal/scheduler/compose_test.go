@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/source"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+func newComposeScheduler(t *testing.T, groups []config.AddressGroup, sources []config.SourceConfig) *Scheduler {
+	t.Helper()
+	cfg := &config.Config{Sources: sources}
+	cfg.State.Path = filepath.Join(t.TempDir(), "state.json")
+	cfg.Sync.AddressGroups = groups
+	return &Scheduler{config: cfg, sources: source.NewRegistry(cfg)}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	dcdn := []*models.DCDNSourceIPInfo{
+		{IP: "10.0.0.0/24", Location: "Hangzhou", ISP: "Aliyun", Origin: "dcdn_l2"},
+		{IP: "192.168.0.0/24", Location: "Beijing", ISP: "Aliyun", Origin: "dcdn_l2"},
+		{IP: "2001:db8::/64", Location: "Shanghai", ISP: "Aliyun", Origin: "dcdn_l2"},
+	}
+	sources := []config.SourceConfig{
+		{Name: "blocked", Type: "static", Entries: []string{"10.0.0.5", "192.168.0.128/25"}},
+		{Name: "office", Type: "static", Entries: []string{"172.16.0.1", "not-an-ip"}},
+		{Name: "narrow", Type: "static", Entries: []string{"10.0.0.64/26", "10.1.0.0/16"}},
+	}
+
+	type entry struct{ ip, location, origin string }
+	tests := []struct {
+		name       string
+		expression string
+		want       []entry
+	}{
+		{
+			name:       "差集拆分的网段沿用原网段的信息",
+			expression: "dcdn_l2 − static:blocked",
+			want: []entry{
+				{"10.0.0.0/30", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.4/32", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.6/31", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.8/29", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.16/28", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.32/27", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.64/26", "Hangzhou", "dcdn_l2"},
+				{"10.0.0.128/25", "Hangzhou", "dcdn_l2"},
+				{"192.168.0.0/25", "Beijing", "dcdn_l2"},
+				{"2001:db8::/64", "Shanghai", "dcdn_l2"},
+			},
+		},
+		{
+			name:       "交集得到的网段沿用包含它的最近网段的信息",
+			expression: "narrow ∩ dcdn_l2",
+			want: []entry{
+				{"10.0.0.64/26", "Static", "static:narrow"},
+			},
+		},
+		{
+			name:       "并集跳过无法解析的条目",
+			expression: "(dcdn_l2 ∩ static:narrow) ∪ office",
+			want: []entry{
+				{"10.0.0.64/26", "Static", "static:narrow"},
+				{"172.16.0.1/32", "Static", "static:office"},
+			},
+		},
+		{
+			name:       "引用其他地址组",
+			expression: "group:base − blocked",
+			want: []entry{
+				{"172.16.0.1/32", "Static", "static:office"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := []config.AddressGroup{
+				{GroupName: "base", Expression: "office ∪ blocked"},
+				{GroupName: "target", Expression: tt.expression},
+			}
+			s := newComposeScheduler(t, groups, sources)
+			inputs := newRunInputs(context.Background(), dcdn, dcdn[:2])
+
+			got, err := s.evaluateExpression(inputs, groups[1])
+			if err != nil {
+				t.Fatalf("evaluateExpression 返回错误: %v", err)
+			}
+			var entries []entry
+			for _, ip := range got {
+				entries = append(entries, entry{ip.IP, ip.Location, ip.Origin})
+			}
+			if !slices.Equal(entries, tt.want) {
+				t.Errorf("evaluateExpression(%q) = %v, 期望 %v", tt.expression, entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionErrors(t *testing.T) {
+	groups := []config.AddressGroup{
+		{GroupName: "a", Expression: "dcdn_l2 − group:b"},
+		{GroupName: "b", Expression: "group:a"},
+		{GroupName: "missing", Expression: "dcdn_l2 ∪ static:unknown"},
+		{GroupName: "invalid", Expression: "dcdn_l2 ∪"},
+	}
+	s := newComposeScheduler(t, groups, nil)
+
+	for _, group := range groups[2:] {
+		inputs := newRunInputs(context.Background(), nil, nil)
+		if _, err := s.evaluateExpression(inputs, group); err == nil {
+			t.Errorf("地址组 %s 的表达式应返回错误", group.GroupName)
+		}
+	}
+
+	inputs := newRunInputs(context.Background(), nil, nil)
+	if _, err := s.groupSourceIPs(inputs, groups[0]); err == nil {
+		t.Error("循环引用应返回错误")
+	}
+}
@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// 计划时地址薄为10.0.0.0/24、10.0.1.0/24，源列表为10.0.0.0/24、10.0.2.0/24
+func newConflictScheduler(t *testing.T, policy string) (*Scheduler, *fakeFirewall, config.AddressGroup) {
+	t.Helper()
+	fake, firewallClient := newFakeFirewall(t, map[string][]string{"dcdn": {"10.0.0.0/24", "10.0.1.0/24"}})
+	s := newTestScheduler(t, &config.Config{})
+	s.firewallClient = firewallClient
+	return s, fake, config.AddressGroup{GroupName: "dcdn", ConflictPolicy: policy, DriftPolicy: "revert", Ownership: "full"}
+}
+
+// syncDCDNBook 同步名为dcdn的地址薄
+func syncDCDNBook(s *Scheduler, task *models.SyncTask, group config.AddressGroup) (*models.AddressBookPlan, error) {
+	spec := models.AddressBookSpec{GroupName: "dcdn", GroupType: "ip"}
+	return s.syncAddressBook(context.Background(), task, group, spec, sourceInfos("10.0.0.0/24", "10.0.2.0/24"))
+}
+
+func TestSyncAddressBookReplan(t *testing.T) {
+	s, fake, group := newConflictScheduler(t, "replan")
+	// 第2次查询是写入前的重新读取，此时运维人员新增192.0.2.99并删除10.0.0.0/24
+	fake.onDescribe = func(call int) {
+		if call == 2 {
+			fake.edit("dcdn", []string{"192.0.2.99"}, []string{"10.0.0.0/24"})
+		}
+	}
+
+	task := &models.SyncTask{}
+	if _, err := syncDCDNBook(s, task, group); err != nil {
+		t.Fatalf("syncAddressBook 返回错误: %v", err)
+	}
+
+	if len(task.Conflicts) != 1 || task.Conflicts[0].Action != "replanned" {
+		t.Fatalf("冲突记录为 %+v，期望一次replanned", task.Conflicts)
+	}
+	conflict := task.Conflicts[0]
+	if !slices.Equal(conflict.ForeignAdded, []string{"192.0.2.99/32"}) || !slices.Equal(conflict.ForeignRemoved, []string{"10.0.0.0/24"}) {
+		t.Errorf("冲突中的外部修改为 +%v -%v", conflict.ForeignAdded, conflict.ForeignRemoved)
+	}
+
+	// 重新计划后保留外部新增和删除，源列表的新增照常写入
+	if got, want := fake.addresses("dcdn"), []string{"10.0.2.0/24", "192.0.2.99/32"}; !slices.Equal(got, want) {
+		t.Errorf("地址薄内容为 %v，期望 %v", got, want)
+	}
+	if fake.writes != 1 {
+		t.Errorf("写入 %d 次，期望 1 次", fake.writes)
+	}
+
+	// 外部修改按写入后发生的修改记录，下次同步按drift_policy处理
+	last := s.state.Group("dcdn")
+	if want := []string{"10.0.2.0/24", "10.0.0.0/24"}; last == nil || !slices.Equal(last.LastApplied, want) {
+		t.Errorf("LastApplied 为 %v，期望 %v", last, want)
+	}
+}
+
+func TestSyncAddressBookAbort(t *testing.T) {
+	s, fake, group := newConflictScheduler(t, "abort")
+	fake.onDescribe = func(call int) {
+		if call == 2 {
+			fake.edit("dcdn", []string{"192.0.2.99"}, nil)
+		}
+	}
+
+	task := &models.SyncTask{}
+	_, err := syncDCDNBook(s, task, group)
+	var conflictErr *client.ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("abort策略下应返回ConflictError，实际为 %v", err)
+	}
+	if client.IsRetryable(err) {
+		t.Error("冲突不应被withRetry重试")
+	}
+	if len(task.Conflicts) != 1 || task.Conflicts[0].Action != "aborted" {
+		t.Errorf("冲突记录为 %+v，期望一次aborted", task.Conflicts)
+	}
+	if fake.writes != 0 || fake.describes != 2 {
+		t.Errorf("查询 %d 次、写入 %d 次，期望查询 2 次且不写入", fake.describes, fake.writes)
+	}
+	if got, want := fake.addresses("dcdn"), []string{"10.0.0.0/24", "10.0.1.0/24", "192.0.2.99"}; !slices.Equal(got, want) {
+		t.Errorf("地址薄内容为 %v，期望保持外部修改后的 %v", got, want)
+	}
+	if s.state.Group("dcdn") != nil {
+		t.Error("放弃写入时不应记录写入快照")
+	}
+}
+
+func TestSyncAddressBookReplanLimit(t *testing.T) {
+	s, fake, group := newConflictScheduler(t, "replan")
+	// 每次写入前都被修改
+	fake.onDescribe = func(call int) {
+		if call%2 == 0 {
+			fake.edit("dcdn", []string{fmt.Sprintf("192.0.2.%d", call)}, nil)
+		}
+	}
+
+	task := &models.SyncTask{}
+	_, err := syncDCDNBook(s, task, group)
+	var conflictErr *client.ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("超过重新计划次数后应返回ConflictError，实际为 %v", err)
+	}
+
+	if len(task.Conflicts) != maxReplans+1 {
+		t.Fatalf("记录了 %d 次冲突，期望 %d 次", len(task.Conflicts), maxReplans+1)
+	}
+	for i, conflict := range task.Conflicts {
+		want := "replanned"
+		if i == maxReplans {
+			want = "aborted"
+		}
+		if conflict.Action != want {
+			t.Errorf("第 %d 次冲突的处理方式为 %s，期望 %s", i+1, conflict.Action, want)
+		}
+	}
+	if fake.writes != 0 || fake.describes != 2*(maxReplans+1) {
+		t.Errorf("查询 %d 次、写入 %d 次，期望查询 %d 次且不写入", fake.describes, fake.writes, 2*(maxReplans+1))
+	}
+}
+
+func TestSyncAddressBookCreatedConcurrently(t *testing.T) {
+	fake, firewallClient := newFakeFirewall(t, nil)
+	s := newTestScheduler(t, &config.Config{})
+	s.firewallClient = firewallClient
+	group := config.AddressGroup{GroupName: "dcdn", ConflictPolicy: "replan", DriftPolicy: "revert", Ownership: "full"}
+
+	// 计划时地址薄不存在，写入前被他人创建
+	fake.onDescribe = func(call int) {
+		if call == 2 {
+			fake.nextID++
+			fake.books["dcdn"] = &fakeBook{id: "uuid-other", name: "dcdn", groupType: "ip", addresses: []string{"192.0.2.1"}}
+		}
+	}
+
+	task := &models.SyncTask{}
+	if _, err := syncDCDNBook(s, task, group); err != nil {
+		t.Fatalf("syncAddressBook 返回错误: %v", err)
+	}
+	if len(task.Conflicts) != 1 || task.Conflicts[0].Reason != "地址薄在计划后被创建、删除或重建" {
+		t.Fatalf("冲突记录为 %+v", task.Conflicts)
+	}
+	if got, want := fake.addresses("dcdn"), []string{"10.0.0.0/24", "10.0.2.0/24", "192.0.2.1/32"}; !slices.Equal(got, want) {
+		t.Errorf("地址薄内容为 %v，期望 %v", got, want)
+	}
+	if len(fake.books) != 1 {
+		t.Errorf("应更新他人创建的地址薄而不是再创建一个，当前有 %d 个地址薄", len(fake.books))
+	}
+}
@@ -0,0 +1,212 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 重叠执行策略
+const (
+	OverlapSkip   = "skip"   // 已有任务运行时跳过本次触发
+	OverlapQueue  = "queue"  // 排队等待当前任务结束后执行，最多排队一个
+	OverlapCancel = "cancel" // 取消正在运行的任务后执行本次触发
+)
+
+// 触发来源
+const (
+	TriggerStartup  = "startup"
+	TriggerCron     = "cron"
+	TriggerInterval = "interval"
+	TriggerManual   = "manual"
+	TriggerLeader   = "leader" // 成为leader后的首次同步
+)
+
+// SkipError 触发被跳过时返回的错误，记录跳过原因
+type SkipError struct {
+	Trigger        string    `json:"trigger"`
+	Reason         string    `json:"reason"`
+	RunningTrigger string    `json:"running_trigger"`
+	RunningSince   time.Time `json:"running_since"`
+	SkippedAt      time.Time `json:"skipped_at"`
+}
+
+// Error 实现error接口
+func (e *SkipError) Error() string {
+	if e.RunningTrigger == "" {
+		return fmt.Sprintf("跳过 %s 触发的同步任务: %s", e.Trigger, e.Reason)
+	}
+	return fmt.Sprintf("跳过 %s 触发的同步任务: %s（当前任务由 %s 触发，开始于 %s）",
+		e.Trigger, e.Reason, e.RunningTrigger, e.RunningSince.Format("2006-01-02 15:04:05"))
+}
+
+// activeRun 正在执行的同步任务
+type activeRun struct {
+	trigger string
+	started time.Time
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// runCoordinator 串行化同步任务，按策略处理重叠触发
+type runCoordinator struct {
+	policy string
+
+	mu        sync.Mutex
+	current   *activeRun
+	queued    bool
+	lastSkip  *SkipError
+	skipCount int
+}
+
+// newRunCoordinator 创建任务协调器
+func newRunCoordinator(policy string) *runCoordinator {
+	if policy == "" {
+		policy = OverlapSkip
+	}
+	return &runCoordinator{policy: policy}
+}
+
+// Run 在协调器控制下执行fn，同一时刻只会有一个fn在运行
+func (c *runCoordinator) Run(parent context.Context, trigger string, fn func(ctx context.Context) error) error {
+	c.mu.Lock()
+
+	waiting := false
+	for c.current != nil {
+		switch c.policy {
+		case OverlapQueue:
+			if c.queued && !waiting {
+				return c.skipLocked(trigger, "已有一个任务在排队等待")
+			}
+			c.queued = true
+			waiting = true
+		case OverlapCancel:
+			c.current.cancel()
+		default:
+			return c.skipLocked(trigger, "上一次同步任务仍在运行")
+		}
+
+		done := c.current.done
+		c.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-parent.Done():
+			c.mu.Lock()
+			if waiting {
+				c.queued = false
+			}
+			c.mu.Unlock()
+			return parent.Err()
+		}
+
+		c.mu.Lock()
+	}
+
+	if waiting {
+		c.queued = false
+	}
+
+	// 等待期间调度器已停止，不再开始新的任务
+	if parent.Err() != nil {
+		c.mu.Unlock()
+		return parent.Err()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	run := &activeRun{
+		trigger: trigger,
+		started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	c.current = run
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.current = nil
+		close(run.done)
+		c.mu.Unlock()
+		cancel()
+	}()
+
+	return fn(ctx)
+}
+
+// Wait 等待正在运行的任务结束，超时返回false
+func (c *runCoordinator) Wait(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		c.mu.Lock()
+		run := c.current
+		c.mu.Unlock()
+
+		if run == nil {
+			return true
+		}
+
+		select {
+		case <-run.done:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Skip 记录一次不进入协调的跳过，如当前实例不是leader
+func (c *runCoordinator) Skip(trigger, reason string) error {
+	c.mu.Lock()
+	return c.skipLocked(trigger, reason)
+}
+
+// CancelCurrent 取消正在运行的任务，任务会在开始下一个地址薄之前停止
+func (c *runCoordinator) CancelCurrent() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current != nil {
+		c.current.cancel()
+	}
+}
+
+// skipLocked 记录并返回跳过原因，调用方需持有锁，返回时释放锁
+func (c *runCoordinator) skipLocked(trigger, reason string) error {
+	skip := &SkipError{
+		Trigger:   trigger,
+		Reason:    reason,
+		SkippedAt: time.Now(),
+	}
+	if c.current != nil {
+		skip.RunningTrigger = c.current.trigger
+		skip.RunningSince = c.current.started
+	}
+	c.lastSkip = skip
+	c.skipCount++
+	c.mu.Unlock()
+	return skip
+}
+
+// Status 返回协调器当前状态
+func (c *runCoordinator) Status() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := map[string]interface{}{
+		"overlap_policy": c.policy,
+		"run_in_flight":  c.current != nil,
+		"run_queued":     c.queued,
+		"skipped_runs":   c.skipCount,
+	}
+	if c.current != nil {
+		status["current_trigger"] = c.current.trigger
+		status["current_started"] = c.current.started.Format("2006-01-02 15:04:05")
+	}
+	if c.lastSkip != nil {
+		status["last_skip"] = *c.lastSkip
+	}
+	return status
+}
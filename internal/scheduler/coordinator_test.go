@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingTask 在release关闭前一直阻塞的任务，记录启动和被取消的情况
+type blockingTask struct {
+	started  chan string
+	release  chan struct{}
+	canceled chan string
+}
+
+func newBlockingTask() *blockingTask {
+	return &blockingTask{
+		started:  make(chan string, 10),
+		release:  make(chan struct{}),
+		canceled: make(chan string, 10),
+	}
+}
+
+// fn 返回以name标识的任务函数
+func (b *blockingTask) fn(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		b.started <- name
+		select {
+		case <-b.release:
+			return nil
+		case <-ctx.Done():
+			b.canceled <- name
+			return ctx.Err()
+		}
+	}
+}
+
+// expect 等待ch中出现name
+func expect(t *testing.T, ch chan string, name, what string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != name {
+			t.Fatalf("%s的任务为 %s，期望 %s", what, got, name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("等待任务 %s %s超时", name, what)
+	}
+}
+
+// expectNone 确认ch中短时间内没有新的记录
+func expectNone(t *testing.T, ch chan string, what string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("任务 %s 不应%s", got, what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// runAsync 在后台执行Run，返回结果通道
+func runAsync(c *runCoordinator, trigger string, fn func(ctx context.Context) error) chan error {
+	result := make(chan error, 1)
+	go func() { result <- c.Run(context.Background(), trigger, fn) }()
+	return result
+}
+
+func TestCoordinatorSkip(t *testing.T) {
+	c := newRunCoordinator("")
+	task := newBlockingTask()
+
+	first := runAsync(c, TriggerCron, task.fn("first"))
+	expect(t, task.started, "first", "启动")
+
+	err := c.Run(context.Background(), TriggerManual, task.fn("second"))
+	var skip *SkipError
+	if !errors.As(err, &skip) {
+		t.Fatalf("重叠触发应返回SkipError，实际为 %v", err)
+	}
+	if skip.Trigger != TriggerManual || skip.RunningTrigger != TriggerCron {
+		t.Errorf("SkipError = %+v，期望记录manual被cron触发的任务跳过", skip)
+	}
+	expectNone(t, task.started, "在skip策略下启动")
+
+	close(task.release)
+	if err := <-first; err != nil {
+		t.Fatalf("第一个任务返回错误: %v", err)
+	}
+	if status := c.Status(); status["skipped_runs"] != 1 || status["run_in_flight"] != false {
+		t.Errorf("协调器状态为 %v", status)
+	}
+}
+
+func TestCoordinatorQueue(t *testing.T) {
+	c := newRunCoordinator(OverlapQueue)
+	task := newBlockingTask()
+
+	first := runAsync(c, TriggerCron, task.fn("first"))
+	expect(t, task.started, "first", "启动")
+
+	queued := runAsync(c, TriggerInterval, task.fn("queued"))
+	for c.Status()["run_queued"] != true {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 已有任务排队时，后续触发合并到排队的任务中
+	err := c.Run(context.Background(), TriggerManual, task.fn("coalesced"))
+	var skip *SkipError
+	if !errors.As(err, &skip) || skip.Reason != "已有一个任务在排队等待" {
+		t.Fatalf("排队已满时应返回SkipError，实际为 %v", err)
+	}
+	expectNone(t, task.started, "在上一个任务结束前启动")
+
+	task.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatalf("第一个任务返回错误: %v", err)
+	}
+	expect(t, task.started, "queued", "排队后启动")
+	close(task.release)
+	if err := <-queued; err != nil {
+		t.Fatalf("排队的任务返回错误: %v", err)
+	}
+	expectNone(t, task.started, "在排队任务之后启动")
+}
+
+func TestCoordinatorCancel(t *testing.T) {
+	c := newRunCoordinator(OverlapCancel)
+	task := newBlockingTask()
+
+	first := runAsync(c, TriggerCron, task.fn("first"))
+	expect(t, task.started, "first", "启动")
+
+	second := runAsync(c, TriggerManual, task.fn("second"))
+	expect(t, task.canceled, "first", "被取消")
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("被取消的任务返回 %v，期望 context.Canceled", err)
+	}
+	expect(t, task.started, "second", "取消上一个任务后启动")
+
+	close(task.release)
+	if err := <-second; err != nil {
+		t.Fatalf("第二个任务返回错误: %v", err)
+	}
+}
+
+func TestCoordinatorQueuedRunStopsWithParent(t *testing.T) {
+	c := newRunCoordinator(OverlapQueue)
+	task := newBlockingTask()
+
+	first := runAsync(c, TriggerCron, task.fn("first"))
+	expect(t, task.started, "first", "启动")
+
+	// 调度器停止时排队的任务不再启动
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan error, 1)
+	go func() { queued <- c.Run(ctx, TriggerInterval, task.fn("queued")) }()
+	for c.Status()["run_queued"] != true {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Fatalf("停止后排队的任务返回 %v，期望 context.Canceled", err)
+	}
+	if c.Status()["run_queued"] != false {
+		t.Error("排队的任务退出后应清除排队标记")
+	}
+
+	close(task.release)
+	<-first
+	expectNone(t, task.started, "在停止后启动")
+}
+
+func TestCoordinatorWait(t *testing.T) {
+	c := newRunCoordinator("")
+	if !c.Wait(10 * time.Millisecond) {
+		t.Fatal("没有任务运行时Wait应立即返回true")
+	}
+
+	task := newBlockingTask()
+	done := runAsync(c, TriggerCron, task.fn("first"))
+	expect(t, task.started, "first", "启动")
+
+	if c.Wait(20 * time.Millisecond) {
+		t.Fatal("任务仍在运行时Wait应超时返回false")
+	}
+	close(task.release)
+	if !c.Wait(time.Second) {
+		t.Fatal("任务结束后Wait应返回true")
+	}
+	<-done
+}
+
+func TestCoordinatorSkipNotLeader(t *testing.T) {
+	c := newRunCoordinator("")
+	err := c.Skip(TriggerCron, "当前实例不是leader")
+	var skip *SkipError
+	if !errors.As(err, &skip) || skip.RunningTrigger != "" {
+		t.Fatalf("Skip 返回 %v", err)
+	}
+	if got := skip.Error(); got != "跳过 cron 触发的同步任务: 当前实例不是leader" {
+		t.Errorf("SkipError.Error() = %q", got)
+	}
+}
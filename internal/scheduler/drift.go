@@ -0,0 +1,241 @@
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/notify"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// driftStat 单个地址组的漂移统计
+type driftStat struct {
+	Events    int                `json:"events"`
+	LastEvent *models.DriftEvent `json:"last_event,omitempty"`
+}
+
+// detectDrift 比较地址薄当前内容与本工具上次写入的快照
+// 没有历史快照（首次同步）时不判定漂移
+// managed模式下地址薄与他人共用，只有归本工具管理的条目被删除才算漂移
+func detectDrift(group config.AddressGroup, plan *models.AddressBookPlan, last *state.GroupState) *models.DriftEvent {
+	if last == nil || last.LastAppliedAt.IsZero() {
+		return nil
+	}
+
+	var added, removed []string
+	if group.Ownership == "managed" {
+		removed = difference(ownedEntries(last), plan.Observed)
+	} else {
+		added = difference(plan.Observed, last.LastApplied)
+		removed = difference(last.LastApplied, plan.Observed)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	return &models.DriftEvent{
+		GroupName:      plan.GroupName,
+		ForeignAdded:   added,
+		ForeignRemoved: removed,
+		Policy:         group.DriftPolicy,
+		LastAppliedAt:  last.LastAppliedAt,
+		DetectedAt:     time.Now(),
+	}
+}
+
+// reconcileDrift 检测漂移并按地址组的drift_policy处理计划
+// report为false时只处理不重复上报（冲突重新计划时使用）
+// alert策略返回需要保留的外部修改：这些条目本次不删除也不写回，源列表带来的其他变化照常写入
+func (s *Scheduler) reconcileDrift(task *models.SyncTask, group config.AddressGroup, plan *models.AddressBookPlan, report bool) (held *models.DriftEvent, err error) {
+	last := s.state.Group(plan.GroupName)
+	event := detectDrift(group, plan, last)
+
+	if report {
+		s.reportDrift(task, plan.GroupName, event, last)
+	}
+
+	switch group.DriftPolicy {
+	case "alert":
+		return event, nil
+
+	case "adopt":
+		if event != nil {
+			err = s.state.Update(plan.GroupName, func(gs *state.GroupState) {
+				gs.AdoptedAdds = union(difference(gs.AdoptedAdds, event.ForeignRemoved), event.ForeignAdded)
+				gs.AdoptedRemoves = union(difference(gs.AdoptedRemoves, event.ForeignAdded), event.ForeignRemoved)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("保存接受的外部修改失败: %w", err)
+			}
+			last = s.state.Group(plan.GroupName)
+		}
+		if last != nil && (len(last.AdoptedAdds) > 0 || len(last.AdoptedRemoves) > 0) {
+			desired := difference(union(plan.Desired, last.AdoptedAdds), last.AdoptedRemoves)
+			s.firewallClient.RevisePlan(plan, desired)
+		}
+	}
+
+	return nil, nil
+}
+
+// reportDrift 将漂移事件写入日志、任务记录、状态统计和通知
+// 通知按漂移指纹去重，同一处外部修改在多次同步中持续存在时只通知一次；漂移消失后清除指纹
+func (s *Scheduler) reportDrift(task *models.SyncTask, bookName string, event *models.DriftEvent, last *state.GroupState) {
+	var notified string
+	if last != nil {
+		notified = last.DriftFingerprint
+	}
+	fingerprint := driftFingerprint(event)
+	if fingerprint != notified {
+		err := s.state.Update(bookName, func(gs *state.GroupState) {
+			gs.DriftFingerprint = fingerprint
+		})
+		if err != nil {
+			log.Printf("警告: 保存地址薄 %s 的漂移指纹失败，下次同步可能重复通知: %v", bookName, err)
+		}
+	}
+	if event == nil {
+		return
+	}
+
+	log.Printf("检测到地址薄 %s 被外部修改（上次写入于 %s）: 外部新增 %v，外部删除 %v，处理策略: %s",
+		event.GroupName, event.LastAppliedAt.Format("2006-01-02 15:04:05"),
+		event.ForeignAdded, event.ForeignRemoved, event.Policy)
+
+	task.DriftEvents = append(task.DriftEvents, *event)
+
+	s.mu.Lock()
+	stat, ok := s.driftStats[event.GroupName]
+	if !ok {
+		stat = &driftStat{}
+		s.driftStats[event.GroupName] = stat
+	}
+	stat.Events++
+	stat.LastEvent = event
+	s.mu.Unlock()
+
+	if fingerprint == notified {
+		log.Printf("地址薄 %s 的外部修改已通知过，本次不再重复通知", event.GroupName)
+		return
+	}
+	s.notifier.Notify(notify.Event{
+		Type:      "drift",
+		GroupName: event.GroupName,
+		Message: fmt.Sprintf("地址薄 %s 在两次同步之间被外部修改：新增 %d 条，删除 %d 条，处理策略 %s",
+			event.GroupName, len(event.ForeignAdded), len(event.ForeignRemoved), event.Policy),
+		Details: event,
+		Time:    event.DetectedAt,
+	})
+}
+
+// driftFingerprint 返回漂移内容的指纹，没有漂移时为空
+func driftFingerprint(event *models.DriftEvent) string {
+	if event == nil {
+		return ""
+	}
+	added := slices.Sorted(slices.Values(event.ForeignAdded))
+	removed := slices.Sorted(slices.Values(event.ForeignRemoved))
+	sum := sha256.Sum256([]byte(strings.Join(added, ",") + "|" + strings.Join(removed, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordApplied 记录本次写入的完整地址列表和归属条目，作为下次漂移检测的基准
+// 冲突期间保留的外部修改按写入后发生的修改记录，下次同步时按drift_policy处理
+func (s *Scheduler) recordApplied(group config.AddressGroup, plan *models.AddressBookPlan, edits conflictEdits) error {
+	applied := difference(union(plan.Desired, edits.removed), edits.added)
+	return s.state.Update(plan.GroupName, func(gs *state.GroupState) {
+		gs.LastApplied = applied
+		gs.LastAppliedAt = time.Now()
+
+		gs.OwnershipTracked = group.Ownership == "managed"
+		gs.Owned = nil
+		if gs.OwnershipTracked {
+			gs.Owned = append([]string(nil), plan.Owned...)
+		}
+	})
+}
+
+// recordSynced 记录地址组同步成功时的完整期望列表，与写入目标使用的内容一致
+// 分片地址组的LastApplied分散在各分片名下，skip_address_book的地址组没有LastApplied，外部读取时使用该记录
+func (s *Scheduler) recordSynced(group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) {
+	desired := desiredEntries(group, sourceIPs)
+	err := s.state.Update(group.GroupName, func(gs *state.GroupState) {
+		gs.LastSynced = desired
+		gs.LastSyncedAt = time.Now()
+	})
+	if err != nil {
+		log.Printf("警告: 保存地址组 %s 的同步记录失败: %v", group.GroupName, err)
+	}
+}
+
+// driftStatus 返回各地址组的漂移统计
+func (s *Scheduler) driftStatus() map[string]driftStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make(map[string]driftStat, len(s.driftStats))
+	for name, stat := range s.driftStats {
+		status[name] = *stat
+	}
+	return status
+}
+
+// difference 返回a中存在而b中不存在的条目，按规范化后的网段比较并去重，保持a的顺序
+// 结果使用规范CIDR形式，无法解析的条目按原字符串比较
+func difference(a, b []string) []string {
+	exclude, invalid := ipset.FromStrings(b)
+	excludeRaw := make(map[string]bool, len(invalid))
+	for _, item := range invalid {
+		excludeRaw[item] = true
+	}
+
+	seen := &ipset.Set{}
+	seenRaw := make(map[string]bool)
+	var result []string
+	for _, item := range a {
+		prefix, err := ipset.ParsePrefix(item)
+		if err != nil {
+			if !excludeRaw[item] && !seenRaw[item] {
+				seenRaw[item] = true
+				result = append(result, item)
+			}
+			continue
+		}
+		if exclude.Has(prefix) || !seen.Add(prefix) {
+			continue
+		}
+		result = append(result, prefix.String())
+	}
+	return result
+}
+
+// union 返回a和b的并集，按规范化后的网段去重并保持出现顺序
+func union(a, b []string) []string {
+	seen := &ipset.Set{}
+	seenRaw := make(map[string]bool)
+	var result []string
+	for _, list := range [][]string{a, b} {
+		for _, item := range list {
+			prefix, err := ipset.ParsePrefix(item)
+			if err != nil {
+				if !seenRaw[item] {
+					seenRaw[item] = true
+					result = append(result, item)
+				}
+				continue
+			}
+			if seen.Add(prefix) {
+				result = append(result, prefix.String())
+			}
+		}
+	}
+	return result
+}
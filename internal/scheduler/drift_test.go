@@ -0,0 +1,95 @@
+package scheduler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+func TestReconcileDriftAlert(t *testing.T) {
+	var notifications atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications.Add(1)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.Notifications.WebhookURLs = []string{server.URL}
+	s := newTestScheduler(t, cfg)
+	s.firewallClient = &client.FirewallClient{}
+	group := config.AddressGroup{GroupName: "dcdn", DriftPolicy: "alert"}
+
+	err := s.state.Update("dcdn", func(gs *state.GroupState) {
+		gs.LastApplied = []string{"10.0.0.0/24", "10.0.1.0/24"}
+		gs.LastAppliedAt = time.Now()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 运维人员删除了10.0.1.0/24并添加了192.0.2.1/32，同时源列表新增10.0.2.0/24、移除10.0.0.0/24
+	observed := []string{"10.0.0.0/24", "192.0.2.1/32"}
+	for run := 1; run <= 3; run++ {
+		task := &models.SyncTask{}
+		plan := &models.AddressBookPlan{GroupName: "dcdn", Exists: true, Observed: observed}
+		s.firewallClient.RevisePlan(plan, []string{"10.0.1.0/24", "10.0.2.0/24"})
+		held, err := s.reconcileDrift(task, group, plan, true)
+		if err != nil {
+			t.Fatalf("第 %d 次 reconcileDrift 返回错误: %v", run, err)
+		}
+		if held == nil || len(task.DriftEvents) != 1 {
+			t.Fatalf("第 %d 次同步应检测到漂移: held=%v, events=%v", run, held, task.DriftEvents)
+		}
+
+		var edits conflictEdits
+		edits.merge(held.ForeignAdded, held.ForeignRemoved)
+		edits.apply(s.firewallClient, plan)
+		if want := []string{"10.0.2.0/24", "192.0.2.1/32"}; !slices.Equal(plan.Desired, want) {
+			t.Fatalf("第 %d 次同步的期望列表为 %v，期望 %v", run, plan.Desired, want)
+		}
+		if run == 1 {
+			if want := []string{"10.0.2.0/24"}; !slices.Equal(plan.ToAdd, want) {
+				t.Errorf("新增 %v，期望 %v", plan.ToAdd, want)
+			}
+			if want := []string{"10.0.0.0/24"}; !slices.Equal(plan.ToRemove, want) {
+				t.Errorf("移除 %v，期望 %v", plan.ToRemove, want)
+			}
+		}
+
+		// 写入后外部修改仍按写入后发生的修改记录，下次同步继续保留
+		if err := s.recordApplied(group, plan, edits); err != nil {
+			t.Fatal(err)
+		}
+		observed = plan.Desired
+		if got := notifications.Load(); got != 1 {
+			t.Errorf("第 %d 次同步后共通知 %d 次，同一处外部修改只应通知 1 次", run, got)
+		}
+	}
+
+	// 外部修改消失后清除指纹，再次出现时重新通知
+	if err := s.state.Update("dcdn", func(gs *state.GroupState) { gs.LastApplied = []string{"10.0.2.0/24"} }); err != nil {
+		t.Fatal(err)
+	}
+	plan := &models.AddressBookPlan{GroupName: "dcdn", Exists: true, Observed: []string{"10.0.2.0/24"}}
+	if held, _ := s.reconcileDrift(&models.SyncTask{}, group, plan, true); held != nil {
+		t.Fatalf("没有外部修改时不应检测到漂移: %+v", held)
+	}
+	if fp := s.state.Group("dcdn").DriftFingerprint; fp != "" {
+		t.Errorf("漂移消失后指纹应清除，实际为 %s", fp)
+	}
+	plan.Observed = []string{"10.0.2.0/24", "192.0.2.1/32"}
+	if _, err := s.reconcileDrift(&models.SyncTask{}, group, plan, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := notifications.Load(); got != 2 {
+		t.Errorf("外部修改再次出现后共通知 %d 次，期望 2 次", got)
+	}
+}
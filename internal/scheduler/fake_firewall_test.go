@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	cloudfw20171207 "github.com/alibabacloud-go/cloudfw-20171207/v8/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// fakeBook 内存中的地址薄
+type fakeBook struct {
+	id, name, groupType string
+	addresses           []string
+}
+
+// fakeFirewall 内存中的云防火墙地址薄API
+// onDescribe在每次查询返回前调用（从1开始计数），用于模拟计划和写入之间的外部修改
+type fakeFirewall struct {
+	mu         sync.Mutex
+	books      map[string]*fakeBook
+	nextID     int
+	describes  int
+	writes     int
+	onDescribe func(call int)
+}
+
+// newFakeFirewall 创建包含指定地址薄的内存API，并返回使用它的客户端
+func newFakeFirewall(t *testing.T, books map[string][]string) (*fakeFirewall, *client.FirewallClient) {
+	t.Helper()
+	f := &fakeFirewall{books: make(map[string]*fakeBook)}
+	for name, addresses := range books {
+		f.nextID++
+		f.books[name] = &fakeBook{id: fmt.Sprintf("uuid-%d", f.nextID), name: name, groupType: "ip", addresses: addresses}
+	}
+	cfg := &config.FirewallConfig{AliyunConfig: config.AliyunConfig{AccessKeyId: "fake-" + t.Name(), QPS: 1000, Burst: 1000}}
+	return f, client.NewFirewallClientWithAPI(cfg, f)
+}
+
+// edit 模拟运维人员在控制台修改地址薄
+func (f *fakeFirewall) edit(name string, add, remove []string) {
+	book := f.books[name]
+	var addresses []string
+	for _, address := range book.addresses {
+		if !slices.Contains(remove, address) {
+			addresses = append(addresses, address)
+		}
+	}
+	book.addresses = append(addresses, add...)
+}
+
+// addresses 返回地址薄当前的地址列表，已排序
+func (f *fakeFirewall) addresses(name string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	book, ok := f.books[name]
+	if !ok {
+		return nil
+	}
+	return slices.Sorted(slices.Values(book.addresses))
+}
+
+// DescribeAddressBookWithOptions 实现client.FirewallAPI接口
+func (f *fakeFirewall) DescribeAddressBookWithOptions(request *cloudfw20171207.DescribeAddressBookRequest, _ *util.RuntimeOptions) (*cloudfw20171207.DescribeAddressBookResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.describes++
+	if f.onDescribe != nil {
+		f.onDescribe(f.describes)
+	}
+
+	body := &cloudfw20171207.DescribeAddressBookResponseBody{}
+	for _, book := range f.books {
+		if book.groupType != tea.StringValue(request.GroupType) || !strings.Contains(book.name, tea.StringValue(request.Query)) {
+			continue
+		}
+		body.Acls = append(body.Acls, &cloudfw20171207.DescribeAddressBookResponseBodyAcls{
+			GroupUuid:   tea.String(book.id),
+			GroupName:   tea.String(book.name),
+			GroupType:   tea.String(book.groupType),
+			AddressList: tea.StringSlice(book.addresses),
+		})
+	}
+	body.TotalCount = tea.String(strconv.Itoa(len(body.Acls)))
+	return &cloudfw20171207.DescribeAddressBookResponse{Body: body}, nil
+}
+
+// AddAddressBookWithOptions 实现client.FirewallAPI接口
+func (f *fakeFirewall) AddAddressBookWithOptions(request *cloudfw20171207.AddAddressBookRequest, _ *util.RuntimeOptions) (*cloudfw20171207.AddAddressBookResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	f.nextID++
+	name := tea.StringValue(request.GroupName)
+	f.books[name] = &fakeBook{
+		id:        fmt.Sprintf("uuid-%d", f.nextID),
+		name:      name,
+		groupType: tea.StringValue(request.GroupType),
+		addresses: splitAddresses(tea.StringValue(request.AddressList)),
+	}
+	return &cloudfw20171207.AddAddressBookResponse{}, nil
+}
+
+// ModifyAddressBookWithOptions 实现client.FirewallAPI接口
+func (f *fakeFirewall) ModifyAddressBookWithOptions(request *cloudfw20171207.ModifyAddressBookRequest, _ *util.RuntimeOptions) (*cloudfw20171207.ModifyAddressBookResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes++
+	for _, book := range f.books {
+		if book.id == tea.StringValue(request.GroupUuid) {
+			book.addresses = splitAddresses(tea.StringValue(request.AddressList))
+			return &cloudfw20171207.ModifyAddressBookResponse{}, nil
+		}
+	}
+	return nil, fmt.Errorf("地址薄 %s 不存在", tea.StringValue(request.GroupUuid))
+}
+
+// DeleteAddressBookWithOptions 实现client.FirewallAPI接口
+func (f *fakeFirewall) DeleteAddressBookWithOptions(request *cloudfw20171207.DeleteAddressBookRequest, _ *util.RuntimeOptions) (*cloudfw20171207.DeleteAddressBookResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, book := range f.books {
+		if book.id == tea.StringValue(request.GroupUuid) {
+			delete(f.books, name)
+			return &cloudfw20171207.DeleteAddressBookResponse{}, nil
+		}
+	}
+	return nil, fmt.Errorf("地址薄 %s 不存在", tea.StringValue(request.GroupUuid))
+}
+
+// splitAddresses 拆分逗号分隔的地址列表
+func splitAddresses(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// graceUpdate 宽限期计算后的条目出现记录，写入成功后才保存，写入失败时本次缺失不计数
+type graceUpdate struct {
+	group   string
+	entries map[string]*state.EntryState
+	pending []models.PendingRemoval
+}
+
+// applyRemovalGrace 根据条目在源列表中的出现情况，把宽限期内消失的条目加回期望列表
+// 新出现的条目立即生效；消失的条目需满足所有已配置的宽限条件（次数、时长）后才真正移除
+// 返回的更新需在写入成功后通过saveRemovalGrace保存，未配置宽限期时为nil
+func (s *Scheduler) applyRemovalGrace(group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) ([]*models.DCDNSourceIPInfo, *graceUpdate) {
+	var period time.Duration
+	if group.RemovalGracePeriod != "" {
+		period, _ = time.ParseDuration(group.RemovalGracePeriod) // 配置加载时已校验
+	}
+	if group.RemovalGraceRuns == 0 && period == 0 {
+		return sourceIPs, nil
+	}
+
+	now := time.Now()
+	update := &graceUpdate{group: group.GroupName}
+	kept := sourceIPs
+
+	// 在副本上计算，写入失败时状态保持不变
+	var entries map[string]*state.EntryState
+	if last := s.state.Group(group.GroupName); last != nil {
+		entries = last.Entries
+	}
+	entries = canonicalEntries(entries)
+
+	present := make(map[string]bool, len(sourceIPs))
+	for _, ip := range sourceIPs {
+		key := entryKey(ip.IP)
+		present[key] = true
+		entry, ok := entries[key]
+		if !ok {
+			entry = &state.EntryState{FirstSeen: now}
+			entries[key] = entry
+		}
+		entry.LastSeen = now
+		entry.MissingRuns = 0
+		entry.Location = ip.Location
+		entry.ISP = ip.ISP
+		entry.Origin = ip.Origin
+	}
+
+	for ip, entry := range entries {
+		if present[ip] {
+			continue
+		}
+		entry.MissingRuns++
+
+		removal := models.PendingRemoval{
+			IP:          ip,
+			FirstSeen:   entry.FirstSeen,
+			LastSeen:    entry.LastSeen,
+			MissingRuns: entry.MissingRuns,
+		}
+		waiting := false
+		if group.RemovalGraceRuns > 0 && entry.MissingRuns < group.RemovalGraceRuns {
+			removal.RemainingRuns = group.RemovalGraceRuns - entry.MissingRuns
+			waiting = true
+		}
+		if period > 0 && now.Sub(entry.LastSeen) < period {
+			removal.RemoveAfter = entry.LastSeen.Add(period)
+			waiting = true
+		}
+
+		if !waiting {
+			log.Printf("地址薄 %s: %s 已缺失 %d 次同步（最后出现于 %s），宽限期结束，将被移除",
+				group.GroupName, ip, entry.MissingRuns, entry.LastSeen.Format("2006-01-02 15:04:05"))
+			delete(entries, ip)
+			continue
+		}
+
+		update.pending = append(update.pending, removal)
+		kept = append(kept, &models.DCDNSourceIPInfo{
+			IP:          ip,
+			Location:    entry.Location,
+			ISP:         entry.ISP,
+			Status:      "PendingRemoval",
+			LastUpdated: entry.LastSeen,
+			Origin:      entry.Origin,
+		})
+	}
+	update.entries = entries
+
+	sort.Slice(update.pending, func(i, j int) bool { return update.pending[i].IP < update.pending[j].IP })
+	for _, p := range update.pending {
+		log.Printf("地址薄 %s: %s 已从源列表消失 %d 次，暂缓移除（剩余次数 %d，最早移除时间 %s）",
+			group.GroupName, p.IP, p.MissingRuns, p.RemainingRuns, formatOptionalTime(p.RemoveAfter))
+	}
+
+	return kept, update
+}
+
+// saveRemovalGrace 写入成功后保存条目出现记录和等待移除的条目
+// 地址薄已写入，保存失败只影响下次的缺失计数
+func (s *Scheduler) saveRemovalGrace(update *graceUpdate) {
+	if update == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.pendingRemovals[update.group] = update.pending
+	s.mu.Unlock()
+
+	err := s.state.Update(update.group, func(gs *state.GroupState) {
+		gs.Entries = update.entries
+	})
+	if err != nil {
+		log.Printf("保存地址薄 %s 的条目出现记录失败: %v", update.group, err)
+	}
+}
+
+// pendingRemovalStatus 返回各地址组等待移除的条目
+func (s *Scheduler) pendingRemovalStatus() map[string][]models.PendingRemoval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make(map[string][]models.PendingRemoval, len(s.pendingRemovals))
+	for name, pending := range s.pendingRemovals {
+		if len(pending) > 0 {
+			status[name] = append([]models.PendingRemoval(nil), pending...)
+		}
+	}
+	return status
+}
+
+// formatOptionalTime 格式化可能为空的时间
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// entryKey 返回条目在状态文件中的键，使用规范CIDR形式，无法解析时使用原字符串
+func entryKey(ip string) string {
+	if canonical, err := ipset.Canonical(ip); err == nil {
+		return canonical
+	}
+	return ip
+}
+
+// canonicalEntries 将旧版本按原字符串记录的条目转换为规范形式，同一网段的记录合并
+func canonicalEntries(entries map[string]*state.EntryState) map[string]*state.EntryState {
+	result := make(map[string]*state.EntryState, len(entries))
+	for ip, entry := range entries {
+		key := entryKey(ip)
+		if existing, ok := result[key]; ok {
+			if entry.FirstSeen.Before(existing.FirstSeen) {
+				existing.FirstSeen = entry.FirstSeen
+			}
+			if entry.LastSeen.After(existing.LastSeen) {
+				existing.LastSeen = entry.LastSeen
+				existing.MissingRuns = entry.MissingRuns
+				existing.Location, existing.ISP, existing.Origin = entry.Location, entry.ISP, entry.Origin
+			}
+			continue
+		}
+		result[key] = entry
+	}
+	return result
+}
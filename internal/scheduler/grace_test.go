@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+)
+
+// graceRun 执行一次宽限期计算，save为true时按写入成功保存，返回排序后的期望条目
+func graceRun(s *Scheduler, group config.AddressGroup, save bool, ips ...string) []string {
+	kept, update := s.applyRemovalGrace(group, sourceInfos(ips...))
+	if save {
+		s.saveRemovalGrace(update)
+	}
+	var got []string
+	for _, ip := range kept {
+		got = append(got, entryKey(ip.IP))
+	}
+	slices.Sort(got)
+	return got
+}
+
+func TestApplyRemovalGrace(t *testing.T) {
+	type run struct {
+		sources []string
+		failed  bool // 本次写入失败，不保存出现记录
+		want    []string
+	}
+	tests := []struct {
+		name  string
+		group config.AddressGroup
+		runs  []run
+	}{
+		{
+			name:  "未配置宽限期时立即移除",
+			group: config.AddressGroup{},
+			runs: []run{
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24"}},
+			},
+		},
+		{
+			name:  "缺失次数达到removal_grace_runs后移除",
+			group: config.AddressGroup{RemovalGraceRuns: 2},
+			runs: []run{
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24"}},
+			},
+		},
+		{
+			name:  "条目重新出现时缺失次数清零",
+			group: config.AddressGroup{RemovalGraceRuns: 2},
+			runs: []run{
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+			},
+		},
+		{
+			name:  "写入失败时本次缺失不计数",
+			group: config.AddressGroup{RemovalGraceRuns: 2},
+			runs: []run{
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, failed: true, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, failed: true, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24"}},
+			},
+		},
+		{
+			name:  "次数和时长都需满足，时长未到时保留",
+			group: config.AddressGroup{RemovalGraceRuns: 1, RemovalGracePeriod: "1h"},
+			runs: []run{
+				{sources: []string{"10.0.0.0/24", "10.0.1.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+				{sources: []string{"10.0.0.0/24"}, want: []string{"10.0.0.0/24", "10.0.1.0/24"}},
+			},
+		},
+		{
+			name:  "同一网段的不同写法按同一条目计数",
+			group: config.AddressGroup{RemovalGraceRuns: 1},
+			runs: []run{
+				{sources: []string{"192.0.2.1"}, want: []string{"192.0.2.1/32"}},
+				{sources: []string{"192.0.2.1/32"}, want: []string{"192.0.2.1/32"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, &config.Config{})
+			tt.group.GroupName = "dcdn"
+			for i, r := range tt.runs {
+				if got := graceRun(s, tt.group, !r.failed, r.sources...); !slices.Equal(got, r.want) {
+					t.Fatalf("第 %d 次同步的期望条目为 %v，期望 %v", i+1, got, r.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemovalGraceAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	group := config.AddressGroup{GroupName: "dcdn", RemovalGraceRuns: 2, RemovalGracePeriod: "1h"}
+	restart := func() *Scheduler {
+		return newTestScheduler(t, &config.Config{State: config.StateConfig{Path: path}})
+	}
+
+	s := restart()
+	graceRun(s, group, true, "10.0.0.0/24", "10.0.1.0/24")
+	if got := graceRun(s, group, true, "10.0.0.0/24"); !slices.Contains(got, "10.0.1.0/24") {
+		t.Fatalf("第一次缺失时应保留 10.0.1.0/24，实际为 %v", got)
+	}
+
+	// 重启后缺失次数和最后出现时间从状态文件恢复
+	s = restart()
+	last := s.state.Group("dcdn")
+	entry := last.Entries["10.0.1.0/24"]
+	if entry == nil || entry.MissingRuns != 1 {
+		t.Fatalf("重启后条目记录为 %+v，期望缺失 1 次", entry)
+	}
+	if got := graceRun(s, group, true, "10.0.0.0/24"); !slices.Contains(got, "10.0.1.0/24") {
+		t.Fatalf("缺失次数已满但时长未到时应保留 10.0.1.0/24，实际为 %v", got)
+	}
+	pending := s.pendingRemovalStatus()["dcdn"]
+	if len(pending) != 1 || pending[0].MissingRuns != 2 || !pending[0].RemoveAfter.Equal(entry.LastSeen.Add(time.Hour)) {
+		t.Fatalf("等待移除的条目为 %+v，期望按重启前的最后出现时间计算移除时间", pending)
+	}
+
+	// 模拟停机超过宽限时长：最后出现时间早于1小时前，重启后移除
+	err := s.state.Update("dcdn", func(gs *state.GroupState) {
+		gs.Entries["10.0.1.0/24"].LastSeen = time.Now().Add(-2 * time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = restart()
+	if got := graceRun(s, group, true, "10.0.0.0/24"); !slices.Equal(got, []string{"10.0.0.0/24"}) {
+		t.Fatalf("宽限期结束后期望条目为 %v，期望只剩 10.0.0.0/24", got)
+	}
+	if _, ok := s.state.Group("dcdn").Entries["10.0.1.0/24"]; ok {
+		t.Error("移除后应删除条目的出现记录")
+	}
+	if pending := s.pendingRemovalStatus()["dcdn"]; len(pending) != 0 {
+		t.Errorf("移除后不应再有等待移除的条目: %+v", pending)
+	}
+}
@@ -0,0 +1,282 @@
+package scheduler
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// normalizeSourceIPs 按地址组的normalize配置去重、去除被覆盖的子网并合并相邻网段
+// lossless模式只做精确覆盖的合并；aggregate模式在条目数超过max_entries时继续合并为更大的网段（会多覆盖地址）
+// 无法解析的条目原样保留并占用max_entries，它们已达到上限时返回错误
+func normalizeSourceIPs(group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) ([]*models.DCDNSourceIPInfo, *models.NormalizeReport, error) {
+	mode := group.Normalize.Mode
+	if mode == "" || mode == "off" {
+		return sourceIPs, nil, nil
+	}
+
+	report := &models.NormalizeReport{
+		GroupName: group.GroupName,
+		Mode:      mode,
+		Before:    len(sourceIPs),
+	}
+
+	set := &ipset.Set{}
+	var invalid []*models.DCDNSourceIPInfo
+	for _, ip := range sourceIPs {
+		prefix, err := ipset.ParsePrefix(ip.IP)
+		if err != nil {
+			// 无法解析的条目原样保留，由后续写入步骤决定是否丢弃
+			invalid = append(invalid, ip)
+			continue
+		}
+		if !set.Add(prefix) {
+			report.Duplicates++
+		}
+	}
+
+	// 去除被更大网段覆盖的子网，再合并相邻网段
+	roots := set.Roots()
+	report.Covered = set.Len() - len(roots)
+	prefixes := ipset.Merge(roots)
+
+	if mode == "aggregate" && group.Normalize.MaxEntries > 0 {
+		limit := group.Normalize.MaxEntries - len(invalid)
+		if limit <= 0 {
+			return nil, nil, fmt.Errorf("地址组 %s 有 %d 个无法解析的条目，已达到normalize.max_entries（%d），无法聚合",
+				group.GroupName, len(invalid), group.Normalize.MaxEntries)
+		}
+		prefixes, report.ExtraAddresses = aggregatePrefixes(prefixes, limit, group.Normalize.MinPrefixLength)
+	}
+
+	// 归一化后的网段沿用被合并条目的来源信息
+	result := make([]*models.DCDNSourceIPInfo, 0, len(prefixes)+len(invalid))
+	result = append(result, prefixInfos(prefixes, sourceIPs)...)
+	result = append(result, invalid...)
+	report.After = len(result)
+
+	log.Printf("地址薄 %s 归一化(%s): %d 条 -> %d 条（重复 %d，被覆盖 %d，额外覆盖地址 %.0f）",
+		group.GroupName, mode, report.Before, report.After, report.Duplicates, report.Covered, report.ExtraAddresses)
+
+	return result, report, nil
+}
+
+// aggregateNode 聚合过程中网段链表的节点
+type aggregateNode struct {
+	prefix     netip.Prefix
+	prev, next *aggregateNode
+	removed    bool
+	version    int // 网段变化时递增，使堆中已有的候选失效
+}
+
+// aggregateCandidate 合并一对相邻网段的候选
+type aggregateCandidate struct {
+	left, right               *aggregateNode
+	leftVersion, rightVersion int
+	super                     netip.Prefix
+	cost                      float64 // 合并后额外覆盖的地址数
+}
+
+// candidateHeap 按额外覆盖地址数排序的候选堆，相同时优先靠前的网段
+type candidateHeap []aggregateCandidate
+
+func (h candidateHeap) Len() int { return len(h) }
+func (h candidateHeap) Less(i, j int) bool {
+	if h[i].cost != h[j].cost {
+		return h[i].cost < h[j].cost
+	}
+	return ipset.Compare(h[i].super, h[j].super) < 0
+}
+func (h candidateHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x any)   { *h = append(*h, x.(aggregateCandidate)) }
+func (h *candidateHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// aggregatePrefixes 反复合并额外覆盖地址最少的一对相邻网段，直到条目数不超过maxEntries
+// 候选保存在堆中，每次合并只重新计算受影响的相邻网段；合并后的网段前缀长度不会短于minPrefixLength，无法继续合并时提前结束
+func aggregatePrefixes(prefixes []netip.Prefix, maxEntries, minPrefixLength int) ([]netip.Prefix, float64) {
+	if len(prefixes) <= maxEntries {
+		return prefixes, 0
+	}
+
+	var head, tail *aggregateNode
+	for _, prefix := range prefixes {
+		node := &aggregateNode{prefix: prefix, prev: tail}
+		if tail == nil {
+			head = node
+		} else {
+			tail.next = node
+		}
+		tail = node
+	}
+
+	h := &candidateHeap{}
+	push := func(left, right *aggregateNode) {
+		if left == nil || right == nil || left.prefix.Addr().Is4() != right.prefix.Addr().Is4() {
+			return
+		}
+		super := commonSupernet(left.prefix, right.prefix)
+		if super.Bits() < minPrefixLength {
+			return
+		}
+		heap.Push(h, aggregateCandidate{
+			left: left, right: right,
+			leftVersion: left.version, rightVersion: right.version,
+			super: super,
+			cost:  prefixSize(super) - prefixSize(left.prefix) - prefixSize(right.prefix),
+		})
+	}
+	for node := head; node != nil && node.next != nil; node = node.next {
+		push(node, node.next)
+	}
+
+	var extra float64
+	count := len(prefixes)
+	for count > maxEntries && h.Len() > 0 {
+		c := heap.Pop(h).(aggregateCandidate)
+		if c.left.removed || c.right.removed || c.left.version != c.leftVersion || c.right.version != c.rightVersion {
+			continue
+		}
+
+		// 合并后的网段可能覆盖更多相邻网段，一并移除
+		covered := prefixSize(c.left.prefix)
+		remove := func(node *aggregateNode) {
+			covered += prefixSize(node.prefix)
+			node.removed = true
+			if node.prev != nil {
+				node.prev.next = node.next
+			}
+			if node.next != nil {
+				node.next.prev = node.prev
+			}
+			count--
+		}
+		remove(c.right)
+		for c.left.next != nil && c.super.Bits() <= c.left.next.prefix.Bits() && c.super.Contains(c.left.next.prefix.Addr()) {
+			remove(c.left.next)
+		}
+		for c.left.prev != nil && c.super.Bits() <= c.left.prev.prefix.Bits() && c.super.Contains(c.left.prev.prefix.Addr()) {
+			if c.left.prev == head {
+				head = c.left
+			}
+			remove(c.left.prev)
+		}
+		extra += prefixSize(c.super) - covered
+
+		c.left.prefix = c.super
+		c.left.version++
+		push(c.left.prev, c.left)
+		push(c.left, c.left.next)
+	}
+
+	if count > maxEntries {
+		log.Printf("警告: 已无法在前缀长度不短于 /%d 的情况下继续合并，当前 %d 条，上限 %d 条",
+			minPrefixLength, count, maxEntries)
+	}
+
+	result := make([]netip.Prefix, 0, count)
+	for node := head; node != nil; node = node.next {
+		result = append(result, node.prefix)
+	}
+	// 合并后的网段可能与相邻网段首尾相连，无损地继续合并
+	return ipset.Merge(result), extra
+}
+
+// commonSupernet 返回同时包含a和b的最小网段
+func commonSupernet(a, b netip.Prefix) netip.Prefix {
+	bits := min(a.Bits(), b.Bits())
+	for ; bits > 0; bits-- {
+		super := netip.PrefixFrom(a.Addr(), bits).Masked()
+		if super.Contains(b.Addr()) {
+			return super
+		}
+	}
+	return netip.PrefixFrom(a.Addr(), 0).Masked()
+}
+
+// prefixInfos 为归一化后的网段生成源IP信息
+// 未被合并的条目保留原有信息；合并后的网段在各条目一致时沿用其位置和运营商，来源为各条目来源的并集
+func prefixInfos(prefixes []netip.Prefix, sourceIPs []*models.DCDNSourceIPInfo) []*models.DCDNSourceIPInfo {
+	type parsed struct {
+		prefix netip.Prefix
+		info   *models.DCDNSourceIPInfo
+	}
+	entries := make([]parsed, 0, len(sourceIPs))
+	for _, ip := range sourceIPs {
+		if prefix, err := ipset.ParsePrefix(ip.IP); err == nil {
+			entries = append(entries, parsed{prefix, ip})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return ipset.Compare(entries[i].prefix, entries[j].prefix) < 0 })
+
+	// 两个列表都按地址排序，归一化后的网段互不重叠且覆盖全部条目
+	result := make([]*models.DCDNSourceIPInfo, 0, len(prefixes))
+	j := 0
+	for _, prefix := range prefixes {
+		var members []*models.DCDNSourceIPInfo
+		for j < len(entries) && ipset.LastAddr(entries[j].prefix).Less(prefix.Addr()) {
+			j++
+		}
+		for ; j < len(entries) && prefix.Bits() <= entries[j].prefix.Bits() && prefix.Contains(entries[j].prefix.Addr()); j++ {
+			members = append(members, entries[j].info)
+		}
+		result = append(result, mergedInfo(prefix, members))
+	}
+	return result
+}
+
+// mergedInfo 合并多个条目的来源信息
+func mergedInfo(prefix netip.Prefix, members []*models.DCDNSourceIPInfo) *models.DCDNSourceIPInfo {
+	info := &models.DCDNSourceIPInfo{
+		IP:       prefix.String(),
+		Location: "Global",
+		ISP:      "阿里云",
+		Status:   "Active",
+	}
+	if len(members) == 0 {
+		info.LastUpdated = time.Now()
+		return info
+	}
+
+	first := members[0]
+	info.Location, info.ISP, info.Status = first.Location, first.ISP, first.Status
+	var origins []string
+	for _, member := range members {
+		if member.Location != info.Location {
+			info.Location = "Global"
+		}
+		if member.ISP != info.ISP {
+			info.ISP = "normalized"
+		}
+		if member.Status != info.Status {
+			info.Status = "Active"
+		}
+		if member.LastUpdated.After(info.LastUpdated) {
+			info.LastUpdated = member.LastUpdated
+		}
+		if member.Origin != "" && !slices.Contains(origins, member.Origin) {
+			origins = append(origins, member.Origin)
+		}
+	}
+	info.Origin = strings.Join(origins, ",")
+	return info
+}
+
+// prefixSize 返回网段包含的地址数量
+func prefixSize(prefix netip.Prefix) float64 {
+	return math.Ldexp(1, prefix.Addr().BitLen()-prefix.Bits())
+}
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+func TestNormalizeSourceIPs(t *testing.T) {
+	tests := []struct {
+		name      string
+		normalize config.NormalizeConfig
+		ips       []string
+		want      []string
+		extra     float64
+	}{
+		{
+			name:      "相邻的同级网段无损合并",
+			normalize: config.NormalizeConfig{Mode: "lossless"},
+			ips:       []string{"10.0.0.128/25", "10.0.0.0/25", "10.0.0.5", "192.0.2.1", "192.0.2.1/32"},
+			want:      []string{"10.0.0.0/24", "192.0.2.1/32"},
+		},
+		{
+			name:      "条目数未超过上限时不聚合",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 3},
+			ips:       []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+			want:      []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+		},
+		{
+			name:      "优先合并额外覆盖地址最少的相邻网段",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 2},
+			ips:       []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+			want:      []string{"10.0.0.0/22", "10.0.8.0/24"},
+			extra:     512,
+		},
+		{
+			name:      "合并后的网段不短于min_prefix_length",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 2, MinPrefixLength: 23},
+			ips:       []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+			want:      []string{"10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+		},
+		{
+			name:      "多次合并累计额外覆盖的地址",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 2},
+			ips:       []string{"10.0.0.0/24", "10.0.1.0/26", "10.0.1.128/26", "10.0.4.0/24"},
+			want:      []string{"10.0.0.0/23", "10.0.4.0/24"},
+			extra:     128,
+		},
+		{
+			name:      "IPv4和IPv6网段不会合并",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 2},
+			ips:       []string{"10.0.0.0/24", "2001:db8::/64", "2001:db8:0:2::/64"},
+			want:      []string{"10.0.0.0/24", "2001:db8::/62"},
+			extra:     2 * (1 << 64),
+		},
+		{
+			name:      "只有两个地址族时无法合并到上限以下",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 1},
+			ips:       []string{"10.0.0.0/24", "2001:db8::/64"},
+			want:      []string{"10.0.0.0/24", "2001:db8::/64"},
+		},
+		{
+			name:      "无法解析的条目原样保留并占用上限",
+			normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 3},
+			ips:       []string{"not-an-ip", "10.0.0.0/24", "10.0.2.0/24", "10.0.8.0/24"},
+			want:      []string{"10.0.0.0/22", "10.0.8.0/24", "not-an-ip"},
+			extra:     512,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := config.AddressGroup{GroupName: "dcdn", Normalize: tt.normalize}
+			result, report, err := normalizeSourceIPs(group, sourceInfos(tt.ips...))
+			if err != nil {
+				t.Fatalf("normalizeSourceIPs 返回错误: %v", err)
+			}
+			var got []string
+			for _, ip := range result {
+				got = append(got, ip.IP)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("归一化结果为 %v，期望 %v", got, tt.want)
+			}
+			if report.ExtraAddresses != tt.extra {
+				t.Errorf("额外覆盖地址 %.0f，期望 %.0f", report.ExtraAddresses, tt.extra)
+			}
+			if report.Before != len(tt.ips) || report.After != len(tt.want) {
+				t.Errorf("报告条目数 %d -> %d，期望 %d -> %d", report.Before, report.After, len(tt.ips), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestNormalizeSourceIPsInvalidReachesLimit(t *testing.T) {
+	group := config.AddressGroup{
+		GroupName: "dcdn",
+		Normalize: config.NormalizeConfig{Mode: "aggregate", MaxEntries: 2},
+	}
+	_, _, err := normalizeSourceIPs(group, sourceInfos("bad-1", "bad-2", "10.0.0.0/24", "10.0.2.0/24"))
+	if err == nil || !strings.Contains(err.Error(), "无法解析的条目") {
+		t.Fatalf("无法解析的条目达到上限时应返回错误，实际为 %v", err)
+	}
+}
+
+func TestPrefixInfos(t *testing.T) {
+	sourceIPs := []*models.DCDNSourceIPInfo{
+		{IP: "10.0.0.0/25", Location: "Hangzhou", ISP: "Aliyun", Status: "Active", Origin: "dcdn_l2"},
+		{IP: "10.0.0.128/25", Location: "Beijing", ISP: "Aliyun", Status: "Active", Origin: "static:office"},
+		{IP: "192.0.2.0/24", Location: "Shanghai", ISP: "Aliyun", Status: "Active", Origin: "dcdn_l2"},
+	}
+	group := config.AddressGroup{GroupName: "dcdn", Normalize: config.NormalizeConfig{Mode: "lossless"}}
+	result, _, err := normalizeSourceIPs(group, sourceIPs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type info struct{ ip, location, isp, origin string }
+	var got []info
+	for _, ip := range result {
+		got = append(got, info{ip.IP, ip.Location, ip.ISP, ip.Origin})
+	}
+	want := []info{
+		{"10.0.0.0/24", "Global", "Aliyun", "dcdn_l2,static:office"},
+		{"192.0.2.0/24", "Shanghai", "Aliyun", "dcdn_l2"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("prefixInfos = %v, 期望 %v", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// staticSourceIPs 将地址组的固定条目转换为源IP信息，固定条目不经过包含/排除模式过滤
+func staticSourceIPs(group config.AddressGroup) []*models.DCDNSourceIPInfo {
+	var result []*models.DCDNSourceIPInfo
+	for _, entry := range group.StaticEntries {
+		result = append(result, &models.DCDNSourceIPInfo{
+			IP:          entry,
+			Location:    "Static",
+			ISP:         "static_entries",
+			Status:      "Static",
+			LastUpdated: time.Now(),
+			Origin:      "static_entries",
+		})
+	}
+	return result
+}
+
+// ownedEntries 返回managed模式下归本工具管理的条目
+func ownedEntries(last *state.GroupState) []string {
+	if last == nil {
+		return nil
+	}
+	if last.OwnershipTracked {
+		return last.Owned
+	}
+	// 从full模式切换过来时，上次写入的条目都是本工具写入的
+	return last.LastApplied
+}
+
+// applyOwnership managed模式下保留地址薄中的外部条目，只增删归本工具管理的条目
+// 期望条目中已由他人添加的条目不计入归属，以后也不会被本工具删除
+func (s *Scheduler) applyOwnership(group config.AddressGroup, plan *models.AddressBookPlan) {
+	if group.Ownership != "managed" {
+		return
+	}
+
+	owned := ownedEntries(s.state.Group(plan.GroupName))
+	managed := plan.Desired
+	foreign := difference(plan.Observed, owned)
+
+	plan.Owned = union(intersection(owned, managed), difference(managed, plan.Observed))
+	s.firewallClient.RevisePlan(plan, union(foreign, managed))
+}
+
+// intersection 返回同时存在于a和b中的条目，保持a的顺序
+func intersection(a, b []string) []string {
+	return difference(a, difference(a, b))
+}
@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+func TestApplyOwnership(t *testing.T) {
+	tests := []struct {
+		name      string
+		book      []string
+		last      *state.GroupState
+		sources   []string
+		wantBook  []string
+		wantOwned []string
+	}{
+		{
+			name:      "首次同步共用地址薄时保留外部条目，已存在的条目不计入归属",
+			book:      []string{"192.0.2.1/32", "10.0.0.0/24"},
+			sources:   []string{"10.0.0.0/24", "10.0.2.0/24"},
+			wantBook:  []string{"10.0.0.0/24", "10.0.2.0/24", "192.0.2.1/32"},
+			wantOwned: []string{"10.0.2.0/24"},
+		},
+		{
+			name:      "归本工具管理的条目从源列表消失后移除",
+			book:      []string{"192.0.2.1/32", "10.0.2.0/24"},
+			last:      &state.GroupState{OwnershipTracked: true, Owned: []string{"10.0.2.0/24"}, LastApplied: []string{"192.0.2.1/32", "10.0.2.0/24"}},
+			sources:   []string{"10.0.3.0/24"},
+			wantBook:  []string{"10.0.3.0/24", "192.0.2.1/32"},
+			wantOwned: []string{"10.0.3.0/24"},
+		},
+		{
+			name:     "曾在源列表中但由他人添加的条目不会被删除",
+			book:     []string{"192.0.2.1/32", "10.0.0.0/24"},
+			last:     &state.GroupState{OwnershipTracked: true, LastApplied: []string{"192.0.2.1/32", "10.0.0.0/24"}},
+			wantBook: []string{"10.0.0.0/24", "192.0.2.1/32"},
+		},
+		{
+			name:      "源列表为空时外部条目全部保留",
+			book:      []string{"192.0.2.1/32", "198.51.100.0/24", "10.0.2.0/24"},
+			last:      &state.GroupState{OwnershipTracked: true, Owned: []string{"10.0.2.0/24"}, LastApplied: []string{"192.0.2.1/32", "198.51.100.0/24", "10.0.2.0/24"}},
+			wantBook:  []string{"192.0.2.1/32", "198.51.100.0/24"},
+			wantOwned: nil,
+		},
+		{
+			name:      "从full模式切换时上次写入的条目归本工具管理",
+			book:      []string{"192.0.2.1/32", "10.0.2.0/24"},
+			last:      &state.GroupState{LastApplied: []string{"10.0.2.0/24"}},
+			sources:   []string{"10.0.3.0/24"},
+			wantBook:  []string{"10.0.3.0/24", "192.0.2.1/32"},
+			wantOwned: []string{"10.0.3.0/24"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, firewallClient := newFakeFirewall(t, map[string][]string{"shared": tt.book})
+			s := newTestScheduler(t, &config.Config{})
+			s.firewallClient = firewallClient
+			if tt.last != nil {
+				err := s.state.Update("shared", func(gs *state.GroupState) {
+					*gs = *tt.last
+					gs.LastAppliedAt = time.Now()
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			group := config.AddressGroup{GroupName: "shared", Ownership: "managed", DriftPolicy: "revert", ConflictPolicy: "abort"}
+			spec := models.AddressBookSpec{GroupName: "shared", GroupType: "ip"}
+			task := &models.SyncTask{}
+			if _, err := s.syncAddressBook(context.Background(), task, group, spec, sourceInfos(tt.sources...)); err != nil {
+				t.Fatalf("syncAddressBook 返回错误: %v", err)
+			}
+
+			if got := fake.addresses("shared"); !slices.Equal(got, tt.wantBook) {
+				t.Errorf("地址薄内容为 %v，期望 %v", got, tt.wantBook)
+			}
+			last := s.state.Group("shared")
+			if !last.OwnershipTracked || !slices.Equal(last.Owned, tt.wantOwned) {
+				t.Errorf("归属记录为 tracked=%v owned=%v，期望 %v", last.OwnershipTracked, last.Owned, tt.wantOwned)
+			}
+			if len(task.DriftEvents) != 0 {
+				t.Errorf("外部条目不应被判定为漂移: %+v", task.DriftEvents)
+			}
+		})
+	}
+}
+
+func TestGroupSourceIPsStaticEntries(t *testing.T) {
+	dcdn := sourceInfos("10.0.0.0/24", "10.0.1.0/24")
+	tests := []struct {
+		name  string
+		group config.AddressGroup
+		dcdn  []*models.DCDNSourceIPInfo
+		want  []string
+	}{
+		{
+			name:  "固定条目与DCDN列表一起写入",
+			group: config.AddressGroup{StaticEntries: []string{"192.0.2.1"}},
+			dcdn:  dcdn,
+			want:  []string{"10.0.0.0/24", "10.0.1.0/24", "192.0.2.1"},
+		},
+		{
+			name:  "固定条目不受排除模式过滤",
+			group: config.AddressGroup{StaticEntries: []string{"10.0.1.5"}, ExcludePatterns: []string{"10.0.1.0/24"}},
+			dcdn:  dcdn,
+			want:  []string{"10.0.0.0/24", "10.0.1.5"},
+		},
+		{
+			name:  "固定条目不受包含模式过滤",
+			group: config.AddressGroup{StaticEntries: []string{"192.0.2.1"}, IncludePatterns: []string{"10.0.0.0/24"}},
+			dcdn:  dcdn,
+			want:  []string{"10.0.0.0/24", "192.0.2.1"},
+		},
+		{
+			name:  "DCDN列表为空时仍写入固定条目",
+			group: config.AddressGroup{StaticEntries: []string{"192.0.2.1", "198.51.100.0/24"}},
+			want:  []string{"192.0.2.1", "198.51.100.0/24"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, &config.Config{})
+			tt.group.GroupName = "dcdn"
+			result, err := s.groupSourceIPs(newRunInputs(context.Background(), tt.dcdn, tt.dcdn), tt.group)
+			if err != nil {
+				t.Fatalf("groupSourceIPs 返回错误: %v", err)
+			}
+			var got []string
+			for _, ip := range result {
+				got = append(got, ip.IP)
+				if slices.Contains(tt.group.StaticEntries, ip.IP) && ip.Origin != "static_entries" {
+					t.Errorf("固定条目 %s 的来源为 %s", ip.IP, ip.Origin)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("源列表为 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,21 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"aliyun-dcdn-firewall-sync/internal/client"
 	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/leader"
+	"aliyun-dcdn-firewall-sync/internal/notify"
+	"aliyun-dcdn-firewall-sync/internal/sink"
+	"aliyun-dcdn-firewall-sync/internal/source"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
 	"aliyun-dcdn-firewall-sync/pkg/models"
 
 	"github.com/robfig/cron/v3"
@@ -24,30 +31,82 @@ type Scheduler struct {
 	ctx            context.Context
 	cancelFunc     context.CancelFunc
 	cron           *cron.Cron
+	coordinator    *runCoordinator
+	stopOnce       sync.Once
+	elector        *leader.Elector // 未启用选主时为nil
+	electionCancel context.CancelFunc
+	state          *state.Store
+	notifier       *notify.Notifier
+	sources        *source.Registry
+	sinks          map[string]sink.Sink // 按名称缓存的写入目标
+
+	mu           sync.Mutex
+	currentTask  string           // 正在运行的任务ID
+	currentGroup string           // 正在写入的地址薄
+	lastTask     *models.SyncTask // 最近一次结束的任务
+	driftStats   map[string]*driftStat
+	// 各地址组宽限期内等待移除的条目
+	pendingRemovals map[string][]models.PendingRemoval
 }
 
 // NewScheduler 创建新的调度器
-func NewScheduler(cfg *config.Config) *Scheduler {
+func NewScheduler(cfg *config.Config) (*Scheduler, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Scheduler{
-		config:         cfg,
-		dcdnClient:     client.NewDCDNClient(&cfg.DCDN),
-		firewallClient: client.NewFirewallClient(&cfg.Firewall, &cfg.Sync),
-		stopCh:         make(chan struct{}),
-		ctx:            ctx,
-		cancelFunc:     cancel,
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled {
+		var err error
+		elector, err = leader.NewElector(&cfg.LeaderElection)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("创建选主器失败: %w", err)
+		}
+	}
+
+	dcdnClient, err := client.NewDCDNClient(&cfg.DCDN)
+	if err != nil {
+		cancel()
+		return nil, err
 	}
+
+	store, err := state.Open(cfg.State.Path)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("打开状态文件失败: %w", err)
+	}
+
+	return &Scheduler{
+		config:          cfg,
+		dcdnClient:      dcdnClient,
+		firewallClient:  client.NewFirewallClient(&cfg.Firewall),
+		stopCh:          make(chan struct{}),
+		ctx:             ctx,
+		cancelFunc:      cancel,
+		coordinator:     newRunCoordinator(cfg.Scheduler.OverlapPolicy),
+		elector:         elector,
+		state:           store,
+		notifier:        notify.NewNotifier(&cfg.Notifications),
+		sources:         source.NewRegistry(cfg),
+		sinks:           make(map[string]sink.Sink),
+		driftStats:      make(map[string]*driftStat),
+		pendingRemovals: make(map[string][]models.PendingRemoval),
+	}, nil
 }
 
 // Start 启动调度器
 func (s *Scheduler) Start() error {
-	// 如果启用了立即执行，先执行一次
-	if s.config.Scheduler.RunOnStart {
+	// 启用选主时，成为leader后再执行初始同步
+	if s.elector != nil {
+		s.startElection()
+	} else if s.config.Scheduler.RunOnStart {
+		// 如果启用了立即执行，先执行一次
+		// 初始任务与定时任务一样交给协调器，避免阻塞调度循环和停止流程
 		log.Println("执行初始同步任务...")
-		if err := s.executeSyncTask(); err != nil {
-			log.Printf("初始同步任务失败: %v", err)
-		}
+		go func() {
+			if err := s.trigger(TriggerStartup); err != nil {
+				log.Printf("初始同步任务失败: %v", err)
+			}
+		}()
 	}
 
 	// 优先使用cron表达式
@@ -59,6 +118,30 @@ func (s *Scheduler) Start() error {
 	return s.startWithInterval()
 }
 
+// startElection 在后台参与选主
+// 选主使用独立的context，停止时等正在运行的任务结束后再释放锁，避免其他实例提前接管
+func (s *Scheduler) startElection() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.electionCancel = cancel
+
+	go s.elector.Run(ctx,
+		func() {
+			if !s.config.Scheduler.RunOnStart {
+				return
+			}
+			go func() {
+				if err := s.trigger(TriggerLeader); err != nil {
+					log.Printf("接管后的同步任务失败: %v", err)
+				}
+			}()
+		},
+		func() {
+			// 失去leader身份后不再写入新的地址薄
+			s.coordinator.CancelCurrent()
+		},
+	)
+}
+
 // startWithCron 使用cron表达式启动调度器
 func (s *Scheduler) startWithCron() error {
 	log.Printf("启动cron调度器，cron表达式: %s", s.config.Scheduler.Cron)
@@ -69,7 +152,7 @@ func (s *Scheduler) startWithCron() error {
 	// 添加任务
 	_, err := s.cron.AddFunc(s.config.Scheduler.Cron, func() {
 		log.Println("开始执行定时同步任务...")
-		if err := s.executeSyncTask(); err != nil {
+		if err := s.trigger(TriggerCron); err != nil {
 			log.Printf("同步任务执行失败: %v", err)
 		}
 	})
@@ -81,13 +164,9 @@ func (s *Scheduler) startWithCron() error {
 	s.cron.Start()
 	log.Printf("cron调度器已启动")
 
-	// 等待停止信号
-	select {
-	case <-s.ctx.Done():
-		log.Println("调度器收到停止信号")
-	case <-s.stopCh:
-		log.Println("调度器已停止")
-	}
+	// 等待Stop完成（包括等待正在运行的任务结束）
+	<-s.stopCh
+	log.Println("调度器已停止")
 
 	return nil
 }
@@ -111,15 +190,13 @@ func (s *Scheduler) startWithInterval() error {
 		select {
 		case <-ticker.C:
 			log.Printf("开始执行定时同步任务...")
-			if err := s.executeSyncTask(); err != nil {
-				log.Printf("同步任务执行失败: %v", err)
-			}
+			go func() {
+				if err := s.trigger(TriggerInterval); err != nil {
+					log.Printf("同步任务执行失败: %v", err)
+				}
+			}()
 			log.Printf("下次执行时间：%s", time.Now().Add(interval).Format("2006-01-02 15:04:05"))
 
-		case <-s.ctx.Done():
-			log.Println("调度器收到停止信号")
-			return nil
-
 		case <-s.stopCh:
 			log.Println("调度器已停止")
 			return nil
@@ -128,87 +205,208 @@ func (s *Scheduler) startWithInterval() error {
 }
 
 // Stop 停止调度器
+// 不再接受新的触发，并等待正在运行的任务写完当前地址薄后退出，最长等待shutdown_timeout
+// 可以安全地重复调用
 func (s *Scheduler) Stop() {
-	log.Println("正在停止调度器...")
-	s.cancelFunc()
-	if s.cron != nil {
-		s.cron.Stop()
-		log.Println("cron调度器已停止")
+	s.stopOnce.Do(func() {
+		log.Println("正在停止调度器...")
+		s.cancelFunc()
+		if s.cron != nil {
+			s.cron.Stop()
+			log.Println("cron调度器已停止")
+		}
+
+		timeout, err := time.ParseDuration(s.config.Scheduler.ShutdownTimeout)
+		if err != nil {
+			timeout = 2 * time.Minute
+		}
+
+		if s.coordinator.Wait(timeout) {
+			log.Println("正在运行的同步任务已结束")
+		} else {
+			s.mu.Lock()
+			task := &models.SyncTask{
+				TaskId:   s.currentTask,
+				Status:   "interrupted",
+				EndTime:  time.Now(),
+				ErrorMsg: fmt.Sprintf("等待 %s 后任务仍未结束，在地址薄 %s 处中断", timeout, s.currentGroup),
+			}
+			s.lastTask = task
+			s.mu.Unlock()
+			log.Printf("同步任务 %s 被中断: %s", task.TaskId, task.ErrorMsg)
+		}
+
+		if s.electionCancel != nil {
+			s.electionCancel()
+		}
+
+		close(s.stopCh)
+	})
+}
+
+// trigger 通过任务协调器执行同步任务，保证同一时刻只有一个任务在运行
+func (s *Scheduler) trigger(source string) error {
+	if s.elector != nil && !s.elector.IsLeader() {
+		reason := fmt.Sprintf("当前实例不是leader（leader: %s）", s.elector.Leader())
+		return s.coordinator.Skip(source, reason)
 	}
-	close(s.stopCh)
+	return s.coordinator.Run(s.ctx, source, s.executeSyncTask)
 }
 
 // executeSyncTask 执行同步任务
-func (s *Scheduler) executeSyncTask() error {
+func (s *Scheduler) executeSyncTask(ctx context.Context) error {
 	startTime := time.Now()
 	log.Printf("=== 开始执行同步任务 [%s] ===", startTime.Format("2006-01-02 15:04:05"))
 
 	// 创建同步任务记录
 	task := &models.SyncTask{
-		TaskId:    fmt.Sprintf("sync_%d", startTime.Unix()),
-		Status:    "running",
-		StartTime: startTime,
-		SourceIPs: []string{},
-		AddedIPs:  []string{},
+		TaskId:     fmt.Sprintf("sync_%d", startTime.Unix()),
+		Status:     "running",
+		StartTime:  startTime,
+		SourceIPs:  []string{},
+		AddedIPs:   []string{},
+		RemovedIPs: []string{},
 	}
 
+	s.mu.Lock()
+	s.currentTask = task.TaskId
+	s.currentGroup = ""
+	s.mu.Unlock()
+
 	defer func() {
 		task.EndTime = time.Now()
-		duration := task.EndTime.Sub(task.StartTime)
-
 		if task.Status == "running" {
 			task.Status = "completed"
 		}
 
+		s.mu.Lock()
+		// Stop等待超时后已将本任务记为中断，任务随后结束时保留中断状态和原因
+		if last := s.lastTask; last != nil && last.TaskId == task.TaskId && last.Status == "interrupted" {
+			task.Status = last.Status
+			task.ErrorMsg = last.ErrorMsg
+		}
+		s.currentTask = ""
+		s.currentGroup = ""
+		s.lastTask = task
+		s.mu.Unlock()
+		duration := task.EndTime.Sub(task.StartTime)
+
 		log.Printf("=== 同步任务完成 [%s] 耗时: %s 状态: %s ===",
 			task.EndTime.Format("2006-01-02 15:04:05"),
 			duration,
 			task.Status)
 
 		if task.Status == "completed" {
-			log.Printf("成功同步 %d 个源IP地址", len(task.AddedIPs))
+			log.Printf("同步成功: 新增 %d 个IP地址，移除 %d 个IP地址", len(task.AddedIPs), len(task.RemovedIPs))
 		} else {
 			log.Printf("同步任务失败: %s", task.ErrorMsg)
 		}
 	}()
 
-	// 1. 查询DCDN L2节点IP信息
-	log.Println("步骤1: 查询DCDN L2节点IP信息...")
-	sourceIPs, err := s.dcdnClient.GetL2IPList()
-	if err != nil {
-		task.Status = "failed"
-		task.ErrorMsg = fmt.Sprintf("查询DCDN L2节点IP信息失败: %v", err)
-		return fmt.Errorf("%s", task.ErrorMsg)
-	}
+	// 1. 查询DCDN L2节点IP信息（所有地址组都只使用其他数据源时跳过）
+	var sourceIPs []*models.DCDNSourceIPInfo
+	if s.config.UsesDCDN() {
+		log.Println("步骤1: 查询DCDN L2节点IP信息...")
+		err := s.withRetry(ctx, "查询DCDN L2节点IP", func() error {
+			var queryErr error
+			sourceIPs, queryErr = s.dcdnClient.GetL2IPList(ctx)
+			return queryErr
+		})
+		if err != nil {
+			task.Status = "failed"
+			task.ErrorMsg = fmt.Sprintf("查询DCDN L2节点IP信息失败: %v", err)
+			return fmt.Errorf("%s", task.ErrorMsg)
+		}
 
-	log.Printf("查询到 %d 个L2节点IP地址", len(sourceIPs))
+		log.Printf("查询到 %d 个L2节点IP地址", len(sourceIPs))
 
-	// 记录源IP列表
-	for _, ip := range sourceIPs {
-		task.SourceIPs = append(task.SourceIPs, ip.IP)
+		// 记录源IP列表
+		for _, ip := range sourceIPs {
+			task.SourceIPs = append(task.SourceIPs, ip.IP)
+		}
+	} else {
+		log.Println("步骤1: 没有地址组使用DCDN L2节点IP，跳过查询")
 	}
 
 	// 2. 处理CIDR格式的IP地址，过滤IPv4地址
 	log.Println("步骤2: 处理CIDR格式IP地址并过滤IPv4地址...")
 	ipv4IPs := s.filterIPv4Addresses(sourceIPs)
 	log.Printf("过滤结果: IPv4地址 %d 个", len(ipv4IPs))
+	inputs := newRunInputs(ctx, sourceIPs, ipv4IPs)
 
 	// 3. 同步到防火墙地址薄
 	log.Println("步骤3: 同步到云防火墙地址薄...")
 	for _, syncGroup := range s.config.Sync.AddressGroups {
+		// 任务被取消时不再开始新的地址薄写入，已完成的地址薄保持更新后的状态
+		if ctx.Err() != nil {
+			task.Status = "canceled"
+			if s.ctx.Err() != nil {
+				task.Status = "interrupted" // 调度器正在停止
+			}
+			task.PendingGroups = s.pendingGroups(task)
+			task.ErrorMsg = fmt.Sprintf("同步任务在地址薄 %s 之前停止: %v，未同步的地址薄: %s",
+				syncGroup.GroupName, ctx.Err(), strings.Join(task.PendingGroups, ","))
+			return fmt.Errorf("%s", task.ErrorMsg)
+		}
+
+		s.mu.Lock()
+		s.currentGroup = syncGroup.GroupName
+		s.mu.Unlock()
+
 		log.Printf("开始同步地址薄: %s", syncGroup.GroupName)
 
-		// 使用IPv4地址
-		targetIPs := ipv4IPs
+		// 计算地址组的源列表：DCDN列表或表达式结果，经过包含/排除模式过滤并加入固定条目
+		filteredIPs, err := s.groupSourceIPs(inputs, syncGroup)
+		if err != nil {
+			log.Printf("计算地址组 %s 的源列表失败: %v", syncGroup.GroupName, err)
+			if task.ErrorMsg == "" {
+				task.ErrorMsg = fmt.Sprintf("计算地址组 %s 的源列表失败: %v", syncGroup.GroupName, err)
+			}
+			continue
+		}
+
+		// 消失的条目在宽限期内保留，避免源列表抖动导致误删；缺失记录在写入成功后才保存
+		filteredIPs, grace := s.applyRemovalGrace(syncGroup, filteredIPs)
+
+		// 可选的CIDR归一化，减少条目数并让差异更稳定
+		filteredIPs, report, err := normalizeSourceIPs(syncGroup, filteredIPs)
+		if err != nil {
+			log.Printf("归一化地址组 %s 失败: %v", syncGroup.GroupName, err)
+			if task.ErrorMsg == "" {
+				task.ErrorMsg = fmt.Sprintf("归一化地址组 %s 失败: %v", syncGroup.GroupName, err)
+			}
+			continue
+		}
+		if report != nil {
+			task.Normalization = append(task.Normalization, *report)
+		}
 
-		// 过滤源IP（如果有过滤规则）
-		filteredIPs := s.filterSourceIPs(targetIPs, syncGroup)
-		log.Printf("地址薄 %s: 过滤后剩余 %d 个IP地址", syncGroup.GroupName, len(filteredIPs))
+		// 写入目标与云防火墙地址薄互不影响，地址薄写入失败时仍然同步
+		sinkErr := s.syncSinks(ctx, task, syncGroup, filteredIPs)
+		if sinkErr != nil && task.ErrorMsg == "" {
+			task.ErrorMsg = fmt.Sprintf("地址组 %s 的%v", syncGroup.GroupName, sinkErr)
+		}
+		if syncGroup.SkipAddressBook {
+			if sinkErr == nil {
+				task.SyncedGroups = append(task.SyncedGroups, syncGroup.GroupName)
+				s.saveRemovalGrace(grace)
+				s.recordSynced(syncGroup, filteredIPs)
+			}
+			log.Printf("地址组 %s 设置了skip_address_book，不写入云防火墙地址薄", syncGroup.GroupName)
+			continue
+		}
 
-		// 执行同步
-		err = s.firewallClient.SyncAddressBook(syncGroup.GroupName, filteredIPs)
+		// 执行同步，启用分片时写入多个地址薄
+		plans, err := s.syncGroupBooks(ctx, task, syncGroup, filteredIPs)
+		var pending []models.PendingRemoval
+		if grace != nil {
+			pending = grace.pending
+		}
+		for _, plan := range plans {
+			plan.PendingRemoval = pendingIn(pending, plan)
+		}
 		if err != nil {
-			log.Printf("同步地址薄 %s 失败: %v", syncGroup.GroupName, err)
+			log.Printf("同步地址薄 %s 失败 (错误分类: %s): %v", syncGroup.GroupName, client.ErrorClassOf(err), err)
 			// 记录错误但继续处理其他地址薄
 			if task.ErrorMsg == "" {
 				task.ErrorMsg = fmt.Sprintf("同步地址薄 %s 失败: %v", syncGroup.GroupName, err)
@@ -216,17 +414,22 @@ func (s *Scheduler) executeSyncTask() error {
 			continue
 		}
 
-		// 记录成功添加的IP
-		for _, ip := range filteredIPs {
-			task.AddedIPs = append(task.AddedIPs, ip.IP)
-		}
+		// 记录实际新增和移除的IP，条目在分片间移动时不计入
+		added, removed := netChanges(plans)
+		task.AddedIPs = append(task.AddedIPs, added...)
+		task.RemovedIPs = append(task.RemovedIPs, removed...)
+		task.SyncedGroups = append(task.SyncedGroups, syncGroup.GroupName)
+		s.saveRemovalGrace(grace)
+		s.recordSynced(syncGroup, filteredIPs)
 
-		log.Printf("地址薄 %s 同步完成", syncGroup.GroupName)
+		log.Printf("地址薄 %s 同步完成: 新增 %d，移除 %d，等待移除 %d",
+			syncGroup.GroupName, len(added), len(removed), len(pending))
 	}
 
 	// 4. 清理和统计
 	log.Println("步骤4: 清理重复IP和生成统计...")
 	task.AddedIPs = s.removeDuplicateIPs(task.AddedIPs)
+	task.RemovedIPs = s.removeDuplicateIPs(task.RemovedIPs)
 
 	if task.ErrorMsg != "" {
 		task.Status = "completed_with_errors"
@@ -235,74 +438,211 @@ func (s *Scheduler) executeSyncTask() error {
 	return nil
 }
 
-// filterIPv4Addresses 过滤出IPv4地址
-func (s *Scheduler) filterIPv4Addresses(sourceIPs []*models.DCDNSourceIPInfo) []*models.DCDNSourceIPInfo {
-	var ipv4IPs []*models.DCDNSourceIPInfo
+// maxReplans 冲突后重新计划的最大次数
+const maxReplans = 3
+
+// syncAddressBook 计划并写入单个地址薄（分片时为其中一个分片）
+// 写入前检测到并发修改时，按地址组的conflict_policy放弃或重新计划，冲突记录写入任务
+// 重新计划时保留冲突期间的外部修改，不会覆盖运维人员在本次任务进行中的操作
+func (s *Scheduler) syncAddressBook(ctx context.Context, task *models.SyncTask, group config.AddressGroup, spec models.AddressBookSpec, sourceIPs []*models.DCDNSourceIPInfo) (*models.AddressBookPlan, error) {
+	var edits conflictEdits
+	for attempt := 0; ; attempt++ {
+		var plan *models.AddressBookPlan
+		err := s.withRetry(ctx, "计划地址薄 "+spec.GroupName, func() error {
+			var planErr error
+			plan, planErr = s.firewallClient.PlanAddressBook(ctx, spec, sourceIPs)
+			return planErr
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	for _, ipInfo := range sourceIPs {
-		// 处理CIDR格式的IP地址
-		if strings.Contains(ipInfo.IP, "/") {
-			// 这是CIDR格式，提取网络地址
-			_, ipNet, err := net.ParseCIDR(ipInfo.IP)
+		// 漂移只在首次计划时上报，冲突后重新计划时不重复上报
+		held, err := s.reconcileDrift(task, group, plan, attempt == 0)
+		if err != nil {
+			return nil, err
+		}
+		// alert策略下保留外部修改，与冲突期间的外部修改一样按写入后发生的修改记录，下次同步仍会检测到
+		if held != nil && attempt == 0 {
+			edits.merge(held.ForeignAdded, held.ForeignRemoved)
+		}
+
+		// managed模式下保留他人维护的条目
+		s.applyOwnership(group, plan)
+		edits.apply(s.firewallClient, plan)
+
+		err = s.withRetry(ctx, "写入地址薄 "+spec.GroupName, func() error {
+			return s.firewallClient.ApplyPlan(ctx, plan)
+		})
+
+		var conflictErr *client.ConflictError
+		if !errors.As(err, &conflictErr) {
 			if err != nil {
-				log.Printf("警告: 无法解析CIDR格式的IP地址: %s, 错误: %v", ipInfo.IP, err)
-				continue
+				return plan, err
 			}
+			if err := s.recordApplied(group, plan, edits); err != nil {
+				// 地址薄已写入，状态保存失败只影响下次漂移检测
+				log.Printf("保存地址薄 %s 的写入快照失败: %v", spec.GroupName, err)
+			}
+			return plan, nil
+		}
+
+		conflict := conflictErr.Conflict
+		conflict.Action = "aborted"
+		if group.ConflictPolicy == "replan" && attempt < maxReplans && ctx.Err() == nil {
+			conflict.Action = "replanned"
+		}
+		task.Conflicts = append(task.Conflicts, *conflict)
+
+		log.Printf("地址薄 %s 在计划后被修改: 外部新增 %v，外部删除 %v，处理方式: %s",
+			spec.GroupName, conflict.ForeignAdded, conflict.ForeignRemoved, conflict.Action)
+
+		if conflict.Action == "aborted" {
+			return nil, err
+		}
+		edits.merge(conflict.ForeignAdded, conflict.ForeignRemoved)
+	}
+}
+
+// conflictEdits 本次任务中需要保留的外部修改（alert策略下的漂移和冲突），后发生的修改优先
+type conflictEdits struct {
+	added   []string
+	removed []string
+}
+
+// merge 合并一次外部修改
+func (e *conflictEdits) merge(added, removed []string) {
+	e.added = union(difference(e.added, removed), added)
+	e.removed = union(difference(e.removed, added), removed)
+}
+
+// apply 将外部修改带入重新计划的结果：外部新增的条目保留且不归本工具管理，外部删除的条目不再写回
+func (e conflictEdits) apply(firewallClient *client.FirewallClient, plan *models.AddressBookPlan) {
+	if len(e.added) == 0 && len(e.removed) == 0 {
+		return
+	}
+	if plan.Owned != nil {
+		plan.Owned = difference(plan.Owned, e.added)
+	}
+	firewallClient.RevisePlan(plan, union(difference(plan.Desired, e.removed), e.added))
+}
+
+// pendingGroups 返回本次任务尚未成功同步的地址薄
+func (s *Scheduler) pendingGroups(task *models.SyncTask) []string {
+	synced := make(map[string]bool)
+	for _, name := range task.SyncedGroups {
+		synced[name] = true
+	}
+
+	var pending []string
+	for _, group := range s.config.Sync.AddressGroups {
+		if !synced[group.GroupName] {
+			pending = append(pending, group.GroupName)
+		}
+	}
+	return pending
+}
 
-			// 只处理IPv4地址
-			if ipNet.IP.To4() != nil {
-				ipv4IPs = append(ipv4IPs, &models.DCDNSourceIPInfo{
-					IP:          ipInfo.IP, // 保持原始CIDR格式
-					Location:    ipInfo.Location,
-					ISP:         ipInfo.ISP,
-					Status:      ipInfo.Status,
-					LastUpdated: ipInfo.LastUpdated,
-				})
+// withRetry 对限流和暂时性错误按指数退避重试，其他错误分类直接返回
+func (s *Scheduler) withRetry(ctx context.Context, operation string, fn func() error) error {
+	backoff := time.Second
+	var err error
+
+	for attempt := 0; attempt <= s.config.Scheduler.MaxRetries; attempt++ {
+		if attempt > 0 {
+			// 限流时退避时间加倍，给令牌桶和服务端配额留出恢复时间
+			wait := backoff
+			if client.ErrorClassOf(err) == client.ErrorClassThrottled {
+				wait *= 2
 			}
-		} else {
-			// 单个IP地址
-			if s.isIPv4(ipInfo.IP) {
-				ipv4IPs = append(ipv4IPs, ipInfo)
+			log.Printf("%s 失败 (错误分类: %s)，%s 后进行第 %d 次重试", operation, client.ErrorClassOf(err), wait, attempt)
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return err
 			}
+			backoff *= 2
+		}
+
+		err = fn()
+		if err == nil || !client.IsRetryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// filterIPv4Addresses 过滤出IPv4地址
+func (s *Scheduler) filterIPv4Addresses(sourceIPs []*models.DCDNSourceIPInfo) []*models.DCDNSourceIPInfo {
+	var ipv4IPs []*models.DCDNSourceIPInfo
+
+	for _, ipInfo := range sourceIPs {
+		prefix, err := ipset.ParsePrefix(ipInfo.IP)
+		if err != nil {
+			log.Printf("警告: 无法解析IP地址: %s, 错误: %v", ipInfo.IP, err)
+			continue
+		}
+
+		// 只处理IPv4地址，保持原始格式，写入前统一规范化
+		if prefix.Addr().Is4() {
+			ipv4IPs = append(ipv4IPs, ipInfo)
 		}
 	}
 
 	return ipv4IPs
 }
 
+// ipPatterns 编译后的过滤模式：IP或CIDR形式的模式按网段匹配，其他模式按通配符匹配
+type ipPatterns struct {
+	prefixes *ipset.Set
+	globs    []string
+}
+
+// compileIPPatterns 编译过滤模式
+func compileIPPatterns(patterns []string) ipPatterns {
+	compiled := ipPatterns{prefixes: &ipset.Set{}}
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "*") {
+			if prefix, err := ipset.ParsePrefix(pattern); err == nil {
+				compiled.prefixes.Add(prefix)
+				continue
+			}
+		}
+		compiled.globs = append(compiled.globs, strings.TrimSpace(pattern))
+	}
+	return compiled
+}
+
 // filterSourceIPs 根据地址薄配置过滤源IP
+// CIDR形式的包含模式要求条目完全落在网段内，排除模式只要条目与网段有重叠即排除
 func (s *Scheduler) filterSourceIPs(sourceIPs []*models.DCDNSourceIPInfo, group config.AddressGroup) []*models.DCDNSourceIPInfo {
 	if len(group.IncludePatterns) == 0 && len(group.ExcludePatterns) == 0 {
 		return sourceIPs
 	}
 
+	include := compileIPPatterns(group.IncludePatterns)
+	exclude := compileIPPatterns(group.ExcludePatterns)
+
 	var filtered []*models.DCDNSourceIPInfo
 
 	for _, ip := range sourceIPs {
-		// 检查排除模式
-		excluded := false
-		for _, pattern := range group.ExcludePatterns {
-			if s.matchIPPattern(ip.IP, pattern) {
-				excluded = true
-				break
-			}
+		prefix, err := ipset.ParsePrefix(ip.IP)
+		if err != nil {
+			log.Printf("警告: 过滤时跳过无法解析的IP地址: %s", ip.IP)
+			continue
 		}
+		raw := strings.TrimSpace(ip.IP)
 
-		if excluded {
+		// 检查排除模式
+		if exclude.prefixes.Overlaps(prefix) || s.matchGlobs(raw, exclude.globs) {
 			continue
 		}
 
 		// 检查包含模式（如果有的话）
 		if len(group.IncludePatterns) > 0 {
-			included := false
-			for _, pattern := range group.IncludePatterns {
-				if s.matchIPPattern(ip.IP, pattern) {
-					included = true
-					break
-				}
-			}
-
-			if !included {
+			if !include.prefixes.ContainsPrefix(prefix) && !s.matchGlobs(raw, include.globs) {
 				continue
 			}
 		}
@@ -313,15 +653,23 @@ func (s *Scheduler) filterSourceIPs(sourceIPs []*models.DCDNSourceIPInfo, group
 	return filtered
 }
 
-// matchIPPattern 简单的IP模式匹配（支持CIDR和通配符）
+// matchGlobs 判断IP是否匹配任一通配符模式
+func (s *Scheduler) matchGlobs(ip string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if s.matchIPPattern(ip, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIPPattern 匹配IP模式
 func (s *Scheduler) matchIPPattern(ip, pattern string) bool {
-	// 简化实现：支持精确匹配和简单通配符
+	// 支持精确匹配和前缀通配符，IP和CIDR形式的模式由ipPatterns按网段匹配
 	if pattern == "*" || pattern == ip {
 		return true
 	}
 
-	// 可以在这里添加更复杂的匹配逻辑，如CIDR匹配
-	// 当前仅支持简单的前缀匹配
 	if len(pattern) > 0 && pattern[len(pattern)-1] == '*' {
 		prefix := pattern[:len(pattern)-1]
 		return len(ip) >= len(prefix) && ip[:len(prefix)] == prefix
@@ -330,42 +678,98 @@ func (s *Scheduler) matchIPPattern(ip, pattern string) bool {
 	return false
 }
 
-// removeDuplicateIPs 移除重复的IP地址
+// removeDuplicateIPs 移除重复的IP地址，结果为排序后的规范CIDR形式
+// 无法解析的条目原样保留在末尾
 func (s *Scheduler) removeDuplicateIPs(ips []string) []string {
-	seen := make(map[string]bool)
-	var result []string
+	set, invalid := ipset.FromStrings(ips)
+	return append(set.Strings(), union(nil, invalid)...)
+}
 
-	for _, ip := range ips {
-		if !seen[ip] {
-			seen[ip] = true
-			result = append(result, ip)
+// RunOnce 立即执行一次同步任务
+// 启用选主时先参与选主，成为leader后才执行，避免与正在运行的leader同时写入
+// 任务未完全成功（部分地址薄失败、被中断等）时返回错误
+func (s *Scheduler) RunOnce() error {
+	log.Println("手动执行同步任务...")
+	if s.elector != nil {
+		release, err := s.awaitLeadership()
+		if err != nil {
+			return err
 		}
+		defer release()
+	}
+
+	if err := s.trigger(TriggerManual); err != nil {
+		return err
 	}
 
-	return result
+	if task := s.lastTaskSnapshot(); task != nil && task.Status != "completed" {
+		return fmt.Errorf("同步任务状态: %s，%s", task.Status, task.ErrorMsg)
+	}
+	return nil
 }
 
-// RunOnce 立即执行一次同步任务
-func (s *Scheduler) RunOnce() error {
-	log.Println("手动执行同步任务...")
-	return s.executeSyncTask()
+// awaitLeadership 参与选主并等待成为leader，最长等待once_timeout
+// 返回的release退出选主并释放锁；等待期间失去leader身份时取消正在运行的任务
+func (s *Scheduler) awaitLeadership() (release func(), err error) {
+	timeout, err := time.ParseDuration(s.config.LeaderElection.OnceTimeout)
+	if err != nil {
+		timeout = time.Minute
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	elected := make(chan struct{})
+	done := make(chan struct{})
+	var electedOnce sync.Once
+	go func() {
+		defer close(done)
+		s.elector.Run(ctx,
+			func() { electedOnce.Do(func() { close(elected) }) },
+			func() { s.coordinator.CancelCurrent() },
+		)
+	}()
+	release = func() {
+		cancel()
+		<-done
+	}
+
+	select {
+	case <-elected:
+		return release, nil
+	case <-time.After(timeout):
+		release()
+		return nil, fmt.Errorf("等待 %s 后仍未成为leader（leader: %s），为避免与leader同时写入，本次不执行同步", timeout, s.elector.Leader())
+	}
 }
 
 // GetStatus 获取调度器状态
 func (s *Scheduler) GetStatus() map[string]interface{} {
-	return map[string]interface{}{
+	status := map[string]interface{}{
 		"running":     s.ctx.Err() == nil,
 		"next_run":    "基于配置的间隔时间",
 		"config":      s.config.Scheduler,
+		"runs":        s.coordinator.Status(),
+		"last_task":   s.lastTaskSnapshot(),
 		"last_update": time.Now().Format("2006-01-02 15:04:05"),
 	}
+
+	if s.elector != nil {
+		status["leader_election"] = s.elector.Status()
+	}
+	status["drift"] = s.driftStatus()
+	status["pending_removal"] = s.pendingRemovalStatus()
+	status["shards"] = s.shardStatus()
+
+	return status
 }
 
-// isIPv4 判断是否为IPv4地址
-func (s *Scheduler) isIPv4(ip string) bool {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return false
+// lastTaskSnapshot 返回最近一次结束任务的副本
+func (s *Scheduler) lastTaskSnapshot() *models.SyncTask {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastTask == nil {
+		return nil
 	}
-	return parsedIP.To4() != nil
+	task := *s.lastTask
+	return &task
 }
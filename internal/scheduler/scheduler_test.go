@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/leader"
+	"aliyun-dcdn-firewall-sync/internal/notify"
+	"aliyun-dcdn-firewall-sync/internal/sink"
+	"aliyun-dcdn-firewall-sync/internal/source"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// newTestScheduler 创建不连接阿里云的调度器，状态文件位于临时目录
+func newTestScheduler(t *testing.T, cfg *config.Config) *Scheduler {
+	t.Helper()
+	if cfg.State.Path == "" {
+		cfg.State.Path = filepath.Join(t.TempDir(), "state.json")
+	}
+	store, err := state.Open(cfg.State.Path)
+	if err != nil {
+		t.Fatalf("打开状态文件失败: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Scheduler{
+		config:          cfg,
+		stopCh:          make(chan struct{}),
+		ctx:             ctx,
+		cancelFunc:      cancel,
+		coordinator:     newRunCoordinator(cfg.Scheduler.OverlapPolicy),
+		state:           store,
+		notifier:        notify.NewNotifier(&cfg.Notifications),
+		sources:         source.NewRegistry(cfg),
+		sinks:           make(map[string]sink.Sink),
+		driftStats:      make(map[string]*driftStat),
+		pendingRemovals: make(map[string][]models.PendingRemoval),
+	}
+}
+
+// fakeSink 记录写入内容的写入目标，设置block时写入会一直阻塞到block关闭，不响应ctx
+type fakeSink struct {
+	name    string
+	block   chan struct{}
+	entered chan struct{}
+	synced  [][]string
+}
+
+// Name 实现sink.Sink接口
+func (f *fakeSink) Name() string { return f.name }
+
+// Sync 实现sink.Sink接口
+func (f *fakeSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	if f.entered != nil {
+		close(f.entered)
+		f.entered = nil
+	}
+	if f.block != nil {
+		<-f.block
+	}
+	f.synced = append(f.synced, desired)
+	return []models.SinkResult{{Sink: f.name, Target: f.name, Added: desired}}, nil
+}
+
+// sinkOnlyConfig 返回只写入一个写入目标的地址组配置，源列表为固定条目，不访问阿里云
+func sinkOnlyConfig() *config.Config {
+	cfg := &config.Config{
+		Sources: []config.SourceConfig{{Name: "office", Type: "static", Entries: []string{"192.0.2.0/24"}}},
+		Sinks:   []config.SinkConfig{{Name: "fake", Type: "webhook", Group: "office"}},
+	}
+	cfg.Sync.AddressGroups = []config.AddressGroup{
+		{GroupName: "office", Expression: "static:office", SkipAddressBook: true},
+	}
+	return cfg
+}
+
+// flockConfig 返回使用临时锁文件的选主配置
+func flockConfig(lockFile string) config.LeaderElectionConfig {
+	return config.LeaderElectionConfig{
+		Enabled:     true,
+		Backend:     "flock",
+		LockFile:    lockFile,
+		RetryPeriod: "20ms",
+		OnceTimeout: "300ms",
+	}
+}
+
+func TestRunOnceWaitsForLeadership(t *testing.T) {
+	lockFile := filepath.Join(t.TempDir(), "sync.lock")
+	cfg := &config.Config{LeaderElection: flockConfig(lockFile)}
+	cfg.LeaderElection.Identity = "once"
+	s := newTestScheduler(t, cfg)
+	var err error
+	if s.elector, err = leader.NewElector(&cfg.LeaderElection); err != nil {
+		t.Fatal(err)
+	}
+
+	// 另一个实例持有锁时不执行同步
+	holderCfg := flockConfig(lockFile)
+	holderCfg.Identity = "daemon"
+	holder, err := leader.NewElector(&holderCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	elected := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		holder.Run(ctx, func() { close(elected) }, nil)
+	}()
+	<-elected
+
+	err = s.RunOnce()
+	if err == nil || !strings.Contains(err.Error(), "仍未成为leader") {
+		t.Fatalf("其他实例持有锁时 RunOnce 返回 %v，期望等待超时的错误", err)
+	}
+	if task := s.lastTaskSnapshot(); task != nil {
+		t.Fatalf("未成为leader时不应执行同步任务: %+v", task)
+	}
+
+	// 锁释放后成为leader并执行，结束后释放锁
+	cancel()
+	<-done
+	if err := s.RunOnce(); err != nil {
+		t.Fatalf("锁释放后 RunOnce 返回错误: %v", err)
+	}
+	if task := s.lastTaskSnapshot(); task == nil || task.Status != "completed" {
+		t.Fatalf("同步任务状态为 %+v，期望 completed", task)
+	}
+	if s.elector.IsLeader() {
+		t.Error("RunOnce 结束后应退出选主")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reacquired := make(chan struct{})
+	go holder.Run(ctx, func() { close(reacquired) }, nil)
+	select {
+	case <-reacquired:
+	case <-ctx.Done():
+		t.Error("RunOnce 结束后锁未释放")
+	}
+}
+
+func TestStopInterruptsBlockedTask(t *testing.T) {
+	cfg := sinkOnlyConfig()
+	cfg.Scheduler.ShutdownTimeout = "100ms"
+	s := newTestScheduler(t, cfg)
+	blocked := &fakeSink{name: "fake", block: make(chan struct{}), entered: make(chan struct{})}
+	entered := blocked.entered
+	s.sinks["fake"] = blocked
+
+	done := make(chan error, 1)
+	go func() { done <- s.trigger(TriggerManual) }()
+	<-entered
+
+	// 并发重复调用Stop，只执行一次停止流程，都在shutdown_timeout后返回
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Stop()
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Stop 在 %s 后返回，期望在shutdown_timeout（100ms）后返回", elapsed)
+	}
+	s.Stop()
+
+	task := s.lastTaskSnapshot()
+	if task == nil || task.Status != "interrupted" || !strings.Contains(task.ErrorMsg, "地址薄 office 处中断") {
+		t.Fatalf("Stop 超时后任务为 %+v，期望记为interrupted", task)
+	}
+	select {
+	case <-s.stopCh:
+	default:
+		t.Error("Stop 返回后stopCh应已关闭")
+	}
+
+	// 阻塞的写入结束后，任务保留中断状态和原因
+	close(blocked.block)
+	if err := <-done; err != nil {
+		t.Fatalf("任务返回错误: %v", err)
+	}
+	final := s.lastTaskSnapshot()
+	if final.TaskId != task.TaskId || final.Status != "interrupted" || final.ErrorMsg != task.ErrorMsg {
+		t.Errorf("任务结束后状态为 %+v，期望保留中断状态 %+v", final, task)
+	}
+}
@@ -0,0 +1,477 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/state"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// bookAssignment 分配给单个地址薄的条目
+type bookAssignment struct {
+	Spec      models.AddressBookSpec
+	SourceIPs []*models.DCDNSourceIPInfo
+	Shrinks   bool // 相对上次写入会移除条目
+}
+
+// shardBucket 一个分片及分配给它的条目
+type shardBucket struct {
+	index   int // 分片序号，从1开始
+	entries []prefixEntry
+}
+
+// shardName 返回地址组第index个分片的地址薄名称（从1开始）
+func shardName(group config.AddressGroup, index int) string {
+	return fmt.Sprintf("%s-%d", group.GroupName, index)
+}
+
+// shardIndex 解析分片地址薄名称中的序号
+func shardIndex(group config.AddressGroup, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, group.GroupName+"-")
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(suffix)
+	return index, err == nil && index > 0
+}
+
+// assignBooks 按地址组的shard配置将条目分配到地址薄
+// 未配置分片时整个地址组写入同名地址薄；count和first_octet分片在上次分片结果的基础上分配，
+// 仍然需要的条目留在原分片，避免条目在分片之间移动
+func (s *Scheduler) assignBooks(group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) ([]bookAssignment, error) {
+	spec := s.firewallClient.AddressBookSpec(group)
+	if group.Shard.Strategy == "" || group.Shard.Strategy == "none" {
+		return []bookAssignment{{Spec: spec, SourceIPs: sourceIPs}}, nil
+	}
+
+	entries := sortedEntries(sourceIPs)
+	maxEntries := group.Shard.MaxEntries
+
+	var buckets []*shardBucket
+	var types []string
+
+	switch group.Shard.Strategy {
+	case "count", "first_octet":
+		entries = filterFamily(entries, spec.GroupType == "ipv6")
+		buckets = s.stickyBuckets(group, entries)
+		for range buckets {
+			types = append(types, spec.GroupType)
+		}
+
+	case "ip_version":
+		// 分片1固定为IPv4，分片2固定为IPv6；没有条目的地址族不写入，已有的分片随后作为旧分片删除
+		v4 := filterFamily(entries, false)
+		v6 := filterFamily(entries, true)
+		if len(v4) > maxEntries || len(v6) > maxEntries {
+			return nil, fmt.Errorf("地址组 %s 按ip_version分片后仍超过 %d 条（IPv4 %d 条，IPv6 %d 条），请改用count分片",
+				group.GroupName, maxEntries, len(v4), len(v6))
+		}
+		if len(v4) > 0 {
+			buckets = append(buckets, &shardBucket{index: 1, entries: v4})
+			types = append(types, "ip")
+		}
+		if len(v6) > 0 {
+			buckets = append(buckets, &shardBucket{index: 2, entries: v6})
+			types = append(types, "ipv6")
+		}
+	}
+
+	assignments := make([]bookAssignment, 0, len(buckets))
+	for i, bucket := range buckets {
+		name := shardName(group, bucket.index)
+		assignment := bookAssignment{
+			Spec: models.AddressBookSpec{
+				GroupName:   name,
+				Description: strings.TrimSpace(fmt.Sprintf("%s (分片 %d/%d)", group.Description, i+1, len(buckets))),
+				GroupType:   types[i],
+			},
+			SourceIPs: infos(bucket.entries),
+		}
+		if last := s.state.Group(name); last != nil {
+			assignment.Shrinks = len(difference(last.LastApplied, prefixStrings(bucket.entries))) > 0
+		}
+		assignments = append(assignments, assignment)
+	}
+
+	// 先写入只新增条目的分片，再写入会移除条目的分片，条目在分片间移动时不会出现不在任何地址薄中的空档
+	sort.SliceStable(assignments, func(i, j int) bool { return !assignments[i].Shrinks && assignments[j].Shrinks })
+	return assignments, nil
+}
+
+// stickyBuckets 在上次分片结果的基础上分配条目
+// 仍然需要的条目留在上次写入的分片中；新条目和超出分片上限的条目放入有空间的分片，没有空间时新建分片
+// 不再有条目的分片不返回，随后作为旧分片删除
+func (s *Scheduler) stickyBuckets(group config.AddressGroup, entries []prefixEntry) []*shardBucket {
+	maxEntries := group.Shard.MaxEntries
+
+	var buckets []*shardBucket
+	owner := make(map[netip.Prefix]*shardBucket)
+	if gs := s.state.Group(group.GroupName); gs != nil {
+		for _, name := range gs.Shards {
+			index, ok := shardIndex(group, name)
+			if !ok {
+				continue
+			}
+			bucket := &shardBucket{index: index}
+			buckets = append(buckets, bucket)
+			if last := s.state.Group(name); last != nil {
+				for _, entry := range last.LastApplied {
+					if prefix, err := ipset.ParsePrefix(entry); err == nil {
+						owner[prefix] = bucket
+					}
+				}
+			}
+		}
+	}
+
+	var unplaced []prefixEntry
+	for _, entry := range entries {
+		if bucket, ok := owner[entry.prefix]; ok && len(bucket.entries) < maxEntries {
+			bucket.entries = append(bucket.entries, entry)
+			continue
+		}
+		unplaced = append(unplaced, entry)
+	}
+	buckets = placeEntries(group.Shard.Strategy, buckets, unplaced, maxEntries)
+
+	var result []*shardBucket
+	for _, bucket := range buckets {
+		if len(bucket.entries) > 0 {
+			result = append(result, bucket)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].index < result[j].index })
+	return result
+}
+
+// placeEntries 将已排序的条目放入第一个有空间的分片，没有空间时新建分片
+// first_octet策略下同一首字节的条目优先放入已有该首字节条目的分片，其次放入能整体容纳它们的分片
+func placeEntries(strategy string, buckets []*shardBucket, entries []prefixEntry, maxEntries int) []*shardBucket {
+	for i := 0; i < len(entries); {
+		j := i + 1
+		if strategy == "first_octet" {
+			for j < len(entries) && firstOctet(entries[j]) == firstOctet(entries[i]) {
+				j++
+			}
+		}
+		run := entries[i:j]
+		i = j
+
+		if strategy == "first_octet" {
+			for _, bucket := range buckets {
+				if len(run) > 0 && bucket.hasOctet(firstOctet(run[0])) {
+					n := min(maxEntries-len(bucket.entries), len(run))
+					bucket.entries = append(bucket.entries, run[:n]...)
+					run = run[n:]
+				}
+			}
+		}
+
+		// 单个首字节的条目超过上限时拆分到多个分片
+		for len(run) > 0 {
+			need := min(len(run), maxEntries)
+			var target *shardBucket
+			for _, bucket := range buckets {
+				if maxEntries-len(bucket.entries) >= need {
+					target = bucket
+					break
+				}
+			}
+			if target == nil {
+				target = &shardBucket{index: nextShardIndex(buckets)}
+				buckets = append(buckets, target)
+			}
+			n := min(maxEntries-len(target.entries), len(run))
+			target.entries = append(target.entries, run[:n]...)
+			run = run[n:]
+		}
+	}
+	return buckets
+}
+
+// nextShardIndex 返回未被使用的最小分片序号
+func nextShardIndex(buckets []*shardBucket) int {
+	used := make(map[int]bool, len(buckets))
+	for _, bucket := range buckets {
+		used[bucket.index] = true
+	}
+	index := 1
+	for used[index] {
+		index++
+	}
+	return index
+}
+
+// hasOctet 判断分片中是否已有指定首字节的条目
+func (b *shardBucket) hasOctet(octet byte) bool {
+	for _, entry := range b.entries {
+		if firstOctet(entry) == octet {
+			return true
+		}
+	}
+	return false
+}
+
+// firstOctet 返回条目地址的首字节
+func firstOctet(entry prefixEntry) byte {
+	return entry.prefix.Addr().AsSlice()[0]
+}
+
+// syncGroupBooks 写入地址组对应的全部地址薄
+// 所有分片写入成功后才删除多余的旧分片，避免出现覆盖空档
+func (s *Scheduler) syncGroupBooks(ctx context.Context, task *models.SyncTask, group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) ([]*models.AddressBookPlan, error) {
+	assignments, err := s.assignBooks(group, sourceIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	// 本工具创建过的分片，只有其中的地址薄可以作为旧分片删除
+	var previous, stale []string
+	if gs := s.state.Group(group.GroupName); gs != nil {
+		previous, stale = gs.Shards, gs.StaleShards
+	}
+	recorded := unionNames(previous, stale)
+	sharded := group.Shard.Strategy != "" && group.Shard.Strategy != "none"
+
+	var plans []*models.AddressBookPlan
+	var names []string
+	for _, assignment := range assignments {
+		err := ctx.Err()
+		if err == nil {
+			var plan *models.AddressBookPlan
+			plan, err = s.syncAddressBook(ctx, task, group, assignment.Spec, assignment.SourceIPs)
+			if err == nil {
+				plans = append(plans, plan)
+				names = append(names, assignment.Spec.GroupName)
+				continue
+			}
+			err = fmt.Errorf("地址薄 %s: %w", assignment.Spec.GroupName, err)
+		}
+		// 已写入的新分片也要记录，以便之后清理
+		if sharded {
+			s.saveShards(group, unionNames(previous, names), stale)
+		}
+		return plans, err
+	}
+
+	if !sharded {
+		// 之前启用过分片时清理遗留的分片地址薄
+		if len(recorded) > 0 {
+			stalePlans, failed := s.removeStaleShards(ctx, group, recorded, nil)
+			plans = append(plans, stalePlans...)
+			s.saveShards(group, nil, failed)
+		}
+		return plans, nil
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := shardIndex(group, names[i])
+		b, _ := shardIndex(group, names[j])
+		return a < b
+	})
+	log.Printf("地址组 %s 使用 %d 个分片: %v", group.GroupName, len(names), names)
+	stalePlans, failed := s.removeStaleShards(ctx, group, recorded, names)
+	plans = append(plans, stalePlans...)
+	s.saveShards(group, names, failed)
+	return plans, nil
+}
+
+// saveShards 保存地址组当前的分片和未能删除的旧分片
+func (s *Scheduler) saveShards(group config.AddressGroup, shards, stale []string) {
+	if err := s.state.Update(group.GroupName, func(gs *state.GroupState) {
+		gs.Shards = shards
+		gs.StaleShards = stale
+	}); err != nil {
+		log.Printf("保存地址组 %s 的分片列表失败: %v", group.GroupName, err)
+	}
+}
+
+// removeStaleShards 删除本工具创建过、已不再使用的旧分片，返回记录已删除条目的计划用于统计和未能删除的分片
+// 只删除状态文件中记录过的分片，不会删除他人创建的同名格式地址薄；被访问控制策略引用的分片无法删除，只记录警告
+func (s *Scheduler) removeStaleShards(ctx context.Context, group config.AddressGroup, recorded, current []string) ([]*models.AddressBookPlan, []string) {
+	keep := make(map[string]bool, len(current))
+	for _, name := range current {
+		keep[name] = true
+	}
+	stale := make(map[string]bool)
+	for _, name := range recorded {
+		if !keep[name] {
+			stale[name] = true
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	var removed []*models.AddressBookPlan
+	var failed []string
+	found := make(map[string]bool)
+	queryFailed := false
+
+	for _, groupType := range []string{"ip", "ipv6"} {
+		books, err := s.firewallClient.ListAddressBooks(ctx, group.GroupName+"-", groupType)
+		if err != nil {
+			log.Printf("查询地址组 %s 的旧分片失败: %v", group.GroupName, err)
+			queryFailed = true
+			continue
+		}
+
+		for _, book := range books {
+			if !stale[book.GroupName] {
+				continue
+			}
+			found[book.GroupName] = true
+			if book.ReferenceCount > 0 {
+				log.Printf("警告: 旧分片 %s 仍被 %d 条访问控制策略引用，暂不删除", book.GroupName, book.ReferenceCount)
+				failed = append(failed, book.GroupName)
+				continue
+			}
+			if err := s.firewallClient.DeleteAddressBook(ctx, book); err != nil {
+				log.Printf("删除旧分片 %s 失败: %v", book.GroupName, err)
+				failed = append(failed, book.GroupName)
+				continue
+			}
+			log.Printf("已删除旧分片 %s（%d 条）", book.GroupName, len(book.AddressList()))
+			removed = append(removed, &models.AddressBookPlan{
+				GroupName: book.GroupName,
+				GroupType: book.GroupType,
+				ToRemove:  book.AddressList(),
+			})
+			if err := s.state.Delete(book.GroupName); err != nil {
+				log.Printf("清理旧分片 %s 的状态失败: %v", book.GroupName, err)
+			}
+		}
+	}
+
+	// 查询失败时保留记录下次重试，已被他人删除的旧分片只清理状态
+	for name := range stale {
+		if found[name] {
+			continue
+		}
+		if queryFailed {
+			failed = append(failed, name)
+			continue
+		}
+		if err := s.state.Delete(name); err != nil {
+			log.Printf("清理旧分片 %s 的状态失败: %v", name, err)
+		}
+	}
+	sort.Strings(failed)
+	return removed, failed
+}
+
+// shardStatus 返回各地址组当前的分片名称
+func (s *Scheduler) shardStatus() map[string][]string {
+	status := make(map[string][]string)
+	for _, group := range s.config.Sync.AddressGroups {
+		if gs := s.state.Group(group.GroupName); gs != nil && len(gs.Shards) > 0 {
+			status[group.GroupName] = gs.Shards
+		}
+	}
+	return status
+}
+
+// prefixEntry 已解析的条目
+type prefixEntry struct {
+	prefix netip.Prefix
+	info   *models.DCDNSourceIPInfo
+}
+
+// sortedEntries 解析、去重并按地址排序，保证分片结果稳定
+func sortedEntries(sourceIPs []*models.DCDNSourceIPInfo) []prefixEntry {
+	seen := make(map[netip.Prefix]bool, len(sourceIPs))
+	var entries []prefixEntry
+	for _, ip := range sourceIPs {
+		prefix, err := ipset.ParsePrefix(ip.IP)
+		if err != nil {
+			log.Printf("警告: 分片时跳过无法解析的条目: %s", ip.IP)
+			continue
+		}
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		entries = append(entries, prefixEntry{prefix: prefix, info: ip})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return ipset.Compare(entries[i].prefix, entries[j].prefix) < 0 })
+	return entries
+}
+
+// filterFamily 只保留指定地址族的条目
+func filterFamily(entries []prefixEntry, ipv6 bool) []prefixEntry {
+	var result []prefixEntry
+	for _, entry := range entries {
+		if entry.prefix.Addr().Is6() == ipv6 {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// infos 提取条目对应的源IP信息
+func infos(entries []prefixEntry) []*models.DCDNSourceIPInfo {
+	result := make([]*models.DCDNSourceIPInfo, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.info)
+	}
+	return result
+}
+
+// pendingIn 返回写入该地址薄的等待移除条目，未分片时即全部等待移除条目
+func pendingIn(pending []models.PendingRemoval, plan *models.AddressBookPlan) []models.PendingRemoval {
+	desired := make(map[string]bool, len(plan.Desired))
+	for _, ip := range plan.Desired {
+		desired[ip] = true
+	}
+
+	var result []models.PendingRemoval
+	for _, entry := range pending {
+		if desired[entry.IP] {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// netChanges 汇总多个分片的新增和移除，条目只是在分片之间移动时不计入
+func netChanges(plans []*models.AddressBookPlan) (added, removed []string) {
+	var allAdded, allRemoved []string
+	for _, plan := range plans {
+		allAdded = append(allAdded, plan.ToAdd...)
+		allRemoved = append(allRemoved, plan.ToRemove...)
+	}
+	return difference(allAdded, allRemoved), difference(allRemoved, allAdded)
+}
+
+// prefixStrings 返回条目的规范CIDR形式
+func prefixStrings(entries []prefixEntry) []string {
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.prefix.String())
+	}
+	return result
+}
+
+// unionNames 返回两个名称列表的并集，保持出现顺序
+func unionNames(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var result []string
+	for _, list := range [][]string{a, b} {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
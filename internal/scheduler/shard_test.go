@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// sourceInfos 将地址列表转换为源IP信息
+func sourceInfos(ips ...string) []*models.DCDNSourceIPInfo {
+	result := make([]*models.DCDNSourceIPInfo, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, &models.DCDNSourceIPInfo{IP: ip, Origin: "dcdn_l2"})
+	}
+	return result
+}
+
+func TestAssignBooksIPVersion(t *testing.T) {
+	group := config.AddressGroup{
+		GroupName: "dcdn",
+		IPType:    "both",
+		Shard:     config.ShardConfig{Strategy: "ip_version", MaxEntries: 10},
+	}
+
+	type book struct {
+		name, groupType string
+		entries         []string
+	}
+	tests := []struct {
+		name string
+		ips  []string
+		want []book
+	}{
+		{
+			name: "两个地址族各一个分片",
+			ips:  []string{"2001:db8::/64", "10.0.0.0/24", "192.0.2.1"},
+			want: []book{
+				{"dcdn-1", "ip", []string{"10.0.0.0/24", "192.0.2.1"}},
+				{"dcdn-2", "ipv6", []string{"2001:db8::/64"}},
+			},
+		},
+		{
+			name: "没有IPv6条目时不创建IPv6分片",
+			ips:  []string{"10.0.0.0/24"},
+			want: []book{{"dcdn-1", "ip", []string{"10.0.0.0/24"}}},
+		},
+		{
+			name: "没有IPv4条目时不创建IPv4分片",
+			ips:  []string{"2001:db8::/64"},
+			want: []book{{"dcdn-2", "ipv6", []string{"2001:db8::/64"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestScheduler(t, &config.Config{})
+			s.firewallClient = &client.FirewallClient{}
+
+			assignments, err := s.assignBooks(group, sourceInfos(tt.ips...))
+			if err != nil {
+				t.Fatalf("assignBooks 返回错误: %v", err)
+			}
+			var got []book
+			for _, assignment := range assignments {
+				var entries []string
+				for _, ip := range assignment.SourceIPs {
+					entries = append(entries, ip.IP)
+				}
+				got = append(got, book{assignment.Spec.GroupName, assignment.Spec.GroupType, entries})
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b book) bool {
+				return a.name == b.name && a.groupType == b.groupType && slices.Equal(a.entries, b.entries)
+			}) {
+				t.Errorf("assignBooks = %v, 期望 %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/internal/sink"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// syncSinks 将地址组的期望列表写入引用它的全部写入目标
+// 各写入目标互不影响，返回第一个失败的错误
+func (s *Scheduler) syncSinks(ctx context.Context, task *models.SyncTask, group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) error {
+	sinks := s.config.SinksFor(group.GroupName)
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	// 空列表会清空白名单或拒绝全部回源请求，多半是数据源异常，所有写入目标都不写入
+	desired := desiredEntries(group, sourceIPs)
+	if len(desired) == 0 {
+		err := fmt.Errorf("地址组 %s 的期望列表为空，为避免清空写入目标本次不写入", group.GroupName)
+		for _, cfg := range sinks {
+			task.SinkResults = append(task.SinkResults, models.SinkResult{
+				Sink: cfg.Name, Type: cfg.Type, Group: cfg.Group, Error: err.Error(),
+			})
+		}
+		log.Printf("警告: %v", err)
+		return err
+	}
+
+	var firstErr error
+	for _, cfg := range sinks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		err := s.syncSink(ctx, task, cfg, desired)
+		if err != nil {
+			log.Printf("写入目标 %s 同步失败 (错误分类: %s): %v", cfg.Name, client.ErrorClassOf(err), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("写入目标 %s: %w", cfg.Name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// syncSink 同步单个写入目标，结果记录到任务中
+// 重试只在这一层进行：可重试的错误按max_retries重新执行整个Sync，写入目标内部不再重试
+func (s *Scheduler) syncSink(ctx context.Context, task *models.SyncTask, cfg config.SinkConfig, desired []string) error {
+	target, err := s.sinkFor(cfg)
+	if err != nil {
+		task.SinkResults = append(task.SinkResults, models.SinkResult{
+			Sink: cfg.Name, Type: cfg.Type, Group: cfg.Group, Error: err.Error(),
+		})
+		return err
+	}
+
+	var results []models.SinkResult
+	err = s.withRetry(ctx, "写入 "+cfg.Name, func() error {
+		var syncErr error
+		results, syncErr = target.Sync(ctx, desired)
+		return syncErr
+	})
+	if len(results) == 0 && err != nil {
+		results = []models.SinkResult{{Sink: cfg.Name, Type: cfg.Type, Group: cfg.Group, Error: err.Error()}}
+	}
+	task.SinkResults = append(task.SinkResults, results...)
+
+	if err == nil {
+		log.Printf("写入目标 %s 同步完成（期望条目 %d）", cfg.Name, len(desired))
+	}
+	return err
+}
+
+// sinkFor 返回写入目标实例，首次使用时创建并缓存
+func (s *Scheduler) sinkFor(cfg config.SinkConfig) (sink.Sink, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if target, ok := s.sinks[cfg.Name]; ok {
+		return target, nil
+	}
+	target, err := sink.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.sinks[cfg.Name] = target
+	return target, nil
+}
+
+// desiredEntries 按地址组的ip_type选择地址族，返回去重、排序后的规范CIDR
+func desiredEntries(group config.AddressGroup, sourceIPs []*models.DCDNSourceIPInfo) []string {
+	set := &ipset.Set{}
+	for _, ip := range sourceIPs {
+		prefix, err := ipset.ParsePrefix(ip.IP)
+		if err != nil {
+			continue
+		}
+		switch group.IPType {
+		case "both":
+		case "ipv6":
+			if prefix.Addr().Is4() {
+				continue
+			}
+		default:
+			if prefix.Addr().Is6() {
+				continue
+			}
+		}
+		set.Add(prefix)
+	}
+	return set.Strings()
+}
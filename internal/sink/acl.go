@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// aclBackend CLB和ALB访问控制列表的公共操作，批量上限由各客户端处理
+type aclBackend interface {
+	// ListACLEntries 返回全部条目和ACL限定的地址族（ipv4、ipv6，为空表示不限）
+	ListACLEntries(ctx context.Context, aclID string) ([]models.ACLEntry, string, error)
+	AddACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error
+	RemoveACLEntries(ctx context.Context, aclID string, entries []models.ACLEntry) error
+}
+
+// ACLSink 将期望列表写入负载均衡访问控制列表，增量添加和删除条目
+type ACLSink struct {
+	config  config.SinkConfig
+	backend aclBackend
+}
+
+// NewACLSink 创建CLB或ALB访问控制列表写入目标
+func NewACLSink(cfg config.SinkConfig) (*ACLSink, error) {
+	var backend aclBackend
+	var err error
+	if cfg.Type == "alb_acl" {
+		backend, err = client.NewALBClient(&cfg.AliyunConfig)
+	} else {
+		backend, err = client.NewSLBClient(&cfg.AliyunConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ACLSink{config: cfg, backend: backend}, nil
+}
+
+// Name 返回写入目标名称
+func (s *ACLSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐个同步配置的ACL，某个ACL失败时继续处理其他ACL
+func (s *ACLSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	return syncEach("ACL", s.config.ACL.ACLIDs, func(id string) (models.SinkResult, error) {
+		return s.syncACL(ctx, id, desired)
+	})
+}
+
+// syncACL 同步单个ACL
+func (s *ACLSink) syncACL(ctx context.Context, id string, desired []string) (models.SinkResult, error) {
+	res := result(s.config, id)
+	acl := s.config.ACL
+
+	existing, family, err := s.backend.ListACLEntries(ctx, id)
+	if err != nil {
+		return res, err
+	}
+
+	// CLB的ACL限定地址族，只写入与之匹配的条目
+	var wanted []string
+	for _, cidr := range desired {
+		isIPv6 := strings.Contains(cidr, ":")
+		if (family == "ipv4" && isIPv6) || (family == "ipv6" && !isIPv6) {
+			continue
+		}
+		wanted = append(wanted, cidr)
+	}
+	if len(wanted) == 0 {
+		return res, fmt.Errorf("期望列表中没有与ACL地址族 %s 匹配的条目，本次不写入", family)
+	}
+
+	current := make([]string, 0, len(existing))
+	byEntry := make(map[string]models.ACLEntry, len(existing))
+	for _, entry := range existing {
+		current = append(current, entry.Entry)
+		byEntry[entry.Entry] = entry
+	}
+	added, removed := diffEntries(current, wanted)
+
+	toAdd := make([]models.ACLEntry, 0, len(added))
+	for _, cidr := range added {
+		toAdd = append(toAdd, models.ACLEntry{Entry: cidr, Description: acl.Marker})
+	}
+	// managed模式下只删除备注为marker的条目；已存在但备注不同的期望条目保持原样，不会被接管
+	var toRemove []models.ACLEntry
+	for _, cidr := range removed {
+		entry := byEntry[cidr]
+		if acl.Ownership == "managed" && entry.Description != acl.Marker {
+			continue
+		}
+		toRemove = append(toRemove, entry)
+	}
+
+	if len(toAdd) == 0 && len(toRemove) == 0 {
+		log.Printf("写入目标 %s: ACL %s 无需更新（条目 %d）", s.config.Name, id, len(existing))
+		return res, nil
+	}
+
+	err = applyAddFirst("条目", len(existing), len(toAdd), len(toRemove), acl.MaxEntries,
+		func() error {
+			if err := s.backend.AddACLEntries(ctx, id, toAdd); err != nil {
+				return err
+			}
+			res.Added = aclEntries(toAdd)
+			return nil
+		},
+		func() error {
+			if err := s.backend.RemoveACLEntries(ctx, id, toRemove); err != nil {
+				return err
+			}
+			res.Removed = aclEntries(toRemove)
+			return nil
+		})
+	if err != nil {
+		return res, err
+	}
+
+	log.Printf("写入目标 %s: ACL %s 新增 %d 条，移除 %d 条", s.config.Name, id, len(toAdd), len(toRemove))
+	return res, nil
+}
+
+// aclEntries 返回条目的地址列表
+func aclEntries(entries []models.ACLEntry) []string {
+	list := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry.Entry)
+	}
+	return list
+}
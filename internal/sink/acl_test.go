@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+const aclMarker = "dcdn-firewall-sync"
+
+// fakeACL 内存中的访问控制列表，按调用顺序记录写入操作，与真实客户端一样空列表不调用API
+type fakeACL struct {
+	entries []models.ACLEntry
+	family  string
+	ops     []string
+}
+
+func (f *fakeACL) ListACLEntries(_ context.Context, _ string) ([]models.ACLEntry, string, error) {
+	return slices.Clone(f.entries), f.family, nil
+}
+
+func (f *fakeACL) AddACLEntries(_ context.Context, _ string, entries []models.ACLEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	f.entries = append(f.entries, entries...)
+	f.ops = append(f.ops, "add "+strings.Join(aclEntries(entries), ","))
+	return nil
+}
+
+func (f *fakeACL) RemoveACLEntries(_ context.Context, _ string, entries []models.ACLEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, entry := range entries {
+		f.entries = slices.DeleteFunc(f.entries, func(e models.ACLEntry) bool { return e.Entry == entry.Entry })
+	}
+	f.ops = append(f.ops, "remove "+strings.Join(aclEntries(entries), ","))
+	return nil
+}
+
+func TestACLSync(t *testing.T) {
+	tests := []struct {
+		name      string
+		ownership string
+		family    string
+		existing  []models.ACLEntry
+		desired   []string
+		maxEntry  int
+		wantOps   []string
+		wantAfter []string
+		wantErr   string
+	}{
+		{
+			name:      "managed模式只删除备注为marker的条目",
+			ownership: "managed",
+			existing: []models.ACLEntry{
+				{Entry: "10.0.0.0/24", Description: aclMarker},
+				{Entry: "10.9.0.0/24", Description: aclMarker},
+				{Entry: "192.0.2.1/32", Description: "office"},
+				{Entry: "10.0.1.0/24", Description: "office"},
+			},
+			desired:   []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24"},
+			wantOps:   []string{"add 10.0.2.0/24", "remove 10.9.0.0/24"},
+			wantAfter: []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "192.0.2.1/32"},
+		},
+		{
+			name:      "full模式删除不在期望列表中的所有条目",
+			ownership: "full",
+			existing: []models.ACLEntry{
+				{Entry: "10.0.0.0/24", Description: aclMarker},
+				{Entry: "192.0.2.1/32", Description: "office"},
+			},
+			desired:   []string{"10.0.0.0/24"},
+			wantOps:   []string{"remove 192.0.2.1/32"},
+			wantAfter: []string{"10.0.0.0/24"},
+		},
+		{
+			name:      "只写入与ACL地址族匹配的条目",
+			ownership: "managed",
+			family:    "ipv4",
+			desired:   []string{"10.0.0.0/24", "2001:db8::/32"},
+			wantOps:   []string{"add 10.0.0.0/24"},
+			wantAfter: []string{"10.0.0.0/24"},
+		},
+		{
+			name:      "没有匹配地址族的条目时不写入",
+			ownership: "managed",
+			family:    "ipv6",
+			existing:  []models.ACLEntry{{Entry: "2001:db8::/32", Description: aclMarker}},
+			desired:   []string{"10.0.0.0/24"},
+			wantAfter: []string{"2001:db8::/32"},
+			wantErr:   "没有与ACL地址族 ipv6 匹配的条目",
+		},
+		{
+			name:      "写入后超过配额时不写入",
+			ownership: "managed",
+			existing:  []models.ACLEntry{{Entry: "192.0.2.1/32", Description: "office"}},
+			desired:   []string{"10.0.0.0/24", "10.0.1.0/24"},
+			maxEntry:  2,
+			wantAfter: []string{"192.0.2.1/32"},
+			wantErr:   "写入后条目数 3 超过配额 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeACL{entries: tt.existing, family: tt.family}
+			s := &ACLSink{
+				config: config.SinkConfig{
+					Name: "clb",
+					Type: "slb_acl",
+					ACL:  config.ACLSinkConfig{ACLIDs: []string{"acl-1"}, Ownership: tt.ownership, Marker: aclMarker, MaxEntries: tt.maxEntry},
+				},
+				backend: backend,
+			}
+			_, err := s.Sync(context.Background(), tt.desired)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Sync 返回 %v，期望包含 %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Sync 返回错误: %v", err)
+			}
+			if !slices.Equal(backend.ops, tt.wantOps) {
+				t.Errorf("写入操作为 %q，期望 %q", backend.ops, tt.wantOps)
+			}
+			after := aclEntries(backend.entries)
+			slices.Sort(after)
+			if !slices.Equal(after, tt.wantAfter) {
+				t.Errorf("写入后的条目为 %v，期望 %v", after, tt.wantAfter)
+			}
+			for _, entry := range backend.entries {
+				if slices.Contains(tt.desired, entry.Entry) && !slices.ContainsFunc(tt.existing, func(e models.ACLEntry) bool { return e.Entry == entry.Entry }) && entry.Description != aclMarker {
+					t.Errorf("新增的条目 %s 备注为 %q，期望 %q", entry.Entry, entry.Description, aclMarker)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// dbWhitelistBackend 数据库白名单的公共操作，RDS、PolarDB和Redis共用
+type dbWhitelistBackend interface {
+	ListWhitelistGroups(ctx context.Context, instanceID string) ([]models.WhitelistGroup, error)
+	SetWhitelistGroup(ctx context.Context, instanceID, name string, entries []string) error
+}
+
+// DBWhitelistSink 将期望列表覆盖写入数据库实例的指定白名单分组
+type DBWhitelistSink struct {
+	config  config.SinkConfig
+	backend dbWhitelistBackend
+}
+
+// NewDBWhitelistSink 创建RDS、PolarDB或Redis白名单写入目标
+func NewDBWhitelistSink(cfg config.SinkConfig) (*DBWhitelistSink, error) {
+	backend, err := client.NewDBWhitelistClient(cfg.Type, &cfg.AliyunConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &DBWhitelistSink{config: cfg, backend: backend}, nil
+}
+
+// Name 返回写入目标名称
+func (s *DBWhitelistSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐个同步配置的实例，某个实例失败时继续处理其他实例
+func (s *DBWhitelistSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	return syncEach("实例", s.config.DBWhitelist.InstanceIDs, func(id string) (models.SinkResult, error) {
+		return s.syncInstance(ctx, id, desired)
+	})
+}
+
+// syncInstance 同步单个实例的白名单分组
+func (s *DBWhitelistSink) syncInstance(ctx context.Context, id string, desired []string) (models.SinkResult, error) {
+	db := s.config.DBWhitelist
+	res := result(s.config, id+"/"+db.GroupName)
+
+	groups, err := s.backend.ListWhitelistGroups(ctx, id)
+	if err != nil {
+		return res, err
+	}
+
+	// 统计实例所有分组的条目数，只读取目标分组的内容，其他分组不做任何修改
+	var existing []string
+	total := 0
+	for _, group := range groups {
+		total += len(group.Entries)
+		if group.Name != db.GroupName {
+			continue
+		}
+		if group.Attribute == "hidden" {
+			return res, fmt.Errorf("白名单分组 %s 是系统隐藏分组，不能写入", db.GroupName)
+		}
+		existing = group.Entries
+	}
+
+	added, removed := diffEntries(existing, desired)
+	if len(added) == 0 && len(removed) == 0 {
+		log.Printf("写入目标 %s: 实例 %s 白名单分组 %s 无需更新（条目 %d）", s.config.Name, id, db.GroupName, len(existing))
+		return res, nil
+	}
+
+	after := total - len(existing) + len(desired)
+	if db.MaxEntries > 0 && after > db.MaxEntries {
+		return res, fmt.Errorf("写入后实例白名单条目总数 %d 超过上限 %d（其他分组 %d，本分组 %d），本次不写入",
+			after, db.MaxEntries, total-len(existing), len(desired))
+	}
+
+	log.Printf("写入目标 %s: 实例 %s 白名单分组 %s 新增 %d 条，移除 %d 条", s.config.Name, id, db.GroupName, len(added), len(removed))
+
+	// 覆盖模式一次写入整个分组，新增和移除同时生效
+	if err := s.backend.SetWhitelistGroup(ctx, id, db.GroupName, desired); err != nil {
+		return res, err
+	}
+	res.Added, res.Removed = added, removed
+	return res, nil
+}
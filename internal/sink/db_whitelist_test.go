@@ -0,0 +1,153 @@
+package sink
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// fakeDBWhitelist 内存中各实例的白名单分组，记录覆盖写入的分组
+type fakeDBWhitelist struct {
+	groups map[string][]models.WhitelistGroup
+	writes []string
+}
+
+func (f *fakeDBWhitelist) ListWhitelistGroups(_ context.Context, instanceID string) ([]models.WhitelistGroup, error) {
+	return slices.Clone(f.groups[instanceID]), nil
+}
+
+func (f *fakeDBWhitelist) SetWhitelistGroup(_ context.Context, instanceID, name string, entries []string) error {
+	f.writes = append(f.writes, instanceID+"/"+name)
+	groups := f.groups[instanceID]
+	for i := range groups {
+		if groups[i].Name == name {
+			groups[i].Entries = slices.Clone(entries)
+			return nil
+		}
+	}
+	f.groups[instanceID] = append(groups, models.WhitelistGroup{Name: name, Entries: slices.Clone(entries)})
+	return nil
+}
+
+// whitelistEntries 返回实例中指定分组的条目
+func (f *fakeDBWhitelist) whitelistEntries(instanceID, name string) []string {
+	for _, group := range f.groups[instanceID] {
+		if group.Name == name {
+			return group.Entries
+		}
+	}
+	return nil
+}
+
+func TestDBWhitelistSync(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      []models.WhitelistGroup
+		desired     []string
+		maxEntries  int
+		wantWrite   bool
+		wantAdded   []string
+		wantRemoved []string
+		wantErr     string
+	}{
+		{
+			name:      "分组不存在时创建",
+			groups:    []models.WhitelistGroup{{Name: "default", Entries: []string{"127.0.0.1/32"}}},
+			desired:   []string{"10.0.0.0/24"},
+			wantWrite: true,
+			wantAdded: []string{"10.0.0.0/24"},
+		},
+		{
+			name: "覆盖写入目标分组，新增和移除同时生效",
+			groups: []models.WhitelistGroup{
+				{Name: "default", Entries: []string{"127.0.0.1/32"}},
+				{Name: "dcdn_firewall_sync", Entries: []string{"10.0.0.0/24", "10.9.0.0/24"}},
+			},
+			desired:     []string{"10.0.0.0/24", "10.0.1.0/24"},
+			wantWrite:   true,
+			wantAdded:   []string{"10.0.1.0/24"},
+			wantRemoved: []string{"10.9.0.0/24"},
+		},
+		{
+			name:    "内容一致时不写入",
+			groups:  []models.WhitelistGroup{{Name: "dcdn_firewall_sync", Entries: []string{"10.0.1.0/24", "10.0.0.0/24"}}},
+			desired: []string{"10.0.0.0/24", "10.0.1.0/24"},
+		},
+		{
+			name: "上限按实例所有分组的条目总数计算",
+			groups: []models.WhitelistGroup{
+				{Name: "default", Entries: []string{"127.0.0.1/32", "192.0.2.0/24"}},
+				{Name: "dcdn_firewall_sync", Entries: []string{"10.0.0.0/24"}},
+			},
+			desired:    []string{"10.0.0.0/24", "10.0.1.0/24"},
+			maxEntries: 3,
+			wantErr:    "条目总数 4 超过上限 3（其他分组 2，本分组 2）",
+		},
+		{
+			name:    "不写入系统隐藏分组",
+			groups:  []models.WhitelistGroup{{Name: "dcdn_firewall_sync", Entries: []string{"10.0.0.0/24"}, Attribute: "hidden"}},
+			desired: []string{"10.0.1.0/24"},
+			wantErr: "系统隐藏分组",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeDBWhitelist{groups: map[string][]models.WhitelistGroup{"rm-1": tt.groups}}
+			s := &DBWhitelistSink{
+				config: config.SinkConfig{
+					Name: "rds",
+					Type: "rds_whitelist",
+					DBWhitelist: config.DBWhitelistSinkConfig{
+						InstanceIDs: []string{"rm-1"},
+						GroupName:   "dcdn_firewall_sync",
+						MaxEntries:  tt.maxEntries,
+					},
+				},
+				backend: backend,
+			}
+			others := make(map[string][]string)
+			for _, group := range tt.groups {
+				others[group.Name] = slices.Clone(group.Entries)
+			}
+
+			results, err := s.Sync(context.Background(), tt.desired)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Sync 返回 %v，期望包含 %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("Sync 返回错误: %v", err)
+			}
+
+			if wrote := len(backend.writes) > 0; wrote != tt.wantWrite {
+				t.Fatalf("写入分组 %v，期望写入: %v", backend.writes, tt.wantWrite)
+			}
+			if tt.wantWrite {
+				if backend.writes[0] != "rm-1/dcdn_firewall_sync" {
+					t.Errorf("写入了分组 %s", backend.writes[0])
+				}
+				if got := backend.whitelistEntries("rm-1", "dcdn_firewall_sync"); !slices.Equal(got, tt.desired) {
+					t.Errorf("分组内容为 %v，期望 %v", got, tt.desired)
+				}
+			}
+			if res := results[0]; res.Target != "rm-1/dcdn_firewall_sync" ||
+				!slices.Equal(res.Added, tt.wantAdded) || !slices.Equal(res.Removed, tt.wantRemoved) {
+				t.Errorf("结果为 %+v，期望新增 %v 移除 %v", res, tt.wantAdded, tt.wantRemoved)
+			}
+
+			// 其他分组保持不变
+			for name, entries := range others {
+				if name == "dcdn_firewall_sync" {
+					continue
+				}
+				if got := backend.whitelistEntries("rm-1", name); !slices.Equal(got, entries) {
+					t.Errorf("分组 %s 被修改为 %v", name, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// securityGroupBackend ECS安全组规则的操作
+type securityGroupBackend interface {
+	ListSecurityGroupRules(ctx context.Context, securityGroupID string) ([]models.SecurityGroupRule, error)
+	AuthorizeRules(ctx context.Context, securityGroupID string, rules []models.SecurityGroupRule) error
+	RevokeRules(ctx context.Context, securityGroupID string, rules []models.SecurityGroupRule) error
+}
+
+// SecurityGroupSink 将期望列表写入ECS安全组的入方向规则
+// 只增删描述等于marker的规则，其他规则（包括他人手工添加的相同规则）保持不变
+type SecurityGroupSink struct {
+	config  config.SinkConfig
+	backend securityGroupBackend
+}
+
+// NewSecurityGroupSink 创建ECS安全组写入目标
+func NewSecurityGroupSink(cfg config.SinkConfig) (*SecurityGroupSink, error) {
+	ecsClient, err := client.NewECSClient(&cfg.AliyunConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &SecurityGroupSink{config: cfg, backend: ecsClient}, nil
+}
+
+// Name 返回写入目标名称
+func (s *SecurityGroupSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐个同步配置的安全组，某个安全组失败时继续处理其他安全组
+func (s *SecurityGroupSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	rules := s.desiredRules(desired)
+	return syncEach("安全组", s.config.SecurityGroup.SecurityGroupIDs, func(id string) (models.SinkResult, error) {
+		return s.syncGroup(ctx, id, rules)
+	})
+}
+
+// desiredRules 为每个CIDR和端口生成规则
+// 经典网络公网网卡不支持IPv6规则，这类条目被跳过
+func (s *SecurityGroupSink) desiredRules(desired []string) []models.SecurityGroupRule {
+	sg := s.config.SecurityGroup
+	var rules []models.SecurityGroupRule
+	skipped := 0
+	for _, cidr := range desired {
+		if strings.Contains(cidr, ":") && sg.NicType == "internet" {
+			skipped++
+			continue
+		}
+		for _, spec := range sg.Ports {
+			protocol, portRange, _ := config.ParsePortSpec(spec) // 加载配置时已验证
+			rules = append(rules, models.SecurityGroupRule{
+				Direction:   "ingress",
+				Protocol:    protocol,
+				PortRange:   portRange,
+				SourceCIDR:  cidr,
+				Policy:      sg.Policy,
+				Priority:    sg.Priority,
+				NicType:     sg.NicType,
+				Description: sg.Marker,
+			})
+		}
+	}
+	if skipped > 0 {
+		log.Printf("写入目标 %s: nic_type为internet时不支持IPv6，跳过 %d 个IPv6网段", s.config.Name, skipped)
+	}
+	return rules
+}
+
+// syncGroup 同步单个安全组
+func (s *SecurityGroupSink) syncGroup(ctx context.Context, id string, desired []models.SecurityGroupRule) (models.SinkResult, error) {
+	res := result(s.config, id)
+
+	existing, err := s.backend.ListSecurityGroupRules(ctx, id)
+	if err != nil {
+		return res, err
+	}
+
+	// 本工具管理的规则；同一规则重复出现时多余的一条也移除
+	owned := make(map[string]models.SecurityGroupRule)
+	var toRevoke []models.SecurityGroupRule
+	for _, rule := range existing {
+		if rule.Direction != "ingress" || rule.Description != s.config.SecurityGroup.Marker {
+			continue
+		}
+		key := ruleKey(rule)
+		if _, ok := owned[key]; ok {
+			toRevoke = append(toRevoke, rule)
+			continue
+		}
+		owned[key] = rule
+	}
+
+	wanted := make(map[string]bool, len(desired))
+	var toAdd []models.SecurityGroupRule
+	for _, rule := range desired {
+		key := ruleKey(rule)
+		wanted[key] = true
+		if _, ok := owned[key]; !ok {
+			toAdd = append(toAdd, rule)
+		}
+	}
+	for _, rule := range existing {
+		key := ruleKey(rule)
+		if first, ok := owned[key]; ok && first.RuleID == rule.RuleID && !wanted[key] {
+			toRevoke = append(toRevoke, rule)
+		}
+	}
+
+	if len(toAdd) == 0 && len(toRevoke) == 0 {
+		log.Printf("写入目标 %s: 安全组 %s 无需更新（管理的规则 %d 条）", s.config.Name, id, len(owned))
+		return res, nil
+	}
+
+	// 规则配额包含出方向和他人维护的规则
+	err = applyAddFirst("规则", len(existing), len(toAdd), len(toRevoke), s.config.SecurityGroup.MaxRules,
+		func() error {
+			if err := s.backend.AuthorizeRules(ctx, id, toAdd); err != nil {
+				return err
+			}
+			res.Added = describeRules(toAdd)
+			return nil
+		},
+		func() error {
+			if err := s.backend.RevokeRules(ctx, id, toRevoke); err != nil {
+				return err
+			}
+			res.Removed = describeRules(toRevoke)
+			return nil
+		})
+	if err != nil {
+		return res, err
+	}
+
+	log.Printf("写入目标 %s: 安全组 %s 新增 %d 条规则，移除 %d 条规则", s.config.Name, id, len(toAdd), len(toRevoke))
+	return res, nil
+}
+
+// ruleKey 规则的比较键，不包含网卡类型，部分接口版本不返回该字段
+func ruleKey(rule models.SecurityGroupRule) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d", rule.Protocol, rule.PortRange, rule.SourceCIDR, rule.Policy, rule.Priority)
+}
+
+// describeRules 将规则格式化为 "tcp/443/443 1.2.3.0/24" 形式，用于结果和日志
+func describeRules(rules []models.SecurityGroupRule) []string {
+	described := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		described = append(described, fmt.Sprintf("%s/%s %s", rule.Protocol, rule.PortRange, rule.SourceCIDR))
+	}
+	return described
+}
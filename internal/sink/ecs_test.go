@@ -0,0 +1,203 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+const ecsMarker = "managed-by:aliyun-dcdn-firewall-sync/sg"
+
+// fakeSecurityGroups 内存中的安全组规则，按调用顺序记录写入操作，与真实客户端一样空列表不调用API
+type fakeSecurityGroups struct {
+	rules   map[string][]models.SecurityGroupRule
+	listErr map[string]error
+	ops     []string
+	nextID  int
+}
+
+func (f *fakeSecurityGroups) ListSecurityGroupRules(_ context.Context, id string) ([]models.SecurityGroupRule, error) {
+	if err := f.listErr[id]; err != nil {
+		return nil, err
+	}
+	return slices.Clone(f.rules[id]), nil
+}
+
+func (f *fakeSecurityGroups) AuthorizeRules(_ context.Context, id string, rules []models.SecurityGroupRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		f.nextID++
+		rule.RuleID = fmt.Sprintf("sgr-new-%d", f.nextID)
+		f.rules[id] = append(f.rules[id], rule)
+	}
+	f.ops = append(f.ops, "authorize "+id+" "+strings.Join(describeRules(rules), ","))
+	return nil
+}
+
+func (f *fakeSecurityGroups) RevokeRules(_ context.Context, id string, rules []models.SecurityGroupRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		f.rules[id] = slices.DeleteFunc(f.rules[id], func(r models.SecurityGroupRule) bool { return r.RuleID == rule.RuleID })
+	}
+	f.ops = append(f.ops, "revoke "+id+" "+strings.Join(describeRules(rules), ","))
+	return nil
+}
+
+// sgRule 返回tcp/443的入方向放行规则
+func sgRule(id, cidr, description string) models.SecurityGroupRule {
+	return models.SecurityGroupRule{
+		RuleID: id, Direction: "ingress", Protocol: "tcp", PortRange: "443/443", SourceCIDR: cidr,
+		Policy: "accept", Priority: 1, NicType: "intranet", Description: description,
+	}
+}
+
+func newSecurityGroupSink(backend securityGroupBackend, maxRules int, ids ...string) *SecurityGroupSink {
+	return &SecurityGroupSink{
+		config: config.SinkConfig{
+			Name: "sg",
+			Type: "ecs_security_group",
+			SecurityGroup: config.SecurityGroupSinkConfig{
+				SecurityGroupIDs: ids,
+				Ports:            []string{"tcp/443"},
+				Policy:           "accept",
+				Priority:         1,
+				NicType:          "intranet",
+				Marker:           ecsMarker,
+				MaxRules:         maxRules,
+			},
+		},
+		backend: backend,
+	}
+}
+
+func TestSecurityGroupDesiredRules(t *testing.T) {
+	s := newSecurityGroupSink(nil, 0)
+	s.config.SecurityGroup.Ports = []string{"tcp/443", "udp/8000-8100", "icmp"}
+	desired := []string{"10.0.0.0/24", "2001:db8::/32"}
+
+	want := []string{
+		"tcp/443/443 10.0.0.0/24", "udp/8000/8100 10.0.0.0/24", "icmp/-1/-1 10.0.0.0/24",
+		"tcp/443/443 2001:db8::/32", "udp/8000/8100 2001:db8::/32", "icmp/-1/-1 2001:db8::/32",
+	}
+	if got := describeRules(s.desiredRules(desired)); !slices.Equal(got, want) {
+		t.Errorf("intranet规则为 %v，期望 %v", got, want)
+	}
+
+	// 经典网络公网网卡不支持IPv6规则
+	s.config.SecurityGroup.NicType = "internet"
+	if got := describeRules(s.desiredRules(desired)); !slices.Equal(got, want[:3]) {
+		t.Errorf("internet规则为 %v，期望 %v", got, want[:3])
+	}
+}
+
+func TestSecurityGroupSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []models.SecurityGroupRule
+		desired  []string
+		maxRules int
+		wantOps  []string
+		wantErr  string
+	}{
+		{
+			name: "只增删带marker的入方向规则，重复的规则移除多余的一条",
+			existing: []models.SecurityGroupRule{
+				sgRule("sgr-1", "10.0.0.0/24", ecsMarker),
+				sgRule("sgr-2", "10.0.0.0/24", ecsMarker),
+				sgRule("sgr-3", "10.9.0.0/24", ecsMarker),
+				sgRule("sgr-4", "10.0.1.0/24", "手工添加"),
+				sgRule("sgr-5", "10.8.0.0/24", "手工添加"),
+				func() models.SecurityGroupRule {
+					r := sgRule("sgr-6", "10.7.0.0/24", ecsMarker)
+					r.Direction = "egress"
+					return r
+				}(),
+			},
+			desired: []string{"10.0.0.0/24", "10.0.1.0/24"},
+			wantOps: []string{
+				"authorize sg-1 tcp/443/443 10.0.1.0/24",
+				"revoke sg-1 tcp/443/443 10.0.0.0/24,tcp/443/443 10.9.0.0/24",
+			},
+		},
+		{
+			name:     "规则已一致时不写入",
+			existing: []models.SecurityGroupRule{sgRule("sgr-1", "10.0.0.0/24", ecsMarker)},
+			desired:  []string{"10.0.0.0/24"},
+		},
+		{
+			name:     "写入后超过配额时不写入",
+			existing: []models.SecurityGroupRule{sgRule("sgr-1", "10.0.0.0/24", "手工添加"), sgRule("sgr-2", "10.0.1.0/24", ecsMarker)},
+			desired:  []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"},
+			maxRules: 3,
+			wantErr:  "写入后规则数 4 超过配额 3",
+		},
+		{
+			name:     "先添加会暂时超过配额时先移除",
+			existing: []models.SecurityGroupRule{sgRule("sgr-1", "10.0.0.0/24", "手工添加"), sgRule("sgr-2", "10.0.1.0/24", ecsMarker)},
+			desired:  []string{"10.0.2.0/24"},
+			maxRules: 2,
+			wantOps: []string{
+				"revoke sg-1 tcp/443/443 10.0.1.0/24",
+				"authorize sg-1 tcp/443/443 10.0.2.0/24",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeSecurityGroups{rules: map[string][]models.SecurityGroupRule{"sg-1": tt.existing}}
+			s := newSecurityGroupSink(backend, tt.maxRules, "sg-1")
+			results, err := s.Sync(context.Background(), tt.desired)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Sync 返回 %v，期望包含 %q", err, tt.wantErr)
+				}
+				if results[0].Error == "" {
+					t.Error("失败的安全组应在结果中记录错误")
+				}
+			} else if err != nil {
+				t.Fatalf("Sync 返回错误: %v", err)
+			}
+			if !slices.Equal(backend.ops, tt.wantOps) {
+				t.Errorf("写入操作为 %q，期望 %q", backend.ops, tt.wantOps)
+			}
+
+			// 他人维护的规则始终保留
+			for _, rule := range tt.existing {
+				if rule.Description == ecsMarker && rule.Direction == "ingress" {
+					continue
+				}
+				if !slices.ContainsFunc(backend.rules["sg-1"], func(r models.SecurityGroupRule) bool { return r.RuleID == rule.RuleID }) {
+					t.Errorf("他人维护的规则 %s 被移除", rule.RuleID)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityGroupSyncContinuesAfterFailure(t *testing.T) {
+	backend := &fakeSecurityGroups{
+		rules:   map[string][]models.SecurityGroupRule{},
+		listErr: map[string]error{"sg-1": errors.New("InvalidSecurityGroupId.NotFound")},
+	}
+	s := newSecurityGroupSink(backend, 0, "sg-1", "sg-2")
+	results, err := s.Sync(context.Background(), []string{"10.0.0.0/24"})
+	if err == nil || !strings.Contains(err.Error(), "安全组 sg-1: InvalidSecurityGroupId.NotFound") {
+		t.Fatalf("Sync 返回 %v，期望包含失败的安全组", err)
+	}
+	if len(results) != 2 || results[0].Error == "" || results[1].Error != "" {
+		t.Fatalf("结果为 %+v，期望sg-1失败、sg-2成功", results)
+	}
+	if !slices.Equal(results[1].Added, []string{"tcp/443/443 10.0.0.0/24"}) {
+		t.Errorf("sg-2 新增 %v", results[1].Added)
+	}
+}
@@ -0,0 +1,270 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// generatedHeader 生成文件的说明注释
+const generatedHeader = "# 由 aliyun-dcdn-firewall-sync 生成（写入目标 %s），请勿手动修改\n"
+
+// ipsetMaxElem ipset集合的容量，固定取值使 create -exist 与已存在集合的参数一致
+const ipsetMaxElem = 262144
+
+// hostFirewallEntry 从规则文件中解析已写入条目的正则，与各渲染函数的输出格式对应
+var hostFirewallEntry = map[string]*regexp.Regexp{
+	"nftables": regexp.MustCompile(`^add element inet \S+ \S+ \{ (\S+) \}$`),
+	"ipset":    regexp.MustCompile(`^add \S+ (\S+)$`),
+	"iptables": regexp.MustCompile(`^-A \S+ -s (\S+) -j ACCEPT$`),
+}
+
+// ruleFile 渲染出的规则文件和应用它的命令
+type ruleFile struct {
+	path    string
+	content []byte
+	command []string // 为空表示只写文件
+	stdin   bool     // 通过标准输入传递文件内容（ipset restore）
+}
+
+// HostFirewallSink 将期望列表写入本机nftables集合、ipset集合或iptables链
+// 规则文件整体渲染后由对应命令在一次操作中应用，命令不存在时只写规则文件
+type HostFirewallSink struct {
+	config config.SinkConfig
+	// applied 本进程是否已成功应用过当前规则文件，重启或重启主机后首次同步会重新应用
+	// 有命令因不存在而未执行时保持false，之后每次同步都会重新尝试
+	applied bool
+}
+
+// NewHostFirewallSink 创建本机防火墙写入目标
+func NewHostFirewallSink(cfg config.SinkConfig) (*HostFirewallSink, error) {
+	return &HostFirewallSink{config: cfg}, nil
+}
+
+// Name 返回写入目标名称
+func (s *HostFirewallSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 渲染规则文件，内容变化或本进程尚未应用时执行命令
+func (s *HostFirewallSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	host := s.config.HostFirewall
+	files := s.render(desired)
+	res := result(s.config, files[0].path)
+
+	if host.Backend == "ipset" {
+		if v4, v6 := splitFamilies(desired); max(len(v4), len(v6)) > ipsetMaxElem {
+			return []models.SinkResult{res}, fmt.Errorf("条目数超过ipset集合容量 %d，本次不写入", ipsetMaxElem)
+		}
+	}
+
+	// 与上次写入的规则文件比较，计算差异并判断是否需要重新应用
+	var previous []string
+	changed := false
+	for _, file := range files {
+		old, err := os.ReadFile(file.path)
+		if err != nil && !os.IsNotExist(err) {
+			return []models.SinkResult{res}, err
+		}
+		if !bytes.Equal(old, file.content) {
+			changed = true
+		}
+		previous = append(previous, parseRuleEntries(host.Backend, old)...)
+	}
+	added, removed := diffEntries(previous, desired)
+
+	if !changed && s.applied {
+		log.Printf("写入目标 %s: 本机%s规则无需更新（条目 %d）", s.config.Name, host.Backend, len(desired))
+		return []models.SinkResult{res}, nil
+	}
+
+	if err := os.MkdirAll(host.OutputDir, 0755); err != nil {
+		return []models.SinkResult{res}, err
+	}
+	for _, file := range files {
+		if err := writeFileAtomic(file.path, file.content); err != nil {
+			return []models.SinkResult{res}, fmt.Errorf("写入规则文件 %s 失败: %w", file.path, err)
+		}
+	}
+	log.Printf("写入目标 %s: 本机%s规则新增 %d 条，移除 %d 条", s.config.Name, host.Backend, len(added), len(removed))
+
+	s.applied = false
+	complete, err := s.apply(ctx, files)
+	if err != nil {
+		res.Error = err.Error()
+		return []models.SinkResult{res}, err
+	}
+	s.applied = complete
+
+	res.Added, res.Removed = added, removed
+	return []models.SinkResult{res}, nil
+}
+
+// apply 逐个执行规则文件对应的命令，file_only或命令不存在时只保留规则文件
+// 返回的complete表示不再有需要执行的命令：file_only时为true，有命令因不存在而未执行时为false
+func (s *HostFirewallSink) apply(ctx context.Context, files []ruleFile) (complete bool, err error) {
+	if s.config.HostFirewall.FileOnly {
+		log.Printf("写入目标 %s: 已设置file_only，只写入规则文件", s.config.Name)
+		return true, nil
+	}
+
+	complete = true
+	var errs []error
+	for _, file := range files {
+		path, err := exec.LookPath(file.command[0])
+		if err != nil {
+			log.Printf("写入目标 %s: 未找到命令 %s，只写入规则文件 %s", s.config.Name, file.command[0], file.path)
+			complete = false
+			continue
+		}
+
+		cmd := exec.CommandContext(ctx, path, file.command[1:]...)
+		if file.stdin {
+			cmd.Stdin = bytes.NewReader(file.content)
+		}
+		if output, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("执行 %s 失败: %v: %s", strings.Join(file.command, " "), err, strings.TrimSpace(string(output))))
+		}
+	}
+	return complete, errors.Join(errs...)
+}
+
+// render 按配置的后端渲染规则文件
+func (s *HostFirewallSink) render(desired []string) []ruleFile {
+	host := s.config.HostFirewall
+	base := filepath.Join(host.OutputDir, s.config.Name)
+	v4, v6 := splitFamilies(desired)
+
+	switch host.Backend {
+	case "ipset":
+		path := base + ".ipset"
+		return []ruleFile{{
+			path:    path,
+			content: renderIPSet(host.Name, v4, v6),
+			command: []string{"ipset", "restore"},
+			stdin:   true,
+		}}
+	case "iptables":
+		header := fmt.Sprintf(generatedHeader, s.config.Name)
+		return []ruleFile{
+			{
+				path:    base + ".v4.rules",
+				content: renderIPTables(header, host.Name, v4),
+				command: []string{"iptables-restore", "--noflush", base + ".v4.rules"},
+			},
+			{
+				path:    base + ".v6.rules",
+				content: renderIPTables(header, host.Name, v6),
+				command: []string{"ip6tables-restore", "--noflush", base + ".v6.rules"},
+			},
+		}
+	default:
+		path := base + ".nft"
+		return []ruleFile{{
+			path:    path,
+			content: renderNFTables(fmt.Sprintf(generatedHeader, s.config.Name), host.Table, host.Name, v4, v6),
+			command: []string{"nft", "-f", path},
+		}}
+	}
+}
+
+// renderNFTables 渲染nft脚本：声明表和集合（已存在时不变），再在同一事务中清空并填充集合
+func renderNFTables(header, table, name string, v4, v6 []string) []byte {
+	var b strings.Builder
+	b.WriteString(header)
+	fmt.Fprintf(&b, "table inet %s {\n", table)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		fmt.Fprintf(&b, "\tset %s_v%s {\n\t\ttype %s_addr\n\t\tflags interval\n\t\tauto-merge\n\t}\n", name, family[3:], family)
+	}
+	b.WriteString("}\n")
+	for _, set := range []struct {
+		suffix  string
+		entries []string
+	}{{"_v4", v4}, {"_v6", v6}} {
+		fmt.Fprintf(&b, "flush set inet %s %s%s\n", table, name, set.suffix)
+		for _, entry := range set.entries {
+			fmt.Fprintf(&b, "add element inet %s %s%s { %s }\n", table, name, set.suffix, entry)
+		}
+	}
+	return []byte(b.String())
+}
+
+// renderIPSet 渲染ipset restore脚本：填充临时集合后与正式集合交换，交换是原子的
+// ipset restore不支持注释，脚本中不包含说明行
+func renderIPSet(name string, v4, v6 []string) []byte {
+	var b strings.Builder
+	for _, set := range []struct {
+		suffix, family string
+		entries        []string
+	}{{"_v4", "inet", v4}, {"_v6", "inet6", v6}} {
+		target := name + set.suffix
+		tmp := target + "-tmp"
+		fmt.Fprintf(&b, "create %s hash:net family %s maxelem %d -exist\n", target, set.family, ipsetMaxElem)
+		fmt.Fprintf(&b, "create %s hash:net family %s maxelem %d -exist\n", tmp, set.family, ipsetMaxElem)
+		fmt.Fprintf(&b, "flush %s\n", tmp)
+		for _, entry := range set.entries {
+			fmt.Fprintf(&b, "add %s %s\n", tmp, entry)
+		}
+		fmt.Fprintf(&b, "swap %s %s\n", tmp, target)
+		fmt.Fprintf(&b, "destroy %s\n", tmp)
+	}
+	return []byte(b.String())
+}
+
+// renderIPTables 渲染iptables-restore片段：声明并清空自定义链后逐条添加放行规则，COMMIT时整体生效
+// 需要自行在INPUT等链中跳转到该链，如 -A INPUT -p tcp --dport 443 -j DCDN_ORIGIN 后接DROP
+func renderIPTables(header, chain string, entries []string) []byte {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", chain)
+	fmt.Fprintf(&b, "-F %s\n", chain)
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "-A %s -s %s -j ACCEPT\n", chain, entry)
+	}
+	b.WriteString("COMMIT\n")
+	return []byte(b.String())
+}
+
+// parseRuleEntries 从上次写入的规则文件中解析条目
+func parseRuleEntries(backend string, content []byte) []string {
+	pattern := hostFirewallEntry[backend]
+	var entries []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if match := pattern.FindStringSubmatch(line); match != nil {
+			entries = append(entries, match[1])
+		}
+	}
+	return entries
+}
+
+// splitFamilies 按地址族拆分条目
+func splitFamilies(entries []string) (v4, v6 []string) {
+	for _, entry := range entries {
+		if strings.Contains(entry, ":") {
+			v6 = append(v6, entry)
+		} else {
+			v4 = append(v4, entry)
+		}
+	}
+	return v4, v6
+}
+
+// writeFileAtomic 先写临时文件再重命名
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+var update = flag.Bool("update", false, "用当前输出更新testdata中的golden文件")
+
+// hostFirewallDesired 渲染测试使用的期望列表，包含两个地址族
+var hostFirewallDesired = []string{"10.0.0.0/24", "192.0.2.1/32", "2001:db8::/32", "2001:db8:1::1/128"}
+
+func newHostFirewallSink(backend, name, outputDir string, fileOnly bool) *HostFirewallSink {
+	return &HostFirewallSink{config: config.SinkConfig{
+		Name: "edge",
+		Type: "host_firewall",
+		HostFirewall: config.HostFirewallSinkConfig{
+			Backend:   backend,
+			Name:      name,
+			Table:     "dcdn_firewall_sync",
+			OutputDir: outputDir,
+			FileOnly:  fileOnly,
+		},
+	}}
+}
+
+// checkGolden 比较内容与golden文件，-update时改为写入golden文件
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取golden文件失败（可使用 go test -update 生成）: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s 内容不一致\n实际:\n%s\n期望:\n%s", path, got, want)
+	}
+}
+
+func TestHostFirewallRender(t *testing.T) {
+	tests := []struct {
+		backend, name string
+		files         []string
+	}{
+		{"nftables", "dcdn_origin", []string{"edge.nft"}},
+		{"ipset", "dcdn_origin", []string{"edge.ipset"}},
+		{"iptables", "DCDN_ORIGIN", []string{"edge.v4.rules", "edge.v6.rules"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			s := newHostFirewallSink(tt.backend, tt.name, "/var/lib/dcdn", false)
+			files := s.render(hostFirewallDesired)
+			if len(files) != len(tt.files) {
+				t.Fatalf("渲染出 %d 个文件，期望 %d 个", len(files), len(tt.files))
+			}
+
+			var parsed []string
+			for i, file := range files {
+				if file.path != filepath.Join("/var/lib/dcdn", tt.files[i]) {
+					t.Errorf("文件路径为 %s，期望 %s", file.path, tt.files[i])
+				}
+				checkGolden(t, filepath.Join("testdata", "host_firewall", tt.files[i]), file.content)
+				parsed = append(parsed, parseRuleEntries(tt.backend, file.content)...)
+			}
+			if !slices.Equal(parsed, hostFirewallDesired) {
+				t.Errorf("parseRuleEntries = %v, 期望 %v", parsed, hostFirewallDesired)
+			}
+		})
+	}
+}
+
+func TestParseRuleEntries(t *testing.T) {
+	tests := []struct {
+		backend string
+		golden  string
+		want    []string
+	}{
+		{"nftables", "edge.nft", hostFirewallDesired},
+		{"ipset", "edge.ipset", hostFirewallDesired},
+		{"iptables", "edge.v4.rules", hostFirewallDesired[:2]},
+		{"iptables", "edge.v6.rules", hostFirewallDesired[2:]},
+		// 其他后端的文件中不会解析出条目
+		{"nftables", "edge.ipset", nil},
+		{"ipset", "edge.v4.rules", nil},
+		{"iptables", "edge.nft", nil},
+	}
+	for _, tt := range tests {
+		content, err := os.ReadFile(filepath.Join("testdata", "host_firewall", tt.golden))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parseRuleEntries(tt.backend, content); !slices.Equal(got, tt.want) {
+			t.Errorf("parseRuleEntries(%s, %s) = %v, 期望 %v", tt.backend, tt.golden, got, tt.want)
+		}
+	}
+
+	if got := parseRuleEntries("nftables", nil); got != nil {
+		t.Errorf("空文件应解析出空列表: %v", got)
+	}
+}
+
+func TestHostFirewallApplied(t *testing.T) {
+	// 命令不存在时只写规则文件，之后每次同步都重新尝试
+	t.Setenv("PATH", t.TempDir())
+	s := newHostFirewallSink("nftables", "dcdn_origin", t.TempDir(), false)
+	for i := 0; i < 2; i++ {
+		results, err := s.Sync(context.Background(), hostFirewallDesired)
+		if err != nil {
+			t.Fatalf("Sync 返回错误: %v", err)
+		}
+		if s.applied {
+			t.Fatal("命令没有执行时不应记为已应用")
+		}
+		if i == 0 && !slices.Equal(results[0].Added, hostFirewallDesired) {
+			t.Errorf("首次写入的新增条目为 %v", results[0].Added)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.config.HostFirewall.OutputDir, "edge.nft")); err != nil {
+		t.Errorf("规则文件应已写入: %v", err)
+	}
+
+	// file_only时写入规则文件即完成，内容不变时不再写入
+	s = newHostFirewallSink("ipset", "dcdn_origin", t.TempDir(), true)
+	if _, err := s.Sync(context.Background(), hostFirewallDesired); err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if !s.applied {
+		t.Fatal("file_only时写入规则文件后应记为已应用")
+	}
+	results, err := s.Sync(context.Background(), hostFirewallDesired[:3])
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if len(results[0].Added) != 0 || !slices.Equal(results[0].Removed, hostFirewallDesired[3:]) {
+		t.Errorf("第二次同步的差异为 新增 %v 移除 %v", results[0].Added, results[0].Removed)
+	}
+}
@@ -0,0 +1,328 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	metav1ac "k8s.io/client-go/applyconfigurations/meta/v1"
+	networkingv1ac "k8s.io/client-go/applyconfigurations/networking/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// 归属标签和注解，用于识别本工具管理的对象
+const (
+	managedByLabel  = "app.kubernetes.io/managed-by"
+	managedByValue  = "aliyun-dcdn-firewall-sync"
+	sinkLabel       = "aliyun-dcdn-firewall-sync/sink"
+	groupAnnotation = "aliyun-dcdn-firewall-sync/group"
+)
+
+// kubernetesObject 一个写入对象，apply写入期望列表并返回写入前的条目
+type kubernetesObject struct {
+	target string
+	apply  func(ctx context.Context, desired []string) ([]string, error)
+}
+
+// String 返回写入对象名称，用于错误信息
+func (o kubernetesObject) String() string {
+	return o.target
+}
+
+// KubernetesSink 将期望列表写入ConfigMap，并按配置写入托管的NetworkPolicy和Calico GlobalNetworkSet
+// 所有对象通过服务端应用写入，写入前检查已存在对象的归属标签，不改写其他人创建的同名对象
+type KubernetesSink struct {
+	config    config.SinkConfig
+	namespace string
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+}
+
+// NewKubernetesSink 创建Kubernetes写入目标
+func NewKubernetesSink(cfg config.SinkConfig) (*KubernetesSink, error) {
+	restConfig, err := client.LoadKubeConfig(cfg.Kubernetes.Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("创建Kubernetes客户端失败: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("创建Kubernetes动态客户端失败: %v", err)
+	}
+	return NewKubernetesSinkWithClients(cfg, clientset, dynamicClient), nil
+}
+
+// NewKubernetesSinkWithClients 使用已有的客户端创建Kubernetes写入目标
+// 未配置global_network_set时dynamicClient可以为nil；fake动态客户端不支持服务端应用，测试时需通过patch的reactor模拟
+func NewKubernetesSinkWithClients(cfg config.SinkConfig, clientset kubernetes.Interface, dynamicClient dynamic.Interface) *KubernetesSink {
+	return &KubernetesSink{
+		config:    cfg,
+		namespace: client.KubernetesNamespace(cfg.Kubernetes.Namespace),
+		clientset: clientset,
+		dynamic:   dynamicClient,
+	}
+}
+
+// Name 返回写入目标名称
+func (s *KubernetesSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 依次写入ConfigMap、NetworkPolicy和GlobalNetworkSet，某个对象失败时继续处理其他对象
+func (s *KubernetesSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	k8s := s.config.Kubernetes
+	objects := []kubernetesObject{{"configmap/" + s.namespace + "/" + k8s.ConfigMap, s.applyConfigMap}}
+	if k8s.NetworkPolicy != "" {
+		objects = append(objects, kubernetesObject{"networkpolicy/" + s.namespace + "/" + k8s.NetworkPolicy, s.applyNetworkPolicy})
+	}
+	if k8s.GlobalNetworkSet != "" {
+		objects = append(objects, kubernetesObject{"globalnetworkset/" + k8s.GlobalNetworkSet, s.applyGlobalNetworkSet})
+	}
+
+	return syncEach("", objects, func(object kubernetesObject) (models.SinkResult, error) {
+		res := result(s.config, object.target)
+		existing, err := object.apply(ctx, desired)
+		if err != nil {
+			return res, kubernetesError(err)
+		}
+
+		added, removed := diffEntries(existing, desired)
+		if len(added) == 0 && len(removed) == 0 {
+			log.Printf("写入目标 %s: %s 无需更新（条目 %d）", s.config.Name, object.target, len(desired))
+		} else {
+			log.Printf("写入目标 %s: %s 新增 %d 条，移除 %d 条", s.config.Name, object.target, len(added), len(removed))
+		}
+		res.Added, res.Removed = added, removed
+		return res, nil
+	})
+}
+
+// applyConfigMap 写入ConfigMap，返回写入前的条目
+func (s *KubernetesSink) applyConfigMap(ctx context.Context, desired []string) ([]string, error) {
+	k8s := s.config.Kubernetes
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+
+	var existing []string
+	current, err := configMaps.Get(ctx, k8s.ConfigMap, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := s.checkOwner("ConfigMap", current.Name, current.Labels); err != nil {
+			return nil, err
+		}
+		existing = strings.Fields(current.Data[k8s.ConfigMapKey])
+	}
+
+	apply := corev1ac.ConfigMap(k8s.ConfigMap, s.namespace).
+		WithLabels(s.labels()).
+		WithAnnotations(s.annotations()).
+		WithData(map[string]string{k8s.ConfigMapKey: strings.Join(desired, "\n") + "\n"})
+	_, err = configMaps.Apply(ctx, apply, s.applyOptions())
+	return existing, err
+}
+
+// applyNetworkPolicy 写入托管的NetworkPolicy，返回写入前的条目
+// 策略选中的Pod只接受来自期望列表的入方向流量（同时被其他策略选中时取并集）
+func (s *KubernetesSink) applyNetworkPolicy(ctx context.Context, desired []string) ([]string, error) {
+	k8s := s.config.Kubernetes
+	policies := s.clientset.NetworkingV1().NetworkPolicies(s.namespace)
+
+	var existing []string
+	current, err := policies.Get(ctx, k8s.NetworkPolicy, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := s.checkOwner("NetworkPolicy", current.Name, current.Labels); err != nil {
+			return nil, err
+		}
+		for _, rule := range current.Spec.Ingress {
+			for _, peer := range rule.From {
+				if peer.IPBlock != nil {
+					existing = append(existing, peer.IPBlock.CIDR)
+				}
+			}
+		}
+	}
+
+	rule := networkingv1ac.NetworkPolicyIngressRule()
+	for _, cidr := range desired {
+		rule.WithFrom(networkingv1ac.NetworkPolicyPeer().WithIPBlock(networkingv1ac.IPBlock().WithCIDR(cidr)))
+	}
+	for _, spec := range k8s.Ports {
+		port, err := networkPolicyPort(spec)
+		if err != nil {
+			return nil, err
+		}
+		rule.WithPorts(port)
+	}
+
+	apply := networkingv1ac.NetworkPolicy(k8s.NetworkPolicy, s.namespace).
+		WithLabels(s.labels()).
+		WithAnnotations(s.annotations()).
+		WithSpec(networkingv1ac.NetworkPolicySpec().
+			WithPodSelector(metav1ac.LabelSelector().WithMatchLabels(k8s.PodSelector)).
+			WithPolicyTypes(networkingv1.PolicyTypeIngress).
+			WithIngress(rule))
+	_, err = policies.Apply(ctx, apply, s.applyOptions())
+	return existing, err
+}
+
+// applyGlobalNetworkSet 写入Calico GlobalNetworkSet，返回写入前的条目
+// Calico的类型不在client-go中，通过动态客户端写入
+func (s *KubernetesSink) applyGlobalNetworkSet(ctx context.Context, desired []string) ([]string, error) {
+	k8s := s.config.Kubernetes
+	if s.dynamic == nil {
+		return nil, fmt.Errorf("未提供动态客户端，无法写入GlobalNetworkSet")
+	}
+	gv, err := schema.ParseGroupVersion(k8s.CalicoAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	sets := s.dynamic.Resource(gv.WithResource("globalnetworksets"))
+
+	var existing []string
+	current, err := sets.Get(ctx, k8s.GlobalNetworkSet, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := s.checkOwner("GlobalNetworkSet", current.GetName(), current.GetLabels()); err != nil {
+			return nil, err
+		}
+		existing, _, _ = unstructured.NestedStringSlice(current.Object, "spec", "nets")
+	}
+
+	labels := make(map[string]interface{})
+	for key, value := range s.labels() {
+		labels[key] = value
+	}
+	annotations := make(map[string]interface{})
+	for key, value := range s.annotations() {
+		annotations[key] = value
+	}
+	nets := make([]interface{}, 0, len(desired))
+	for _, cidr := range desired {
+		nets = append(nets, cidr)
+	}
+	apply := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": k8s.CalicoAPIVersion,
+		"kind":       "GlobalNetworkSet",
+		"metadata": map[string]interface{}{
+			"name":        k8s.GlobalNetworkSet,
+			"labels":      labels,
+			"annotations": annotations,
+		},
+		"spec": map[string]interface{}{"nets": nets},
+	}}
+	_, err = sets.Apply(ctx, k8s.GlobalNetworkSet, apply, s.applyOptions())
+	return existing, err
+}
+
+// checkOwner 检查已存在对象的归属标签，不属于本写入目标时拒绝写入
+func (s *KubernetesSink) checkOwner(kind, name string, labels map[string]string) error {
+	if labels[managedByLabel] == managedByValue && labels[sinkLabel] == s.config.Name {
+		return nil
+	}
+	return &client.APIError{Class: client.ErrorClassInvalidParam, Err: fmt.Errorf(
+		"%s %s 已存在但没有本写入目标的归属标签（%s=%s、%s=%s），为避免覆盖他人维护的对象本次不写入",
+		kind, name, managedByLabel, managedByValue, sinkLabel, s.config.Name)}
+}
+
+// labels 返回写入对象的标签，归属标签不能被配置的附加标签覆盖
+func (s *KubernetesSink) labels() map[string]string {
+	labels := make(map[string]string, len(s.config.Kubernetes.Labels)+2)
+	for key, value := range s.config.Kubernetes.Labels {
+		labels[key] = value
+	}
+	labels[managedByLabel] = managedByValue
+	labels[sinkLabel] = s.config.Name
+	return labels
+}
+
+// annotations 返回写入对象的注解，地址组名称可能不是合法的标签值，记录在注解中
+func (s *KubernetesSink) annotations() map[string]string {
+	return map[string]string{groupAnnotation: s.config.Group}
+}
+
+// applyOptions 服务端应用的选项，强制接管冲突字段（对象归属已通过标签确认）
+func (s *KubernetesSink) applyOptions() metav1.ApplyOptions {
+	return metav1.ApplyOptions{FieldManager: s.config.Kubernetes.FieldManager, Force: true}
+}
+
+// networkPolicyPort 将 "tcp/443"、"tcp/8000-8100" 形式的配置转换为NetworkPolicy端口
+func networkPolicyPort(spec string) (*networkingv1ac.NetworkPolicyPortApplyConfiguration, error) {
+	protocol, portRange, err := config.ParsePortSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	from, to, _ := strings.Cut(portRange, "/")
+	low, _ := strconv.Atoi(from)
+	high, _ := strconv.Atoi(to)
+
+	port := networkingv1ac.NetworkPolicyPort().
+		WithProtocol(corev1.Protocol(strings.ToUpper(protocol))).
+		WithPort(intstr.FromInt32(int32(low)))
+	if high > low {
+		port.WithEndPort(int32(high))
+	}
+	return port, nil
+}
+
+// kubernetesError 将Kubernetes API错误包装为带分类的错误，限流、服务端错误、冲突和网络错误可以重试
+func kubernetesError(err error) error {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return err
+	}
+
+	class := client.ErrorClassUnknown
+	var netErr net.Error
+	switch {
+	case apierrors.IsTooManyRequests(err):
+		class = client.ErrorClassThrottled
+	case apierrors.IsConflict(err), apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err):
+		class = client.ErrorClassTransient
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		class = client.ErrorClassAuth
+	case apierrors.IsNotFound(err):
+		// 写入时资源类型不存在，通常是未安装Calico
+		class = client.ErrorClassNotFound
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		class = client.ErrorClassInvalidParam
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		class = client.ErrorClassTransient
+	}
+
+	var status apierrors.APIStatus
+	statusCode := 0
+	if errors.As(err, &status) {
+		statusCode = int(status.Status().Code)
+	}
+	return &client.APIError{Class: class, StatusCode: statusCode, Err: err}
+}
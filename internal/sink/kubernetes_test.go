@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+func newKubernetesTestSink(clientset *fake.Clientset) *KubernetesSink {
+	return NewKubernetesSinkWithClients(config.SinkConfig{
+		Name:  "origin",
+		Type:  "kubernetes",
+		Group: "dcdn-source-ips-v4",
+		Kubernetes: config.KubernetesSinkConfig{
+			Namespace:     "edge",
+			ConfigMap:     "origin-cidrs",
+			ConfigMapKey:  "cidrs",
+			NetworkPolicy: "origin-allow",
+			PodSelector:   map[string]string{"app": "web"},
+			Ports:         []string{"tcp/443", "tcp/8000-8100"},
+			Labels:        map[string]string{"team": "edge", managedByLabel: "someone-else"},
+			FieldManager:  "aliyun-dcdn-firewall-sync",
+		},
+	}, clientset, nil)
+}
+
+// resultFor 按写入对象查找结果
+func resultFor(t *testing.T, results []models.SinkResult, target string) models.SinkResult {
+	t.Helper()
+	for _, res := range results {
+		if res.Target == target {
+			return res
+		}
+	}
+	t.Fatalf("结果中没有 %s: %+v", target, results)
+	return models.SinkResult{}
+}
+
+func TestKubernetesSinkApply(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	s := newKubernetesTestSink(clientset)
+
+	desired := []string{"10.0.0.0/24", "192.0.2.1/32"}
+	results, err := s.Sync(ctx, desired)
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("结果数为 %d，期望 2", len(results))
+	}
+	for _, target := range []string{"configmap/edge/origin-cidrs", "networkpolicy/edge/origin-allow"} {
+		res := resultFor(t, results, target)
+		if !slices.Equal(res.Added, desired) || len(res.Removed) != 0 || res.Error != "" {
+			t.Errorf("%s 首次写入的结果为 %+v", target, res)
+		}
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps("edge").Get(ctx, "origin-cidrs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("读取ConfigMap失败: %v", err)
+	}
+	if got := cm.Data["cidrs"]; got != "10.0.0.0/24\n192.0.2.1/32\n" {
+		t.Errorf("ConfigMap内容为 %q", got)
+	}
+	// 归属标签不能被附加标签覆盖
+	if cm.Labels[managedByLabel] != managedByValue || cm.Labels[sinkLabel] != "origin" || cm.Labels["team"] != "edge" {
+		t.Errorf("ConfigMap标签为 %v", cm.Labels)
+	}
+	if cm.Annotations[groupAnnotation] != "dcdn-source-ips-v4" {
+		t.Errorf("ConfigMap注解为 %v", cm.Annotations)
+	}
+
+	policy, err := clientset.NetworkingV1().NetworkPolicies("edge").Get(ctx, "origin-allow", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("读取NetworkPolicy失败: %v", err)
+	}
+	if policy.Spec.PodSelector.MatchLabels["app"] != "web" ||
+		!slices.Equal(policy.Spec.PolicyTypes, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}) {
+		t.Errorf("NetworkPolicy的选择器或类型不正确: %+v", policy.Spec)
+	}
+	if len(policy.Spec.Ingress) != 1 {
+		t.Fatalf("NetworkPolicy应只有一条入方向规则: %+v", policy.Spec.Ingress)
+	}
+	rule := policy.Spec.Ingress[0]
+	var cidrs []string
+	for _, peer := range rule.From {
+		cidrs = append(cidrs, peer.IPBlock.CIDR)
+	}
+	if !slices.Equal(cidrs, desired) {
+		t.Errorf("NetworkPolicy来源为 %v", cidrs)
+	}
+	if len(rule.Ports) != 2 || rule.Ports[0].Port.IntValue() != 443 || rule.Ports[0].EndPort != nil ||
+		rule.Ports[1].Port.IntValue() != 8000 || rule.Ports[1].EndPort == nil || *rule.Ports[1].EndPort != 8100 ||
+		*rule.Ports[1].Protocol != corev1.ProtocolTCP {
+		t.Errorf("NetworkPolicy端口为 %+v", rule.Ports)
+	}
+
+	// 再次写入时按写入前的内容计算差异
+	results, err = s.Sync(ctx, []string{"10.0.0.0/24", "198.51.100.0/24"})
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	for _, target := range []string{"configmap/edge/origin-cidrs", "networkpolicy/edge/origin-allow"} {
+		res := resultFor(t, results, target)
+		if !slices.Equal(res.Added, []string{"198.51.100.0/24"}) || !slices.Equal(res.Removed, []string{"192.0.2.1/32"}) {
+			t.Errorf("%s 第二次写入的差异为 新增 %v 移除 %v", target, res.Added, res.Removed)
+		}
+	}
+
+	// 内容不变时没有差异
+	results, err = s.Sync(ctx, []string{"10.0.0.0/24", "198.51.100.0/24"})
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	for _, res := range results {
+		if len(res.Added) != 0 || len(res.Removed) != 0 {
+			t.Errorf("%s 内容不变时不应有差异: %+v", res.Target, res)
+		}
+	}
+}
+
+func TestKubernetesSinkRefusesForeignObjects(t *testing.T) {
+	ctx := context.Background()
+	foreign := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "origin-cidrs", Namespace: "edge", Labels: map[string]string{managedByLabel: managedByValue, sinkLabel: "other"}},
+		Data:       map[string]string{"cidrs": "203.0.113.0/24\n"},
+	}
+	clientset := fake.NewClientset(foreign)
+	s := newKubernetesTestSink(clientset)
+
+	results, err := s.Sync(ctx, []string{"10.0.0.0/24"})
+	if err == nil {
+		t.Fatal("ConfigMap属于其他写入目标时应返回错误")
+	}
+	if client.ErrorClassOf(err) != client.ErrorClassInvalidParam || client.IsRetryable(err) {
+		t.Errorf("归属检查失败不应重试: %v", err)
+	}
+
+	res := resultFor(t, results, "configmap/edge/origin-cidrs")
+	if !strings.Contains(res.Error, "归属标签") || len(res.Added) != 0 {
+		t.Errorf("ConfigMap的结果为 %+v", res)
+	}
+	cm, err := clientset.CoreV1().ConfigMaps("edge").Get(ctx, "origin-cidrs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data["cidrs"] != "203.0.113.0/24\n" || cm.Labels[sinkLabel] != "other" {
+		t.Errorf("他人维护的ConfigMap被改写: %+v", cm)
+	}
+
+	// 其他对象继续写入
+	if res := resultFor(t, results, "networkpolicy/edge/origin-allow"); res.Error != "" || !slices.Equal(res.Added, []string{"10.0.0.0/24"}) {
+		t.Errorf("NetworkPolicy的结果为 %+v", res)
+	}
+}
+
+func TestKubernetesSinkGlobalNetworkSetWithoutDynamicClient(t *testing.T) {
+	s := newKubernetesTestSink(fake.NewClientset())
+	s.config.Kubernetes.NetworkPolicy = ""
+	s.config.Kubernetes.GlobalNetworkSet = "dcdn-origin"
+	s.config.Kubernetes.CalicoAPIVersion = "crd.projectcalico.org/v1"
+
+	results, err := s.Sync(context.Background(), []string{"10.0.0.0/24"})
+	if err == nil || !strings.Contains(err.Error(), "globalnetworkset/dcdn-origin") {
+		t.Fatalf("未提供动态客户端时写入GlobalNetworkSet应返回错误: %v", err)
+	}
+	if res := resultFor(t, results, "configmap/edge/origin-cidrs"); res.Error != "" {
+		t.Errorf("ConfigMap应写入成功: %+v", res)
+	}
+}
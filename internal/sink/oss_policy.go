@@ -0,0 +1,303 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// sourceIPKey Bucket Policy中来源IP的条件键
+const sourceIPKey = "acs:SourceIp"
+
+// ossPolicyBackend Bucket Policy的读写操作
+type ossPolicyBackend interface {
+	GetBucketPolicy(ctx context.Context, bucket string) ([]byte, error)
+	PutBucketPolicy(ctx context.Context, bucket string, policy []byte) error
+}
+
+// OSSPolicySink 将期望列表写入Bucket Policy中标记Statement的acs:SourceIp条件
+// OSS的Policy接口不支持条件写入，写入前重新读取并与首次读取的内容比较，不一致时放弃本次写入；
+// 写入前保存原Policy快照，写入后读取校验，校验失败时自动恢复快照
+type OSSPolicySink struct {
+	config  config.SinkConfig
+	backend ossPolicyBackend
+}
+
+// NewOSSPolicySink 创建OSS Bucket Policy写入目标
+func NewOSSPolicySink(cfg config.SinkConfig) (*OSSPolicySink, error) {
+	backend, err := client.NewOSSClient(&cfg.AliyunConfig, cfg.OSSPolicy.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &OSSPolicySink{config: cfg, backend: backend}, nil
+}
+
+// Name 返回写入目标名称
+func (s *OSSPolicySink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐个同步配置的Bucket，某个Bucket失败时继续处理其他Bucket
+func (s *OSSPolicySink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	return syncEach("Bucket", s.config.OSSPolicy.Buckets, func(bucket string) (models.SinkResult, error) {
+		return s.syncBucket(ctx, bucket, desired)
+	})
+}
+
+// syncBucket 同步单个Bucket的Policy
+func (s *OSSPolicySink) syncBucket(ctx context.Context, bucket string, desired []string) (models.SinkResult, error) {
+	res := result(s.config, bucket)
+	sid := s.config.OSSPolicy.StatementSid
+
+	original, err := s.backend.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		return res, err
+	}
+	updated, existing, err := rewriteSourceIP(original, sid, desired)
+	if err != nil {
+		return res, err
+	}
+
+	added, removed := diffEntries(existing, desired)
+	if len(added) == 0 && len(removed) == 0 {
+		log.Printf("写入目标 %s: Bucket %s 的Policy无需更新（条目 %d）", s.config.Name, bucket, len(existing))
+		return res, nil
+	}
+
+	// 乐观并发控制：计算期间Policy被他人修改时放弃写入，下一轮基于新内容重新计算
+	current, err := s.backend.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		return res, err
+	}
+	if !bytes.Equal(current, original) {
+		return res, &client.APIError{Class: client.ErrorClassTransient, Err: fmt.Errorf("Policy在本次计算期间被修改，放弃写入")}
+	}
+
+	snapshot, err := s.saveSnapshot(bucket, original)
+	if err != nil {
+		return res, fmt.Errorf("保存Policy快照失败，本次不写入: %w", err)
+	}
+
+	log.Printf("写入目标 %s: Bucket %s 的Policy新增 %d 条，移除 %d 条（快照 %s）",
+		s.config.Name, bucket, len(added), len(removed), snapshot)
+	if err := s.backend.PutBucketPolicy(ctx, bucket, updated); err != nil {
+		return res, err
+	}
+
+	// 写入后校验标记Statement的内容，不一致时恢复原Policy
+	written, err := s.backend.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		return res, fmt.Errorf("写入后读取Policy失败，快照保存在 %s: %w", snapshot, err)
+	}
+	if _, applied, err := rewriteSourceIP(written, sid, desired); err != nil || !sameEntries(applied, desired) {
+		if rbErr := s.backend.PutBucketPolicy(ctx, bucket, original); rbErr != nil {
+			return res, fmt.Errorf("写入后校验失败，恢复快照 %s 也失败: %w", snapshot, rbErr)
+		}
+		return res, fmt.Errorf("写入后校验失败，已恢复为写入前的Policy")
+	}
+
+	res.Added, res.Removed = added, removed
+	return res, nil
+}
+
+// Rollback 将各Bucket的Policy恢复为最近一次快照，恢复前将当前Policy保存到rollback子目录
+// rollback子目录中的快照不参与之后的回滚，连续执行时逐个回退到更早的快照
+func (s *OSSPolicySink) Rollback(ctx context.Context) error {
+	var errs []error
+	for _, bucket := range s.config.OSSPolicy.Buckets {
+		if err := s.rollbackBucket(ctx, bucket); err != nil {
+			errs = append(errs, fmt.Errorf("Bucket %s: %w", bucket, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollbackBucket 恢复单个Bucket的Policy
+func (s *OSSPolicySink) rollbackBucket(ctx context.Context, bucket string) error {
+	snapshots, err := s.snapshots(bucket)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("没有可用的快照")
+	}
+	latest := snapshots[len(snapshots)-1]
+	policy, err := os.ReadFile(latest)
+	if err != nil {
+		return err
+	}
+
+	current, err := s.backend.GetBucketPolicy(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, policy) {
+		log.Printf("写入目标 %s: Bucket %s 的Policy与快照 %s 一致，无需恢复", s.config.Name, bucket, latest)
+		return nil
+	}
+	saved, err := s.writeSnapshot(filepath.Join(s.snapshotDir(bucket), "rollback"), current)
+	if err != nil {
+		return fmt.Errorf("保存当前Policy快照失败: %w", err)
+	}
+	if err := s.backend.PutBucketPolicy(ctx, bucket, policy); err != nil {
+		return err
+	}
+	// 删除已恢复的快照，连续执行时逐个回退到更早的快照
+	if err := os.Remove(latest); err != nil && !os.IsNotExist(err) {
+		log.Printf("写入目标 %s: 删除已恢复的快照 %s 失败: %v", s.config.Name, latest, err)
+	}
+	log.Printf("写入目标 %s: Bucket %s 的Policy已恢复为快照 %s，恢复前的内容保存在 %s", s.config.Name, bucket, latest, saved)
+	return nil
+}
+
+// snapshotDir 返回Bucket的快照目录
+func (s *OSSPolicySink) snapshotDir(bucket string) string {
+	return filepath.Join(s.config.OSSPolicy.SnapshotDir, s.config.Name, bucket)
+}
+
+// snapshots 返回Bucket可用于回滚的快照文件，按时间从旧到新排列
+func (s *OSSPolicySink) snapshots(bucket string) ([]string, error) {
+	return listSnapshots(s.snapshotDir(bucket))
+}
+
+// listSnapshots 返回目录中的快照文件，按时间从旧到新排列
+func listSnapshots(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// saveSnapshot 保存写入前的Policy快照
+func (s *OSSPolicySink) saveSnapshot(bucket string, policy []byte) (string, error) {
+	return s.writeSnapshot(s.snapshotDir(bucket), policy)
+}
+
+// writeSnapshot 在dir中保存Policy快照，并删除超出保留数量的旧快照
+func (s *OSSPolicySink) writeSnapshot(dir string, policy []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000000000Z")+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, policy, 0o600); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return path, nil
+	}
+	for len(snapshots) > s.config.OSSPolicy.KeepSnapshots {
+		if err := os.Remove(snapshots[0]); err != nil {
+			log.Printf("写入目标 %s: 删除旧快照 %s 失败: %v", s.config.Name, snapshots[0], err)
+		}
+		snapshots = snapshots[1:]
+	}
+	return path, nil
+}
+
+// rewriteSourceIP 将Sid为sid的Statement中的acs:SourceIp条件替换为desired，返回新Policy和原有条目
+// Policy的其他内容原样保留（键顺序可能变化）
+func rewriteSourceIP(policy []byte, sid string, desired []string) ([]byte, []string, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(policy))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("解析Policy失败: %w", err)
+	}
+
+	statements, _ := doc["Statement"].([]interface{})
+	var statement map[string]interface{}
+	for _, item := range statements {
+		fields, ok := item.(map[string]interface{})
+		if !ok || fields["Sid"] != sid {
+			continue
+		}
+		if statement != nil {
+			return nil, nil, fmt.Errorf("Policy中有多个Sid为 %s 的Statement", sid)
+		}
+		statement = fields
+	}
+	if statement == nil {
+		return nil, nil, fmt.Errorf("Policy中没有Sid为 %s 的Statement，请先手动添加", sid)
+	}
+
+	// 条件结构为 {"IpAddress": {"acs:SourceIp": [...]}}，运算符可以是IpAddress或NotIpAddress
+	conditions, _ := statement["Condition"].(map[string]interface{})
+	var operator map[string]interface{}
+	var key string
+	for _, value := range conditions {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name := range fields {
+			if !strings.EqualFold(name, sourceIPKey) {
+				continue
+			}
+			if operator != nil {
+				return nil, nil, fmt.Errorf("Statement %s 中有多个%s条件", sid, sourceIPKey)
+			}
+			operator, key = fields, name
+		}
+	}
+	if operator == nil {
+		return nil, nil, fmt.Errorf("Statement %s 中没有%s条件，请先手动添加", sid, sourceIPKey)
+	}
+
+	existing := policyEntries(operator[key])
+	values := make([]interface{}, 0, len(desired))
+	for _, cidr := range desired {
+		values = append(values, cidr)
+	}
+	operator[key] = values
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, existing, nil
+}
+
+// policyEntries 解析条件值，兼容单个字符串和数组，返回规范形式
+func policyEntries(value interface{}) []string {
+	var raw []string
+	switch v := value.(type) {
+	case string:
+		raw = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			raw = append(raw, fmt.Sprint(item))
+		}
+	}
+
+	entries := make([]string, 0, len(raw))
+	for _, entry := range raw {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := ipset.ParsePrefix(entry); err == nil {
+			entry = prefix.String()
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
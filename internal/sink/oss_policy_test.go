@@ -0,0 +1,313 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+const ossSid = "aliyun-dcdn-firewall-sync"
+
+// ossPolicy 返回只包含一个Statement的Policy，condition为该Statement的Condition
+func ossPolicy(sid, condition string) string {
+	return `{"Version":"1","Statement":[` +
+		`{"Sid":"public-read","Effect":"Allow","Action":["oss:GetObject"],"Principal":["*"],"Resource":["acs:oss:*:*:static/*"]},` +
+		`{"Sid":"` + sid + `","Effect":"Deny","Action":["oss:*"],"Principal":["*"],"Resource":["acs:oss:*:*:static/*"],"Condition":` + condition + `}]}`
+}
+
+func TestRewriteSourceIP(t *testing.T) {
+	desired := []string{"10.0.0.0/24", "192.0.2.1/32"}
+	tests := []struct {
+		name     string
+		policy   string
+		existing []string
+		wantErr  string
+	}{
+		{
+			name:     "数组形式的条件值",
+			policy:   ossPolicy(ossSid, `{"NotIpAddress":{"acs:SourceIp":["10.0.0.0/24","198.51.100.0/24"]}}`),
+			existing: []string{"10.0.0.0/24", "198.51.100.0/24"},
+		},
+		{
+			name:     "单个字符串形式的条件值",
+			policy:   ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":"192.0.2.1"}}`),
+			existing: []string{"192.0.2.1/32"},
+		},
+		{
+			name:     "条件键不区分大小写，原有条目转换为规范形式",
+			policy:   ossPolicy(ossSid, `{"IpAddress":{"acs:sourceip":["10.0.0.7/24"," 192.0.2.1 "]}}`),
+			existing: []string{"10.0.0.0/24", "192.0.2.1/32"},
+		},
+		{
+			name:    "没有标记的Statement",
+			policy:  ossPolicy("other", `{"IpAddress":{"acs:SourceIp":[]}}`),
+			wantErr: "没有Sid为 aliyun-dcdn-firewall-sync 的Statement",
+		},
+		{
+			name: "多个Sid相同的Statement",
+			policy: `{"Version":"1","Statement":[` +
+				`{"Sid":"aliyun-dcdn-firewall-sync","Condition":{"IpAddress":{"acs:SourceIp":[]}}},` +
+				`{"Sid":"aliyun-dcdn-firewall-sync","Condition":{"IpAddress":{"acs:SourceIp":[]}}}]}`,
+			wantErr: "有多个Sid为 aliyun-dcdn-firewall-sync 的Statement",
+		},
+		{
+			name:    "没有acs:SourceIp条件",
+			policy:  ossPolicy(ossSid, `{"StringEquals":{"acs:UserAgent":["curl"]}}`),
+			wantErr: "中没有acs:SourceIp条件",
+		},
+		{
+			name:    "多个acs:SourceIp条件",
+			policy:  ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":["10.0.0.0/24"]},"NotIpAddress":{"acs:SourceIp":["10.0.1.0/24"]}}`),
+			wantErr: "中有多个acs:SourceIp条件",
+		},
+		{
+			name:    "无法解析的Policy",
+			policy:  `{"Statement":`,
+			wantErr: "解析Policy失败",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, existing, err := rewriteSourceIP([]byte(tt.policy), ossSid, desired)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("rewriteSourceIP 返回 %v，期望包含 %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rewriteSourceIP 返回错误: %v", err)
+			}
+			if !slices.Equal(existing, tt.existing) {
+				t.Errorf("原有条目为 %v，期望 %v", existing, tt.existing)
+			}
+
+			// 再次解析得到写入的条目，其他Statement原样保留
+			_, applied, err := rewriteSourceIP(updated, ossSid, nil)
+			if err != nil || !slices.Equal(applied, desired) {
+				t.Errorf("写入后的条目为 %v（%v），期望 %v", applied, err, desired)
+			}
+			var before, after map[string]any
+			if err := json.Unmarshal([]byte(tt.policy), &before); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(updated, &after); err != nil {
+				t.Fatal(err)
+			}
+			beforeFirst, _ := json.Marshal(before["Statement"].([]any)[0])
+			afterFirst, _ := json.Marshal(after["Statement"].([]any)[0])
+			if string(beforeFirst) != string(afterFirst) || after["Version"] != "1" {
+				t.Errorf("其他内容被修改:\n%s\n%s", beforeFirst, afterFirst)
+			}
+		})
+	}
+}
+
+// fakeOSS 内存中的Bucket Policy
+// onGet在每次读取前调用（从1开始计数），onPut可改写实际保存的内容或返回错误
+type fakeOSS struct {
+	policy []byte
+	gets   int
+	puts   [][]byte
+	onGet  func(call int)
+	onPut  func(call int, policy []byte) ([]byte, error)
+}
+
+func (f *fakeOSS) GetBucketPolicy(_ context.Context, _ string) ([]byte, error) {
+	f.gets++
+	if f.onGet != nil {
+		f.onGet(f.gets)
+	}
+	return slices.Clone(f.policy), nil
+}
+
+func (f *fakeOSS) PutBucketPolicy(_ context.Context, _ string, policy []byte) error {
+	f.puts = append(f.puts, policy)
+	if f.onPut != nil {
+		stored, err := f.onPut(len(f.puts), policy)
+		if err != nil {
+			return err
+		}
+		policy = stored
+	}
+	f.policy = slices.Clone(policy)
+	return nil
+}
+
+func newOSSPolicySink(t *testing.T, backend ossPolicyBackend) *OSSPolicySink {
+	return &OSSPolicySink{
+		config: config.SinkConfig{
+			Name: "oss",
+			Type: "oss_policy",
+			OSSPolicy: config.OSSPolicySinkConfig{
+				Buckets:       []string{"static"},
+				StatementSid:  ossSid,
+				SnapshotDir:   t.TempDir(),
+				KeepSnapshots: 2,
+			},
+		},
+		backend: backend,
+	}
+}
+
+// sourceIPs 返回Policy中标记Statement的条目
+func sourceIPs(t *testing.T, policy []byte) []string {
+	t.Helper()
+	_, entries, err := rewriteSourceIP(policy, ossSid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestOSSPolicySync(t *testing.T) {
+	original := []byte(ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":["10.0.0.0/24","10.9.0.0/24"]}}`))
+	desired := []string{"10.0.0.0/24", "10.0.1.0/24"}
+
+	backend := &fakeOSS{policy: original}
+	s := newOSSPolicySink(t, backend)
+	results, err := s.Sync(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if got := sourceIPs(t, backend.policy); !slices.Equal(got, desired) {
+		t.Errorf("写入后的条目为 %v，期望 %v", got, desired)
+	}
+	if !slices.Equal(results[0].Added, []string{"10.0.1.0/24"}) || !slices.Equal(results[0].Removed, []string{"10.9.0.0/24"}) {
+		t.Errorf("结果为 %+v", results[0])
+	}
+	snapshots, _ := s.snapshots("static")
+	if len(snapshots) != 1 {
+		t.Fatalf("快照 %v，期望写入前保存一个快照", snapshots)
+	}
+	if saved, _ := os.ReadFile(snapshots[0]); string(saved) != string(original) {
+		t.Errorf("快照内容为 %s，期望写入前的Policy", saved)
+	}
+
+	// 内容一致时不写入也不保存快照
+	if _, err := s.Sync(context.Background(), desired); err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if snapshots, _ := s.snapshots("static"); len(backend.puts) != 1 || len(snapshots) != 1 {
+		t.Errorf("内容一致时写入 %d 次、快照 %d 个，期望不再写入", len(backend.puts), len(snapshots))
+	}
+
+	// 回滚恢复为写入前的Policy，回滚前的内容保存到rollback子目录
+	written := slices.Clone(backend.policy)
+	if err := s.Rollback(context.Background()); err != nil {
+		t.Fatalf("Rollback 返回错误: %v", err)
+	}
+	if string(backend.policy) != string(original) {
+		t.Errorf("回滚后的Policy为 %s", backend.policy)
+	}
+	saved, _ := listSnapshots(filepath.Join(s.snapshotDir("static"), "rollback"))
+	if len(saved) != 1 {
+		t.Fatalf("rollback子目录中有 %d 个快照，期望 1 个", len(saved))
+	}
+	if content, _ := os.ReadFile(saved[0]); string(content) != string(written) {
+		t.Errorf("回滚前的快照内容为 %s，期望 %s", content, written)
+	}
+	if snapshots, _ := s.snapshots("static"); len(snapshots) != 0 {
+		t.Errorf("已恢复的快照应删除: %v", snapshots)
+	}
+}
+
+func TestOSSPolicyModifiedDuringSync(t *testing.T) {
+	original := []byte(ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":["10.0.0.0/24"]}}`))
+	backend := &fakeOSS{policy: original}
+	// 第二次读取前他人修改了Policy
+	backend.onGet = func(call int) {
+		if call == 2 {
+			backend.policy = []byte(ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":["10.0.0.0/24","203.0.113.0/24"]}}`))
+		}
+	}
+	s := newOSSPolicySink(t, backend)
+	_, err := s.Sync(context.Background(), []string{"10.0.1.0/24"})
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.Class != client.ErrorClassTransient {
+		t.Fatalf("Sync 返回 %v，期望可重试的暂时性错误", err)
+	}
+	if len(backend.puts) != 0 {
+		t.Error("Policy被他人修改时不应写入")
+	}
+}
+
+func TestOSSPolicyVerifyRestore(t *testing.T) {
+	original := []byte(ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":["10.0.0.0/24"]}}`))
+	desired := []string{"10.0.0.0/24", "10.0.1.0/24"}
+	// 写入后读取到的内容与写入的不一致（如被服务端截断）
+	truncate := func(call int, policy []byte) ([]byte, error) {
+		if call == 1 {
+			updated, _, err := rewriteSourceIP(policy, ossSid, desired[:1])
+			return updated, err
+		}
+		return policy, nil
+	}
+
+	t.Run("校验失败时恢复原Policy", func(t *testing.T) {
+		backend := &fakeOSS{policy: original, onPut: truncate}
+		s := newOSSPolicySink(t, backend)
+		_, err := s.Sync(context.Background(), desired)
+		if err == nil || !strings.Contains(err.Error(), "已恢复为写入前的Policy") {
+			t.Fatalf("Sync 返回 %v，期望校验失败并恢复", err)
+		}
+		if len(backend.puts) != 2 || string(backend.puts[1]) != string(original) {
+			t.Fatalf("写入 %d 次，第二次应写回原Policy", len(backend.puts))
+		}
+		if string(backend.policy) != string(original) {
+			t.Errorf("恢复后的Policy为 %s", backend.policy)
+		}
+	})
+
+	t.Run("恢复也失败时报告快照位置", func(t *testing.T) {
+		backend := &fakeOSS{policy: original, onPut: func(call int, policy []byte) ([]byte, error) {
+			if call == 2 {
+				return nil, errors.New("AccessDenied")
+			}
+			return truncate(call, policy)
+		}}
+		s := newOSSPolicySink(t, backend)
+		_, err := s.Sync(context.Background(), desired)
+		if err == nil || !strings.Contains(err.Error(), "恢复快照") || !strings.Contains(err.Error(), "AccessDenied") {
+			t.Fatalf("Sync 返回 %v，期望包含恢复失败的原因", err)
+		}
+		snapshots, _ := s.snapshots("static")
+		if len(snapshots) != 1 || !strings.Contains(err.Error(), snapshots[0]) {
+			t.Errorf("错误信息应包含快照路径 %v: %v", snapshots, err)
+		}
+	})
+}
+
+func TestOSSPolicySnapshotsKept(t *testing.T) {
+	backend := &fakeOSS{policy: []byte(ossPolicy(ossSid, `{"IpAddress":{"acs:SourceIp":[]}}`))}
+	s := newOSSPolicySink(t, backend)
+	var history [][]byte
+	for _, cidr := range []string{"10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"} {
+		history = append(history, slices.Clone(backend.policy))
+		if _, err := s.Sync(context.Background(), []string{cidr}); err != nil {
+			t.Fatalf("Sync 返回错误: %v", err)
+		}
+	}
+	snapshots, _ := s.snapshots("static")
+	if len(snapshots) != 2 {
+		t.Fatalf("保留 %d 个快照，期望keep_snapshots个", len(snapshots))
+	}
+
+	// 连续回滚逐个回退到更早的快照
+	for i := len(history) - 1; i >= len(history)-2; i-- {
+		if err := s.Rollback(context.Background()); err != nil {
+			t.Fatalf("Rollback 返回错误: %v", err)
+		}
+		if string(backend.policy) != string(history[i]) {
+			t.Errorf("回滚后的条目为 %v，期望 %v", sourceIPs(t, backend.policy), sourceIPs(t, history[i]))
+		}
+	}
+}
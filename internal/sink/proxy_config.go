@@ -0,0 +1,281 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// nginxDirective 解析nginx格式中已写入的条目
+var nginxDirective = regexp.MustCompile(`^(?:set_real_ip_from|allow) (\S+);$`)
+
+// envoyCIDR Envoy的CidrRange
+type envoyCIDR struct {
+	AddressPrefix string `json:"address_prefix"`
+	PrefixLen     int    `json:"prefix_len"`
+}
+
+// ProxyConfigSink 将期望列表渲染为反向代理的可信代理配置文件
+// 文件原子写入，之后执行校验和重新加载命令，任一命令失败时恢复原文件
+type ProxyConfigSink struct {
+	config  config.SinkConfig
+	timeout time.Duration
+}
+
+// NewProxyConfigSink 创建反向代理配置文件写入目标
+func NewProxyConfigSink(cfg config.SinkConfig) (*ProxyConfigSink, error) {
+	timeout, err := time.ParseDuration(cfg.ProxyConfig.CommandTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("写入目标 %s 的command_timeout无效: %v", cfg.Name, err)
+	}
+	return &ProxyConfigSink{config: cfg, timeout: timeout}, nil
+}
+
+// Name 返回写入目标名称
+func (s *ProxyConfigSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 渲染配置文件，内容变化时写入并执行校验和重新加载命令
+func (s *ProxyConfigSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	proxy := s.config.ProxyConfig
+	res := result(s.config, proxy.Path)
+
+	content, err := renderProxyConfig(proxy.Format, s.config.Name, proxy.Snippet, desired)
+	if err != nil {
+		return []models.SinkResult{res}, err
+	}
+
+	old, err := os.ReadFile(proxy.Path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return []models.SinkResult{res}, err
+	}
+	if existed && bytes.Equal(old, content) {
+		log.Printf("写入目标 %s: 配置文件 %s 无需更新（条目 %d）", s.config.Name, proxy.Path, len(desired))
+		return []models.SinkResult{res}, nil
+	}
+	added, removed := diffEntries(parseProxyConfig(proxy.Format, old), desired)
+
+	if err := os.MkdirAll(filepath.Dir(proxy.Path), 0755); err != nil {
+		return []models.SinkResult{res}, err
+	}
+	if err := writeFileAtomic(proxy.Path, content); err != nil {
+		return []models.SinkResult{res}, fmt.Errorf("写入配置文件 %s 失败: %w", proxy.Path, err)
+	}
+	log.Printf("写入目标 %s: 配置文件 %s 新增 %d 条，移除 %d 条", s.config.Name, proxy.Path, len(added), len(removed))
+
+	for _, step := range []struct{ name, command string }{
+		{"校验", proxy.ValidateCommand},
+		{"重新加载", proxy.ReloadCommand},
+	} {
+		if step.command == "" {
+			continue
+		}
+		if err := s.run(ctx, step.command); err != nil {
+			// 重新加载失败时代理仍在使用旧配置，恢复文件后不再重复执行重新加载
+			if restoreErr := s.restore(old, existed); restoreErr != nil {
+				err = fmt.Errorf("%w；恢复原文件也失败: %v", err, restoreErr)
+			} else {
+				log.Printf("写入目标 %s: %s失败，已恢复原配置文件 %s", s.config.Name, step.name, proxy.Path)
+			}
+			res.Error = fmt.Sprintf("%s失败: %v", step.name, err)
+			return []models.SinkResult{res}, fmt.Errorf("%s失败: %w", step.name, err)
+		}
+	}
+
+	res.Added, res.Removed = added, removed
+	return []models.SinkResult{res}, nil
+}
+
+// run 通过 sh -c 执行命令
+func (s *ProxyConfigSink) run(ctx context.Context, command string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("执行 %q 失败: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// restore 恢复写入前的文件，写入前文件不存在时删除
+func (s *ProxyConfigSink) restore(old []byte, existed bool) error {
+	if !existed {
+		return os.Remove(s.config.ProxyConfig.Path)
+	}
+	return writeFileAtomic(s.config.ProxyConfig.Path, old)
+}
+
+// renderProxyConfig 按格式渲染配置文件
+func renderProxyConfig(format, name, snippet string, desired []string) ([]byte, error) {
+	header := fmt.Sprintf(generatedHeader, name)
+	var b strings.Builder
+
+	switch format {
+	case "nginx_real_ip", "nginx_allow":
+		// 在http/server中include；nginx_allow之后需自行添加 deny all;
+		directive := "set_real_ip_from"
+		if format == "nginx_allow" {
+			directive = "allow"
+		}
+		b.WriteString(header)
+		for _, cidr := range desired {
+			fmt.Fprintf(&b, "%s %s;\n", directive, cidr)
+		}
+	case "haproxy_acl":
+		// acl from_dcdn src -f <path>
+		b.WriteString(header)
+		for _, cidr := range desired {
+			b.WriteString(cidr + "\n")
+		}
+	case "caddy":
+		// 在全局选项的servers块中 import <snippet>
+		b.WriteString(header)
+		fmt.Fprintf(&b, "(%s) {\n\ttrusted_proxies static %s\n}\n", snippet, strings.Join(desired, " "))
+	case "envoy_rbac", "envoy_xff":
+		cidrs, err := envoyCIDRs(desired)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(envoyConfig(format, name, cidrs), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		b.Write(data)
+		b.WriteString("\n")
+	default:
+		return nil, fmt.Errorf("不支持的格式: %s", format)
+	}
+	return []byte(b.String()), nil
+}
+
+// envoyXFF HttpConnectionManager.original_ip_detection_extensions中的XFF扩展
+type envoyXFF struct {
+	Name        string `json:"name"`
+	TypedConfig struct {
+		Type            string `json:"@type"`
+		XffTrustedCIDRs struct {
+			CIDRs []envoyCIDR `json:"cidrs"`
+		} `json:"xff_trusted_cidrs"`
+	} `json:"typed_config"`
+}
+
+// envoyRBAC HTTP RBAC过滤器的typed_config
+type envoyRBAC struct {
+	Type  string `json:"@type"`
+	Rules struct {
+		Action   string                     `json:"action"`
+		Policies map[string]envoyRBACPolicy `json:"policies"`
+	} `json:"rules"`
+}
+
+// envoyRBACPolicy RBAC策略，按直连IP（即DCDN节点）放行
+type envoyRBACPolicy struct {
+	Permissions []map[string]bool `json:"permissions"`
+	Principals  []envoyPrincipal  `json:"principals"`
+}
+
+// envoyPrincipal RBAC主体
+type envoyPrincipal struct {
+	DirectRemoteIP envoyCIDR `json:"direct_remote_ip"`
+}
+
+// envoyConfig 生成Envoy配置片段，JSON同时也是合法的YAML
+// envoy_rbac为HTTP RBAC过滤器的typed_config；envoy_xff只信任来自这些网段的XFF
+func envoyConfig(format, name string, cidrs []envoyCIDR) interface{} {
+	if format == "envoy_xff" {
+		var xff envoyXFF
+		xff.Name = "envoy.http.original_ip_detection.xff"
+		xff.TypedConfig.Type = "type.googleapis.com/envoy.extensions.http.original_ip_detection.xff.v3.XffConfig"
+		xff.TypedConfig.XffTrustedCIDRs.CIDRs = cidrs
+		return xff
+	}
+
+	policy := envoyRBACPolicy{Permissions: []map[string]bool{{"any": true}}}
+	for _, cidr := range cidrs {
+		policy.Principals = append(policy.Principals, envoyPrincipal{DirectRemoteIP: cidr})
+	}
+	var rbac envoyRBAC
+	rbac.Type = "type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC"
+	rbac.Rules.Action = "ALLOW"
+	rbac.Rules.Policies = map[string]envoyRBACPolicy{name: policy}
+	return rbac
+}
+
+// envoyCIDRs 将CIDR拆分为Envoy的地址和前缀长度
+func envoyCIDRs(desired []string) ([]envoyCIDR, error) {
+	cidrs := make([]envoyCIDR, 0, len(desired))
+	for _, entry := range desired {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR %s: %w", entry, err)
+		}
+		cidrs = append(cidrs, envoyCIDR{AddressPrefix: prefix.Addr().String(), PrefixLen: prefix.Bits()})
+	}
+	return cidrs, nil
+}
+
+// parseProxyConfig 从上次写入的文件中解析条目，用于计算差异
+func parseProxyConfig(format string, content []byte) []string {
+	var entries []string
+	switch format {
+	case "envoy_rbac", "envoy_xff":
+		var doc interface{}
+		if json.Unmarshal(content, &doc) == nil {
+			collectEnvoyCIDRs(doc, &entries)
+		}
+		return entries
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case format == "haproxy_acl":
+			entries = append(entries, line)
+		case format == "caddy":
+			if fields := strings.Fields(line); len(fields) > 2 && fields[0] == "trusted_proxies" {
+				entries = append(entries, fields[2:]...)
+			}
+		default:
+			if match := nginxDirective.FindStringSubmatch(line); match != nil {
+				entries = append(entries, match[1])
+			}
+		}
+	}
+	return entries
+}
+
+// collectEnvoyCIDRs 递归查找Envoy配置中的CidrRange
+func collectEnvoyCIDRs(value interface{}, entries *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if address, ok := v["address_prefix"].(string); ok {
+			if bits, ok := v["prefix_len"].(float64); ok {
+				*entries = append(*entries, fmt.Sprintf("%s/%d", address, int(bits)))
+				return
+			}
+		}
+		for _, item := range v {
+			collectEnvoyCIDRs(item, entries)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectEnvoyCIDRs(item, entries)
+		}
+	}
+}
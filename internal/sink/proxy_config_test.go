@@ -0,0 +1,170 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+// proxyFormats 各格式及其golden文件名
+var proxyFormats = []struct{ format, golden string }{
+	{"nginx_real_ip", "edge.real_ip.conf"},
+	{"nginx_allow", "edge.allow.conf"},
+	{"haproxy_acl", "edge.acl"},
+	{"caddy", "edge.caddy"},
+	{"envoy_rbac", "edge.rbac.json"},
+	{"envoy_xff", "edge.xff.json"},
+}
+
+func newProxyConfigSink(format, path string) *ProxyConfigSink {
+	return &ProxyConfigSink{
+		config: config.SinkConfig{
+			Name: "edge",
+			Type: "proxy_config",
+			ProxyConfig: config.ProxyConfigSinkConfig{
+				Format:  format,
+				Path:    path,
+				Snippet: "dcdn_trusted_proxies",
+			},
+		},
+		timeout: 10 * time.Second,
+	}
+}
+
+func TestProxyConfigRender(t *testing.T) {
+	for _, tt := range proxyFormats {
+		t.Run(tt.format, func(t *testing.T) {
+			content, err := renderProxyConfig(tt.format, "edge", "dcdn_trusted_proxies", hostFirewallDesired)
+			if err != nil {
+				t.Fatalf("renderProxyConfig 返回错误: %v", err)
+			}
+			checkGolden(t, filepath.Join("testdata", "proxy_config", tt.golden), content)
+
+			// 渲染结果可以解析回原来的期望列表
+			if got := parseProxyConfig(tt.format, content); !slices.Equal(got, hostFirewallDesired) {
+				t.Errorf("parseProxyConfig = %v, 期望 %v", got, hostFirewallDesired)
+			}
+		})
+	}
+
+	if _, err := renderProxyConfig("envoy_rbac", "edge", "", []string{"not-a-cidr"}); err == nil {
+		t.Error("envoy格式遇到无效的CIDR应返回错误")
+	}
+	if _, err := renderProxyConfig("traefik", "edge", "", hostFirewallDesired); err == nil {
+		t.Error("不支持的格式应返回错误")
+	}
+}
+
+func TestParseProxyConfig(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+		want    []string
+	}{
+		{"nginx_real_ip", "# 注释\nset_real_ip_from 10.0.0.0/24;\nreal_ip_header X-Forwarded-For;\n  allow 192.0.2.1/32;  \n", []string{"10.0.0.0/24", "192.0.2.1/32"}},
+		{"haproxy_acl", "# 注释\n\n10.0.0.0/24\n", []string{"10.0.0.0/24"}},
+		{"caddy", "(dcdn) {\n\ttrusted_proxies static 10.0.0.0/24 192.0.2.1/32\n}\n", []string{"10.0.0.0/24", "192.0.2.1/32"}},
+		{"envoy_xff", "not json", nil},
+		{"nginx_allow", "", nil},
+	}
+	for _, tt := range tests {
+		if got := parseProxyConfig(tt.format, []byte(tt.content)); !slices.Equal(got, tt.want) {
+			t.Errorf("parseProxyConfig(%s, %q) = %v, 期望 %v", tt.format, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestProxyConfigSync(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dcdn.conf")
+	log := filepath.Join(dir, "commands.log")
+	s := newProxyConfigSink("nginx_real_ip", path)
+	s.config.ProxyConfig.ValidateCommand = "echo validate >> " + log
+	s.config.ProxyConfig.ReloadCommand = "echo reload >> " + log
+
+	results, err := s.Sync(context.Background(), hostFirewallDesired[:2])
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if !slices.Equal(results[0].Added, hostFirewallDesired[:2]) || results[0].Target != path {
+		t.Errorf("首次写入的结果为 %+v", results[0])
+	}
+
+	results, err = s.Sync(context.Background(), hostFirewallDesired[1:3])
+	if err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	if !slices.Equal(results[0].Added, hostFirewallDesired[2:3]) || !slices.Equal(results[0].Removed, hostFirewallDesired[:1]) {
+		t.Errorf("第二次写入的结果为 %+v", results[0])
+	}
+
+	// 内容不变时不写入也不执行命令
+	if _, err := s.Sync(context.Background(), hostFirewallDesired[1:3]); err != nil {
+		t.Fatalf("Sync 返回错误: %v", err)
+	}
+	commands, _ := os.ReadFile(log)
+	if got := strings.Fields(string(commands)); !slices.Equal(got, []string{"validate", "reload", "validate", "reload"}) {
+		t.Errorf("执行的命令为 %v", got)
+	}
+}
+
+func TestProxyConfigRestore(t *testing.T) {
+	tests := []struct {
+		name             string
+		existed          bool
+		validate, reload string
+		wantErr          string
+	}{
+		{name: "校验失败时恢复原文件", existed: true, validate: "echo 'nginx: [emerg] invalid' >&2; exit 1", wantErr: "校验失败"},
+		{name: "重新加载失败时恢复原文件", existed: true, validate: "true", reload: "exit 1", wantErr: "重新加载失败"},
+		{name: "原文件不存在时删除写入的文件", validate: "exit 1", wantErr: "校验失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dcdn.conf")
+			original := []byte("# 手动维护的旧内容\nset_real_ip_from 10.9.0.0/24;\n")
+			if tt.existed {
+				if err := os.WriteFile(path, original, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := newProxyConfigSink("nginx_real_ip", path)
+			s.config.ProxyConfig.ValidateCommand = tt.validate
+			s.config.ProxyConfig.ReloadCommand = tt.reload
+
+			results, err := s.Sync(context.Background(), hostFirewallDesired)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Sync 返回 %v，期望包含 %q", err, tt.wantErr)
+			}
+			if !strings.Contains(results[0].Error, tt.wantErr) || len(results[0].Added) != 0 {
+				t.Errorf("失败时的结果为 %+v", results[0])
+			}
+
+			content, err := os.ReadFile(path)
+			if !tt.existed {
+				if !os.IsNotExist(err) {
+					t.Errorf("原文件不存在时应删除写入的文件，读取结果: %q %v", content, err)
+				}
+				return
+			}
+			if string(content) != string(original) {
+				t.Errorf("恢复后的文件内容为 %q，期望 %q", content, original)
+			}
+		})
+	}
+
+	t.Run("校验输出写入错误信息", func(t *testing.T) {
+		s := newProxyConfigSink("haproxy_acl", filepath.Join(t.TempDir(), "dcdn.acl"))
+		s.config.ProxyConfig.ValidateCommand = "echo 'config error at line 3' >&2; exit 2"
+		_, err := s.Sync(context.Background(), hostFirewallDesired)
+		if err == nil || !strings.Contains(err.Error(), "config error at line 3") {
+			t.Errorf("Sync 返回 %v，期望包含命令输出", err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// Sink 云防火墙地址薄以外的写入目标
+type Sink interface {
+	// Name 写入目标名称，用于日志
+	Name() string
+	// Sync 使目标内容与期望列表一致，desired为去重、排序后的规范CIDR，调用方保证不为空
+	// 一个写入目标可能包含多个写入对象（如多个安全组），每个对象返回一条结果，部分对象失败时同时返回结果和错误
+	// Sync本身不重试，可重试的错误由调度器按max_retries整体重试，因此Sync需要可以安全地重复执行
+	Sync(ctx context.Context, desired []string) ([]models.SinkResult, error)
+}
+
+// New 根据配置创建写入目标
+func New(cfg config.SinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case "ecs_security_group":
+		return NewSecurityGroupSink(cfg)
+	case "slb_acl", "alb_acl":
+		return NewACLSink(cfg)
+	case "waf_whitelist":
+		return NewWAFSink(cfg)
+	case "rds_whitelist", "polardb_whitelist", "redis_whitelist":
+		return NewDBWhitelistSink(cfg)
+	case "oss_policy":
+		return NewOSSPolicySink(cfg)
+	case "host_firewall":
+		return NewHostFirewallSink(cfg)
+	case "proxy_config":
+		return NewProxyConfigSink(cfg)
+	case "kubernetes":
+		return NewKubernetesSink(cfg)
+	case "webhook":
+		return NewWebhookSink(cfg)
+	default:
+		return nil, fmt.Errorf("写入目标 %s 不支持的类型: %s", cfg.Name, cfg.Type)
+	}
+}
+
+// result 创建写入对象的结果
+func result(cfg config.SinkConfig, target string) models.SinkResult {
+	return models.SinkResult{Sink: cfg.Name, Type: cfg.Type, Group: cfg.Group, Target: target}
+}
+
+// syncEach 逐个同步写入对象，某个对象失败时记录错误并继续处理其他对象
+// kind为错误信息中写入对象的类型，如 "安全组"，为空时只使用对象名称
+func syncEach[T any](kind string, targets []T, sync func(target T) (models.SinkResult, error)) ([]models.SinkResult, error) {
+	var results []models.SinkResult
+	var errs []error
+	for _, target := range targets {
+		res, err := sync(target)
+		if err != nil {
+			label := fmt.Sprint(target)
+			if kind != "" {
+				label = kind + " " + label
+			}
+			res.Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+		}
+		results = append(results, res)
+	}
+	return results, errors.Join(errs...)
+}
+
+// applyAddFirst 执行新增和移除，total为现有数量，limit为配额（0表示不限），unit用于错误信息，如 "规则"
+// 写入后超过配额时不写入；白名单优先先添加后移除，避免放行出现空档，先添加会暂时超过配额时改为先移除
+func applyAddFirst(unit string, total, toAdd, toRemove, limit int, add, remove func() error) error {
+	if limit > 0 && total+toAdd-toRemove > limit {
+		return fmt.Errorf("写入后%s数 %d 超过配额 %d（现有 %d，新增 %d，移除 %d），本次不写入",
+			unit, total+toAdd-toRemove, limit, total, toAdd, toRemove)
+	}
+
+	steps := []func() error{add, remove}
+	if limit > 0 && total+toAdd > limit {
+		steps = []func() error{remove, add}
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffEntries 计算从existing到desired需要新增和移除的条目
+func diffEntries(existing, desired []string) (added, removed []string) {
+	present := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		present[entry] = true
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, entry := range desired {
+		wanted[entry] = true
+		if !present[entry] {
+			added = append(added, entry)
+		}
+	}
+	for _, entry := range existing {
+		if !wanted[entry] {
+			removed = append(removed, entry)
+		}
+	}
+	return added, removed
+}
+
+// sameEntries 判断两组条目是否一致
+func sameEntries(a, b []string) bool {
+	added, removed := diffEntries(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
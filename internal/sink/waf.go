@@ -0,0 +1,267 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// WAFSink 将期望列表写入WAF 3.0白名单规则的IP条件，使回源流量跳过Bot等防护检测
+type WAFSink struct {
+	config config.SinkConfig
+	client *client.WAFClient
+}
+
+// NewWAFSink 创建WAF白名单规则写入目标
+func NewWAFSink(cfg config.SinkConfig) (*WAFSink, error) {
+	wafClient, err := client.NewWAFClient(&cfg.AliyunConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &WAFSink{config: cfg, client: wafClient}, nil
+}
+
+// Name 返回写入目标名称
+func (s *WAFSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐条同步配置的白名单规则，某条规则失败时继续处理其他规则
+func (s *WAFSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	owned := s.loadOwned()
+	return syncEach("规则", s.config.WAF.RuleIDs, func(id int64) (models.SinkResult, error) {
+		return s.syncRule(ctx, id, desired, owned)
+	})
+}
+
+// syncRule 同步单条白名单规则
+// 只移除owned中记录的本工具添加的条目，他人添加的条目保持原样
+func (s *WAFSink) syncRule(ctx context.Context, id int64, desired []string, owned map[string][]string) (models.SinkResult, error) {
+	res := result(s.config, fmt.Sprintf("%s/%d", s.config.WAF.InstanceID, id))
+	key := strconv.FormatInt(id, 10)
+
+	rule, err := s.client.GetRule(ctx, s.config.WAF.InstanceID, id)
+	if err != nil {
+		return res, err
+	}
+	// 只改写白名单场景的规则，避免误把期望列表写入拦截类规则
+	if rule.DefenseScene != "whitelist" {
+		return res, fmt.Errorf("规则属于 %s 场景，只支持白名单（whitelist）规则", rule.DefenseScene)
+	}
+	original, err := json.Marshal(rule.Config)
+	if err != nil {
+		return res, err
+	}
+
+	condition, err := ipCondition(rule.Config)
+	if err != nil {
+		return res, err
+	}
+
+	// 不在记录中的现有条目属于他人，原样保留；与期望列表重复的条目也不接管
+	mine := make(map[string]bool, len(owned[key]))
+	for _, entry := range owned[key] {
+		mine[entry] = true
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, entry := range desired {
+		wanted[entry] = true
+	}
+	present := make(map[string]bool)
+	var values []string
+	for _, raw := range splitIPValues(condition["values"]) {
+		entry := canonicalValue(raw)
+		seen := present[entry]
+		present[entry] = true
+		switch {
+		case !mine[entry]:
+			values = append(values, raw)
+		case seen:
+			// 本工具添加的条目重复出现时只保留一条
+		case wanted[entry]:
+			values = append(values, entry)
+		default:
+			res.Removed = append(res.Removed, entry)
+		}
+	}
+	var managed []string
+	for _, entry := range desired {
+		if !present[entry] {
+			values = append(values, entry)
+			res.Added = append(res.Added, entry)
+		}
+		if !present[entry] || mine[entry] {
+			managed = append(managed, entry)
+		}
+	}
+
+	if len(res.Added) == 0 && len(res.Removed) == 0 {
+		log.Printf("写入目标 %s: WAF规则 %d 无需更新（条目 %d）", s.config.Name, id, len(present))
+		if !sameEntries(owned[key], managed) {
+			owned[key] = managed
+			s.saveOwnedOrWarn(owned)
+		}
+		return res, nil
+	}
+
+	if limit := s.config.WAF.MaxEntries; limit > 0 && len(values) > limit {
+		added, removed := len(res.Added), len(res.Removed)
+		res.Added, res.Removed = nil, nil
+		return res, fmt.Errorf("写入后条目数 %d 超过上限 %d（现有 %d，新增 %d，移除 %d），本次不写入",
+			len(values), limit, len(present), added, removed)
+	}
+
+	// 乐观并发控制：计算期间规则被他人修改时放弃写入，下一轮基于新内容重新计算
+	current, err := s.client.GetRule(ctx, s.config.WAF.InstanceID, id)
+	if err != nil {
+		res.Added, res.Removed = nil, nil
+		return res, err
+	}
+	if latest, err := json.Marshal(current.Config); err != nil || !bytes.Equal(latest, original) {
+		res.Added, res.Removed = nil, nil
+		return res, &client.APIError{Class: client.ErrorClassTransient, Err: fmt.Errorf("规则在本次计算期间被修改，放弃写入")}
+	}
+
+	// 写入前先记录将要添加的条目，写入失败时多记录的条目不在规则中，不影响之后的计算
+	owned[key] = unionEntries(owned[key], res.Added)
+	if err := s.saveOwned(owned); err != nil {
+		res.Added, res.Removed = nil, nil
+		return res, fmt.Errorf("保存条目记录失败，本次不写入: %w", err)
+	}
+
+	log.Printf("写入目标 %s: WAF规则 %d 新增 %d 条，移除 %d 条", s.config.Name, id, len(res.Added), len(res.Removed))
+
+	// 规则的IP条件整体替换，新增和移除在一次调用中生效，不存在放行空档；保持条件值原有的字符串或数组形式
+	condition["values"] = joinIPValues(condition["values"], values)
+	if err := s.client.ModifyRule(ctx, s.config.WAF.InstanceID, rule); err != nil {
+		res.Added, res.Removed = nil, nil
+		return res, err
+	}
+
+	owned[key] = managed
+	s.saveOwnedOrWarn(owned)
+	return res, nil
+}
+
+// statePath 返回条目记录文件路径
+func (s *WAFSink) statePath() string {
+	return filepath.Join(s.config.WAF.StateDir, s.config.Name+".json")
+}
+
+// loadOwned 读取各规则中本工具添加的条目，键为规则ID；文件不存在或已损坏时视为没有添加过任何条目
+func (s *WAFSink) loadOwned() map[string][]string {
+	owned := make(map[string][]string)
+	data, err := os.ReadFile(s.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("警告: 读取写入目标 %s 的条目记录失败: %v", s.config.Name, err)
+		}
+		return owned
+	}
+	if err := json.Unmarshal(data, &owned); err != nil {
+		log.Printf("警告: 写入目标 %s 的条目记录已损坏，现有条目都将视为他人添加: %v", s.config.Name, err)
+		return make(map[string][]string)
+	}
+	return owned
+}
+
+// saveOwned 保存各规则中本工具添加的条目
+func (s *WAFSink) saveOwned(owned map[string][]string) error {
+	data, err := json.MarshalIndent(owned, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.config.WAF.StateDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.statePath(), data)
+}
+
+// saveOwnedOrWarn 保存条目记录，失败时只记录日志
+func (s *WAFSink) saveOwnedOrWarn(owned map[string][]string) {
+	if err := s.saveOwned(owned); err != nil {
+		log.Printf("警告: 保存写入目标 %s 的条目记录失败: %v", s.config.Name, err)
+	}
+}
+
+// ipCondition 返回规则配置中的"IP 属于"条件
+func ipCondition(ruleConfig map[string]interface{}) (map[string]interface{}, error) {
+	conditions, _ := ruleConfig["conditions"].([]interface{})
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, _ := condition["key"].(string)
+		op, _ := condition["opValue"].(string)
+		if strings.EqualFold(key, "IP") && op == "contain" {
+			return condition, nil
+		}
+	}
+	return nil, fmt.Errorf("规则中没有 \"IP 属于\"（key=IP, opValue=contain）匹配条件，请先在控制台添加")
+}
+
+// splitIPValues 解析条件中逗号分隔的IP列表，条件值可以是字符串或数组，返回去除空白后的原始条目
+func splitIPValues(value interface{}) []string {
+	var raw []string
+	switch v := value.(type) {
+	case string:
+		raw = strings.Split(v, ",")
+	case []interface{}:
+		for _, item := range v {
+			raw = append(raw, strings.Split(fmt.Sprint(item), ",")...)
+		}
+	}
+
+	var entries []string
+	for _, entry := range raw {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// joinIPValues 按条件值原有的形式生成新的值：数组时每个元素一个条目，否则为逗号分隔的字符串
+func joinIPValues(original interface{}, entries []string) interface{} {
+	if _, ok := original.([]interface{}); ok {
+		values := make([]interface{}, 0, len(entries))
+		for _, entry := range entries {
+			values = append(values, entry)
+		}
+		return values
+	}
+	return strings.Join(entries, ",")
+}
+
+// canonicalValue 返回条目的规范形式，无法解析的条目原样返回
+func canonicalValue(entry string) string {
+	if prefix, err := ipset.ParsePrefix(entry); err == nil {
+		return prefix.String()
+	}
+	return entry
+}
+
+// unionEntries 返回两组条目的并集，保持先后顺序
+func unionEntries(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var union []string
+	for _, entry := range append(append([]string{}, a...), b...) {
+		if !seen[entry] {
+			seen[entry] = true
+			union = append(union, entry)
+		}
+	}
+	return union
+}
@@ -0,0 +1,265 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// 签名相关的请求头，签名内容为 "<时间戳>.<请求体>"
+const (
+	webhookTimestampHeader = "X-Dcdn-Sync-Timestamp"
+	webhookSignatureHeader = "X-Dcdn-Sync-Signature"
+)
+
+// webhookPayload 推送的内容，同时作为请求体模板的数据
+type webhookPayload struct {
+	Sink    string    `json:"sink"`
+	Group   string    `json:"group"`
+	Entries []string  `json:"entries"`
+	Added   []string  `json:"added"`   // 相对该地址上次推送成功的内容新增的条目
+	Removed []string  `json:"removed"` // 相对该地址上次推送成功的内容移除的条目
+	Count   int       `json:"count"`
+	SHA256  string    `json:"sha256"` // 每行一个条目（含末尾换行）的摘要，用于接收方校验
+	Time    time.Time `json:"time"`
+}
+
+// webhookDelivery 一个地址最近一次推送成功的内容
+type webhookDelivery struct {
+	Entries     []string  `json:"entries"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// WebhookSink 将期望列表和差异推送到配置的HTTP地址，供自建防火墙、设备等使用
+// 每个地址单独计算差异，推送成功的内容记录在状态文件中，重启后差异仍然相对上次推送成功的内容
+type WebhookSink struct {
+	config     config.SinkConfig
+	client     *http.Client
+	template   *template.Template
+	expectBody *regexp.Regexp
+	secret     string
+}
+
+// NewWebhookSink 创建Webhook写入目标
+func NewWebhookSink(cfg config.SinkConfig) (*WebhookSink, error) {
+	webhook := cfg.Webhook
+	timeout, err := time.ParseDuration(webhook.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("写入目标 %s 的timeout无效: %v", cfg.Name, err)
+	}
+
+	s := &WebhookSink{
+		config: cfg,
+		client: &http.Client{Timeout: timeout},
+		secret: webhook.Secret,
+	}
+	if s.secret == "" && webhook.SecretEnv != "" {
+		s.secret = os.Getenv(webhook.SecretEnv)
+	}
+	if webhook.BodyTemplate != "" {
+		if s.template, err = config.ParseWebhookTemplate(cfg.Name, webhook.BodyTemplate); err != nil {
+			return nil, fmt.Errorf("写入目标 %s 的body_template无效: %v", cfg.Name, err)
+		}
+	}
+	if webhook.ExpectBody != "" {
+		if s.expectBody, err = regexp.Compile(webhook.ExpectBody); err != nil {
+			return nil, fmt.Errorf("写入目标 %s 的expect_body无效: %v", cfg.Name, err)
+		}
+	}
+	return s, nil
+}
+
+// Name 返回写入目标名称
+func (s *WebhookSink) Name() string {
+	return s.config.Name
+}
+
+// Sync 逐个地址推送，某个地址失败时继续处理其他地址
+func (s *WebhookSink) Sync(ctx context.Context, desired []string) ([]models.SinkResult, error) {
+	deliveries := s.loadDeliveries()
+	sum := sha256.Sum256([]byte(strings.Join(desired, "\n") + "\n"))
+	digest := hex.EncodeToString(sum[:])
+
+	delivered := false
+	results, err := syncEach("", s.config.Webhook.URLs, func(url string) (models.SinkResult, error) {
+		res := result(s.config, url)
+		previous, known := deliveries[url]
+		added, removed := diffEntries(previous.Entries, desired)
+		if known && len(added) == 0 && len(removed) == 0 && !s.config.Webhook.SendUnchanged {
+			log.Printf("写入目标 %s: %s 无需推送（条目 %d）", s.config.Name, url, len(desired))
+			return res, nil
+		}
+
+		body, err := s.render(webhookPayload{
+			Sink:    s.config.Name,
+			Group:   s.config.Group,
+			Entries: desired,
+			Added:   nonNil(added),
+			Removed: nonNil(removed),
+			Count:   len(desired),
+			SHA256:  digest,
+			Time:    time.Now(),
+		})
+		if err == nil {
+			err = s.post(ctx, url, body)
+		}
+		if err != nil {
+			return res, err
+		}
+
+		log.Printf("写入目标 %s: 已推送到 %s，新增 %d 条，移除 %d 条", s.config.Name, url, len(added), len(removed))
+		deliveries[url] = webhookDelivery{Entries: desired, DeliveredAt: time.Now()}
+		delivered = true
+		res.Added, res.Removed = added, removed
+		return res, nil
+	})
+
+	if delivered {
+		if err := s.saveDeliveries(deliveries); err != nil {
+			log.Printf("警告: 保存写入目标 %s 的推送记录失败，下次将重新推送: %v", s.config.Name, err)
+		}
+	}
+	return results, err
+}
+
+// render 生成请求体，未配置模板时为JSON
+func (s *WebhookSink) render(payload webhookPayload) ([]byte, error) {
+	if s.template == nil {
+		return json.Marshal(payload)
+	}
+	var b bytes.Buffer
+	if err := s.template.Execute(&b, payload); err != nil {
+		return nil, fmt.Errorf("渲染请求体模板失败: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// post 推送到单个地址并校验响应，不在此重试，限流、服务端错误和网络错误由调度器统一重试
+func (s *WebhookSink) post(ctx context.Context, url string, body []byte) error {
+	webhook := s.config.Webhook
+	req, err := http.NewRequestWithContext(ctx, webhook.Method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", webhook.ContentType)
+	req.Header.Set("User-Agent", "aliyun-dcdn-firewall-sync")
+	for key, value := range webhook.Headers {
+		req.Header.Set(key, value)
+	}
+	if s.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(s.secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return client.HTTPError(err, 0)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return client.HTTPError(err, 0)
+	}
+
+	if !s.statusAccepted(resp.StatusCode) {
+		return client.HTTPError(fmt.Errorf("HTTP %s: %s", resp.Status, truncate(data)), resp.StatusCode)
+	}
+	if s.expectBody != nil && !s.expectBody.Match(data) {
+		return fmt.Errorf("响应体不匹配expect_body: %s", truncate(data))
+	}
+	return nil
+}
+
+// statusAccepted 判断响应状态码是否视为成功
+func (s *WebhookSink) statusAccepted(statusCode int) bool {
+	if len(s.config.Webhook.ExpectStatus) > 0 {
+		return slices.Contains(s.config.Webhook.ExpectStatus, statusCode)
+	}
+	return statusCode >= 200 && statusCode < 300
+}
+
+// statePath 返回推送记录文件路径
+func (s *WebhookSink) statePath() string {
+	return filepath.Join(s.config.Webhook.StateDir, s.config.Name+".json")
+}
+
+// loadDeliveries 读取各地址的推送记录，文件不存在或已损坏时视为从未推送
+func (s *WebhookSink) loadDeliveries() map[string]webhookDelivery {
+	deliveries := make(map[string]webhookDelivery)
+	data, err := os.ReadFile(s.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("警告: 读取写入目标 %s 的推送记录失败: %v", s.config.Name, err)
+		}
+		return deliveries
+	}
+	if err := json.Unmarshal(data, &deliveries); err != nil {
+		log.Printf("警告: 写入目标 %s 的推送记录已损坏，将重新推送: %v", s.config.Name, err)
+		return make(map[string]webhookDelivery)
+	}
+	return deliveries
+}
+
+// saveDeliveries 保存各地址的推送记录，已不在配置中的地址不再保留
+func (s *WebhookSink) saveDeliveries(deliveries map[string]webhookDelivery) error {
+	current := make(map[string]webhookDelivery, len(deliveries))
+	for _, url := range s.config.Webhook.URLs {
+		if delivery, ok := deliveries[url]; ok {
+			current[url] = delivery
+		}
+	}
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.config.Webhook.StateDir, 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.statePath(), data)
+}
+
+// signWebhook 计算 "<时间戳>.<请求体>" 的HMAC-SHA256签名，接收方可据此校验来源并拒绝过期的请求
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nonNil 将nil切片转换为空切片，使JSON中为 [] 而不是 null
+func nonNil(entries []string) []string {
+	if entries == nil {
+		return []string{}
+	}
+	return entries
+}
+
+// truncate 截断响应体用于错误信息，按字符截断，避免截断多字节字符
+func truncate(data []byte) string {
+	const limit = 200
+	text := []rune(strings.TrimSpace(string(data)))
+	if len(text) > limit {
+		return string(text[:limit]) + "..."
+	}
+	return string(text)
+}
@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+)
+
+func newWebhookTestSink(t *testing.T, urls ...string) *WebhookSink {
+	t.Helper()
+	s, err := NewWebhookSink(config.SinkConfig{
+		Name:  "appliance",
+		Type:  "webhook",
+		Group: "dcdn-source-ips-v4",
+		Webhook: config.WebhookSinkConfig{
+			URLs:        urls,
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Timeout:     "5s",
+			StateDir:    t.TempDir(),
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookSink 返回错误: %v", err)
+	}
+	return s
+}
+
+func TestWebhookSinkLeavesRetriesToScheduler(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "稍后再试", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := newWebhookTestSink(t, server.URL)
+	desired := []string{"10.0.0.0/24"}
+
+	_, err := s.Sync(context.Background(), desired)
+	if err == nil {
+		t.Fatal("503 应返回错误")
+	}
+	if !client.IsRetryable(err) {
+		t.Errorf("503 应为可重试错误: %v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("Sync 发送了 %d 次请求，期望 1 次（重试由调度器负责）", requests)
+	}
+
+	results, err := s.Sync(context.Background(), desired)
+	if err != nil {
+		t.Fatalf("再次 Sync 返回错误: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Added) != 1 {
+		t.Errorf("再次 Sync 的结果为 %+v，期望新增 1 条", results)
+	}
+
+	// 内容未变化时不再推送
+	if _, err := s.Sync(context.Background(), desired); err != nil {
+		t.Fatalf("第三次 Sync 返回错误: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("共发送 %d 次请求，期望 2 次", requests)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("错", 250)
+	got := truncate([]byte("  " + long + "\n"))
+	if !utf8.ValidString(got) {
+		t.Fatalf("truncate 截断了多字节字符: %q", got)
+	}
+	if want := strings.Repeat("错", 200) + "..."; got != want {
+		t.Errorf("truncate 返回 %d 个字符，期望 200 个字符加省略号", utf8.RuneCountInString(got))
+	}
+	if got := truncate([]byte(" ok \n")); got != "ok" {
+		t.Errorf("truncate(%q) = %q, 期望 %q", " ok \n", got, "ok")
+	}
+}
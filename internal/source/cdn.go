@@ -0,0 +1,35 @@
+package source
+
+import (
+	"context"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// CDNSource 经典CDN加速域名的L2节点IP
+// 每个域名单独查询，结果合并去重，条目的Origin记录所属域名
+type CDNSource struct {
+	name    string
+	domains []string
+	client  *client.CDNClient
+}
+
+// NewCDNSource 创建CDN数据源
+func NewCDNSource(name string, domains []string, cdnClient *client.CDNClient) *CDNSource {
+	return &CDNSource{name: name, domains: domains, client: cdnClient}
+}
+
+// Name 返回数据源名称
+func (s *CDNSource) Name() string {
+	return s.name
+}
+
+// Fetch 查询全部域名并合并结果，任一域名查询失败时本次获取失败，避免地址薄缺少部分域名的节点
+func (s *CDNSource) Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
+	domains, err := s.client.QueryDomains(ctx, s.domains)
+	if err != nil {
+		return nil, err
+	}
+	return client.MergeDomainIPs(domains), nil
+}
@@ -0,0 +1,31 @@
+package source
+
+import (
+	"context"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// ESASource ESA站点的回源IP白名单
+// 多个站点的结果合并去重，条目的Origin记录所属站点
+type ESASource struct {
+	name    string
+	siteIDs []int64
+	client  *client.ESAClient
+}
+
+// NewESASource 创建ESA数据源
+func NewESASource(name string, siteIDs []int64, esaClient *client.ESAClient) *ESASource {
+	return &ESASource{name: name, siteIDs: siteIDs, client: esaClient}
+}
+
+// Name 返回数据源名称
+func (s *ESASource) Name() string {
+	return s.name
+}
+
+// Fetch 查询全部站点并合并结果，任一站点查询失败时本次获取失败
+func (s *ESASource) Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
+	return s.client.QuerySites(ctx, s.siteIDs)
+}
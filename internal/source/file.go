@@ -0,0 +1,49 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// FileSource 从本地文件读取IP列表，支持txt、csv、json、yaml格式
+type FileSource struct {
+	name   string
+	config config.SourceConfig
+}
+
+// NewFileSource 创建文件数据源
+func NewFileSource(name string, cfg config.SourceConfig) *FileSource {
+	return &FileSource{name: name, config: cfg}
+}
+
+// Name 返回数据源名称
+func (s *FileSource) Name() string {
+	return s.name
+}
+
+// Fetch 读取并解析文件中的条目
+func (s *FileSource) Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
+	info, err := os.Stat(s.config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件信息失败: %w", err)
+	}
+	data, err := os.ReadFile(s.config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+
+	format := s.config.Format
+	if format == "" {
+		format = detectFormat(s.config.Path, "")
+	}
+	entries, err := parseEntries(data, format, s.config.Field)
+	if err != nil {
+		return nil, fmt.Errorf("文件 %s: %w", s.config.Path, err)
+	}
+
+	return buildSourceIPs(s.name, "file:"+s.config.Path, "File", entries, s.config.InvalidRatio(), info.ModTime())
+}
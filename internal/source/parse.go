@@ -0,0 +1,262 @@
+package source
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// defaultFields 未配置field时，对象数组中依次尝试的字段名
+var defaultFields = []string{"ip", "cidr", "prefix", "ip_prefix", "ipv6_prefix", "address"}
+
+// detectFormat 根据文件名或Content-Type判断内容格式，无法判断时按txt处理
+func detectFormat(name, contentType string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return "csv"
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".txt", ".list", ".conf":
+		return "txt"
+	}
+
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		return "json"
+	case strings.Contains(contentType, "yaml"):
+		return "yaml"
+	case strings.Contains(contentType, "csv"):
+		return "csv"
+	}
+	return "txt"
+}
+
+// parseEntries 按格式从内容中提取条目字符串
+func parseEntries(data []byte, format, field string) ([]string, error) {
+	switch format {
+	case "csv":
+		return parseCSV(data, field)
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析JSON失败: %w", err)
+		}
+		return requireEntries(collectField(doc, field))
+	case "yaml":
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析YAML失败: %w", err)
+		}
+		return requireEntries(collectField(doc, field))
+	default:
+		return parseText(data), nil
+	}
+}
+
+// requireEntries 结构化内容中没有提取到任何条目时，多半是format或field配置与内容不符
+func requireEntries(entries []string) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("内容中没有找到IP条目，请检查format和field配置")
+	}
+	return entries, nil
+}
+
+// parseText 解析文本内容：# 之后为注释，每行可以有多个以空白或逗号分隔的条目
+func parseText(data []byte) []string {
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		entries = append(entries, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\r'
+		})...)
+	}
+	return entries
+}
+
+// parseCSV 解析CSV内容，field为列名或列号，默认第一列
+// 未按列名指定时，第一行该列若不是IP地址则视为表头
+func parseCSV(data []byte, field string) ([]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	column := 0
+	index, indexErr := strconv.Atoi(field)
+	if field != "" && indexErr == nil {
+		if index < 0 {
+			return nil, fmt.Errorf("CSV列号不能为负数: %s", field)
+		}
+		column = index
+	}
+
+	var entries []string
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析CSV失败: %w", err)
+		}
+
+		if first {
+			first = false
+			if field != "" && indexErr != nil {
+				// 按列名查找
+				column = -1
+				for i, name := range record {
+					if strings.EqualFold(strings.TrimSpace(name), field) {
+						column = i
+						break
+					}
+				}
+				if column < 0 {
+					return nil, fmt.Errorf("CSV表头中没有列 %s", field)
+				}
+				continue
+			}
+			if column < len(record) {
+				if _, err := ipset.ParsePrefix(record[column]); err != nil {
+					continue // 表头
+				}
+			}
+		}
+
+		if column < len(record) {
+			entries = append(entries, strings.TrimSpace(record[column]))
+		}
+	}
+	return entries, nil
+}
+
+// collectField 从JSON/YAML文档中按字段路径提取字符串，遇到数组时对每个元素继续提取
+func collectField(doc interface{}, field string) []string {
+	var parts []string
+	if field != "" {
+		parts = strings.Split(field, ".")
+	}
+
+	var result []string
+	var visit func(value interface{}, parts []string)
+	visit = func(value interface{}, parts []string) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				visit(item, parts)
+			}
+		case string:
+			if len(parts) == 0 {
+				result = append(result, v)
+			}
+		default:
+			fields, ok := toStringMap(value)
+			if !ok {
+				return
+			}
+			if len(parts) > 0 {
+				visit(fields[parts[0]], parts[1:])
+				return
+			}
+			// 未指定字段路径时使用常见字段名，对象中没有这些字段时继续查找其中的数组
+			if field == "" {
+				for _, name := range defaultFields {
+					if s, ok := fields[name].(string); ok {
+						result = append(result, s)
+						return
+					}
+				}
+				keys := make([]string, 0, len(fields))
+				for key := range fields {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					if list, ok := fields[key].([]interface{}); ok {
+						visit(list, nil)
+					}
+				}
+			}
+		}
+	}
+	visit(doc, parts)
+	return result
+}
+
+// toStringMap 将JSON或YAML解析出的对象转换为以字符串为键的map
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = item
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// buildSourceIPs 校验条目并转换为源IP信息
+// 解析失败的条目被丢弃，比例超过maxInvalidRatio或没有任何可用条目时返回错误，避免格式变化导致地址薄被清空
+func buildSourceIPs(name, origin, location string, entries []string, maxInvalidRatio float64, updated time.Time) ([]*models.DCDNSourceIPInfo, error) {
+	var result []*models.DCDNSourceIPInfo
+	var invalid []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, err := ipset.ParsePrefix(entry); err != nil {
+			invalid = append(invalid, entry)
+			continue
+		}
+		result = append(result, &models.DCDNSourceIPInfo{
+			IP:          entry,
+			Location:    location,
+			ISP:         name,
+			Status:      "Active",
+			LastUpdated: updated,
+			Origin:      origin,
+		})
+	}
+
+	total := len(result) + len(invalid)
+	if len(invalid) > 0 {
+		ratio := float64(len(invalid)) / float64(total)
+		sample := invalid
+		if len(sample) > 5 {
+			sample = sample[:5]
+		}
+		if ratio > maxInvalidRatio {
+			return nil, fmt.Errorf("%s 中 %d/%d 条无法解析（%.1f%%），超过允许的 %.1f%%，示例: %v",
+				origin, len(invalid), total, ratio*100, maxInvalidRatio*100, sample)
+		}
+		log.Printf("警告: %s 中 %d/%d 条无法解析，已跳过，示例: %v", origin, len(invalid), total, sample)
+	}
+	// 空列表会让写入端清空地址薄，内容为空多半是文件被截断或服务端异常，按获取失败处理
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%s 中没有任何可用的条目", origin)
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// Source IP列表数据源
+type Source interface {
+	// Name 数据源名称，用于日志
+	Name() string
+	// Fetch 获取数据源当前的IP列表
+	Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error)
+}
+
+// Registry 根据地址组表达式中的名称创建数据源
+// 数据源实例在注册表中复用，URL数据源的缓存状态因此可以跨任务保留
+type Registry struct {
+	config   *config.Config
+	cacheDir string
+
+	mu        sync.Mutex
+	sources   map[string]Source
+	cdnClient *client.CDNClient // 首次使用CDN数据源时创建
+	esaClient *client.ESAClient // 首次使用ESA数据源时创建
+}
+
+// NewRegistry 创建数据源注册表
+func NewRegistry(cfg *config.Config) *Registry {
+	return &Registry{
+		config:   cfg,
+		cacheDir: filepath.Join(filepath.Dir(cfg.State.Path), "sources"),
+		sources:  make(map[string]Source),
+	}
+}
+
+// Lookup 返回表达式名称对应的数据源
+// 内置的dcdn_l2和group:引用由调用方处理
+func (r *Registry) Lookup(term string) (Source, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if source, ok := r.sources[term]; ok {
+		return source, nil
+	}
+	source, err := r.create(term)
+	if err != nil {
+		return nil, err
+	}
+	r.sources[term] = source
+	return source, nil
+}
+
+// create 创建表达式名称对应的数据源
+func (r *Registry) create(term string) (Source, error) {
+	kind, ref := config.ParseSourceTerm(term)
+
+	switch kind {
+	case "":
+		if ref == config.SourceCDNL2 {
+			return r.cdnSource(ref, r.config.CDN.Domains)
+		}
+		if ref == config.SourceESAOrigin {
+			return r.esaSource(ref, r.config.ESA.SiteIDs)
+		}
+		source := r.config.FindSource(ref)
+		if source == nil {
+			return nil, fmt.Errorf("未定义的数据源: %s", ref)
+		}
+		return r.fromConfig(source)
+	case "static":
+		source := r.config.FindSource(ref)
+		if source == nil || source.Type != "static" {
+			return nil, fmt.Errorf("未定义的static数据源: %s", ref)
+		}
+		return r.fromConfig(source)
+	case "file":
+		return NewFileSource(term, config.SourceConfig{Name: term, Type: "file", Path: ref}), nil
+	case "url":
+		return NewURLSource(term, config.SourceConfig{Name: term, Type: "url", URL: ref}, r.cacheDir), nil
+	case "cdn":
+		return r.cdnSource(term, []string{ref})
+	case "esa":
+		siteID, err := strconv.ParseInt(ref, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的ESA站点ID: %s", ref)
+		}
+		return r.esaSource(term, []int64{siteID})
+	default:
+		return nil, fmt.Errorf("不支持的数据源类型: %s", kind)
+	}
+}
+
+// fromConfig 根据具名数据源配置创建数据源
+func (r *Registry) fromConfig(source *config.SourceConfig) (Source, error) {
+	switch source.Type {
+	case "static":
+		return NewStaticSource(source.Name, source.Entries), nil
+	case "file":
+		return NewFileSource(source.Name, *source), nil
+	case "url":
+		return NewURLSource(source.Name, *source, r.cacheDir), nil
+	case "cdn":
+		return r.cdnSource(source.Name, source.Domains)
+	case "esa":
+		return r.esaSource(source.Name, source.SiteIDs)
+	default:
+		return nil, fmt.Errorf("数据源 %s 不支持的类型: %s", source.Name, source.Type)
+	}
+}
+
+// cdnSource 创建CDN数据源，所有CDN数据源共用一个使用cdn段配置的客户端
+func (r *Registry) cdnSource(name string, domains []string) (Source, error) {
+	if r.cdnClient == nil {
+		cdnClient, err := client.NewCDNClient(&r.config.CDN)
+		if err != nil {
+			return nil, err
+		}
+		r.cdnClient = cdnClient
+	}
+	return NewCDNSource(name, domains, r.cdnClient), nil
+}
+
+// esaSource 创建ESA数据源，所有ESA数据源共用一个使用esa段配置的客户端
+func (r *Registry) esaSource(name string, siteIDs []int64) (Source, error) {
+	if r.esaClient == nil {
+		esaClient, err := client.NewESAClient(&r.config.ESA)
+		if err != nil {
+			return nil, err
+		}
+		r.esaClient = esaClient
+	}
+	return NewESASource(name, siteIDs, r.esaClient), nil
+}
@@ -0,0 +1,41 @@
+package source
+
+import (
+	"context"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// StaticSource 配置中直接列出的条目
+type StaticSource struct {
+	name    string
+	entries []string
+}
+
+// NewStaticSource 创建静态数据源
+func NewStaticSource(name string, entries []string) *StaticSource {
+	return &StaticSource{name: name, entries: entries}
+}
+
+// Name 返回数据源名称
+func (s *StaticSource) Name() string {
+	return s.name
+}
+
+// Fetch 返回配置的条目
+func (s *StaticSource) Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
+	now := time.Now()
+	result := make([]*models.DCDNSourceIPInfo, 0, len(s.entries))
+	for _, entry := range s.entries {
+		result = append(result, &models.DCDNSourceIPInfo{
+			IP:          entry,
+			Location:    "Static",
+			ISP:         s.name,
+			Status:      "Static",
+			LastUpdated: now,
+			Origin:      "static:" + s.name,
+		})
+	}
+	return result, nil
+}
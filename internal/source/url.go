@@ -0,0 +1,270 @@
+package source
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/models"
+)
+
+// maxBodySize 单次下载内容的大小上限
+const maxBodySize = 32 << 20
+
+// URLSource 从HTTP(S)地址获取IP列表
+// 使用ETag/Last-Modified条件请求，内容未变化时使用本地缓存；缓存只保存校验和解析都通过的内容
+type URLSource struct {
+	name     string
+	config   config.SourceConfig
+	client   *http.Client
+	cacheDir string
+}
+
+// urlCacheMeta 缓存的元数据
+type urlCacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	SHA256       string    `json:"sha256"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// NewURLSource 创建URL数据源
+func NewURLSource(name string, cfg config.SourceConfig, cacheDir string) *URLSource {
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		if d, err := time.ParseDuration(cfg.Timeout); err == nil {
+			timeout = d
+		}
+	}
+	if cfg.CacheDir != "" {
+		cacheDir = cfg.CacheDir
+	}
+
+	return &URLSource{
+		name:     name,
+		config:   cfg,
+		client:   &http.Client{Timeout: timeout},
+		cacheDir: cacheDir,
+	}
+}
+
+// Name 返回数据源名称
+func (s *URLSource) Name() string {
+	return s.name
+}
+
+// Fetch 下载、校验并解析IP列表
+func (s *URLSource) Fetch(ctx context.Context) ([]*models.DCDNSourceIPInfo, error) {
+	meta, cached := s.loadCache()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err)
+	}
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if cached != nil {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, client.HTTPError(err, 0)
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		log.Printf("数据源 %s: 内容未变化，使用缓存（获取于 %s）", s.name, meta.FetchedAt.Format("2006-01-02 15:04:05"))
+		body = cached
+	case resp.StatusCode == http.StatusOK:
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+		if err != nil {
+			return nil, client.HTTPError(err, 0)
+		}
+		if len(body) > maxBodySize {
+			return nil, fmt.Errorf("内容超过 %d 字节上限", maxBodySize)
+		}
+		if err := s.verify(ctx, body); err != nil {
+			return nil, err
+		}
+		meta = &urlCacheMeta{
+			URL:          s.config.URL,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+			FetchedAt:    time.Now(),
+		}
+	default:
+		return nil, client.HTTPError(fmt.Errorf("HTTP %s", resp.Status), resp.StatusCode)
+	}
+
+	format := s.config.Format
+	if format == "" {
+		format = detectFormat(req.URL.Path, meta.ContentType)
+	}
+	entries, err := parseEntries(body, format, s.config.Field)
+	if err != nil {
+		return nil, err
+	}
+	result, err := buildSourceIPs(s.name, "url:"+s.config.URL, "URL", entries, s.config.InvalidRatio(), meta.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if err := s.saveCache(meta, body); err != nil {
+			log.Printf("警告: 保存数据源 %s 的缓存失败: %v", s.name, err)
+		}
+	}
+	return result, nil
+}
+
+// verify 按配置校验内容摘要和签名
+func (s *URLSource) verify(ctx context.Context, body []byte) error {
+	sum := sha256.Sum256(body)
+	digest := hex.EncodeToString(sum[:])
+
+	if s.config.Checksum != "" {
+		expected := strings.TrimPrefix(s.config.Checksum, "sha256:")
+		if !strings.EqualFold(expected, digest) {
+			return fmt.Errorf("内容摘要不匹配: 期望 %s，实际 %s", expected, digest)
+		}
+	}
+
+	if s.config.ChecksumURL != "" {
+		data, err := s.download(ctx, s.config.ChecksumURL)
+		if err != nil {
+			return fmt.Errorf("获取摘要文件失败: %w", err)
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) == 0 || !strings.EqualFold(fields[0], digest) {
+			return fmt.Errorf("内容摘要与摘要文件不匹配: 实际 %s", digest)
+		}
+	}
+
+	if s.config.SignatureURL != "" {
+		key, err := base64.StdEncoding.DecodeString(s.config.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("public_key不是有效的base64 Ed25519公钥")
+		}
+		data, err := s.download(ctx, s.config.SignatureURL)
+		if err != nil {
+			return fmt.Errorf("获取签名文件失败: %w", err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("签名文件不是有效的base64: %w", err)
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), body, signature) {
+			return fmt.Errorf("内容签名校验失败")
+		}
+	}
+	return nil
+}
+
+// download 下载校验用的小文件
+func (s *URLSource) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, client.HTTPError(err, 0)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, client.HTTPError(fmt.Errorf("HTTP %s", resp.Status), resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// cachePaths 返回缓存文件路径，按URL的摘要命名
+func (s *URLSource) cachePaths() (metaPath, bodyPath string) {
+	sum := sha256.Sum256([]byte(s.config.URL))
+	base := filepath.Join(s.cacheDir, hex.EncodeToString(sum[:8]))
+	return base + ".json", base + ".body"
+}
+
+// loadCache 读取缓存，缓存不存在或已损坏时返回nil
+func (s *URLSource) loadCache() (*urlCacheMeta, []byte) {
+	metaPath, bodyPath := s.cachePaths()
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil
+	}
+	var meta urlCacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.URL != s.config.URL {
+		return nil, nil
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, nil
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != meta.SHA256 {
+		log.Printf("警告: 数据源 %s 的缓存内容与摘要不一致，忽略缓存", s.name)
+		return nil, nil
+	}
+	return &meta, body
+}
+
+// saveCache 保存内容和元数据
+func (s *URLSource) saveCache(meta *urlCacheMeta, body []byte) error {
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return fmt.Errorf("创建缓存目录失败: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+	meta.SHA256 = hex.EncodeToString(sum[:])
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// 先写内容再写元数据，元数据中的摘要用于发现不完整的缓存
+	metaPath, bodyPath := s.cachePaths()
+	if err := writeFileAtomic(bodyPath, body); err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath, data)
+}
+
+// writeFileAtomic 先写临时文件再重命名
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,156 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// GroupState 单个地址组的持久化状态
+type GroupState struct {
+	GroupName      string    `json:"group_name"`
+	LastApplied    []string  `json:"last_applied"`              // 最近一次由本工具写入的完整地址列表
+	LastAppliedAt  time.Time `json:"last_applied_at"`           // 最近一次写入时间
+	AdoptedAdds    []string  `json:"adopted_adds,omitempty"`    // drift_policy为adopt时接受的外部新增
+	AdoptedRemoves []string  `json:"adopted_removes,omitempty"` // drift_policy为adopt时接受的外部删除
+	// 最近一次同步成功时地址组的完整期望列表；启用分片或设置skip_address_book的地址组也在地址组名下记录
+	LastSynced   []string  `json:"last_synced,omitempty"`
+	LastSyncedAt time.Time `json:"last_synced_at"`
+	// ownership为managed时由本工具添加、归本工具管理的条目
+	Owned []string `json:"owned,omitempty"`
+	// 是否已按managed模式记录过归属，用于区分"没有归属条目"和"尚未开始记录"
+	OwnershipTracked bool `json:"ownership_tracked,omitempty"`
+	// 源列表中每个条目的出现记录，用于移除前的宽限期判断
+	Entries map[string]*EntryState `json:"entries,omitempty"`
+	// 启用分片时当前使用的分片地址薄名称
+	Shards []string `json:"shards,omitempty"`
+	// 已不再使用、因被引用等原因未能删除的旧分片，之后继续尝试删除
+	StaleShards []string `json:"stale_shards,omitempty"`
+	// 最近一次已通知的漂移指纹，同一处外部修改持续存在时不重复通知
+	DriftFingerprint string `json:"drift_fingerprint,omitempty"`
+}
+
+// EntryState 单个条目在源列表中的出现记录
+type EntryState struct {
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	MissingRuns int       `json:"missing_runs"` // 连续缺失的同步次数
+	// 最近一次出现时的来源信息，宽限期内保留的条目沿用
+	Location string `json:"location,omitempty"`
+	ISP      string `json:"isp,omitempty"`
+	Origin   string `json:"origin,omitempty"`
+}
+
+// File 状态文件内容
+type File struct {
+	Version   int                    `json:"version"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Groups    map[string]*GroupState `json:"groups"`
+}
+
+// Store 基于本地JSON文件的状态存储
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data *File
+}
+
+// Open 打开状态文件，文件不存在时返回空状态
+func Open(path string) (*Store, error) {
+	store := &Store{
+		path: path,
+		data: &File{Version: 1, Groups: make(map[string]*GroupState)},
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取状态文件失败: %w", err)
+	}
+
+	if err := json.Unmarshal(data, store.data); err != nil {
+		return nil, fmt.Errorf("解析状态文件失败: %w", err)
+	}
+	if store.data.Groups == nil {
+		store.data.Groups = make(map[string]*GroupState)
+	}
+
+	return store, nil
+}
+
+// Group 返回地址组状态的副本，不存在时返回nil
+func (s *Store) Group(name string) *GroupState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.data.Groups[name]
+	if !ok {
+		return nil
+	}
+	copied := *group
+	if group.Entries != nil {
+		copied.Entries = make(map[string]*EntryState, len(group.Entries))
+		for ip, entry := range group.Entries {
+			e := *entry
+			copied.Entries[ip] = &e
+		}
+	}
+	return &copied
+}
+
+// Update 修改地址组状态并立即写入文件
+func (s *Store) Update(name string, fn func(group *GroupState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, ok := s.data.Groups[name]
+	if !ok {
+		group = &GroupState{GroupName: name}
+		s.data.Groups[name] = group
+	}
+	fn(group)
+
+	return s.saveLocked()
+}
+
+// Delete 删除地址组状态并立即写入文件，用于清理已删除的分片
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.Groups[name]; !ok {
+		return nil
+	}
+	delete(s.data.Groups, name)
+
+	return s.saveLocked()
+}
+
+// saveLocked 原子写入状态文件（先写临时文件再重命名）
+func (s *Store) saveLocked() error {
+	s.data.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态失败: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("创建状态目录失败: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入状态文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("替换状态文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package ipset
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// 表达式运算符
+const (
+	OpUnion        = "∪" // 并集，也可写作 + 或 |
+	OpIntersection = "∩" // 交集（按地址范围），也可写作 &
+	OpDifference   = "−" // 差集（按地址范围），也可写作 - 或 ∖
+)
+
+// asciiOps ASCII形式的运算符，只有前后有空白时才视为运算符，避免与名称、路径中的字符混淆
+var asciiOps = map[string]string{
+	"+": OpUnion,
+	"|": OpUnion,
+	"&": OpIntersection,
+	"-": OpDifference,
+}
+
+// unicodeOps Unicode形式的运算符，任何位置都视为运算符
+var unicodeOps = map[string]string{
+	"∪": OpUnion,
+	"∩": OpIntersection,
+	"−": OpDifference,
+	"∖": OpDifference,
+}
+
+// Resolver 将表达式中的名称解析为集合
+type Resolver func(term string) (*Set, error)
+
+// Expr 集合表达式，如 dcdn_l2 ∪ static:office − file:blocked.txt
+// 交集优先级高于并集和差集，并集和差集从左到右计算，可以使用括号
+type Expr interface {
+	// Eval 计算表达式，名称通过resolve解析
+	Eval(resolve Resolver) (*Set, error)
+	// Terms 返回表达式中引用的全部名称，按出现顺序去重
+	Terms() []string
+	// String 返回规范化的表达式文本
+	String() string
+}
+
+// termExpr 名称
+type termExpr struct {
+	name string
+}
+
+// Eval 实现Expr接口
+func (e *termExpr) Eval(resolve Resolver) (*Set, error) {
+	set, err := resolve(e.name)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %w", e.name, err)
+	}
+	if set == nil {
+		set = &Set{}
+	}
+	return set, nil
+}
+
+// Terms 实现Expr接口
+func (e *termExpr) Terms() []string { return []string{e.name} }
+
+// String 实现Expr接口
+func (e *termExpr) String() string { return e.name }
+
+// binaryExpr 二元运算
+type binaryExpr struct {
+	op          string
+	left, right Expr
+}
+
+// Eval 实现Expr接口
+func (e *binaryExpr) Eval(resolve Resolver) (*Set, error) {
+	left, err := e.left.Eval(resolve)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.Eval(resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case OpUnion:
+		return left.Union(right), nil
+	case OpIntersection:
+		return left.Restrict(right), nil
+	default:
+		return left.Exclude(right), nil
+	}
+}
+
+// Terms 实现Expr接口
+func (e *binaryExpr) Terms() []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, term := range append(e.left.Terms(), e.right.Terms()...) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// String 实现Expr接口
+func (e *binaryExpr) String() string {
+	return "(" + e.left.String() + " " + e.op + " " + e.right.String() + ")"
+}
+
+// ParseExpr 解析集合表达式
+func ParseExpr(text string) (Expr, error) {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("表达式为空")
+	}
+
+	p := &exprParser{tokens: tokens, end: utf8.RuneCountInString(text) + 1}
+	expr, err := p.parseUnion()
+	if err != nil {
+		return nil, fmt.Errorf("表达式 %q %w", text, err)
+	}
+	if p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		return nil, fmt.Errorf("表达式 %q 第 %d 个字符 %q 处有多余的内容", text, tok.col, tok.text)
+	}
+	return expr, nil
+}
+
+// token 词法单元
+type token struct {
+	text string
+	op   string // 运算符或括号，名称为空
+	col  int    // 在表达式中的位置，按字符从1开始计数
+}
+
+// tokenize 按空白、Unicode运算符和括号切分表达式
+func tokenize(text string) []token {
+	var tokens []token
+	var current strings.Builder
+	start := 0
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		word := current.String()
+		current.Reset()
+		if op, ok := asciiOps[word]; ok {
+			tokens = append(tokens, token{text: word, op: op, col: start})
+			return
+		}
+		tokens = append(tokens, token{text: word, col: start})
+	}
+
+	col := 0
+	for _, r := range text {
+		col++
+		ch := string(r)
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		case ch == "(" || ch == ")":
+			flush()
+			tokens = append(tokens, token{text: ch, op: ch, col: col})
+		case unicodeOps[ch] != "":
+			flush()
+			tokens = append(tokens, token{text: ch, op: unicodeOps[ch], col: col})
+		default:
+			if current.Len() == 0 {
+				start = col
+			}
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// exprParser 递归下降解析器
+type exprParser struct {
+	tokens []token
+	pos    int
+	end    int // 表达式末尾的位置，用于报告缺少的内容
+}
+
+// parseUnion 解析并集和差集
+func (p *exprParser) parseUnion() (Expr, error) {
+	left, err := p.parseIntersection()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) {
+		op := p.tokens[p.pos].op
+		if op != OpUnion && op != OpDifference {
+			break
+		}
+		p.pos++
+		right, err := p.parseIntersection()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseIntersection 解析交集
+func (p *exprParser) parseIntersection() (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].op == OpIntersection {
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: OpIntersection, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parsePrimary 解析名称或括号内的表达式
+func (p *exprParser) parsePrimary() (Expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("不完整，第 %d 个字符处缺少运算数", p.end)
+	}
+
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.op {
+	case "":
+		return &termExpr{name: tok.text}, nil
+	case "(":
+		expr, err := p.parseUnion()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].op != ")" {
+			return nil, fmt.Errorf("第 %d 个字符处的左括号缺少对应的右括号", tok.col)
+		}
+		p.pos++
+		return expr, nil
+	default:
+		return nil, fmt.Errorf("第 %d 个字符 %q 处缺少运算数", tok.col, tok.text)
+	}
+}
@@ -0,0 +1,145 @@
+package ipset
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		terms []string
+	}{
+		{"dcdn_l2", "dcdn_l2", []string{"dcdn_l2"}},
+		// 交集优先于并集和差集
+		{"a ∪ b ∩ c", "(a ∪ (b ∩ c))", []string{"a", "b", "c"}},
+		{"a ∩ b − c", "((a ∩ b) − c)", []string{"a", "b", "c"}},
+		// 并集和差集从左到右计算
+		{"a − b ∪ c", "((a − b) ∪ c)", []string{"a", "b", "c"}},
+		{"a ∪ b − c", "((a ∪ b) − c)", []string{"a", "b", "c"}},
+		{"a − b − c", "((a − b) − c)", []string{"a", "b", "c"}},
+		// 括号
+		{"a − (b ∪ c)", "(a − (b ∪ c))", []string{"a", "b", "c"}},
+		{"(a ∪ b) ∩ c", "((a ∪ b) ∩ c)", []string{"a", "b", "c"}},
+		{"((a))", "a", []string{"a"}},
+		// ASCII运算符
+		{"a + b | c", "((a ∪ b) ∪ c)", []string{"a", "b", "c"}},
+		{"a - b & c", "(a − (b ∩ c))", []string{"a", "b", "c"}},
+		// Unicode运算符不需要空白，∖ 等同于 −
+		{"a∪b∖c", "((a ∪ b) − c)", []string{"a", "b", "c"}},
+		{"(a∪b)∩c", "((a ∪ b) ∩ c)", []string{"a", "b", "c"}},
+		// 名称中的ASCII运算符字符不视为运算符
+		{"file:a-b.txt - url:https://x/y+z|w&v", "(file:a-b.txt − url:https://x/y+z|w&v)", []string{"file:a-b.txt", "url:https://x/y+z|w&v"}},
+		// 重复的名称只返回一次
+		{"\ta ∪\n b − a", "((a ∪ b) − a)", []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.in)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) 返回错误: %v", tt.in, err)
+			continue
+		}
+		if got := expr.String(); got != tt.want {
+			t.Errorf("ParseExpr(%q) = %s, 期望 %s", tt.in, got, tt.want)
+		}
+		if got := expr.Terms(); !slices.Equal(got, tt.terms) {
+			t.Errorf("ParseExpr(%q).Terms() = %v, 期望 %v", tt.in, got, tt.terms)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "表达式为空"},
+		{"  ", "表达式为空"},
+		{"a ∪", "第 4 个字符处缺少运算数"},
+		{"a −   ", "第 7 个字符处缺少运算数"},
+		{"∪ a", `第 1 个字符 "∪" 处缺少运算数`},
+		{"a ∪ ∩ b", `第 5 个字符 "∩" 处缺少运算数`},
+		{"a - - b", `第 5 个字符 "-" 处缺少运算数`},
+		{"a ∪ )", `第 5 个字符 ")" 处缺少运算数`},
+		{"a b", `第 3 个字符 "b" 处有多余的内容`},
+		{"(a ∪ b) c", `第 9 个字符 "c" 处有多余的内容`},
+		{"a ∪ b)", `第 6 个字符 ")" 处有多余的内容`},
+		{"a ∪ (b − (c ∩ d)", "第 5 个字符处的左括号缺少对应的右括号"},
+		{"(a", "第 1 个字符处的左括号缺少对应的右括号"},
+		{"()", `第 2 个字符 ")" 处缺少运算数`},
+	}
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.in)
+		if err == nil {
+			t.Errorf("ParseExpr(%q) 应返回错误", tt.in)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseExpr(%q) 错误为 %q, 期望包含 %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	sets := map[string]*Set{
+		"dcdn":    mustSet(t, "10.0.0.0/24", "192.168.0.0/24", "2001:db8::/64"),
+		"blocked": mustSet(t, "10.0.0.5", "192.168.0.128/25"),
+		"v4":      mustSet(t, "0.0.0.0/0"),
+		"office":  mustSet(t, "172.16.0.1"),
+		"narrow":  mustSet(t, "10.0.0.64/26", "10.1.0.0/16"),
+	}
+	resolve := func(term string) (*Set, error) {
+		if set, ok := sets[term]; ok {
+			return set, nil
+		}
+		if term == "empty" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("未定义的数据源: %s", term)
+	}
+
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"dcdn ∪ office", []string{"10.0.0.0/24", "172.16.0.1/32", "192.168.0.0/24", "2001:db8::/64"}},
+		// 差集把网段拆分为不含被排除地址的最少网段
+		{"dcdn − blocked", []string{
+			"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31", "10.0.0.8/29", "10.0.0.16/28",
+			"10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25", "192.168.0.0/25", "2001:db8::/64",
+		}},
+		// 交集按地址范围计算，结果可以比两边的网段都小
+		{"dcdn ∩ narrow", []string{"10.0.0.64/26"}},
+		{"dcdn ∩ v4 − blocked ∩ narrow", []string{
+			"10.0.0.0/24", "192.168.0.0/24",
+		}},
+		{"(dcdn ∩ v4 − blocked) ∩ narrow", []string{"10.0.0.64/26"}},
+		{"dcdn − dcdn ∪ office", []string{"172.16.0.1/32"}},
+		{"office ∪ empty", []string{"172.16.0.1/32"}},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.in)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) 返回错误: %v", tt.in, err)
+		}
+		result, err := expr.Eval(resolve)
+		if err != nil {
+			t.Errorf("Eval(%q) 返回错误: %v", tt.in, err)
+			continue
+		}
+		if got := result.Strings(); !slices.Equal(got, tt.want) {
+			t.Errorf("Eval(%q) = %v, 期望 %v", tt.in, got, tt.want)
+		}
+	}
+
+	expr, err := ParseExpr("dcdn − missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := expr.Eval(resolve); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("未定义的名称应返回错误: %v", err)
+	}
+}
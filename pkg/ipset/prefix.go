@@ -0,0 +1,162 @@
+package ipset
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix 解析IP或CIDR并转换为规范形式
+// 去除首尾空白，单个IP视为/32或/128，清除主机位，IPv4映射的IPv6地址按IPv4处理
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Prefix{}, fmt.Errorf("空的地址")
+	}
+
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("无效的CIDR %q: %w", s, err)
+		}
+		addr := prefix.Addr()
+		if addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("无效的IP地址 %q: %w", s, err)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Canonical 返回地址的规范字符串，始终为CIDR形式，如 1.2.3.4/32
+func Canonical(s string) (string, error) {
+	prefix, err := ParsePrefix(s)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
+
+// Compare 按地址族、起始地址、前缀长度比较两个网段，IPv4排在IPv6之前
+func Compare(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// LastAddr 返回网段中的最后一个地址
+func LastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	hostBits := len(bytes)*8 - prefix.Bits()
+	for i := len(bytes) - 1; i >= 0 && hostBits > 0; i-- {
+		if hostBits >= 8 {
+			bytes[i] = 0xff
+		} else {
+			bytes[i] |= byte(1<<hostBits) - 1
+		}
+		hostBits -= 8
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// RangeToPrefixes 将连续地址区间[start, end]拆分为最少的CIDR网段
+func RangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	var result []netip.Prefix
+	for {
+		prefix := netip.PrefixFrom(start, start.BitLen())
+		for bits := 0; bits <= start.BitLen(); bits++ {
+			candidate := netip.PrefixFrom(start, bits).Masked()
+			if candidate.Addr() == start && LastAddr(candidate).Compare(end) <= 0 {
+				prefix = candidate
+				break
+			}
+		}
+		result = append(result, prefix)
+
+		last := LastAddr(prefix)
+		if last.Compare(end) >= 0 {
+			return result
+		}
+		start = last.Next()
+	}
+}
+
+// Merge 将已排序且互不重叠的网段合并为覆盖相同地址的最少网段
+func Merge(prefixes []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+
+	i := 0
+	for i < len(prefixes) {
+		start := prefixes[i].Addr()
+		end := LastAddr(prefixes[i])
+
+		// 合并首尾相连的网段，组成连续地址区间
+		j := i + 1
+		for j < len(prefixes) {
+			next := prefixes[j]
+			if next.Addr().Is4() != start.Is4() {
+				break
+			}
+			succ := end.Next()
+			if !succ.IsValid() || succ != next.Addr() {
+				break
+			}
+			end = LastAddr(next)
+			j++
+		}
+
+		result = append(result, RangeToPrefixes(start, end)...)
+		i = j
+	}
+	return result
+}
+
+// bitAt 返回地址第i位（从最高位开始计数）的值
+func bitAt(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits 返回两个同族网段的公共前缀长度，不超过两者中较短的前缀长度
+func commonBits(a, b netip.Prefix) int {
+	limit := a.Bits()
+	if b.Bits() < limit {
+		limit = b.Bits()
+	}
+
+	var x, y []byte
+	if a.Addr().Is4() {
+		ax, bx := a.Addr().As4(), b.Addr().As4()
+		x, y = ax[:], bx[:]
+	} else {
+		ax, bx := a.Addr().As16(), b.Addr().As16()
+		x, y = ax[:], bx[:]
+	}
+
+	n := 0
+	for i := range x {
+		if x[i] == y[i] {
+			n += 8
+			continue
+		}
+		n += bits.LeadingZeros8(x[i] ^ y[i])
+		break
+	}
+	if n > limit {
+		n = limit
+	}
+	return n
+}
@@ -0,0 +1,451 @@
+// Package ipset 基于net/netip的IP网段集合，内部使用压缩前缀树（radix trie）存储
+//
+// 集合中的元素是规范化后的网段：1.2.3.4 与 1.2.3.4/32、1.2.3.1/24 与 1.2.3.0/24 视为同一个条目。
+// Union、Intersection、Difference 按条目计算；Contains、ContainsPrefix、Overlaps 按地址覆盖范围查询。
+package ipset
+
+import (
+	"net/netip"
+)
+
+// node 前缀树节点
+// 不含条目的节点只用于分叉，始终有两个子节点；叶子节点始终是条目
+type node struct {
+	prefix netip.Prefix
+	entry  bool
+	child  [2]*node
+}
+
+// Set IP网段集合，零值可直接使用，不支持并发写入
+type Set struct {
+	v4   *node
+	v6   *node
+	size int
+}
+
+// New 创建包含指定网段的集合
+func New(prefixes ...netip.Prefix) *Set {
+	s := &Set{}
+	for _, prefix := range prefixes {
+		s.Add(prefix)
+	}
+	return s
+}
+
+// FromStrings 解析字符串列表创建集合，返回无法解析的条目
+func FromStrings(entries []string) (*Set, []string) {
+	s := &Set{}
+	var invalid []string
+	for _, entry := range entries {
+		if err := s.AddString(entry); err != nil {
+			invalid = append(invalid, entry)
+		}
+	}
+	return s, invalid
+}
+
+// root 返回网段所属地址族的根节点指针
+func (s *Set) root(prefix netip.Prefix) **node {
+	if prefix.Addr().Is4() {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+// normalize 将网段转换为集合内部使用的规范形式
+func normalize(prefix netip.Prefix) (netip.Prefix, bool) {
+	if !prefix.IsValid() {
+		return netip.Prefix{}, false
+	}
+	addr := prefix.Addr()
+	if addr.Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96).Masked(), true
+	}
+	return netip.PrefixFrom(addr.WithZone(""), prefix.Bits()).Masked(), true
+}
+
+// Add 加入网段，返回集合是否发生变化
+func (s *Set) Add(prefix netip.Prefix) bool {
+	prefix, ok := normalize(prefix)
+	if !ok {
+		return false
+	}
+
+	link := s.root(prefix)
+	for {
+		n := *link
+		if n == nil {
+			*link = &node{prefix: prefix, entry: true}
+			s.size++
+			return true
+		}
+
+		common := commonBits(n.prefix, prefix)
+		switch {
+		case common == n.prefix.Bits() && common == prefix.Bits():
+			// 同一网段
+			if n.entry {
+				return false
+			}
+			n.entry = true
+			s.size++
+			return true
+
+		case common == n.prefix.Bits():
+			// 新网段在当前节点之下
+			link = &n.child[bitAt(prefix.Addr(), common)]
+
+		case common == prefix.Bits():
+			// 当前节点在新网段之下
+			parent := &node{prefix: prefix, entry: true}
+			parent.child[bitAt(n.prefix.Addr(), common)] = n
+			*link = parent
+			s.size++
+			return true
+
+		default:
+			// 在公共前缀处分叉
+			fork := &node{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+			leaf := &node{prefix: prefix, entry: true}
+			fork.child[bitAt(prefix.Addr(), common)] = leaf
+			fork.child[bitAt(n.prefix.Addr(), common)] = n
+			*link = fork
+			s.size++
+			return true
+		}
+	}
+}
+
+// AddString 解析并加入地址，无法解析时返回错误
+func (s *Set) AddString(entry string) error {
+	prefix, err := ParsePrefix(entry)
+	if err != nil {
+		return err
+	}
+	s.Add(prefix)
+	return nil
+}
+
+// Remove 移除网段（只移除完全相同的条目），返回集合是否发生变化
+func (s *Set) Remove(prefix netip.Prefix) bool {
+	prefix, ok := normalize(prefix)
+	if !ok {
+		return false
+	}
+
+	// 记录查找路径，移除后自下而上压缩不再需要的分叉节点
+	var path []**node
+	link := s.root(prefix)
+	for {
+		n := *link
+		if n == nil || n.prefix.Bits() > prefix.Bits() || !n.prefix.Contains(prefix.Addr()) {
+			return false
+		}
+		path = append(path, link)
+		if n.prefix.Bits() == prefix.Bits() {
+			break
+		}
+		link = &n.child[bitAt(prefix.Addr(), n.prefix.Bits())]
+	}
+
+	n := *link
+	if !n.entry {
+		return false
+	}
+	n.entry = false
+	s.size--
+
+	for i := len(path) - 1; i >= 0; i-- {
+		n := *path[i]
+		if n.entry {
+			break
+		}
+		switch {
+		case n.child[0] == nil && n.child[1] == nil:
+			*path[i] = nil
+		case n.child[0] == nil:
+			*path[i] = n.child[1]
+		case n.child[1] == nil:
+			*path[i] = n.child[0]
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// Has 判断集合中是否存在完全相同的网段
+func (s *Set) Has(prefix netip.Prefix) bool {
+	prefix, ok := normalize(prefix)
+	if !ok || s == nil {
+		return false
+	}
+
+	n := *s.root(prefix)
+	for n != nil && n.prefix.Bits() <= prefix.Bits() && n.prefix.Contains(prefix.Addr()) {
+		if n.prefix.Bits() == prefix.Bits() {
+			return n.entry
+		}
+		n = n.child[bitAt(prefix.Addr(), n.prefix.Bits())]
+	}
+	return false
+}
+
+// HasString 判断集合中是否存在与字符串表示相同的网段
+func (s *Set) HasString(entry string) bool {
+	prefix, err := ParsePrefix(entry)
+	if err != nil {
+		return false
+	}
+	return s.Has(prefix)
+}
+
+// Contains 判断地址是否被集合中的某个网段覆盖
+func (s *Set) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	return s.ContainsPrefix(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// ContainsPrefix 判断网段是否完全被集合中的某个网段覆盖
+func (s *Set) ContainsPrefix(prefix netip.Prefix) bool {
+	prefix, ok := normalize(prefix)
+	if !ok || s == nil {
+		return false
+	}
+
+	n := *s.root(prefix)
+	for n != nil && n.prefix.Bits() <= prefix.Bits() && n.prefix.Contains(prefix.Addr()) {
+		if n.entry {
+			return true
+		}
+		if n.prefix.Bits() == prefix.Bits() {
+			return false
+		}
+		n = n.child[bitAt(prefix.Addr(), n.prefix.Bits())]
+	}
+	return false
+}
+
+// Overlaps 判断网段是否与集合中的任一网段有地址重叠
+func (s *Set) Overlaps(prefix netip.Prefix) bool {
+	prefix, ok := normalize(prefix)
+	if !ok || s == nil {
+		return false
+	}
+
+	n := *s.root(prefix)
+	for n != nil {
+		if !n.prefix.Overlaps(prefix) {
+			return false
+		}
+		// 节点在网段之内，子树中至少有一个条目
+		if n.prefix.Bits() >= prefix.Bits() {
+			return true
+		}
+		if n.entry {
+			return true
+		}
+		n = n.child[bitAt(prefix.Addr(), n.prefix.Bits())]
+	}
+	return false
+}
+
+// Len 返回集合中的条目数
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return s.size
+}
+
+// Walk 按Compare顺序遍历集合中的条目，fn返回false时停止
+func (s *Set) Walk(fn func(prefix netip.Prefix) bool) {
+	if s == nil {
+		return
+	}
+	if walk(s.v4, fn) {
+		walk(s.v6, fn)
+	}
+}
+
+// walk 先序遍历子树，返回是否继续
+func walk(n *node, fn func(prefix netip.Prefix) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.entry && !fn(n.prefix) {
+		return false
+	}
+	return walk(n.child[0], fn) && walk(n.child[1], fn)
+}
+
+// Prefixes 返回排序后的全部条目
+func (s *Set) Prefixes() []netip.Prefix {
+	result := make([]netip.Prefix, 0, s.Len())
+	s.Walk(func(prefix netip.Prefix) bool {
+		result = append(result, prefix)
+		return true
+	})
+	return result
+}
+
+// Strings 返回排序后的全部条目的规范字符串
+func (s *Set) Strings() []string {
+	result := make([]string, 0, s.Len())
+	s.Walk(func(prefix netip.Prefix) bool {
+		result = append(result, prefix.String())
+		return true
+	})
+	return result
+}
+
+// Roots 返回未被集合中其他网段覆盖的条目，结果互不重叠
+func (s *Set) Roots() []netip.Prefix {
+	var result []netip.Prefix
+	var visit func(n *node)
+	visit = func(n *node) {
+		if n == nil {
+			return
+		}
+		if n.entry {
+			result = append(result, n.prefix)
+			return
+		}
+		visit(n.child[0])
+		visit(n.child[1])
+	}
+	if s != nil {
+		visit(s.v4)
+		visit(s.v6)
+	}
+	return result
+}
+
+// Compact 返回覆盖相同地址的最少网段组成的集合：去除被覆盖的子网并合并相邻网段
+func (s *Set) Compact() *Set {
+	return New(Merge(s.Roots())...)
+}
+
+// Clone 返回集合的副本
+func (s *Set) Clone() *Set {
+	if s == nil {
+		return &Set{}
+	}
+	return &Set{v4: cloneNode(s.v4), v6: cloneNode(s.v6), size: s.size}
+}
+
+// cloneNode 复制子树
+func cloneNode(n *node) *node {
+	if n == nil {
+		return nil
+	}
+	copied := &node{prefix: n.prefix, entry: n.entry}
+	copied.child[0] = cloneNode(n.child[0])
+	copied.child[1] = cloneNode(n.child[1])
+	return copied
+}
+
+// Union 返回两个集合的并集
+func (s *Set) Union(other *Set) *Set {
+	result := s.Clone()
+	other.Walk(func(prefix netip.Prefix) bool {
+		result.Add(prefix)
+		return true
+	})
+	return result
+}
+
+// Intersection 返回同时存在于两个集合中的条目
+func (s *Set) Intersection(other *Set) *Set {
+	result := &Set{}
+	s.Walk(func(prefix netip.Prefix) bool {
+		if other.Has(prefix) {
+			result.Add(prefix)
+		}
+		return true
+	})
+	return result
+}
+
+// Difference 返回存在于s而不存在于other中的条目
+func (s *Set) Difference(other *Set) *Set {
+	result := &Set{}
+	s.Walk(func(prefix netip.Prefix) bool {
+		if !other.Has(prefix) {
+			result.Add(prefix)
+		}
+		return true
+	})
+	return result
+}
+
+// Equal 判断两个集合的条目是否完全相同
+func (s *Set) Equal(other *Set) bool {
+	if s.Len() != other.Len() {
+		return false
+	}
+	equal := true
+	s.Walk(func(prefix netip.Prefix) bool {
+		equal = other.Has(prefix)
+		return equal
+	})
+	return equal
+}
+
+// Exclude 返回去除other覆盖的地址后剩余的地址
+// 按地址范围计算：条目与other部分重叠时拆分为不与other重叠的最大网段
+func (s *Set) Exclude(other *Set) *Set {
+	result := &Set{}
+	s.Walk(func(prefix netip.Prefix) bool {
+		excludePrefix(result, prefix, other)
+		return true
+	})
+	return result
+}
+
+// excludePrefix 将prefix中不被other覆盖的部分加入result
+func excludePrefix(result *Set, prefix netip.Prefix, other *Set) {
+	switch {
+	case !other.Overlaps(prefix):
+		result.Add(prefix)
+	case other.ContainsPrefix(prefix):
+	default:
+		low, high := halves(prefix)
+		excludePrefix(result, low, other)
+		excludePrefix(result, high, other)
+	}
+}
+
+// Restrict 返回同时被s和other覆盖的地址
+// 按地址范围计算：条目只有部分被other覆盖时拆分为被覆盖的最大网段
+func (s *Set) Restrict(other *Set) *Set {
+	result := &Set{}
+	s.Walk(func(prefix netip.Prefix) bool {
+		restrictPrefix(result, prefix, other)
+		return true
+	})
+	return result
+}
+
+// restrictPrefix 将prefix中被other覆盖的部分加入result
+func restrictPrefix(result *Set, prefix netip.Prefix, other *Set) {
+	switch {
+	case !other.Overlaps(prefix):
+	case other.ContainsPrefix(prefix):
+		result.Add(prefix)
+	default:
+		low, high := halves(prefix)
+		restrictPrefix(result, low, other)
+		restrictPrefix(result, high, other)
+	}
+}
+
+// halves 将网段平分为两个前缀长度加一的子网段，调用方保证网段不是单个地址
+func halves(prefix netip.Prefix) (netip.Prefix, netip.Prefix) {
+	low := netip.PrefixFrom(prefix.Addr(), prefix.Bits()+1)
+	high := netip.PrefixFrom(LastAddr(low).Next(), prefix.Bits()+1)
+	return low, high
+}
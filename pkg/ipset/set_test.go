@@ -0,0 +1,235 @@
+package ipset
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func mustSet(t *testing.T, entries ...string) *Set {
+	t.Helper()
+	s, invalid := FromStrings(entries)
+	if len(invalid) > 0 {
+		t.Fatalf("无法解析的条目: %v", invalid)
+	}
+	return s
+}
+
+func TestParsePrefixCanonical(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"1.2.3.4", "1.2.3.4/32"},
+		{" 1.2.3.4 ", "1.2.3.4/32"},
+		{"1.2.3.9/24", "1.2.3.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::1/32", "2001:db8::/32"},
+		{"::ffff:1.2.3.4", "1.2.3.4/32"},
+		{"::ffff:1.2.3.0/120", "1.2.3.0/24"},
+		{"fe80::1%eth0", "fe80::1/128"},
+	}
+	for _, tt := range tests {
+		got, err := Canonical(tt.in)
+		if err != nil {
+			t.Errorf("Canonical(%q) 返回错误: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Canonical(%q) = %q, 期望 %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "1.2.3", "1.2.3.4/33", "example.com"} {
+		if _, err := ParsePrefix(in); err == nil {
+			t.Errorf("ParsePrefix(%q) 应返回错误", in)
+		}
+	}
+}
+
+func TestSetAddRemoveHas(t *testing.T) {
+	s := &Set{}
+	if !s.Add(netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Fatal("首次加入应改变集合")
+	}
+	if s.Add(netip.MustParsePrefix("10.1.2.3/8")) {
+		t.Error("主机位不同的同一网段不应重复加入")
+	}
+	if !s.Add(netip.MustParsePrefix("10.1.0.0/16")) {
+		t.Error("子网是独立的条目")
+	}
+	if !s.Add(netip.MustParsePrefix("::ffff:10.2.0.0/112")) {
+		t.Error("4in6网段应按IPv4加入")
+	}
+	if s.Len() != 3 {
+		t.Fatalf("Len() = %d, 期望 3", s.Len())
+	}
+	if !s.Has(netip.MustParsePrefix("10.2.0.0/16")) {
+		t.Error("4in6网段应以IPv4形式存在")
+	}
+	if s.Has(netip.MustParsePrefix("10.1.0.0/24")) {
+		t.Error("Has只匹配完全相同的网段")
+	}
+	if !s.HasString("10.1.0.0/16") || s.HasString("not-an-ip") {
+		t.Error("HasString 结果不正确")
+	}
+
+	if s.Remove(netip.MustParsePrefix("10.1.0.0/24")) {
+		t.Error("不存在的网段不应被移除")
+	}
+	if !s.Remove(netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Fatal("应移除 10.0.0.0/8")
+	}
+	if s.Has(netip.MustParsePrefix("10.0.0.0/8")) || !s.Has(netip.MustParsePrefix("10.1.0.0/16")) {
+		t.Error("移除父网段不应影响子网")
+	}
+	if s.Remove(netip.MustParsePrefix("10.0.0.0/8")) {
+		t.Error("重复移除不应改变集合")
+	}
+	if got := s.Strings(); !slices.Equal(got, []string{"10.1.0.0/16", "10.2.0.0/16"}) {
+		t.Errorf("Strings() = %v", got)
+	}
+
+	// 零值和nil集合可以直接查询
+	var empty *Set
+	if empty.Len() != 0 || empty.Has(netip.MustParsePrefix("1.2.3.4/32")) || empty.Contains(netip.MustParseAddr("1.2.3.4")) {
+		t.Error("nil集合应为空")
+	}
+}
+
+func TestSetContainsAndOverlaps(t *testing.T) {
+	s := mustSet(t, "10.0.0.0/8", "192.168.1.0/24", "2001:db8::/32")
+
+	addrs := []struct {
+		addr string
+		want bool
+	}{
+		{"10.255.0.1", true},
+		{"11.0.0.1", false},
+		{"192.168.1.200", true},
+		{"192.168.2.1", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range addrs {
+		if got := s.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("Contains(%s) = %v, 期望 %v", tt.addr, got, tt.want)
+		}
+	}
+
+	prefixes := []struct {
+		prefix             string
+		contains, overlaps bool
+	}{
+		{"10.1.0.0/16", true, true},
+		{"10.0.0.0/8", true, true},
+		{"10.0.0.0/7", false, true},
+		{"192.168.0.0/16", false, true},
+		{"192.168.1.128/25", true, true},
+		{"172.16.0.0/12", false, false},
+		{"0.0.0.0/0", false, true},
+		{"2001:db8:1::/48", true, true},
+		{"2001::/16", false, true},
+		{"2002::/16", false, false},
+	}
+	for _, tt := range prefixes {
+		p := netip.MustParsePrefix(tt.prefix)
+		if got := s.ContainsPrefix(p); got != tt.contains {
+			t.Errorf("ContainsPrefix(%s) = %v, 期望 %v", tt.prefix, got, tt.contains)
+		}
+		if got := s.Overlaps(p); got != tt.overlaps {
+			t.Errorf("Overlaps(%s) = %v, 期望 %v", tt.prefix, got, tt.overlaps)
+		}
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	a := mustSet(t, "1.1.1.1", "2.2.2.0/24", "10.0.0.0/8")
+	b := mustSet(t, "1.1.1.1/32", "10.0.0.0/16", "2001:db8::/32")
+
+	if got := a.Union(b).Strings(); !slices.Equal(got, []string{"1.1.1.1/32", "2.2.2.0/24", "10.0.0.0/8", "10.0.0.0/16", "2001:db8::/32"}) {
+		t.Errorf("Union = %v", got)
+	}
+	if got := a.Intersection(b).Strings(); !slices.Equal(got, []string{"1.1.1.1/32"}) {
+		t.Errorf("Intersection = %v", got)
+	}
+	if got := a.Difference(b).Strings(); !slices.Equal(got, []string{"2.2.2.0/24", "10.0.0.0/8"}) {
+		t.Errorf("Difference = %v", got)
+	}
+	if !a.Equal(mustSet(t, "10.0.0.0/8", "2.2.2.9/24", "1.1.1.1")) || a.Equal(b) {
+		t.Error("Equal 结果不正确")
+	}
+	if got := a.Roots(); !slices.Equal(got, []netip.Prefix{
+		netip.MustParsePrefix("1.1.1.1/32"),
+		netip.MustParsePrefix("2.2.2.0/24"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+	}) {
+		t.Errorf("Roots = %v", got)
+	}
+}
+
+func TestSetExcludeRestrict(t *testing.T) {
+	s := mustSet(t, "10.0.0.0/24", "192.168.0.0/24")
+	other := mustSet(t, "10.0.0.5", "192.168.0.0/16")
+
+	excluded := s.Exclude(other)
+	if excluded.Contains(netip.MustParseAddr("10.0.0.5")) || excluded.Overlaps(netip.MustParsePrefix("192.168.0.0/24")) {
+		t.Errorf("Exclude 结果仍包含被排除的地址: %v", excluded.Strings())
+	}
+	for _, addr := range []string{"10.0.0.0", "10.0.0.4", "10.0.0.6", "10.0.0.255"} {
+		if !excluded.Contains(netip.MustParseAddr(addr)) {
+			t.Errorf("Exclude 结果缺少 %s", addr)
+		}
+	}
+	if got := excluded.Strings(); !slices.Equal(got, []string{
+		"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31", "10.0.0.8/29", "10.0.0.16/28",
+		"10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25",
+	}) {
+		t.Errorf("Exclude = %v", got)
+	}
+
+	restricted := mustSet(t, "10.0.0.0/8").Restrict(mustSet(t, "10.1.0.0/16", "10.2.3.0/24", "11.0.0.0/8"))
+	if got := restricted.Strings(); !slices.Equal(got, []string{"10.1.0.0/16", "10.2.3.0/24"}) {
+		t.Errorf("Restrict = %v", got)
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       []string
+	}{
+		{"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}},
+		{"10.0.0.1", "10.0.0.1", []string{"10.0.0.1/32"}},
+		{"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"2001:db8::", "2001:db8::3", []string{"2001:db8::/126"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range RangeToPrefixes(netip.MustParseAddr(tt.start), netip.MustParseAddr(tt.end)) {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("RangeToPrefixes(%s, %s) = %v, 期望 %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merged := Merge(mustSet(t, "10.0.0.0/25", "10.0.0.128/25", "10.0.1.0/24", "10.0.3.0/24", "2001:db8::/33", "2001:db8:8000::/33").Roots())
+	var got []string
+	for _, p := range merged {
+		got = append(got, p.String())
+	}
+	if want := []string{"10.0.0.0/23", "10.0.3.0/24", "2001:db8::/32"}; !slices.Equal(got, want) {
+		t.Errorf("Merge = %v, 期望 %v", got, want)
+	}
+
+	// 地址族边界不合并
+	boundary := Merge([]netip.Prefix{netip.MustParsePrefix("255.255.255.255/32"), netip.MustParsePrefix("::/128")})
+	if len(boundary) != 2 {
+		t.Errorf("不同地址族的网段不应合并: %v", boundary)
+	}
+}
@@ -11,6 +11,7 @@ type DCDNSourceIPInfo struct {
 	ISP         string    `json:"isp"`
 	Status      string    `json:"status"`
 	LastUpdated time.Time `json:"last_updated"`
+	Origin      string    `json:"origin,omitempty"` // 条目来源，如 dcdn_l2、file:/etc/ips.txt、url:https://...
 }
 
 // DCDNDomainInfo 表示DCDN域名信息
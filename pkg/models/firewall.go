@@ -15,11 +15,88 @@ type FirewallAddressEntry struct {
 
 // FirewallAddressBook 云防火墙地址簿
 type FirewallAddressBook struct {
-	GroupName   string                 `json:"group_name"`
-	GroupId     string                 `json:"group_id"`
-	Description string                 `json:"description"`
-	Entries     []FirewallAddressEntry `json:"entries"`
-	UpdateTime  time.Time              `json:"update_time"`
+	GroupName      string                 `json:"group_name"`
+	GroupId        string                 `json:"group_id"`
+	GroupType      string                 `json:"group_type"` // ip, ipv6
+	Description    string                 `json:"description"`
+	ReferenceCount int                    `json:"reference_count"` // 被访问控制策略引用的次数
+	Entries        []FirewallAddressEntry `json:"entries"`
+	UpdateTime     time.Time              `json:"update_time"`
+}
+
+// AddressList 返回地址薄中的全部地址
+func (b *FirewallAddressBook) AddressList() []string {
+	list := make([]string, 0, len(b.Entries))
+	for _, entry := range b.Entries {
+		list = append(list, entry.IP)
+	}
+	return list
+}
+
+// AddressBookSpec 要写入的地址薄
+type AddressBookSpec struct {
+	GroupName   string `json:"group_name"`
+	Description string `json:"description"`
+	GroupType   string `json:"group_type"` // ip, ipv6
+}
+
+// AddressBookPlan 地址薄同步计划
+type AddressBookPlan struct {
+	GroupName   string    `json:"group_name"`
+	GroupType   string    `json:"group_type"`
+	Description string    `json:"description"`
+	GroupId     string    `json:"group_id,omitempty"`
+	Exists      bool      `json:"exists"`   // 计划时地址薄是否已存在
+	Desired     []string  `json:"desired"`  // 写入后的完整地址列表
+	Observed    []string  `json:"observed"` // 计划时读取到的地址列表
+	ToAdd       []string  `json:"to_add"`
+	ToRemove    []string  `json:"to_remove"`
+	PlannedAt   time.Time `json:"planned_at"`
+	// ownership为managed时写入后归本工具管理的条目，full模式下为空
+	Owned []string `json:"owned,omitempty"`
+	// 已从源列表消失、仍在宽限期内而保留的条目
+	PendingRemoval []PendingRemoval `json:"pending_removal,omitempty"`
+}
+
+// PendingRemoval 等待移除的条目
+type PendingRemoval struct {
+	IP            string    `json:"ip"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	MissingRuns   int       `json:"missing_runs"`
+	RemainingRuns int       `json:"remaining_runs,omitempty"` // 还需缺失的同步次数
+	RemoveAfter   time.Time `json:"remove_after,omitempty"`   // 最早移除时间
+}
+
+// AddressBookConflict 计划与写入之间地址薄被外部修改的记录
+type AddressBookConflict struct {
+	GroupName      string    `json:"group_name"`
+	Reason         string    `json:"reason"`
+	ForeignAdded   []string  `json:"foreign_added,omitempty"`   // 计划后被外部新增的条目
+	ForeignRemoved []string  `json:"foreign_removed,omitempty"` // 计划后被外部删除的条目
+	Action         string    `json:"action"`                    // aborted, replanned
+	DetectedAt     time.Time `json:"detected_at"`
+}
+
+// NormalizeReport CIDR归一化结果统计
+type NormalizeReport struct {
+	GroupName      string  `json:"group_name"`
+	Mode           string  `json:"mode"`
+	Before         int     `json:"before"`          // 归一化前条目数
+	After          int     `json:"after"`           // 归一化后条目数
+	Duplicates     int     `json:"duplicates"`      // 重复条目数
+	Covered        int     `json:"covered"`         // 被更大网段覆盖的条目数
+	ExtraAddresses float64 `json:"extra_addresses"` // aggregate模式额外覆盖的地址数，lossless模式为0
+}
+
+// DriftEvent 地址薄在两次同步之间被外部修改的事件
+type DriftEvent struct {
+	GroupName      string    `json:"group_name"`
+	ForeignAdded   []string  `json:"foreign_added,omitempty"`   // 上次写入后被外部新增的条目
+	ForeignRemoved []string  `json:"foreign_removed,omitempty"` // 上次写入后被外部删除的条目
+	Policy         string    `json:"policy"`                    // revert, adopt, alert
+	LastAppliedAt  time.Time `json:"last_applied_at"`
+	DetectedAt     time.Time `json:"detected_at"`
 }
 
 // AddAddressRequest 添加地址请求
@@ -46,12 +123,19 @@ type FirewallAPIResponse struct {
 
 // SyncTask 同步任务
 type SyncTask struct {
-	TaskId     string    `json:"task_id"`
-	Status     string    `json:"status"` // pending, running, completed, failed
-	StartTime  time.Time `json:"start_time"`
-	EndTime    time.Time `json:"end_time,omitempty"`
-	SourceIPs  []string  `json:"source_ips"`
-	AddedIPs   []string  `json:"added_ips"`
-	RemovedIPs []string  `json:"removed_ips"`
-	ErrorMsg   string    `json:"error_msg,omitempty"`
+	TaskId        string    `json:"task_id"`
+	Status        string    `json:"status"` // pending, running, completed, completed_with_errors, failed, canceled, interrupted
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time,omitempty"`
+	SourceIPs     []string  `json:"source_ips"`
+	AddedIPs      []string  `json:"added_ips"`
+	RemovedIPs    []string  `json:"removed_ips"`
+	SyncedGroups  []string  `json:"synced_groups,omitempty"`  // 已成功写入的地址薄
+	PendingGroups []string  `json:"pending_groups,omitempty"` // 任务停止时尚未写入的地址薄
+	ErrorMsg      string    `json:"error_msg,omitempty"`
+
+	Conflicts     []AddressBookConflict `json:"conflicts,omitempty"`     // 写入前检测到的并发修改
+	DriftEvents   []DriftEvent          `json:"drift_events,omitempty"`  // 两次同步之间的外部修改
+	Normalization []NormalizeReport     `json:"normalization,omitempty"` // 各地址组的归一化统计
+	SinkResults   []SinkResult          `json:"sink_results,omitempty"`  // 云防火墙地址薄以外的写入目标
 }
@@ -0,0 +1,38 @@
+package models
+
+// SinkResult 写入目标的同步结果
+type SinkResult struct {
+	Sink    string   `json:"sink"`
+	Type    string   `json:"type"`
+	Group   string   `json:"group"`
+	Target  string   `json:"target,omitempty"` // 实际写入的对象，如安全组ID
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// SecurityGroupRule ECS安全组规则
+type SecurityGroupRule struct {
+	RuleID      string `json:"rule_id"`
+	Direction   string `json:"direction"`
+	Protocol    string `json:"protocol"`   // 小写，如 tcp、udp、all
+	PortRange   string `json:"port_range"` // 如 443/443
+	SourceCIDR  string `json:"source_cidr"`
+	Policy      string `json:"policy"` // 小写，accept或drop
+	Priority    int    `json:"priority"`
+	NicType     string `json:"nic_type"`
+	Description string `json:"description"`
+}
+
+// ACLEntry 负载均衡访问控制列表条目
+type ACLEntry struct {
+	Entry       string `json:"entry"` // 规范CIDR
+	Description string `json:"description,omitempty"`
+}
+
+// WhitelistGroup 数据库实例的IP白名单分组
+type WhitelistGroup struct {
+	Name      string   `json:"name"`
+	Entries   []string `json:"entries"`             // 规范CIDR，无法解析的条目原样保留
+	Attribute string   `json:"attribute,omitempty"` // hidden表示控制台不可见的系统分组
+}
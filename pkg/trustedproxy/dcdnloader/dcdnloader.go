@@ -0,0 +1,50 @@
+// Package dcdnloader 通过DCDN接口加载L2节点IP的trustedproxy.Loader
+// 依赖阿里云SDK和同步工具的客户端实现，单独成包，只使用文件或状态文件的服务不需要引入
+package dcdnloader
+
+import (
+	"context"
+
+	"aliyun-dcdn-firewall-sync/internal/client"
+	"aliyun-dcdn-firewall-sync/internal/config"
+	"aliyun-dcdn-firewall-sync/pkg/trustedproxy"
+)
+
+// Options 通过DCDN接口加载L2节点IP时使用的账号配置
+// AccessKeyID为空时与同步工具相同，依次查找DCDN_ALIBABA_CLOUD_ACCESS_KEY_ID/SECRET、标准环境变量和默认凭证链
+type Options struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	Region          string  // 默认 ap-southeast-1
+	QPS             float64 // 客户端限流，默认与同步工具相同
+}
+
+// New 返回通过DescribeDcdnL2Ips加载账号下全部L2节点IP的Loader，与同步工具的dcdn_l2数据源一致
+func New(opts Options) (trustedproxy.Loader, error) {
+	cfg := &config.DCDNConfig{AliyunConfig: config.AliyunConfig{
+		AccessKeyId:     opts.AccessKeyID,
+		AccessKeySecret: opts.AccessKeySecret,
+		Region:          opts.Region,
+		QPS:             opts.QPS,
+	}}
+	if cfg.Region == "" {
+		cfg.Region = "ap-southeast-1"
+	}
+
+	dcdn, err := client.NewDCDNClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return trustedproxy.LoaderFunc(func(ctx context.Context) ([]string, error) {
+		ips, err := dcdn.GetL2IPList(ctx)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			entries = append(entries, ip.IP)
+		}
+		return entries, nil
+	}), nil
+}
@@ -0,0 +1,88 @@
+package trustedproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"aliyun-dcdn-firewall-sync/internal/state"
+)
+
+// Loader 加载可信代理网段，返回IP或CIDR列表
+// 通过DCDN接口加载的Loader位于子包dcdnloader，本包不依赖阿里云SDK
+type Loader interface {
+	Load(ctx context.Context) ([]string, error)
+}
+
+// LoaderFunc 函数形式的Loader
+type LoaderFunc func(ctx context.Context) ([]string, error)
+
+// Load 实现Loader接口
+func (f LoaderFunc) Load(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// FileLoader 返回从本地快照文件加载的Loader
+// 文件可以是字符串JSON数组，也可以是每行一个条目的文本；文本格式忽略空行和#开头的注释，
+// 每行取最后一个字段并去掉结尾的分号，因此也可以直接读取proxy_config写入目标生成的nginx和HAProxy文件
+func FileLoader(path string) Loader {
+	return LoaderFunc(func(ctx context.Context) ([]string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+			var entries []string
+			if err := json.Unmarshal(trimmed, &entries); err != nil {
+				return nil, fmt.Errorf("解析快照文件 %s 失败: %w", path, err)
+			}
+			return entries, nil
+		}
+
+		var entries []string
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			entries = append(entries, strings.TrimSuffix(fields[len(fields)-1], ";"))
+		}
+		return entries, nil
+	})
+}
+
+// StateLoader 返回从同步工具状态文件加载的Loader
+// 优先使用地址组最近一次同步成功的完整期望列表；旧版本的状态文件没有该记录时，
+// 使用地址组（启用分片时为各分片合并）最近一次写入地址薄的内容
+func StateLoader(path, group string) Loader {
+	return LoaderFunc(func(ctx context.Context) ([]string, error) {
+		store, err := state.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		gs := store.Group(group)
+		if gs == nil {
+			return nil, fmt.Errorf("状态文件 %s 中没有地址组 %s", path, group)
+		}
+		if len(gs.LastSynced) > 0 {
+			return gs.LastSynced, nil
+		}
+		if len(gs.Shards) == 0 {
+			return gs.LastApplied, nil
+		}
+
+		var entries []string
+		for _, name := range gs.Shards {
+			shard := store.Group(name)
+			if shard == nil {
+				return nil, fmt.Errorf("状态文件 %s 中没有地址组 %s 的分片 %s", path, group, name)
+			}
+			entries = append(entries, shard.LastApplied...)
+		}
+		return entries, nil
+	})
+}
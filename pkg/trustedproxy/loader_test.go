@@ -0,0 +1,62 @@
+package trustedproxy
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"aliyun-dcdn-firewall-sync/internal/state"
+)
+
+func TestStateLoader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(name string, fn func(gs *state.GroupState)) {
+		if err := store.Update(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 旧版本状态文件：未分片的地址组只有LastApplied
+	update("plain", func(gs *state.GroupState) { gs.LastApplied = []string{"10.0.0.1/32"} })
+	// 旧版本状态文件：分片地址组的内容在各分片名下
+	update("sharded", func(gs *state.GroupState) { gs.Shards = []string{"sharded-1", "sharded-2"} })
+	update("sharded-1", func(gs *state.GroupState) { gs.LastApplied = []string{"10.0.0.0/24"} })
+	update("sharded-2", func(gs *state.GroupState) { gs.LastApplied = []string{"192.168.0.0/24"} })
+	// 记录了完整期望列表时优先使用
+	update("synced", func(gs *state.GroupState) {
+		gs.Shards = []string{"synced-1"}
+		gs.LastSynced = []string{"10.0.0.0/24", "10.0.1.0/24"}
+	})
+	update("synced-1", func(gs *state.GroupState) { gs.LastApplied = []string{"10.0.0.0/24"} })
+	update("broken", func(gs *state.GroupState) { gs.Shards = []string{"broken-1"} })
+
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"plain", []string{"10.0.0.1/32"}},
+		{"sharded", []string{"10.0.0.0/24", "192.168.0.0/24"}},
+		{"synced", []string{"10.0.0.0/24", "10.0.1.0/24"}},
+	}
+	for _, tt := range tests {
+		got, err := StateLoader(path, tt.group).Load(context.Background())
+		if err != nil {
+			t.Errorf("加载地址组 %s 失败: %v", tt.group, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("地址组 %s = %v，期望 %v", tt.group, got, tt.want)
+		}
+	}
+
+	for _, group := range []string{"missing", "broken"} {
+		if _, err := StateLoader(path, group).Load(context.Background()); err == nil {
+			t.Errorf("地址组 %s 应返回错误", group)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package trustedproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// HeaderAliCdnRealIP DCDN回源时携带的客户端真实IP头
+const HeaderAliCdnRealIP = "Ali-Cdn-Real-Ip"
+
+// Options 中间件配置
+type Options struct {
+	// 按顺序读取的客户端IP请求头，默认先 Ali-Cdn-Real-Ip 后 X-Forwarded-For
+	Headers []string
+	// 直连对端不属于可信代理网段时拒绝请求，默认放行但不采信请求头
+	RejectUntrusted bool
+	// 拒绝请求时的状态码，默认403
+	RejectStatus int
+}
+
+// contextKey 请求上下文中保存IP信息的键
+type contextKey struct{}
+
+// addrInfo 中间件解析出的地址信息
+type addrInfo struct {
+	client  netip.Addr
+	peer    netip.Addr
+	trusted bool
+}
+
+// Middleware 返回根据可信代理网段改写客户端IP的中间件
+// 直连对端属于set时，按Headers顺序取客户端IP并改写 r.RemoteAddr（端口保持不变）；
+// 不属于set时删除这些请求头，避免后续处理逻辑误用伪造的值，设置RejectUntrusted时直接拒绝
+func Middleware(set *Set, opts Options) func(http.Handler) http.Handler {
+	headers := opts.Headers
+	if len(headers) == 0 {
+		headers = []string{HeaderAliCdnRealIP, "X-Forwarded-For"}
+	}
+	status := opts.RejectStatus
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			peer, err := netip.ParseAddr(host)
+			peer = peer.Unmap()
+			if err != nil || !set.Contains(peer) {
+				if opts.RejectUntrusted {
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+				for _, header := range headers {
+					r.Header.Del(header)
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, addrInfo{client: peer, peer: peer})))
+				return
+			}
+
+			info := addrInfo{client: peer, peer: peer, trusted: true}
+			client, ok := clientFromHeaders(r.Header, headers, set)
+			if ok {
+				info.client = client
+			}
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, info))
+			if ok {
+				r.RemoteAddr = client.String()
+				if port != "" {
+					r.RemoteAddr = net.JoinHostPort(client.String(), port)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientFromHeaders 按顺序从请求头中取客户端IP
+// X-Forwarded-For从右向左跳过可信代理，取第一个不可信的地址；全部可信时取最左侧的地址。
+// 遇到无法解析的地址时无法判断其左侧内容的来源，该请求头被忽略
+func clientFromHeaders(header http.Header, names []string, set *Set) (netip.Addr, bool) {
+	for _, name := range names {
+		var hops []string
+		for _, value := range header.Values(name) {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					hops = append(hops, part)
+				}
+			}
+		}
+
+		var leftmost netip.Addr
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(hops[i])
+			if err != nil {
+				leftmost = netip.Addr{}
+				break
+			}
+			addr = addr.Unmap()
+			if !set.Contains(addr) {
+				return addr, true
+			}
+			leftmost = addr
+		}
+		if leftmost.IsValid() {
+			return leftmost, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// ClientIP 返回中间件解析出的客户端IP，请求未经过中间件时返回false
+func ClientIP(r *http.Request) (netip.Addr, bool) {
+	info, ok := r.Context().Value(contextKey{}).(addrInfo)
+	return info.client, ok && info.client.IsValid()
+}
+
+// PeerIP 返回直连对端IP，以及它是否属于可信代理网段
+func PeerIP(r *http.Request) (addr netip.Addr, trusted bool) {
+	info, _ := r.Context().Value(contextKey{}).(addrInfo)
+	return info.peer, info.trusted
+}
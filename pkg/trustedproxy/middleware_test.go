@@ -0,0 +1,236 @@
+package trustedproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestSet(t *testing.T, entries ...string) *Set {
+	t.Helper()
+	set, err := NewSet(context.Background(), LoaderFunc(func(ctx context.Context) ([]string, error) {
+		return entries, nil
+	}), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestMiddleware(t *testing.T) {
+	set := newTestSet(t, "10.0.0.0/8", "2001:db8::/32")
+
+	tests := []struct {
+		name       string
+		opts       Options
+		remoteAddr string
+		headers    map[string][]string
+		wantStatus int
+		wantRemote string // 处理函数看到的RemoteAddr
+		wantClient string
+		wantPeer   string
+		trusted    bool
+		keepHeader bool // 请求头是否保留给处理函数
+	}{
+		{
+			name:       "可信对端使用Ali-Cdn-Real-Ip",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{HeaderAliCdnRealIP: {"203.0.113.9"}, "X-Forwarded-For": {"198.51.100.1"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "不可信对端的请求头被删除",
+			remoteAddr: "198.51.100.7:4567",
+			headers:    map[string][]string{HeaderAliCdnRealIP: {"203.0.113.9"}, "X-Forwarded-For": {"203.0.113.9"}},
+			wantRemote: "198.51.100.7:4567",
+			wantClient: "198.51.100.7",
+			wantPeer:   "198.51.100.7",
+		},
+		{
+			name:       "伪造的最左侧X-Forwarded-For不被采信",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.9, 10.9.9.9"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "多个X-Forwarded-For请求头按顺序拼接",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.9", "10.0.0.2,10.0.0.3"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "全部可信时取最左侧的地址",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.1, ::ffff:10.0.0.2, 10.0.0.3"}},
+			wantRemote: "10.0.0.1:4567",
+			wantClient: "10.0.0.1",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "无法解析的地址之前找到不可信地址",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"unknown, 203.0.113.9, 10.0.0.3, "}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "无法解析的地址使请求头被忽略",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.9, 1.2.3.4:80, 10.0.0.3"}},
+			wantRemote: "10.1.2.3:4567",
+			wantClient: "10.1.2.3",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "无法解析的请求头之后使用下一个请求头",
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{HeaderAliCdnRealIP: {"unknown"}, "X-Forwarded-For": {"203.0.113.9"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "可信对端没有请求头",
+			remoteAddr: "[2001:db8::1]:443",
+			wantRemote: "[2001:db8::1]:443",
+			wantClient: "2001:db8::1",
+			wantPeer:   "2001:db8::1",
+			trusted:    true,
+		},
+		{
+			name:       "IPv4映射的对端地址",
+			remoteAddr: "[::ffff:10.1.2.3]:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8:ffff::1, 203.0.113.9"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "自定义请求头",
+			opts:       Options{Headers: []string{"X-Real-Ip"}},
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.9"}, HeaderAliCdnRealIP: {"198.51.100.1"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+		{
+			name:       "RejectUntrusted拒绝不可信对端",
+			opts:       Options{RejectUntrusted: true},
+			remoteAddr: "198.51.100.7:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.1"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "RejectUntrusted使用自定义状态码",
+			opts:       Options{RejectUntrusted: true, RejectStatus: http.StatusUnauthorized},
+			remoteAddr: "bad-address",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "RejectUntrusted放行可信对端",
+			opts:       Options{RejectUntrusted: true},
+			remoteAddr: "10.1.2.3:4567",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.9"}},
+			wantRemote: "203.0.113.9:4567",
+			wantClient: "203.0.113.9",
+			wantPeer:   "10.1.2.3",
+			trusted:    true,
+			keepHeader: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			var gotRemote, gotClient, gotPeer string
+			var gotTrusted, gotHeader bool
+			handler := Middleware(set, tt.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				gotRemote = r.RemoteAddr
+				if client, ok := ClientIP(r); ok {
+					gotClient = client.String()
+				}
+				peer, trusted := PeerIP(r)
+				gotPeer, gotTrusted = peer.String(), trusted
+				for name := range tt.headers {
+					if r.Header.Get(name) != "" {
+						gotHeader = true
+					}
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if rec.Code != wantStatus {
+				t.Fatalf("状态码为 %d，期望 %d", rec.Code, wantStatus)
+			}
+			if wantStatus != http.StatusOK {
+				if called {
+					t.Error("被拒绝的请求不应到达处理函数")
+				}
+				return
+			}
+
+			if gotRemote != tt.wantRemote {
+				t.Errorf("RemoteAddr = %s，期望 %s", gotRemote, tt.wantRemote)
+			}
+			if gotClient != tt.wantClient {
+				t.Errorf("ClientIP = %s，期望 %s", gotClient, tt.wantClient)
+			}
+			if gotPeer != tt.wantPeer || gotTrusted != tt.trusted {
+				t.Errorf("PeerIP = %s %v，期望 %s %v", gotPeer, gotTrusted, tt.wantPeer, tt.trusted)
+			}
+			if gotHeader != tt.keepHeader {
+				t.Errorf("请求头保留 = %v，期望 %v", gotHeader, tt.keepHeader)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := ClientIP(req); ok {
+		t.Error("未经过中间件的请求不应返回客户端IP")
+	}
+	if _, trusted := PeerIP(req); trusted {
+		t.Error("未经过中间件的请求不应视为可信")
+	}
+}
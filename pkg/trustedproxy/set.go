@@ -0,0 +1,112 @@
+// Package trustedproxy 供源站Go服务使用的可信代理中间件
+//
+// Set 从DCDN接口、快照文件或同步工具的状态文件加载回源节点网段并定期刷新；
+// Middleware 只在直连对端属于该集合时根据 Ali-Cdn-Real-Ip / X-Forwarded-For 改写客户端IP，
+// 也可以拒绝来自其他地址的请求。
+package trustedproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"sync"
+	"time"
+
+	"aliyun-dcdn-firewall-sync/pkg/ipset"
+)
+
+// Set 自动刷新的可信代理网段集合，可并发使用
+type Set struct {
+	loader Loader
+
+	mu        sync.RWMutex
+	set       *ipset.Set
+	updatedAt time.Time
+	lastErr   error
+}
+
+// NewSet 创建集合并同步加载一次，首次加载失败时返回错误
+// interval大于0时在后台按间隔刷新，直到ctx结束；刷新失败时继续使用上一次成功加载的内容
+func NewSet(ctx context.Context, loader Loader, interval time.Duration) (*Set, error) {
+	s := &Set{loader: loader}
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		go s.run(ctx, interval)
+	}
+	return s, nil
+}
+
+// run 定期刷新集合
+func (s *Set) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				log.Printf("刷新可信代理网段失败，继续使用 %s 加载的内容: %v", s.UpdatedAt().Format(time.RFC3339), err)
+			}
+		}
+	}
+}
+
+// Refresh 立即重新加载集合
+// 加载结果为空或全部无法解析时视为失败，避免误把全部请求当作不可信
+func (s *Set) Refresh(ctx context.Context) error {
+	entries, err := s.loader.Load(ctx)
+	var set *ipset.Set
+	if err == nil {
+		var invalid []string
+		set, invalid = ipset.FromStrings(entries)
+		if len(invalid) > 0 {
+			log.Printf("忽略 %d 个无法解析的可信代理条目，如 %s", len(invalid), invalid[0])
+		}
+		if set.Len() == 0 {
+			err = fmt.Errorf("加载到的可信代理网段为空")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	if err != nil {
+		return err
+	}
+	s.set, s.updatedAt = set, time.Now()
+	return nil
+}
+
+// Contains 判断地址是否属于可信代理网段
+func (s *Set) Contains(addr netip.Addr) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set != nil && s.set.Contains(addr.Unmap())
+}
+
+// Len 返回当前集合的条目数
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set.Len()
+}
+
+// UpdatedAt 返回最近一次成功加载的时间
+func (s *Set) UpdatedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.updatedAt
+}
+
+// LastError 返回最近一次刷新的错误，最近一次刷新成功时为nil
+func (s *Set) LastError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastErr
+}